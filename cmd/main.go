@@ -3,14 +3,21 @@ package main
 import (
 	"clearway-test-task/internal/config"
 	myinit "clearway-test-task/internal/init"
+	"context"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 )
 
 const errExit = 1
 
 func main() {
+	// migrate up|down [steps]|status runs schema migrations and exits, it needs only the db config
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(migrate(os.Args[2:]))
+	}
+
 	cfg, err := config.New()
 	if err != nil {
 		lg := myinit.Logger(cfg)
@@ -21,7 +28,27 @@ func main() {
 	lg := myinit.Logger(cfg)
 	lg.Debug("logger init success")
 
-	db, auth, err := myinit.Storage(cfg, lg)
+	if cfg.Db.AutoMigrate && cfg.Db.Driver != "memory" {
+		if err = myinit.Migrate(context.Background(), cfg, []string{"up"}, os.Stdout, lg); err != nil {
+			lg.Error("migrate error", "error", err.Error())
+			os.Exit(errExit)
+		}
+		lg.Debug("migrate success")
+	}
+
+	stopTracing, err := myinit.Tracing(cfg)
+	if err != nil {
+		lg.Error("tracing init error", "error", err.Error())
+		os.Exit(errExit)
+	}
+	lg.Debug("tracing init success")
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = stopTracing(ctx)
+	}()
+
+	db, auth, audit, err := myinit.Storage(cfg, lg)
 	if err != nil {
 		lg.Error("db init error", "error", err.Error())
 		os.Exit(errExit)
@@ -29,8 +56,18 @@ func main() {
 	lg.Debug("db init success")
 	defer func() { _ = db.Close(lg) }()
 	defer func() { _ = auth.Close() }()
+	defer func() { _ = audit.Close() }()
+
+	limiter := myinit.Limiter(cfg, lg)
+	defer func() { _ = limiter.Close() }()
 
-	svr := myinit.Net(cfg, auth.ValidateToken, auth.GetToken, db, lg)
+	rp, err := myinit.Oidc(cfg)
+	if err != nil {
+		lg.Error("oidc init error", "error", err.Error())
+		os.Exit(errExit)
+	}
+
+	svr := myinit.Net(cfg, auth, limiter, audit, rp, db, lg)
 	lg.Debug("http init success")
 	defer func() { _ = svr.Close(lg) }()
 
@@ -39,4 +76,24 @@ func main() {
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
+	svr.Drain()
+	lg.Info("shutting down", "Delay", cfg.Http.ShutdownDelay)
+	time.Sleep(cfg.Http.ShutdownDelay)
+}
+
+func migrate(args []string) int {
+	cfg, err := config.NewDb()
+	lg := myinit.Logger(cfg)
+	if err != nil {
+		lg.Error("config init error", "error", err.Error())
+		return errExit
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	if err = myinit.Migrate(ctx, cfg, args, os.Stdout, lg); err != nil {
+		lg.Error("migrate error", "error", err.Error())
+		return errExit
+	}
+	return 0
 }
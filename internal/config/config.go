@@ -17,6 +17,12 @@ type Http struct {
 	WriteTimeout time.Duration `mapstructure:"net_write_timeout" validate:"min=500ms,max=1m"`
 	// NET_IDLE_TIMEOUT. Idle connection timeout. Min 100 ms. Default to 1 s
 	IdleTimeout time.Duration `mapstructure:"net_idle_timeout" validate:"min=1s,max=1m"`
+	// NET_SHUTDOWN_DELAY. On SIGTERM /readyz fails at once, requests are served for this long before shutdown,
+	// so load balancers stop routing to the instance. Default to 0
+	ShutdownDelay time.Duration `mapstructure:"net_shutdown_delay" validate:"min=0,max=5m"`
+	// NET_HEALTH_CACHE_TTL. Readiness check results are reused for this long, so probes don't load the db.
+	// 0 runs checks on every request. Default to 1 s
+	HealthCacheTtl time.Duration `mapstructure:"net_health_cache_ttl" validate:"min=0,max=1m"`
 }
 
 type Logging struct {
@@ -24,6 +30,9 @@ type Logging struct {
 	Format string `mapstructure:"log_format" validate:"oneof=text json"`
 	// LOG_LEVEL. Log level. Default to info
 	Level string `mapstructure:"log_level" validate:"oneof=debug info warn error"`
+	// LOG_ACCESS. Access log of served requests: structured lines of the logger, common or combined for Common
+	// and Combined Log Format lines on stdout, or off. Default to structured
+	Access string `mapstructure:"log_access" validate:"oneof=structured common combined off"`
 }
 
 type Auth struct {
@@ -33,26 +42,163 @@ type Auth struct {
 	CacheCleanupInterval time.Duration `mapstructure:"auth_cache_cleanup_int" validate:"min=1s,max=24h"`
 	// HMAC_SECRET. Secret for token decoding. Required
 	HmacSecret string `mapstructure:"auth_hmac_secret" validate:"required,alphanum,min=6,max=32"`
+	// AUTH_SESSION_STORE. Where active sessions are looked up: memory keeps all of them, postgres queries db
+	// on every request, lru keeps recently used ones. Default to memory
+	SessionStore string `mapstructure:"auth_session_store" validate:"oneof=memory postgres lru"`
+	// AUTH_SESSION_STORE_SHARDS. Number of lock shards of memory store. Default to 32
+	SessionStoreShards int `mapstructure:"auth_session_store_shards" validate:"min=1,max=4096"`
+	// AUTH_SESSION_STORE_SIZE. Max sessions kept by lru store. Default to 100000
+	SessionStoreSize int `mapstructure:"auth_session_store_size" validate:"min=1,max=100000000"`
+	// AUTH_REGISTRATION_ENABLED. Allows self-registration via POST /users. Default to false
+	RegistrationEnabled bool `mapstructure:"auth_registration_enabled"`
+	// AUTH_PWD_ALGO. Algorithm for new password hashes. Existing hashes are migrated on login. Default to argon2id
+	PwdAlgo string `mapstructure:"auth_pwd_algo" validate:"oneof=bcrypt argon2id"`
+	// AUTH_BCRYPT_COST. Bcrypt cost. Default to 10
+	BcryptCost int `mapstructure:"auth_bcrypt_cost" validate:"min=4,max=31"`
+	// AUTH_ARGON2_TIME. Argon2id number of iterations. Default to 2
+	Argon2Time uint32 `mapstructure:"auth_argon2_time" validate:"min=1,max=10"`
+	// AUTH_ARGON2_MEMORY. Argon2id memory in KiB. Default to 19456
+	Argon2Memory uint32 `mapstructure:"auth_argon2_memory" validate:"min=8192,max=1048576"`
+	// AUTH_ARGON2_THREADS. Argon2id parallelism. Default to 1
+	Argon2Threads uint8 `mapstructure:"auth_argon2_threads" validate:"min=1,max=16"`
+	// AUTH_PWD_MIN_LENGTH. Min length of new passwords in characters, at most 72 with bcrypt. Default to 8
+	PwdMinLength int `mapstructure:"auth_pwd_min_length" validate:"min=1,max=1024"`
+	// AUTH_PWD_MIN_CLASSES. Character classes (lowercase, uppercase, digits, symbols) new passwords must contain. Default to 1
+	PwdMinClasses int `mapstructure:"auth_pwd_min_classes" validate:"min=1,max=4"`
+	// AUTH_PWD_MAX_AGE. Password age after which login requires changing it, 0 disables expiration. Default to 0
+	PwdMaxAge time.Duration `mapstructure:"auth_pwd_max_age" validate:"min=0,max=87600h"`
+	// AUTH_PWD_HISTORY. Number of recent passwords, including the current one, which can't be reused. Default to 5
+	PwdHistory int `mapstructure:"auth_pwd_history" validate:"min=0,max=50"`
+	// AUTH_PWD_BREACHED_FILE. File of breached passwords, one password or SHA-1 per line. Optional
+	PwdBreachedFile string `mapstructure:"auth_pwd_breached_file" validate:"omitempty,file"`
+	// AUTH_LOCKOUT_THRESHOLD. Failed attempts per login before lockout. Anyone knowing a login can lock it out,
+	// so ips the user logged in from within 30 days are counted apart and stay allowed. Default to 10
+	LockoutThreshold int `mapstructure:"auth_lockout_threshold" validate:"min=4,max=1000"`
+	// AUTH_LOCKOUT_IP_THRESHOLD. Failed attempts per remote ip before lockout. Default to 100
+	LockoutIpThreshold int `mapstructure:"auth_lockout_ip_threshold" validate:"min=4,max=100000"`
+	// AUTH_LOCKOUT_DURATION. Lockout duration. Default to 15 m
+	LockoutDuration time.Duration `mapstructure:"auth_lockout_duration" validate:"min=1s,max=24h"`
+	// AUTH_BACKOFF_BASE. Delay after the first failure exceeding free attempts, doubled on each next one. Default to 1 s
+	BackoffBase time.Duration `mapstructure:"auth_backoff_base" validate:"min=10ms,max=1m"`
+	// AUTH_BACKOFF_MAX. Max backoff delay. Default to 1 m
+	BackoffMax time.Duration `mapstructure:"auth_backoff_max" validate:"min=10ms,max=1h"`
+	// AUTH_AUDIT_BUFFER_SIZE. Login events waiting to be written, further events are dropped. Default to 10000
+	AuditBufferSize int `mapstructure:"auth_audit_buffer_size" validate:"min=1,max=1000000"`
+	// AUTH_AUDIT_FLUSH_INT. Login events are written at least this often. Default to 1 s
+	AuditFlushInterval time.Duration `mapstructure:"auth_audit_flush_int" validate:"min=10ms,max=1m"`
+	// AUTH_AUDIT_WRITE_TIMEOUT. Timeout of writing a batch of login events. Default to 5 s
+	AuditWriteTimeout time.Duration `mapstructure:"auth_audit_write_timeout" validate:"min=100ms,max=1m"`
+	// AUTH_TOTP_ISSUER. Issuer shown in authenticator apps. Default to clearway
+	TotpIssuer string `mapstructure:"auth_totp_issuer" validate:"min=1,max=64"`
+}
+
+type Oidc struct {
+	// OIDC_ENABLED. Enables login via external OpenID Connect provider. Default to false
+	Enabled bool `mapstructure:"oidc_enabled"`
+	// OIDC_ISSUER. Provider issuer url, discovery document is fetched from it. Required if enabled
+	Issuer string `mapstructure:"oidc_issuer" validate:"required_if=Enabled true,omitempty,url"`
+	// OIDC_CLIENT_ID. Client id registered at the provider. Required if enabled
+	ClientId string `mapstructure:"oidc_client_id" validate:"required_if=Enabled true"`
+	// OIDC_CLIENT_SECRET. Client secret registered at the provider. Required if enabled
+	ClientSecret string `mapstructure:"oidc_client_secret" validate:"required_if=Enabled true"`
+	// OIDC_REDIRECT_URL. Public url of GET /oidc/callback. Required if enabled
+	RedirectUrl string `mapstructure:"oidc_redirect_url" validate:"required_if=Enabled true,omitempty,url"`
+	// OIDC_SCOPES. Space separated scopes requested from the provider. Default to "openid profile email"
+	Scopes string `mapstructure:"oidc_scopes" validate:"contains=openid"`
+	// OIDC_LOGIN_CLAIM. ID token claim used as login of provisioned users. Default to preferred_username
+	LoginClaim string `mapstructure:"oidc_login_claim" validate:"min=1,max=64"`
+	// OIDC_AUTO_PROVISION. Creates local user on first login of an unlinked subject. Default to false
+	AutoProvision bool `mapstructure:"oidc_auto_provision"`
+	// OIDC_TIMEOUT. Timeout of requests to the provider. Default to 5 s
+	Timeout time.Duration `mapstructure:"oidc_timeout" validate:"min=100ms,max=1m"`
+}
+
+type Ldap struct {
+	// LDAP_ENABLED. Checks passwords by binding to LDAP server before local password hashes. Default to false
+	Enabled bool `mapstructure:"ldap_enabled"`
+	// LDAP_URL. Server url, ldap:// or ldaps://. Required if enabled
+	Url string `mapstructure:"ldap_url" validate:"required_if=Enabled true,omitempty,url"`
+	// LDAP_BIND_DN. Bind DN template, %s is replaced by login. Required if enabled
+	BindDn string `mapstructure:"ldap_bind_dn" validate:"required_if=Enabled true,omitempty,contains=%s"`
+	// LDAP_START_TLS. Upgrades ldap:// connection with StartTLS. Default to false
+	StartTls bool `mapstructure:"ldap_start_tls"`
+	// LDAP_TLS_CA_FILE. PEM file with CA certificates of the server. Default to system pool
+	TlsCaFile string `mapstructure:"ldap_tls_ca_file" validate:"omitempty,file"`
+	// LDAP_TLS_SKIP_VERIFY. Disables server certificate verification. Default to false
+	TlsSkipVerify bool `mapstructure:"ldap_tls_skip_verify"`
+	// LDAP_GROUP_ROLES. Semicolon separated "role:group dn" pairs, role is user or admin. Earlier pairs take precedence.
+	// Roles aren't synced if empty. Default to empty
+	GroupRoles string `mapstructure:"ldap_group_roles"`
+	// LDAP_AUTO_PROVISION. Creates local user on first successful bind. Default to false
+	AutoProvision bool `mapstructure:"ldap_auto_provision"`
+	// LDAP_TIMEOUT. Connection and request timeout. Default to 5 s
+	Timeout time.Duration `mapstructure:"ldap_timeout" validate:"min=100ms,max=1m"`
 }
 
 type Db struct {
-	// DB_DSN. DB dsn. Required
-	Dsn string `mapstructure:"db_dsn" validate:"uri"`
-	// DB_CONN_MAX. The maximum number of open connections to the database. Default to 5
-	ConnMax int `mapstructure:"db_conn_max" validate:"min=1,max=1000"`
-	// DB_CONN_MAX_IDLE. The maximum number of connections in the idle connection pool. Default to 5
+	// DB_DRIVER. Storage backend: postgres, sqlite for a single instance, or memory which keeps everything in process
+	// and loses it on restart, starting with the demo user alice like migrations of the others. Default to postgres
+	Driver string `mapstructure:"db_driver" validate:"oneof=postgres sqlite memory"`
+	// DB_DSN. DB dsn, database file path for sqlite. Required for postgres and sqlite
+	Dsn string `mapstructure:"db_dsn" validate:"required_unless=Driver memory"`
+	// DB_REPLICA_DSNS. Comma separated dsns of read-only replicas serving asset reads and listings. Default to empty
+	ReplicaDsns string `mapstructure:"db_replica_dsns"`
+	// DB_REPLICA_CHECK_INTERVAL. How often replicas are pinged. Default to 5 s
+	ReplicaCheckInterval time.Duration `mapstructure:"db_replica_check_interval" validate:"min=100ms,max=1h"`
+	// DB_REPLICA_STICKY_WINDOW. Reads of a login stay on the primary for this long after the instance wrote its data,
+	// it should exceed replication lag. Default to 5 s
+	ReplicaStickyWindow time.Duration `mapstructure:"db_replica_sticky_window" validate:"min=0,max=1h"`
+	// DB_CONN_MAX. The maximum number of open connections to the database, one is held by session events listener. Default to 5
+	ConnMax int `mapstructure:"db_conn_max" validate:"min=2,max=1000"`
+	// DB_CONN_MAX_IDLE. The number of connections kept open while idle, capped by DB_CONN_MAX. Default to 5
 	ConnMaxIdle int `mapstructure:"db_conn_max_idle" validate:"min=1,max=1000"`
-	// DB_CONN_MAX_REUSE. The maximum amount of time a connection may be reused. Default to 1 s
+	// DB_CONN_MAX_REUSE. The maximum amount of time a connection may be reused, prepared statements are cached
+	// per connection. Default to 30 m
 	ConnMaxReuse time.Duration `mapstructure:"db_conn_max_reuse" validate:"min=10ms,max=1h"`
+	// DB_CONN_MAX_IDLE_TIME. Idle connections above DB_CONN_MAX_IDLE are closed after it. Default to 5 m
+	ConnMaxIdleTime time.Duration `mapstructure:"db_conn_max_idle_time" validate:"min=1s,max=1h"`
+	// DB_HEALTH_CHECK_PERIOD. How often idle connections are checked. Default to 1 m
+	HealthCheckPeriod time.Duration `mapstructure:"db_health_check_period" validate:"min=1s,max=1h"`
+	// DB_RETRY_ATTEMPTS. The maximum number of runs of an operation failed with serialization, deadlock or connection
+	// error, 1 disables retries. Default to 3
+	RetryAttempts int `mapstructure:"db_retry_attempts" validate:"min=1,max=10"`
+	// DB_RETRY_BASE. Delay before the first retry, doubled on each next one. Default to 20 ms
+	RetryBase time.Duration `mapstructure:"db_retry_base" validate:"min=1ms,max=10s"`
+	// DB_RETRY_MAX. Max delay between retries. Default to 500 ms
+	RetryMax time.Duration `mapstructure:"db_retry_max" validate:"min=1ms,max=1m"`
+	// DB_BREAKER_THRESHOLD. The number of consecutive operations failed to reach the database after which requests
+	// fail fast with 503 until a probe query succeeds, 0 disables it. Default to 5
+	BreakerThreshold int `mapstructure:"db_breaker_threshold" validate:"min=0,max=1000"`
+	// DB_BREAKER_OPEN_TIMEOUT. How long requests fail fast before the database is probed again. Default to 5 s
+	BreakerOpenTimeout time.Duration `mapstructure:"db_breaker_open_timeout" validate:"min=100ms,max=10m"`
+	// DB_AUTO_MIGRATE. Applies pending schema migrations on startup. Default to false
+	AutoMigrate bool `mapstructure:"db_auto_migrate"`
+	// DB_MIGRATE_TIMEOUT. The maximum time for migrations to run, including waiting for other runners. Default to 5 m
+	MigrateTimeout time.Duration `mapstructure:"db_migrate_timeout" validate:"min=1s,max=24h"`
 	// DB_DELETE_SESSION_TIMEOUT. The maximum for delete request to run on cache cleaner. Default to 100 ms
 	DeleteSessionTimeout time.Duration `mapstructure:"db_delete_session_timeout" validate:"min=10ms,max=1s"`
 }
 
+type Tracing struct {
+	// TRACE_EXPORTER. Where spans are exported: none, stdout, or file which appends OTLP/JSON lines to TRACE_FILE.
+	// traceparent headers are propagated to logs even with none. Default to none
+	Exporter string `mapstructure:"trace_exporter" validate:"oneof=none stdout file"`
+	// TRACE_FILE. File of the file exporter. Default to traces.jsonl
+	File string `mapstructure:"trace_file" validate:"required_if=Exporter file"`
+	// TRACE_SAMPLE_RATIO. Fraction of new traces recorded, traces started upstream follow their sampled flag. Default to 1
+	SampleRatio float64 `mapstructure:"trace_sample_ratio" validate:"min=0,max=1"`
+	// TRACE_SERVICE_NAME. Service name reported with spans. Default to clearway
+	ServiceName string `mapstructure:"trace_service_name" validate:"required"`
+}
+
 type Config struct {
-	Http Http    `mapstructure:",squash"`
-	Log  Logging `mapstructure:",squash"`
-	Auth Auth    `mapstructure:",squash"`
-	Db   Db      `mapstructure:",squash"`
+	Http  Http    `mapstructure:",squash"`
+	Log   Logging `mapstructure:",squash"`
+	Auth  Auth    `mapstructure:",squash"`
+	Oidc  Oidc    `mapstructure:",squash"`
+	Ldap  Ldap    `mapstructure:",squash"`
+	Db    Db      `mapstructure:",squash"`
+	Trace Tracing `mapstructure:",squash"`
 }
 
 func New() (Config, error) {
@@ -70,3 +216,21 @@ func New() (Config, error) {
 
 	return c, nil
 }
+
+// NewDb is New validating only the db section, for commands which don't serve requests
+func NewDb() (Config, error) {
+	c := Config{}
+
+	err := loadEnv(&c)
+	if err != nil {
+		return Config{}, fmt.Errorf("config unmarshalling error: %w", err)
+	}
+
+	// sections are validated as fields of a struct, the way they are nested in Config
+	err = validator.ValInstance.ValidateStruct(struct{ Db Db }{c.Db})
+	if err != nil {
+		return Config{}, err
+	}
+
+	return c, nil
+}
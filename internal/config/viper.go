@@ -19,6 +19,12 @@ func setNetworkEnv() {
 
 	viper.SetDefault("net_idle_timeout", "1s")
 	_ = viper.BindEnv("net_idle_timeout")
+
+	viper.SetDefault("net_shutdown_delay", "0s")
+	_ = viper.BindEnv("net_shutdown_delay")
+
+	viper.SetDefault("net_health_cache_ttl", "1s")
+	_ = viper.BindEnv("net_health_cache_ttl")
 }
 
 func setLoggingEnv() {
@@ -27,6 +33,9 @@ func setLoggingEnv() {
 
 	viper.SetDefault("log_level", "info")
 	_ = viper.BindEnv("log_level")
+
+	viper.SetDefault("log_access", "structured")
+	_ = viper.BindEnv("log_access")
 }
 
 func setAuthEnv() {
@@ -37,29 +46,202 @@ func setAuthEnv() {
 	_ = viper.BindEnv("auth_cache_cleanup_int")
 
 	_ = viper.BindEnv("auth_hmac_secret")
+
+	viper.SetDefault("auth_session_store", "memory")
+	_ = viper.BindEnv("auth_session_store")
+
+	viper.SetDefault("auth_session_store_shards", "32")
+	_ = viper.BindEnv("auth_session_store_shards")
+
+	viper.SetDefault("auth_session_store_size", "100000")
+	_ = viper.BindEnv("auth_session_store_size")
+
+	viper.SetDefault("auth_registration_enabled", "false")
+	_ = viper.BindEnv("auth_registration_enabled")
+
+	viper.SetDefault("auth_pwd_algo", "argon2id")
+	_ = viper.BindEnv("auth_pwd_algo")
+
+	viper.SetDefault("auth_bcrypt_cost", "10")
+	_ = viper.BindEnv("auth_bcrypt_cost")
+
+	viper.SetDefault("auth_argon2_time", "2")
+	_ = viper.BindEnv("auth_argon2_time")
+
+	viper.SetDefault("auth_argon2_memory", "19456")
+	_ = viper.BindEnv("auth_argon2_memory")
+
+	viper.SetDefault("auth_argon2_threads", "1")
+	_ = viper.BindEnv("auth_argon2_threads")
+
+	viper.SetDefault("auth_pwd_min_length", "8")
+	_ = viper.BindEnv("auth_pwd_min_length")
+
+	viper.SetDefault("auth_pwd_min_classes", "1")
+	_ = viper.BindEnv("auth_pwd_min_classes")
+
+	viper.SetDefault("auth_pwd_max_age", "0")
+	_ = viper.BindEnv("auth_pwd_max_age")
+
+	viper.SetDefault("auth_pwd_history", "5")
+	_ = viper.BindEnv("auth_pwd_history")
+
+	_ = viper.BindEnv("auth_pwd_breached_file")
+
+	viper.SetDefault("auth_lockout_threshold", "10")
+	_ = viper.BindEnv("auth_lockout_threshold")
+
+	viper.SetDefault("auth_lockout_ip_threshold", "100")
+	_ = viper.BindEnv("auth_lockout_ip_threshold")
+
+	viper.SetDefault("auth_lockout_duration", "15m")
+	_ = viper.BindEnv("auth_lockout_duration")
+
+	viper.SetDefault("auth_backoff_base", "1s")
+	_ = viper.BindEnv("auth_backoff_base")
+
+	viper.SetDefault("auth_backoff_max", "1m")
+	_ = viper.BindEnv("auth_backoff_max")
+
+	viper.SetDefault("auth_audit_buffer_size", "10000")
+	_ = viper.BindEnv("auth_audit_buffer_size")
+
+	viper.SetDefault("auth_audit_flush_int", "1s")
+	_ = viper.BindEnv("auth_audit_flush_int")
+
+	viper.SetDefault("auth_audit_write_timeout", "5s")
+	_ = viper.BindEnv("auth_audit_write_timeout")
+
+	viper.SetDefault("auth_totp_issuer", "clearway")
+	_ = viper.BindEnv("auth_totp_issuer")
+}
+
+func setOidcEnv() {
+	viper.SetDefault("oidc_enabled", "false")
+	_ = viper.BindEnv("oidc_enabled")
+
+	_ = viper.BindEnv("oidc_issuer")
+
+	_ = viper.BindEnv("oidc_client_id")
+
+	_ = viper.BindEnv("oidc_client_secret")
+
+	_ = viper.BindEnv("oidc_redirect_url")
+
+	viper.SetDefault("oidc_scopes", "openid profile email")
+	_ = viper.BindEnv("oidc_scopes")
+
+	viper.SetDefault("oidc_login_claim", "preferred_username")
+	_ = viper.BindEnv("oidc_login_claim")
+
+	viper.SetDefault("oidc_auto_provision", "false")
+	_ = viper.BindEnv("oidc_auto_provision")
+
+	viper.SetDefault("oidc_timeout", "5s")
+	_ = viper.BindEnv("oidc_timeout")
+}
+
+func setLdapEnv() {
+	viper.SetDefault("ldap_enabled", "false")
+	_ = viper.BindEnv("ldap_enabled")
+
+	_ = viper.BindEnv("ldap_url")
+
+	_ = viper.BindEnv("ldap_bind_dn")
+
+	viper.SetDefault("ldap_start_tls", "false")
+	_ = viper.BindEnv("ldap_start_tls")
+
+	_ = viper.BindEnv("ldap_tls_ca_file")
+
+	viper.SetDefault("ldap_tls_skip_verify", "false")
+	_ = viper.BindEnv("ldap_tls_skip_verify")
+
+	_ = viper.BindEnv("ldap_group_roles")
+
+	viper.SetDefault("ldap_auto_provision", "false")
+	_ = viper.BindEnv("ldap_auto_provision")
+
+	viper.SetDefault("ldap_timeout", "5s")
+	_ = viper.BindEnv("ldap_timeout")
 }
 
 func setDbEnv() {
+	viper.SetDefault("db_driver", "postgres")
+	_ = viper.BindEnv("db_driver")
+
 	_ = viper.BindEnv("db_dsn")
 
+	_ = viper.BindEnv("db_replica_dsns")
+
+	viper.SetDefault("db_replica_check_interval", "5s")
+	_ = viper.BindEnv("db_replica_check_interval")
+
+	viper.SetDefault("db_replica_sticky_window", "5s")
+	_ = viper.BindEnv("db_replica_sticky_window")
+
 	viper.SetDefault("db_conn_max", "5")
 	_ = viper.BindEnv("db_conn_max")
 
 	viper.SetDefault("db_conn_max_idle", "5")
 	_ = viper.BindEnv("db_conn_max_idle")
 
-	viper.SetDefault("db_conn_max_reuse", "1s")
+	viper.SetDefault("db_conn_max_reuse", "30m")
 	_ = viper.BindEnv("db_conn_max_reuse")
 
+	viper.SetDefault("db_conn_max_idle_time", "5m")
+	_ = viper.BindEnv("db_conn_max_idle_time")
+
+	viper.SetDefault("db_health_check_period", "1m")
+	_ = viper.BindEnv("db_health_check_period")
+
+	viper.SetDefault("db_retry_attempts", "3")
+	_ = viper.BindEnv("db_retry_attempts")
+
+	viper.SetDefault("db_retry_base", "20ms")
+	_ = viper.BindEnv("db_retry_base")
+
+	viper.SetDefault("db_retry_max", "500ms")
+	_ = viper.BindEnv("db_retry_max")
+
+	viper.SetDefault("db_breaker_threshold", "5")
+	_ = viper.BindEnv("db_breaker_threshold")
+
+	viper.SetDefault("db_breaker_open_timeout", "5s")
+	_ = viper.BindEnv("db_breaker_open_timeout")
+
+	viper.SetDefault("db_auto_migrate", "false")
+	_ = viper.BindEnv("db_auto_migrate")
+
+	viper.SetDefault("db_migrate_timeout", "5m")
+	_ = viper.BindEnv("db_migrate_timeout")
+
 	viper.SetDefault("db_delete_session_timeout", "100ms")
 	_ = viper.BindEnv("db_delete_session_timeout")
 }
 
+func setTracingEnv() {
+	viper.SetDefault("trace_exporter", "none")
+	_ = viper.BindEnv("trace_exporter")
+
+	viper.SetDefault("trace_file", "traces.jsonl")
+	_ = viper.BindEnv("trace_file")
+
+	viper.SetDefault("trace_sample_ratio", "1")
+	_ = viper.BindEnv("trace_sample_ratio")
+
+	viper.SetDefault("trace_service_name", "clearway")
+	_ = viper.BindEnv("trace_service_name")
+}
+
 func loadEnv(c *Config) error {
 	setNetworkEnv()
 	setLoggingEnv()
 	setAuthEnv()
+	setOidcEnv()
+	setLdapEnv()
 	setDbEnv()
+	setTracingEnv()
 
 	viper.AutomaticEnv()
 	return viper.Unmarshal(c)
@@ -3,6 +3,7 @@ package errors
 import (
 	"errors"
 	"fmt"
+	"time"
 )
 
 // ErrNotFound is a sentinel error to indicate resource not found.
@@ -12,3 +13,49 @@ var ErrNotFound = errors.New("resource not found")
 func NewNotFoundError(resource string) error {
 	return fmt.Errorf("%w: %s", ErrNotFound, resource)
 }
+
+// ErrInvalidArgument is a sentinel error to indicate invalid user input.
+var ErrInvalidArgument = errors.New("invalid argument")
+
+// NewInvalidArgumentError creates a formatted invalid-argument error.
+func NewInvalidArgumentError(reason string) error {
+	return fmt.Errorf("%w: %s", ErrInvalidArgument, reason)
+}
+
+// ErrOtpRequired is a sentinel error to indicate the user has two-factor auth enabled and no code was provided.
+var ErrOtpRequired = errors.New("one-time code required")
+
+// ErrOtpInvalid is a sentinel error to indicate wrong or already used one-time code.
+var ErrOtpInvalid = errors.New("one-time code invalid")
+
+// ErrInvalidScope is a sentinel error to indicate unknown or not allowed scope.
+var ErrInvalidScope = errors.New("invalid scope")
+
+// NewInvalidScopeError creates a formatted invalid-scope error, which is an invalid-argument error as well.
+func NewInvalidScopeError(reason string) error {
+	return fmt.Errorf("%w: %w: %s", ErrInvalidArgument, ErrInvalidScope, reason)
+}
+
+// ErrPasswordExpired is a sentinel error to indicate the password is correct but older than allowed by policy.
+var ErrPasswordExpired = errors.New("password expired")
+
+// ErrUnavailable is a sentinel error to indicate the storage is down and the request was rejected without trying it.
+var ErrUnavailable = errors.New("service unavailable")
+
+// ErrRetryAfter is an unavailable error carrying the time after which the request is worth retrying.
+type ErrRetryAfter struct {
+	After time.Duration
+}
+
+func (e ErrRetryAfter) Error() string {
+	return fmt.Sprintf("%s: retry after %s", ErrUnavailable, e.After)
+}
+
+func (e ErrRetryAfter) Unwrap() error {
+	return ErrUnavailable
+}
+
+// NewErrRetryAfter creates an unavailable error to be retried after the given time.
+func NewErrRetryAfter(after time.Duration) error {
+	return ErrRetryAfter{After: after}
+}
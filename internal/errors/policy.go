@@ -0,0 +1,35 @@
+package errors
+
+import (
+	"fmt"
+	"strings"
+)
+
+// PolicyViolation is a single failed password policy rule
+type PolicyViolation struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ErrPasswordPolicy is an invalid-argument error listing all rules the password failed
+type ErrPasswordPolicy struct {
+	Violations []PolicyViolation
+}
+
+func (e ErrPasswordPolicy) Error() string {
+	codes := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		codes = append(codes, v.Code)
+	}
+	return fmt.Sprintf("%s: password policy violated: %s", ErrInvalidArgument, strings.Join(codes, ", "))
+}
+
+func (e ErrPasswordPolicy) Unwrap() error {
+	return ErrInvalidArgument
+}
+
+func NewErrPasswordPolicy(violations []PolicyViolation) error {
+	return ErrPasswordPolicy{
+		Violations: violations,
+	}
+}
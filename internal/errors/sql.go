@@ -31,3 +31,75 @@ func NewErrUserNotFound(login string) error {
 		Login: login,
 	}
 }
+
+type ErrApiKeyNotFound struct {
+	Key string
+}
+
+func (e ErrApiKeyNotFound) Error() string {
+	return fmt.Sprintf("api key not found: %s", e.Key)
+}
+
+func NewErrApiKeyNotFound(key string) error {
+	return ErrApiKeyNotFound{
+		Key: key,
+	}
+}
+
+type ErrUserExists struct {
+	Login string
+}
+
+func (e ErrUserExists) Error() string {
+	return fmt.Sprintf("user already exists: %s", e.Login)
+}
+
+func NewErrUserExists(login string) error {
+	return ErrUserExists{
+		Login: login,
+	}
+}
+
+type ErrClientNotFound struct {
+	ClientId string
+}
+
+func (e ErrClientNotFound) Error() string {
+	return fmt.Sprintf("client not found: %s", e.ClientId)
+}
+
+func NewErrClientNotFound(clientId string) error {
+	return ErrClientNotFound{
+		ClientId: clientId,
+	}
+}
+
+type ErrIdentityNotFound struct {
+	Issuer  string
+	Subject string
+}
+
+func (e ErrIdentityNotFound) Error() string {
+	return fmt.Sprintf("identity not found: %s at %s", e.Subject, e.Issuer)
+}
+
+func NewErrIdentityNotFound(issuer, subject string) error {
+	return ErrIdentityNotFound{
+		Issuer:  issuer,
+		Subject: subject,
+	}
+}
+
+type ErrSessionNotFound struct {
+	Id string
+}
+
+func (e ErrSessionNotFound) Error() string {
+	return fmt.Sprintf("session not found: %s", e.Id)
+}
+
+func NewErrSessionNotFound(id string) error {
+	return ErrSessionNotFound{
+		Id: id,
+	}
+}
@@ -0,0 +1,46 @@
+package myinit
+
+import (
+	"clearway-test-task/internal/metrics"
+	"clearway-test-task/internal/storage/authStorage"
+	"clearway-test-task/internal/storage/db"
+)
+
+// registerMetrics adds metrics read from components on scrape. Pool stats are reported by postgres only
+func registerMetrics(auth *authStorage.AuthStorage, database Db) {
+	metrics.Registry.NewGaugeFunc("session_cache_size", "Sessions kept in memory.", func() float64 {
+		return float64(auth.CacheSize())
+	})
+
+	pg, ok := database.(*db.Db)
+	if !ok {
+		return
+	}
+	gauge := func(name, help string, f func() int32) {
+		metrics.Registry.NewGaugeFunc(name, help, func() float64 { return float64(f()) })
+	}
+	counter := func(name, help string, f func() int64) {
+		metrics.Registry.NewCounterFunc(name, help, func() float64 { return float64(f()) })
+	}
+	gauge("db_pool_max_conns", "Maximum size of the pool.", func() int32 { return pg.Stat().MaxConns() })
+	gauge("db_pool_total_conns", "Open connections, idle, acquired and being constructed.",
+		func() int32 { return pg.Stat().TotalConns() })
+	gauge("db_pool_acquired_conns", "Connections in use.", func() int32 { return pg.Stat().AcquiredConns() })
+	gauge("db_pool_idle_conns", "Idle connections.", func() int32 { return pg.Stat().IdleConns() })
+	gauge("db_pool_constructing_conns", "Connections being established.",
+		func() int32 { return pg.Stat().ConstructingConns() })
+	counter("db_pool_acquires_total", "Successful acquires of a connection.",
+		func() int64 { return pg.Stat().AcquireCount() })
+	counter("db_pool_empty_acquires_total", "Acquires which waited for a connection as none was idle.",
+		func() int64 { return pg.Stat().EmptyAcquireCount() })
+	counter("db_pool_canceled_acquires_total", "Acquires canceled by their context.",
+		func() int64 { return pg.Stat().CanceledAcquireCount() })
+	counter("db_pool_new_conns_total", "Connections opened.", func() int64 { return pg.Stat().NewConnsCount() })
+	counter("db_pool_max_lifetime_closed_total", "Connections closed for exceeding DB_CONN_MAX_REUSE.",
+		func() int64 { return pg.Stat().MaxLifetimeDestroyCount() })
+	counter("db_pool_max_idle_closed_total", "Connections closed for exceeding DB_CONN_MAX_IDLE_TIME.",
+		func() int64 { return pg.Stat().MaxIdleDestroyCount() })
+	metrics.Registry.NewCounterFunc("db_pool_acquire_seconds_total", "Time spent acquiring connections.", func() float64 {
+		return pg.Stat().AcquireDuration().Seconds()
+	})
+}
@@ -0,0 +1,101 @@
+package myinit
+
+import (
+	"clearway-test-task/internal/config"
+	"clearway-test-task/internal/storage/db"
+	"clearway-test-task/internal/storage/migrate"
+	"clearway-test-task/internal/storage/sqliteStorage"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	// registers pgx driver for database/sql, migrations run through it
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"io"
+	"io/fs"
+	"log/slog"
+	"strconv"
+	"time"
+)
+
+// Migrate runs migrate command: up, down [steps] or status. Status is written to out
+func Migrate(ctx context.Context, cfg config.Config, args []string, out io.Writer, lg *slog.Logger) error {
+	if len(args) == 0 {
+		return errors.New("usage: migrate up|down [steps]|status")
+	}
+	if cfg.Db.Driver == "memory" {
+		return errors.New("memory driver has no schema to migrate")
+	}
+	steps := 1
+	if args[0] == "down" && len(args) > 1 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid number of steps: %s", args[1])
+		}
+		steps = n
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, cfg.Db.MigrateTimeout)
+	defer cancel()
+	m, conn, err := migrator(cfg, lg)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+
+	switch args[0] {
+	case "up":
+		n, err := m.Up(ctx)
+		if err != nil {
+			return err
+		}
+		lg.Info("migrations applied", "Count", n)
+	case "down":
+		n, err := m.Down(ctx, steps)
+		if err != nil {
+			return err
+		}
+		lg.Info("migrations reverted", "Count", n)
+	case "status":
+		st, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, s := range st {
+			applied := "pending"
+			if s.AppliedAt != 0 {
+				applied = time.Unix(s.AppliedAt, 0).UTC().Format(time.RFC3339)
+			}
+			if _, err = fmt.Fprintf(out, "%04d %-32s %s\n", s.Version, s.Name, applied); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("unknown migrate command: %s", args[0])
+	}
+	return nil
+}
+
+// migrator opens the database of the configured driver with its migrations
+func migrator(cfg config.Config, lg *slog.Logger) (*migrate.Migrator, *sql.DB, error) {
+	var conn *sql.DB
+	var err error
+	var dialect migrate.Dialect
+	var migrations fs.FS
+	if cfg.Db.Driver == "sqlite" {
+		conn, err = sqliteStorage.Open(cfg.Db.Dsn)
+		dialect, migrations = migrate.Sqlite, sqliteStorage.Migrations
+	} else {
+		conn, err = sql.Open("pgx", cfg.Db.Dsn)
+		dialect, migrations = migrate.Postgres, db.Migrations
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	m, err := migrate.NewMigrator(conn, dialect, migrations, "migrations", lg)
+	if err != nil {
+		_ = conn.Close()
+		return nil, nil, err
+	}
+	return m, conn, nil
+}
@@ -3,25 +3,90 @@ package myinit
 import (
 	"clearway-test-task/internal/config"
 	myhttp "clearway-test-task/internal/net/http"
+	"clearway-test-task/internal/net/http/handlers/healthHandlers"
 	"clearway-test-task/internal/storage"
+	"clearway-test-task/internal/storage/authStorage"
+	"clearway-test-task/internal/storage/db"
+	"clearway-test-task/pkg/oidc"
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
 	"github.com/google/uuid"
 	"log/slog"
+	"net/http"
 	"strconv"
+	"strings"
 )
 
-func Net(cfg config.Config, ValidateToken func(token string) (string, error),
-	GetToken func(ctx context.Context, login string, password string) (string, int64, error),
-	db storage.Db, lg *slog.Logger) *myhttp.HttpServer {
+func Net(cfg config.Config, auth *authStorage.AuthStorage, limiter storage.LoginLimiter, auditor storage.Auditor, rp *oidc.RelyingParty, db Db, lg *slog.Logger) *myhttp.HttpServer {
+	registerMetrics(auth, db)
 	return myhttp.NewHttpServer(cfg.Http.Host,
 		strconv.Itoa(cfg.Http.Port),
 		cfg.Http.ReadTimeout,
 		cfg.Http.WriteTimeout,
 		cfg.Http.IdleTimeout,
-		ValidateToken,
-		GetToken,
+		cfg.Auth.RegistrationEnabled,
+		authStorage.NewTracedAuth(auth),
+		limiter,
+		auditor,
+		rp,
+		cfg.Oidc.LoginClaim,
+		cfg.Oidc.AutoProvision,
 		loggerForHandlers(lg),
+		cfg.Log.Access,
 		db,
+		Health(cfg, auth, db),
+	)
+}
+
+// Health checks db is reachable and the session cache is loaded. Postgres checks its statements and reports pool stats
+func Health(cfg config.Config, auth *authStorage.AuthStorage, database Db) *healthHandlers.HealthHandler {
+	checks := []healthHandlers.Check{
+		{Name: "db", Run: database.Ping},
+		{Name: "session_cache", Run: func(context.Context) error {
+			if !auth.CacheLoaded() {
+				return errors.New("sessions not loaded")
+			}
+			return nil
+		}},
+	}
+	var details func() map[string]any
+	if pg, ok := database.(*db.Db); ok {
+		checks = append(checks, healthHandlers.Check{Name: "db_statements", Run: pg.CheckStatements})
+		details = func() map[string]any {
+			st := pg.Stat()
+			return map[string]any{"db_pool": map[string]any{
+				"max_conns":          st.MaxConns(),
+				"total_conns":        st.TotalConns(),
+				"acquired_conns":     st.AcquiredConns(),
+				"idle_conns":         st.IdleConns(),
+				"constructing_conns": st.ConstructingConns(),
+				"acquire_count":      st.AcquireCount(),
+				"empty_acquire":      st.EmptyAcquireCount(),
+				"canceled_acquire":   st.CanceledAcquireCount(),
+			}}
+		}
+	}
+	// leave half of the write timeout for writing the response
+	return healthHandlers.NewHealthHandler(cfg.Http.WriteTimeout/2, cfg.Http.HealthCacheTtl, checks, details)
+}
+
+// Oidc creates OpenID Connect relying party. Returns nil if external login is disabled.
+// State key is derived from the token secret, which all instances share
+func Oidc(cfg config.Config) (*oidc.RelyingParty, error) {
+	if !cfg.Oidc.Enabled {
+		return nil, nil
+	}
+	mac := hmac.New(sha256.New, []byte(cfg.Auth.HmacSecret))
+	mac.Write([]byte("oidc state"))
+	return oidc.NewRelyingParty(cfg.Oidc.Issuer,
+		cfg.Oidc.ClientId,
+		cfg.Oidc.ClientSecret,
+		cfg.Oidc.RedirectUrl,
+		strings.Fields(cfg.Oidc.Scopes),
+		&http.Client{Timeout: cfg.Oidc.Timeout},
+		mac.Sum(nil),
 	)
 }
 
@@ -2,25 +2,197 @@ package myinit
 
 import (
 	"clearway-test-task/internal/config"
+	"clearway-test-task/internal/storage"
+	"clearway-test-task/internal/storage/auditStorage"
 	"clearway-test-task/internal/storage/authStorage"
 	"clearway-test-task/internal/storage/db"
+	"clearway-test-task/internal/storage/limiterStorage"
+	"clearway-test-task/internal/storage/memoryStorage"
+	"clearway-test-task/internal/storage/sessionStorage"
+	"clearway-test-task/internal/storage/sqliteStorage"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
 	"log/slog"
+	"net/url"
+	"os"
+	"strings"
 )
 
-func Storage(cfg config.Config, lg *slog.Logger) (*db.Db, *authStorage.AuthStorage, error) {
-	database, err := db.NewDb(cfg.Db.Dsn, cfg.Db.ConnMax, cfg.Db.ConnMaxIdle, cfg.Db.ConnMaxReuse)
+// demo user seeded by migration 0002 of the sql backends, password: secret
+const (
+	seedLogin   = "alice"
+	seedPwdHash = "$2a$04$zkIAKg6l2DAuOMDDkRI9wuK43PjfONy41pgFqI6m8P2lueM13Rg1i"
+)
+
+// Db is storage.Db owned by main
+type Db interface {
+	storage.Db
+	Ping(ctx context.Context) error
+	Close(lg *slog.Logger) error
+}
+
+func Storage(cfg config.Config, lg *slog.Logger) (Db, *authStorage.AuthStorage, *auditStorage.AuditStorage, error) {
+	directory, err := Directory(cfg)
 	if err != nil {
-		return &db.Db{}, &authStorage.AuthStorage{}, err
+		return &db.Db{}, &authStorage.AuthStorage{}, &auditStorage.AuditStorage{}, err
 	}
+	pv := authStorage.NewDetectingPasswordValidator(cfg.Auth.PwdAlgo,
+		authStorage.BcryptPasswordValidator{Cost: cfg.Auth.BcryptCost},
+		authStorage.Argon2idPasswordValidator{
+			Time:    cfg.Auth.Argon2Time,
+			Memory:  cfg.Auth.Argon2Memory,
+			Threads: cfg.Auth.Argon2Threads,
+		},
+	)
+	if cfg.Auth.PwdMinLength > pv.MaxPasswordLen() {
+		return &db.Db{}, &authStorage.AuthStorage{}, &auditStorage.AuditStorage{},
+			fmt.Errorf("min password length %d exceeds %d bytes accepted by %s", cfg.Auth.PwdMinLength, pv.MaxPasswordLen(), cfg.Auth.PwdAlgo)
+	}
+	policy, err := authStorage.NewPasswordPolicy(cfg.Auth.PwdMinLength,
+		cfg.Auth.PwdMinClasses,
+		cfg.Auth.PwdMaxAge,
+		cfg.Auth.PwdHistory,
+		cfg.Auth.PwdBreachedFile,
+	)
+	if err != nil {
+		return &db.Db{}, &authStorage.AuthStorage{}, &auditStorage.AuditStorage{}, err
+	}
+	database, err := Database(cfg, lg)
+	if err != nil {
+		return &db.Db{}, &authStorage.AuthStorage{}, &auditStorage.AuditStorage{}, err
+	}
+	audit := auditStorage.NewAuditStorage(database,
+		cfg.Auth.AuditBufferSize,
+		cfg.Auth.AuditFlushInterval,
+		cfg.Auth.AuditWriteTimeout,
+		lg,
+	)
 
 	return database,
 		authStorage.NewAuthStorage(database,
+			SessionStore(cfg, database),
 			cfg.Db.DeleteSessionTimeout,
-			authStorage.BcryptPasswordValidator{},
+			pv,
+			policy,
 			cfg.Auth.CacheCleanupInterval,
 			cfg.Auth.TokenTTL,
 			cfg.Auth.HmacSecret,
+			cfg.Auth.TotpIssuer,
+			directory,
+			cfg.Ldap.AutoProvision,
+			audit,
 			lg,
 		),
+		audit,
 		nil
 }
+
+// Database connects to the storage backend chosen by config
+func Database(cfg config.Config, lg *slog.Logger) (Db, error) {
+	switch cfg.Db.Driver {
+	case "memory":
+		// memory storage has no migrations, so it gets the demo user here
+		m := memoryStorage.NewMemoryStorage()
+		if err := m.CreateUser(context.Background(), seedLogin, seedPwdHash); err != nil {
+			return nil, err
+		}
+		return m, nil
+	case "sqlite":
+		return sqliteStorage.NewSqliteStorage(cfg.Db.Dsn)
+	}
+	database, err := db.NewDb(cfg.Db.Dsn,
+		cfg.Db.ConnMax,
+		cfg.Db.ConnMaxIdle,
+		cfg.Db.ConnMaxReuse,
+		cfg.Db.ConnMaxIdleTime,
+		cfg.Db.HealthCheckPeriod,
+		db.Replicas{
+			Dsns:          replicaDsns(cfg.Db.ReplicaDsns),
+			CheckInterval: cfg.Db.ReplicaCheckInterval,
+			StickyWindow:  cfg.Db.ReplicaStickyWindow,
+		},
+		db.Retry{
+			Attempts: cfg.Db.RetryAttempts,
+			Base:     cfg.Db.RetryBase,
+			Max:      cfg.Db.RetryMax,
+		},
+		db.Breaker{
+			Threshold:   cfg.Db.BreakerThreshold,
+			OpenTimeout: cfg.Db.BreakerOpenTimeout,
+		},
+		lg,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return database, nil
+}
+
+// replicaDsns splits comma separated dsns skipping empty ones
+func replicaDsns(dsns string) []string {
+	var res []string
+	for _, dsn := range strings.Split(dsns, ",") {
+		if dsn = strings.TrimSpace(dsn); dsn != "" {
+			res = append(res, dsn)
+		}
+	}
+	return res
+}
+
+// SessionStore creates store of active sessions chosen by config
+func SessionStore(cfg config.Config, db storage.Db) storage.SessionStore {
+	switch cfg.Auth.SessionStore {
+	case "postgres":
+		return sessionStorage.NewDbStorage(db)
+	case "lru":
+		return sessionStorage.NewLruStorage(db, cfg.Auth.SessionStoreSize)
+	default:
+		return sessionStorage.NewShardedStorage(cfg.Auth.SessionStoreShards)
+	}
+}
+
+// Directory creates LDAP directory. Returns nil if LDAP is disabled
+func Directory(cfg config.Config) (storage.Directory, error) {
+	if !cfg.Ldap.Enabled {
+		return nil, nil
+	}
+	groupRoles, err := authStorage.ParseGroupRoles(cfg.Ldap.GroupRoles)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{InsecureSkipVerify: cfg.Ldap.TlsSkipVerify}
+	if cfg.Ldap.TlsCaFile != "" {
+		pem, err := os.ReadFile(cfg.Ldap.TlsCaFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ldap ca file: %w", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificates found in ldap ca file")
+		}
+	}
+	if u, err := url.Parse(cfg.Ldap.Url); err == nil {
+		tlsConfig.ServerName = u.Hostname()
+	}
+
+	return authStorage.NewLdapDirectory(cfg.Ldap.Url,
+		cfg.Ldap.BindDn,
+		cfg.Ldap.StartTls,
+		tlsConfig,
+		cfg.Ldap.Timeout,
+		groupRoles,
+	), nil
+}
+
+func Limiter(cfg config.Config, lg *slog.Logger) *limiterStorage.LimiterStorage {
+	return limiterStorage.NewLimiterStorage(cfg.Auth.LockoutThreshold,
+		cfg.Auth.LockoutIpThreshold,
+		cfg.Auth.LockoutDuration,
+		cfg.Auth.BackoffBase,
+		cfg.Auth.BackoffMax,
+		lg,
+	)
+}
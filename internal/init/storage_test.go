@@ -0,0 +1,30 @@
+package myinit
+
+import (
+	"clearway-test-task/internal/config"
+	"clearway-test-task/internal/storage/authStorage"
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+)
+
+func TestMemoryDatabaseSeeded(t *testing.T) {
+	ctx := context.Background()
+	cfg := config.Config{Db: config.Db{Driver: "memory"}}
+	database, err := Database(cfg, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := database.GetUserPwdHashByLogin(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = (authStorage.BcryptPasswordValidator{Cost: 4}).Validate(hash, "secret"); err != nil {
+		t.Fatalf("seeded password doesn't match migration 0002: %v", err)
+	}
+	role, err := database.GetUserRole(ctx, "alice")
+	if err != nil || role != "user" {
+		t.Fatalf("got role %q, error %v, want user", role, err)
+	}
+}
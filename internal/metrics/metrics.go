@@ -0,0 +1,21 @@
+package metrics
+
+import "clearway-test-task/pkg/prom"
+
+// Registry is served on /metrics. Metrics of optional components are registered on startup
+var Registry = prom.NewRegistry()
+
+var (
+	HttpRequests = Registry.NewCounterVec("http_requests_total",
+		"Requests by route pattern, method and status code.", "route", "method", "code")
+	HttpDuration = Registry.NewHistogramVec("http_request_duration_seconds",
+		"Request latency by route pattern, method and status code.", prom.DefBuckets, "route", "method", "code")
+	AssetBytes = Registry.NewCounterVec("asset_bytes_total",
+		"Asset bytes stored (in) and served (out).", "direction")
+	AuthAttempts = Registry.NewCounterVec("auth_attempts_total",
+		"Login attempts by method and result, reason tells why a failed one failed.", "method", "result", "reason")
+	CacheCleanerRuns = Registry.NewCounter("session_cache_cleaner_runs_total",
+		"Runs of the expired sessions cleaner.")
+	CacheCleanerRemoved = Registry.NewCounter("session_cache_cleaner_removed_total",
+		"Expired sessions removed by the cleaner.")
+)
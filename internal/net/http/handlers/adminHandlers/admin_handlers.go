@@ -0,0 +1,203 @@
+package adminHandlers
+
+import (
+	myerrors "clearway-test-task/internal/errors"
+	"clearway-test-task/internal/net/http/middleware/authMiddleware"
+	"clearway-test-task/internal/net/http/middleware/logMiddleware"
+	"clearway-test-task/internal/storage"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+)
+
+type createClientRequest struct {
+	Login string `json:"login"`
+	Scope string `json:"scope"`
+}
+
+type client struct {
+	ClientId     string `json:"client_id"`
+	ClientSecret string `json:"client_secret,omitempty"`
+	Login        string `json:"login"`
+	Scope        string `json:"scope"`
+	CreatedAt    int64  `json:"created_at"`
+}
+
+type AdminHandler struct {
+	limiter      storage.LoginLimiter
+	createClient func(ctx context.Context, login, scope string) (string, storage.Client, error)
+	getClients   func(ctx context.Context) ([]storage.Client, error)
+	deleteClient func(ctx context.Context, clientId string) error
+}
+
+func NewAdminHandler(limiter storage.LoginLimiter,
+	CreateClient func(ctx context.Context, login, scope string) (string, storage.Client, error),
+	GetClients func(ctx context.Context) ([]storage.Client, error),
+	DeleteClient func(ctx context.Context, clientId string) error) *AdminHandler {
+	return &AdminHandler{
+		limiter:      limiter,
+		createClient: CreateClient,
+		getClients:   GetClients,
+		deleteClient: DeleteClient,
+	}
+}
+
+func RegAdminHandlers(unlockLogin http.Handler, unlockIp http.Handler) {
+	http.Handle("DELETE /admin/lockout/login/{login}", unlockLogin)
+	http.Handle("DELETE /admin/lockout/ip/{ip}", unlockIp)
+}
+
+func RegClientHandlers(get http.Handler, post http.Handler, del http.Handler) {
+	http.Handle("GET /admin/clients", get)
+	http.Handle("POST /admin/clients", post)
+	http.Handle("DELETE /admin/clients/{clientId}", del)
+}
+
+func (a *AdminHandler) LockoutLoginDelete() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		const fn string = "LockoutLoginDelete"
+		lg := logMiddleware.SetupLoggerFromContext(fn, r)
+
+		login := r.PathValue("login")
+		if login == "" {
+			lg.Error("login required", "error", "login required")
+			http.Error(w, "", http.StatusBadRequest)
+			return
+		}
+		a.limiter.Unlock(login)
+
+		writeOk(w, lg)
+		admin, _ := authMiddleware.GetLoginFromContext(r.Context())
+		lg.Info("success", "Admin", admin, "Login", login)
+	})
+}
+
+func (a *AdminHandler) LockoutIpDelete() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		const fn string = "LockoutIpDelete"
+		lg := logMiddleware.SetupLoggerFromContext(fn, r)
+
+		ip := net.ParseIP(r.PathValue("ip"))
+		if ip == nil {
+			lg.Error("invalid ip", "error", "invalid ip", "Ip", r.PathValue("ip"))
+			http.Error(w, "", http.StatusBadRequest)
+			return
+		}
+		a.limiter.UnlockIp(ip.String())
+
+		writeOk(w, lg)
+		admin, _ := authMiddleware.GetLoginFromContext(r.Context())
+		lg.Info("success", "Admin", admin, "Ip", ip.String())
+	})
+}
+
+func (a *AdminHandler) ClientsGet() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		const fn string = "ClientsGet"
+		lg := logMiddleware.SetupLoggerFromContext(fn, r)
+
+		clients, err := a.getClients(r.Context())
+		if err != nil {
+			lg.Error("error getting clients", "error", err)
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		res := make([]client, 0, len(clients))
+		for _, c := range clients {
+			res = append(res, toClient(c))
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		if err = json.NewEncoder(w).Encode(res); err != nil {
+			lg.Error("error writing response", "error", err)
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		admin, _ := authMiddleware.GetLoginFromContext(r.Context())
+		lg.Info("success", "Admin", admin)
+	})
+}
+
+func (a *AdminHandler) ClientsPost() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() { _ = r.Body.Close() }()
+		const fn string = "ClientsPost"
+		lg := logMiddleware.SetupLoggerFromContext(fn, r)
+
+		var req createClientRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			lg.Error("error decoding body", "error", err)
+			http.Error(w, "", http.StatusBadRequest)
+			return
+		}
+		secret, c, err := a.createClient(r.Context(), req.Login, req.Scope)
+		if err != nil {
+			var userErr myerrors.ErrUserNotFound
+			switch {
+			case errors.As(err, &userErr):
+				lg.Error("client owner does not exist", "error", err, "Login", req.Login)
+				http.Error(w, "", http.StatusBadRequest)
+			case errors.Is(err, myerrors.ErrInvalidArgument):
+				lg.Error("invalid client parameters", "error", err)
+				http.Error(w, "", http.StatusBadRequest)
+			default:
+				lg.Error("error creating client", "error", err)
+				http.Error(w, "", http.StatusInternalServerError)
+			}
+			return
+		}
+		res := toClient(c)
+		res.ClientSecret = secret
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusCreated)
+		if err = json.NewEncoder(w).Encode(res); err != nil {
+			lg.Error("error writing response", "error", err)
+			return
+		}
+		admin, _ := authMiddleware.GetLoginFromContext(r.Context())
+		lg.Info("success", "Admin", admin, "ClientId", c.ClientId, "Login", c.Login)
+	})
+}
+
+func (a *AdminHandler) ClientDelete() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		const fn string = "ClientDelete"
+		lg := logMiddleware.SetupLoggerFromContext(fn, r)
+
+		clientId := r.PathValue("clientId")
+		if err := a.deleteClient(r.Context(), clientId); err != nil {
+			var clientErr myerrors.ErrClientNotFound
+			if errors.As(err, &clientErr) {
+				lg.Error("client does not exist", "error", err, "ClientId", clientId)
+				http.Error(w, "", http.StatusNotFound)
+				return
+			}
+			lg.Error("error deleting client", "error", err)
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+
+		writeOk(w, lg)
+		admin, _ := authMiddleware.GetLoginFromContext(r.Context())
+		lg.Info("success", "Admin", admin, "ClientId", clientId)
+	})
+}
+
+func toClient(c storage.Client) client {
+	return client{
+		ClientId:  c.ClientId,
+		Login:     c.Login,
+		Scope:     c.Scope,
+		CreatedAt: c.CreatedAt,
+	}
+}
+
+func writeOk(w http.ResponseWriter, lg *slog.Logger) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if _, err := w.Write([]byte("{\"status\":\"ok\"}")); err != nil {
+		lg.Error("error writing response", "error", err)
+		http.Error(w, "", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,57 @@
+package adminHandlers
+
+import (
+	"clearway-test-task/internal/storage/limiterStorage"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLockoutDelete(t *testing.T) {
+	lg := slog.New(slog.NewTextHandler(io.Discard, nil))
+	tests := []struct {
+		name   string
+		path   string
+		status int
+		// loginUnlocked and ipUnlocked tell which of the lockouts is removed
+		loginUnlocked bool
+		ipUnlocked    bool
+	}{
+		{name: "login", path: "/admin/lockout/login/bob", status: http.StatusOK, loginUnlocked: true},
+		{name: "other login", path: "/admin/lockout/login/ann", status: http.StatusOK},
+		{name: "ip", path: "/admin/lockout/ip/10.0.0.1", status: http.StatusOK, ipUnlocked: true},
+		{name: "invalid ip", path: "/admin/lockout/ip/10.0.0", status: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// bob is locked out, and so is the address after failing logins of others
+			limiter := limiterStorage.NewLimiterStorage(3, 3, time.Hour, time.Second, time.Minute, lg)
+			t.Cleanup(func() { _ = limiter.Close() })
+			for _, ip := range []string{"10.0.0.2", "10.0.0.3", "10.0.0.4"} {
+				limiter.Failure("bob", ip)
+			}
+			for _, login := range []string{"a", "b", "c"} {
+				limiter.Failure(login, "10.0.0.1")
+			}
+			h := NewAdminHandler(limiter, nil, nil, nil)
+			mux := http.NewServeMux()
+			mux.Handle("DELETE /admin/lockout/login/{login}", h.LockoutLoginDelete())
+			mux.Handle("DELETE /admin/lockout/ip/{ip}", h.LockoutIpDelete())
+
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, tt.path, nil))
+			if w.Code != tt.status {
+				t.Fatalf("got status %d, want %d", w.Code, tt.status)
+			}
+
+			_, loginAllowed := limiter.Allow("bob", "10.0.0.5")
+			_, ipAllowed := limiter.Allow("ann", "10.0.0.1")
+			if loginAllowed != tt.loginUnlocked || ipAllowed != tt.ipUnlocked {
+				t.Fatalf("got login allowed %v, ip allowed %v", loginAllowed, ipAllowed)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package adminHandlers
+
+import (
+	"clearway-test-task/internal/net/http/middleware/authMiddleware"
+	"clearway-test-task/internal/net/http/middleware/logMiddleware"
+	"clearway-test-task/internal/storage"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+const (
+	defaultAuditLimit = 50
+	maxAuditLimit     = 500
+)
+
+type loginEvent struct {
+	Id        int64  `json:"id"`
+	Login     string `json:"login"`
+	Method    string `json:"method"`
+	Success   bool   `json:"success"`
+	Reason    string `json:"reason"`
+	Ip        string `json:"ip"`
+	UserAgent string `json:"user_agent"`
+	SessionId string `json:"session_id,omitempty"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+type loginEventsResponse struct {
+	Events []loginEvent `json:"events"`
+	// Next is the before parameter of the next page, absent on the last page
+	Next int64 `json:"next,omitempty"`
+}
+
+type AuditHandler struct {
+	getLoginEvents func(ctx context.Context, login string, beforeId int64, limit int) ([]storage.LoginEvent, error)
+}
+
+func NewAuditHandler(GetLoginEvents func(ctx context.Context, login string, beforeId int64, limit int) ([]storage.LoginEvent, error)) *AuditHandler {
+	return &AuditHandler{getLoginEvents: GetLoginEvents}
+}
+
+func RegAuditHandlers(get http.Handler) {
+	http.Handle("GET /admin/audit/logins", get)
+}
+
+// LoginEventsGet pages through the login audit trail newest first. Query parameters: login, before, limit
+func (a *AuditHandler) LoginEventsGet() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		const fn string = "LoginEventsGet"
+		lg := logMiddleware.SetupLoggerFromContext(fn, r)
+
+		q := r.URL.Query()
+		login := q.Get("login")
+		var before int64
+		if v := q.Get("before"); v != "" {
+			var err error
+			if before, err = strconv.ParseInt(v, 10, 64); err != nil || before <= 0 {
+				lg.Error("invalid before", "error", "invalid before", "Before", v)
+				http.Error(w, "", http.StatusBadRequest)
+				return
+			}
+		}
+		limit := defaultAuditLimit
+		if v := q.Get("limit"); v != "" {
+			var err error
+			if limit, err = strconv.Atoi(v); err != nil || limit <= 0 || limit > maxAuditLimit {
+				lg.Error("invalid limit", "error", "invalid limit", "Limit", v)
+				http.Error(w, "", http.StatusBadRequest)
+				return
+			}
+		}
+
+		events, err := a.getLoginEvents(r.Context(), login, before, limit)
+		if err != nil {
+			lg.Error("error getting login events", "error", err)
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		res := loginEventsResponse{Events: make([]loginEvent, 0, len(events))}
+		for _, ev := range events {
+			res.Events = append(res.Events, loginEvent{
+				Id:        ev.Id,
+				Login:     ev.Login,
+				Method:    ev.Method,
+				Success:   ev.Success,
+				Reason:    ev.Reason,
+				Ip:        ev.Ip,
+				UserAgent: ev.UserAgent,
+				SessionId: ev.SessionId,
+				CreatedAt: ev.CreatedAt,
+			})
+		}
+		if len(events) == limit {
+			res.Next = events[len(events)-1].Id
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		if err = json.NewEncoder(w).Encode(res); err != nil {
+			lg.Error("error writing response", "error", err)
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		admin, _ := authMiddleware.GetLoginFromContext(r.Context())
+		lg.Info("success", "Admin", admin, "Login", login)
+	})
+}
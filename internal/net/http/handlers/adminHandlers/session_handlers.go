@@ -0,0 +1,158 @@
+package adminHandlers
+
+import (
+	myerrors "clearway-test-task/internal/errors"
+	"clearway-test-task/internal/net/http/middleware/authMiddleware"
+	"clearway-test-task/internal/net/http/middleware/logMiddleware"
+	"clearway-test-task/internal/storage"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+type session struct {
+	Id       string `json:"id"`
+	Subject  string `json:"subject"`
+	Login    string `json:"login"`
+	Scope    string `json:"scope,omitempty"`
+	IssuedAt int64  `json:"issued_at"`
+	ExpireAt int64  `json:"expire_at"`
+}
+
+type revokedResponse struct {
+	Revoked int64 `json:"revoked"`
+}
+
+type SessionHandler struct {
+	getSessions  func(ctx context.Context, login string) ([]storage.Session, error)
+	revoke       func(ctx context.Context, id string) error
+	revokeLogin  func(ctx context.Context, login string) error
+	revokeBefore func(ctx context.Context, before int64) (int64, error)
+}
+
+func NewSessionHandler(GetSessions func(ctx context.Context, login string) ([]storage.Session, error),
+	RevokeSession func(ctx context.Context, id string) error,
+	RevokeSessionsByLogin func(ctx context.Context, login string) error,
+	RevokeSessionsIssuedBefore func(ctx context.Context, before int64) (int64, error)) *SessionHandler {
+	return &SessionHandler{
+		getSessions:  GetSessions,
+		revoke:       RevokeSession,
+		revokeLogin:  RevokeSessionsByLogin,
+		revokeBefore: RevokeSessionsIssuedBefore,
+	}
+}
+
+func RegSessionHandlers(get http.Handler, del http.Handler, delOne http.Handler) {
+	http.Handle("GET /admin/sessions", get)
+	http.Handle("DELETE /admin/sessions", del)
+	http.Handle("DELETE /admin/sessions/{id}", delOne)
+}
+
+// SessionsGet lists active sessions, optionally of a single login
+func (s *SessionHandler) SessionsGet() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		const fn string = "SessionsGet"
+		lg := logMiddleware.SetupLoggerFromContext(fn, r)
+
+		login := r.URL.Query().Get("login")
+		sessions, err := s.getSessions(r.Context(), login)
+		if err != nil {
+			lg.Error("error getting sessions", "error", err)
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		res := make([]session, 0, len(sessions))
+		for _, ss := range sessions {
+			res = append(res, session{
+				Id:       ss.Id,
+				Subject:  ss.Subject,
+				Login:    ss.Login,
+				Scope:    ss.Scope,
+				IssuedAt: ss.IssuedAt,
+				ExpireAt: ss.ExpireAt,
+			})
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		if err = json.NewEncoder(w).Encode(res); err != nil {
+			lg.Error("error writing response", "error", err)
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		admin, _ := authMiddleware.GetLoginFromContext(r.Context())
+		lg.Info("success", "Admin", admin, "Login", login)
+	})
+}
+
+// SessionsDelete revokes all sessions of login or all sessions issued before unix timestamp,
+// exactly one of login and issued_before query parameters is required
+func (s *SessionHandler) SessionsDelete() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		const fn string = "SessionsDelete"
+		lg := logMiddleware.SetupLoggerFromContext(fn, r)
+
+		q := r.URL.Query()
+		login, before := q.Get("login"), q.Get("issued_before")
+		if (login == "") == (before == "") {
+			lg.Error("either login or issued_before required", "error", "either login or issued_before required")
+			http.Error(w, "", http.StatusBadRequest)
+			return
+		}
+		admin, _ := authMiddleware.GetLoginFromContext(r.Context())
+
+		if login != "" {
+			if err := s.revokeLogin(r.Context(), login); err != nil {
+				lg.Error("error revoking sessions", "error", err, "Login", login)
+				http.Error(w, "", http.StatusInternalServerError)
+				return
+			}
+			writeOk(w, lg)
+			lg.Info("success", "Admin", admin, "Login", login)
+			return
+		}
+
+		ts, err := strconv.ParseInt(before, 10, 64)
+		if err != nil || ts <= 0 {
+			lg.Error("invalid issued_before", "error", "invalid issued_before", "IssuedBefore", before)
+			http.Error(w, "", http.StatusBadRequest)
+			return
+		}
+		n, err := s.revokeBefore(r.Context(), ts)
+		if err != nil {
+			lg.Error("error revoking sessions", "error", err, "IssuedBefore", ts)
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		if err = json.NewEncoder(w).Encode(revokedResponse{Revoked: n}); err != nil {
+			lg.Error("error writing response", "error", err)
+			return
+		}
+		lg.Info("success", "Admin", admin, "IssuedBefore", ts, "Revoked", n)
+	})
+}
+
+func (s *SessionHandler) SessionDelete() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		const fn string = "SessionDelete"
+		lg := logMiddleware.SetupLoggerFromContext(fn, r)
+
+		id := r.PathValue("id")
+		if err := s.revoke(r.Context(), id); err != nil {
+			var sessionErr myerrors.ErrSessionNotFound
+			if errors.As(err, &sessionErr) {
+				lg.Error("session does not exist", "error", err, "SessionId", id)
+				http.Error(w, "", http.StatusNotFound)
+				return
+			}
+			lg.Error("error revoking session", "error", err)
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+
+		writeOk(w, lg)
+		admin, _ := authMiddleware.GetLoginFromContext(r.Context())
+		lg.Info("success", "Admin", admin, "SessionId", id)
+	})
+}
@@ -0,0 +1,180 @@
+package apiKeyHandlers
+
+import (
+	myerrors "clearway-test-task/internal/errors"
+	"clearway-test-task/internal/net/http/middleware/authMiddleware"
+	"clearway-test-task/internal/net/http/middleware/logMiddleware"
+	"clearway-test-task/internal/storage"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+type createRequest struct {
+	Scope     string `json:"scope"`
+	ExpiresIn int64  `json:"expires_in"`
+}
+
+type apiKey struct {
+	Id         int64  `json:"id"`
+	Key        string `json:"key,omitempty"`
+	Prefix     string `json:"prefix"`
+	Scope      string `json:"scope"`
+	ExpiresAt  int64  `json:"expires_at"`
+	LastUsedAt int64  `json:"last_used_at"`
+	CreatedAt  int64  `json:"created_at"`
+}
+
+type ApiKeyHandler struct {
+	createApiKey func(ctx context.Context, login, scope string, expiresIn int64) (string, storage.ApiKey, error)
+	getApiKeys   func(ctx context.Context, login string) ([]storage.ApiKey, error)
+	revokeApiKey func(ctx context.Context, login string, id int64) error
+}
+
+func NewApiKeyHandler(CreateApiKey func(ctx context.Context, login, scope string, expiresIn int64) (string, storage.ApiKey, error),
+	GetApiKeys func(ctx context.Context, login string) ([]storage.ApiKey, error),
+	RevokeApiKey func(ctx context.Context, login string, id int64) error) *ApiKeyHandler {
+	return &ApiKeyHandler{
+		createApiKey: CreateApiKey,
+		getApiKeys:   GetApiKeys,
+		revokeApiKey: RevokeApiKey,
+	}
+}
+
+func RegApiKeyHandlers(get http.Handler, post http.Handler, del http.Handler) {
+	http.Handle("GET /apikey", get)
+	http.Handle("POST /apikey", post)
+	http.Handle("DELETE /apikey/{id}", del)
+}
+
+func (a *ApiKeyHandler) ApiKeyPost() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() { _ = r.Body.Close() }()
+		const fn string = "ApiKeyPost"
+		lg := logMiddleware.SetupLoggerFromContext(fn, r)
+
+		login, ok := authMiddleware.GetLoginFromContext(r.Context())
+		if !ok {
+			lg.Error("login not found", "error", "login not found")
+			http.Error(w, "", http.StatusBadRequest)
+			return
+		}
+		var req createRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			lg.Error("error decoding body", "error", err)
+			http.Error(w, "", http.StatusBadRequest)
+			return
+		}
+		scope, ok := authMiddleware.RestrictScope(r.Context(), req.Scope)
+		if !ok {
+			lg.Error("scope exceeds credentials scope", "error", "scope exceeds credentials scope", "Scope", req.Scope)
+			http.Error(w, "", http.StatusForbidden)
+			return
+		}
+		plain, key, err := a.createApiKey(r.Context(), login, scope, req.ExpiresIn)
+		if err != nil {
+			if errors.Is(err, myerrors.ErrInvalidArgument) {
+				lg.Error("invalid api key parameters", "error", err)
+				http.Error(w, "", http.StatusBadRequest)
+				return
+			}
+			lg.Error("error creating api key", "error", err)
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+
+		res := toResponse(key)
+		res.Key = plain
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusCreated)
+		if err = json.NewEncoder(w).Encode(res); err != nil {
+			lg.Error("error writing response", "error", err)
+			return
+		}
+		lg.Info("success", "Login", login, "Prefix", key.Prefix)
+	})
+}
+
+func (a *ApiKeyHandler) ApiKeyGet() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		const fn string = "ApiKeyGet"
+		lg := logMiddleware.SetupLoggerFromContext(fn, r)
+
+		login, ok := authMiddleware.GetLoginFromContext(r.Context())
+		if !ok {
+			lg.Error("login not found", "error", "login not found")
+			http.Error(w, "", http.StatusBadRequest)
+			return
+		}
+		keys, err := a.getApiKeys(r.Context(), login)
+		if err != nil {
+			lg.Error("error getting api keys", "error", err)
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+
+		res := make([]apiKey, 0, len(keys))
+		for _, k := range keys {
+			res = append(res, toResponse(k))
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		if err = json.NewEncoder(w).Encode(res); err != nil {
+			lg.Error("error writing response", "error", err)
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		lg.Info("success", "Login", login)
+	})
+}
+
+func (a *ApiKeyHandler) ApiKeyDelete() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() { _ = r.Body.Close() }()
+		const fn string = "ApiKeyDelete"
+		lg := logMiddleware.SetupLoggerFromContext(fn, r)
+
+		login, ok := authMiddleware.GetLoginFromContext(r.Context())
+		if !ok {
+			lg.Error("login not found", "error", "login not found")
+			http.Error(w, "", http.StatusBadRequest)
+			return
+		}
+		id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			lg.Error("invalid api key id", "error", err)
+			http.Error(w, "", http.StatusBadRequest)
+			return
+		}
+		if err = a.revokeApiKey(r.Context(), login, id); err != nil {
+			var keyErr myerrors.ErrApiKeyNotFound
+			if errors.As(err, &keyErr) {
+				lg.Error("api key does not exist", "error", err, "Login", login)
+				http.Error(w, "", http.StatusNotFound)
+				return
+			}
+			lg.Error("error revoking api key", "error", err)
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		if _, err = w.Write([]byte("{\"status\":\"ok\"}")); err != nil {
+			lg.Error("error writing response", "error", err)
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		lg.Info("success", "Login", login, "Id", id)
+	})
+}
+
+func toResponse(k storage.ApiKey) apiKey {
+	return apiKey{
+		Id:         k.Id,
+		Prefix:     k.Prefix,
+		Scope:      k.Scope,
+		ExpiresAt:  k.ExpireAt,
+		LastUsedAt: k.LastUsedAt,
+		CreatedAt:  k.CreatedAt,
+	}
+}
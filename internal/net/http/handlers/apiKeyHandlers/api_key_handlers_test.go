@@ -0,0 +1,73 @@
+package apiKeyHandlers
+
+import (
+	"clearway-test-task/internal/net/http/middleware/authMiddleware"
+	"clearway-test-task/internal/storage/authStorage"
+	"clearway-test-task/internal/storage/memoryStorage"
+	"clearway-test-task/internal/storage/sessionStorage"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestApiKeyPostScope(t *testing.T) {
+	lg := slog.New(slog.NewTextHandler(io.Discard, nil))
+	db := memoryStorage.NewMemoryStorage()
+	if err := db.CreateUser(context.Background(), "bob", "hash"); err != nil {
+		t.Fatal(err)
+	}
+	policy, err := authStorage.NewPasswordPolicy(8, 1, 0, 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := authStorage.NewAuthStorage(db, sessionStorage.NewShardedStorage(4), time.Second,
+		authStorage.BcryptPasswordValidator{Cost: 4}, policy, time.Hour, time.Hour, "0123456789abcdef", "test", nil, false, nil, lg)
+	t.Cleanup(func() { _ = auth.Close() })
+	h := NewApiKeyHandler(auth.CreateApiKey, auth.GetApiKeys, auth.RevokeApiKey)
+
+	tests := []struct {
+		name string
+		// granted is the scope of credentials creating the key, empty for unrestricted ones
+		granted   string
+		requested string
+		status    int
+		scope     string
+	}{
+		{name: "unrestricted credentials", requested: "admin", status: http.StatusCreated, scope: "admin"},
+		{name: "subset", granted: "apikey:manage asset:read asset:write", requested: "asset:read",
+			status: http.StatusCreated, scope: "asset:read"},
+		{name: "empty request inherits", granted: "apikey:manage asset:read", status: http.StatusCreated,
+			scope: "apikey:manage asset:read"},
+		{name: "broader scope", granted: "apikey:manage asset:read", requested: "asset:read admin", status: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := `{"scope":"` + tt.requested + `"}`
+			r := httptest.NewRequest(http.MethodPost, "/apikey", strings.NewReader(body))
+			ctx := context.WithValue(r.Context(), authMiddleware.UserKey, "bob")
+			ctx = context.WithValue(ctx, authMiddleware.ScopeKey, tt.granted)
+			w := httptest.NewRecorder()
+			h.ApiKeyPost().ServeHTTP(w, r.WithContext(ctx))
+
+			if w.Code != tt.status {
+				t.Fatalf("got status %d, want %d", w.Code, tt.status)
+			}
+			if tt.status != http.StatusCreated {
+				return
+			}
+			var res apiKey
+			if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+				t.Fatal(err)
+			}
+			if res.Scope != tt.scope {
+				t.Fatalf("got scope %q, want %q", res.Scope, tt.scope)
+			}
+		})
+	}
+}
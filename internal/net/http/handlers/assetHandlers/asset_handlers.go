@@ -2,6 +2,7 @@ package assetHandlers
 
 import (
 	myerrors "clearway-test-task/internal/errors"
+	"clearway-test-task/internal/metrics"
 	"clearway-test-task/internal/net/http/middleware/authMiddleware"
 	"clearway-test-task/internal/net/http/middleware/logMiddleware"
 	"clearway-test-task/internal/storage"
@@ -80,6 +81,7 @@ func (a *AssetHandler) AssetPost() http.Handler {
 			http.Error(w, "", http.StatusInternalServerError)
 			return
 		}
+		metrics.AssetBytes.WithLabelValues("in").Add(float64(len(b)))
 		w.Header().Set("Content-Type", "application/json; charset=utf-8")
 		if _, err = w.Write([]byte("{\"status\":\"ok\"}")); err != nil {
 			lg.Error("error writing response", "error", err)
@@ -123,7 +125,9 @@ func (a *AssetHandler) AssetGet() http.Handler {
 		default:
 			w.Header().Set("Content-Type", "application/json; charset=utf-8")
 		}
-		if _, err = w.Write(d); err != nil {
+		n, err := w.Write(d)
+		metrics.AssetBytes.WithLabelValues("out").Add(float64(n))
+		if err != nil {
 			lg.Error("error writing response", "error", err)
 			http.Error(w, "", http.StatusInternalServerError)
 			return
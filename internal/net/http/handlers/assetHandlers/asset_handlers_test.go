@@ -0,0 +1,170 @@
+package assetHandlers
+
+import (
+	"clearway-test-task/internal/net/http/handlers/authHandlers"
+	"clearway-test-task/internal/net/http/middleware/authMiddleware"
+	"clearway-test-task/internal/storage/authStorage"
+	"clearway-test-task/internal/storage/limiterStorage"
+	"clearway-test-task/internal/storage/memoryStorage"
+	"clearway-test-task/internal/storage/sessionStorage"
+	"clearway-test-task/pkg"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newServer serves login and asset routes over memory storage with users ann and bob, password is their login twice
+func newServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	lg := slog.New(slog.NewTextHandler(io.Discard, nil))
+	db := memoryStorage.NewMemoryStorage()
+	pv := authStorage.BcryptPasswordValidator{Cost: 4}
+	for _, login := range []string{"ann", "bob"} {
+		hash, err := pv.Hash(login + login)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = db.CreateUser(context.Background(), login, hash); err != nil {
+			t.Fatal(err)
+		}
+	}
+	policy, err := authStorage.NewPasswordPolicy(4, 1, 0, 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := authStorage.NewAuthStorage(db, sessionStorage.NewShardedStorage(4), time.Second, pv, policy,
+		time.Hour, time.Hour, "0123456789abcdef", "test", nil, false, nil, lg)
+	limiter := limiterStorage.NewLimiterStorage(10, 100, time.Minute, time.Second, time.Minute, lg)
+	t.Cleanup(func() {
+		_ = auth.Close()
+		_ = limiter.Close()
+	})
+
+	assetH := NewAssetHandler(db)
+	authH := authHandlers.NewAuthHandler(auth.GetToken, auth.ChangeExpiredPassword, limiter, nil)
+	authM := authMiddleware.NewAuthMiddleware(auth.ValidateToken, auth.ValidateApiKey, auth.IsAdmin)
+	withAuth := func(scope string, next http.Handler) http.Handler {
+		return authM.WithBasicAuth(authMiddleware.RequireScope(scope, next))
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("POST /auth", authH.AuthPost())
+	mux.Handle("GET /asset/{assetName}", withAuth("asset:read", assetH.AssetGet()))
+	mux.Handle("POST /asset/{assetName}", withAuth("asset:write", assetH.AssetPost()))
+	mux.Handle("DELETE /asset/{assetName}", withAuth("asset:write", assetH.AssetDelete()))
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func login(t *testing.T, srv *httptest.Server, login, password string) string {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, srv.URL+"/auth", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth(login, password)
+	res, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = res.Body.Close() }()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("login of %s: got status %d", login, res.StatusCode)
+	}
+	var tkn struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err = json.NewDecoder(res.Body).Decode(&tkn); err != nil {
+		t.Fatal(err)
+	}
+	return tkn.AccessToken
+}
+
+// do sends request with the bearer token, if any, and returns status and body
+func do(t *testing.T, srv *httptest.Server, method, path, token, body string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	req.Header.Set("Content-Type", "text/plain")
+	res, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = res.Body.Close() }()
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.StatusCode, string(b)
+}
+
+func TestAssetFlow(t *testing.T) {
+	srv := newServer(t)
+	ann := login(t, srv, "ann", "annann")
+	bob := login(t, srv, "bob", "bobbob")
+
+	if status, _ := do(t, srv, http.MethodPost, "/asset/notes", ann, "hello"); status != http.StatusOK {
+		t.Fatalf("upload: got status %d", status)
+	}
+	if status, body := do(t, srv, http.MethodGet, "/asset/notes", ann, ""); status != http.StatusOK || body != "hello" {
+		t.Fatalf("download: got status %d, body %q", status, body)
+	}
+	// assets are private to their owner
+	if status, _ := do(t, srv, http.MethodGet, "/asset/notes", bob, ""); status != http.StatusNotFound {
+		t.Fatalf("download by other user: got status %d, want %d", status, http.StatusNotFound)
+	}
+	if status, _ := do(t, srv, http.MethodDelete, "/asset/notes", ann, ""); status != http.StatusOK {
+		t.Fatalf("delete: got status %d", status)
+	}
+	if status, _ := do(t, srv, http.MethodGet, "/asset/notes", ann, ""); status != http.StatusNotFound {
+		t.Fatalf("download of deleted asset: got status %d, want %d", status, http.StatusNotFound)
+	}
+}
+
+func TestAssetRequiresToken(t *testing.T) {
+	srv := newServer(t)
+	tests := []struct {
+		name   string
+		token  string
+		status int
+	}{
+		{name: "no token", status: http.StatusBadRequest},
+		{name: "malformed token", token: "not-base64!", status: http.StatusBadRequest},
+		{name: "forged token", token: forged(t, login(t, srv, "ann", "annann")), status: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status, _ := do(t, srv, http.MethodGet, "/asset/notes", tt.token, ""); status != tt.status {
+				t.Fatalf("got status %d, want %d", status, tt.status)
+			}
+		})
+	}
+}
+
+// forged changes a signature character of the base64 encoded token. The last one is avoided, its low bits are padding
+func forged(t *testing.T, token string) string {
+	t.Helper()
+	b := []byte(pkg.Base64Decode(token))
+	if len(b) < 5 {
+		t.Fatalf("unexpected token %q", token)
+	}
+	i := len(b) - 5
+	if b[i] == 'A' {
+		b[i] = 'B'
+	} else {
+		b[i] = 'A'
+	}
+	return pkg.Base64Encode(string(b))
+}
@@ -3,11 +3,16 @@ package authHandlers
 import (
 	myerrors "clearway-test-task/internal/errors"
 	"clearway-test-task/internal/net/http/middleware/logMiddleware"
+	"clearway-test-task/internal/storage"
 	"clearway-test-task/pkg"
 	"context"
 	"encoding/json"
 	"errors"
+	"log/slog"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 type token struct {
@@ -16,19 +21,37 @@ type token struct {
 	TokenType   string `json:"token_type"`
 }
 
+// OtpHeader carries one-time code or recovery code for users with two-factor auth enabled
+const OtpHeader = "X-OTP-Code"
+
+type changeExpiredPasswordRequest struct {
+	NewPassword string `json:"new_password"`
+}
+
 type AuthHandler struct {
-	getToken func(ctx context.Context, login string, password string) (string, int64, error)
+	getToken              func(ctx context.Context, login, password, otp string) (string, int64, error)
+	changeExpiredPassword func(ctx context.Context, login, currentPassword, otp, newPassword string) error
+	limiter               storage.LoginLimiter
+	auditor               storage.Auditor
 }
 
-func NewAuthHandler(GetToken func(ctx context.Context, login string,
-	password string) (string, int64, error)) *AuthHandler {
+func NewAuthHandler(GetToken func(ctx context.Context, login, password, otp string) (string, int64, error),
+	ChangeExpiredPassword func(ctx context.Context, login, currentPassword, otp, newPassword string) error,
+	limiter storage.LoginLimiter,
+	auditor storage.Auditor) *AuthHandler {
 	return &AuthHandler{
-		getToken: GetToken,
+		getToken:              GetToken,
+		changeExpiredPassword: ChangeExpiredPassword,
+		limiter:               limiter,
+		auditor:               auditor,
 	}
 }
 
-func RegAuthHandlers(post http.Handler) {
+// RegAuthHandlers registers login routes. PUT /auth/password lets users with expired password set a new one,
+// it's authenticated by the current password since no token is issued for them
+func RegAuthHandlers(post http.Handler, password http.Handler) {
 	http.Handle("POST /auth", post)
+	http.Handle("PUT /auth/password", password)
 }
 
 func (a *AuthHandler) AuthPost() http.Handler {
@@ -43,21 +66,50 @@ func (a *AuthHandler) AuthPost() http.Handler {
 			return
 		}
 
-		t, exp, err := a.getToken(r.Context(), l, p)
+		info := ClientInfo(r)
+		ip := info.Ip
+		if wait, ok := a.limiter.Allow(l, ip); !ok {
+			lg.Error("too many failed attempts", "error", "too many failed attempts", "Login", l, "Ip", ip)
+			RecordThrottled(a.auditor, l, storage.LoginMethodPassword, info)
+			w.Header().Set("Retry-After", retryAfter(wait))
+			http.Error(w, "", http.StatusTooManyRequests)
+			return
+		}
+
+		ctx := storage.WithClientInfo(r.Context(), info)
+		t, exp, err := a.getToken(ctx, l, p, r.Header.Get(OtpHeader))
 		if err != nil {
-			var userErr myerrors.ErrUserNotFound
-			if errors.As(err, &userErr) {
-				lg.Error("auth error",
-					"error", err,
-					"Login", userErr.Login,
-				)
-				http.Error(w, "", http.StatusNotFound)
+			if errors.Is(err, myerrors.ErrOtpRequired) {
+				// password is correct, client has to repeat the request with the code
+				lg.Info("one-time code required", "Login", l)
+				writeError(w, lg, http.StatusUnauthorized, "otp_required")
+				return
+			}
+			if errors.Is(err, myerrors.ErrOtpInvalid) {
+				a.limiter.Failure(l, ip)
+				lg.Error("auth error", "error", err, "Login", l)
+				writeError(w, lg, http.StatusUnauthorized, "otp_invalid")
+				return
+			}
+			if errors.Is(err, myerrors.ErrPasswordExpired) {
+				// credentials are correct, password has to be changed via PUT /auth/password
+				a.limiter.Success(l, ip)
+				lg.Info("password expired", "Login", l)
+				writeError(w, lg, http.StatusForbidden, "password_expired")
+				return
+			}
+			if IsCredentialsError(err) {
+				a.limiter.Failure(l, ip)
+				lg.Error("auth error", "error", err, "Login", l)
+				// same response for unknown login and wrong password
+				http.Error(w, "", http.StatusUnauthorized)
 				return
 			}
 			lg.Error("failed to get auth token", "error", err)
 			http.Error(w, "", http.StatusInternalServerError)
 			return
 		}
+		a.limiter.Success(l, ip)
 		res.AccessToken = pkg.Base64Encode(t)
 		res.ExpiresIn = exp
 		res.TokenType = "Bearer"
@@ -71,3 +123,117 @@ func (a *AuthHandler) AuthPost() http.Handler {
 		lg.Info("success", "Login", l)
 	})
 }
+
+func (a *AuthHandler) PasswordPut() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() { _ = r.Body.Close() }()
+		const fn string = "AuthPasswordPut"
+		lg := logMiddleware.SetupLoggerFromContext(fn, r)
+		l, p, ok := r.BasicAuth()
+		if !ok {
+			lg.Error("basic auth required", "error", "basic auth required")
+			http.Error(w, "", http.StatusBadRequest)
+			return
+		}
+		var req changeExpiredPasswordRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			lg.Error("error decoding body", "error", err)
+			http.Error(w, "", http.StatusBadRequest)
+			return
+		}
+
+		info := ClientInfo(r)
+		ip := info.Ip
+		if wait, ok := a.limiter.Allow(l, ip); !ok {
+			lg.Error("too many failed attempts", "error", "too many failed attempts", "Login", l, "Ip", ip)
+			RecordThrottled(a.auditor, l, storage.LoginMethodPassword, info)
+			w.Header().Set("Retry-After", retryAfter(wait))
+			http.Error(w, "", http.StatusTooManyRequests)
+			return
+		}
+
+		ctx := storage.WithClientInfo(r.Context(), info)
+		err := a.changeExpiredPassword(ctx, l, p, r.Header.Get(OtpHeader), req.NewPassword)
+		if err != nil {
+			var policyErr myerrors.ErrPasswordPolicy
+			switch {
+			case errors.Is(err, myerrors.ErrOtpRequired):
+				lg.Info("one-time code required", "Login", l)
+				writeError(w, lg, http.StatusUnauthorized, "otp_required")
+			case errors.Is(err, myerrors.ErrOtpInvalid):
+				a.limiter.Failure(l, ip)
+				lg.Error("auth error", "error", err, "Login", l)
+				writeError(w, lg, http.StatusUnauthorized, "otp_invalid")
+			case IsCredentialsError(err):
+				a.limiter.Failure(l, ip)
+				lg.Error("auth error", "error", err, "Login", l)
+				http.Error(w, "", http.StatusUnauthorized)
+			case errors.As(err, &policyErr):
+				lg.Info("password policy violated", "error", err, "Login", l)
+				WritePolicyError(w, lg, policyErr)
+			case errors.Is(err, myerrors.ErrInvalidArgument):
+				lg.Error("invalid new password", "error", err)
+				http.Error(w, "", http.StatusBadRequest)
+			default:
+				lg.Error("error changing password", "error", err)
+				http.Error(w, "", http.StatusInternalServerError)
+			}
+			return
+		}
+		a.limiter.Success(l, ip)
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		if _, err = w.Write([]byte("{\"status\":\"ok\"}")); err != nil {
+			lg.Error("error writing response", "error", err)
+			return
+		}
+		lg.Info("success", "Login", l)
+	})
+}
+
+// WritePolicyError responds with violated password policy rules
+func WritePolicyError(w http.ResponseWriter, lg *slog.Logger, policyErr myerrors.ErrPasswordPolicy) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusBadRequest)
+	err := json.NewEncoder(w).Encode(struct {
+		Error      string                     `json:"error"`
+		Violations []myerrors.PolicyViolation `json:"violations"`
+	}{"password_policy", policyErr.Violations})
+	if err != nil {
+		lg.Error("error writing response", "error", err)
+	}
+}
+
+// IsCredentialsError reports whether err is caused by unknown login or wrong password
+func IsCredentialsError(err error) bool {
+	var userErr myerrors.ErrUserNotFound
+	return errors.As(err, &userErr) || errors.Is(err, myerrors.ErrNotFound)
+}
+
+// ClientInfo describes the requesting party for the audit trail
+func ClientInfo(r *http.Request) storage.ClientInfo {
+	return storage.ClientInfo{Ip: pkg.RemoteIp(r), UserAgent: r.UserAgent()}
+}
+
+// RecordThrottled records attempt rejected by the limiter, it never reaches storage
+func RecordThrottled(auditor storage.Auditor, login, method string, info storage.ClientInfo) {
+	auditor.Record(storage.LoginEvent{
+		Login:     login,
+		Method:    method,
+		Reason:    storage.LoginThrottled,
+		Ip:        info.Ip,
+		UserAgent: info.UserAgent,
+	})
+}
+
+// retryAfter formats wait as Retry-After header value in whole seconds
+func retryAfter(wait time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(wait.Seconds())))
+}
+
+func writeError(w http.ResponseWriter, lg *slog.Logger, status int, code string) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if _, err := w.Write([]byte("{\"error\":\"" + code + "\"}")); err != nil {
+		lg.Error("error writing response", "error", err)
+	}
+}
@@ -0,0 +1,184 @@
+package authHandlers
+
+import (
+	"clearway-test-task/internal/storage"
+	"clearway-test-task/internal/storage/authStorage"
+	"clearway-test-task/internal/storage/limiterStorage"
+	"clearway-test-task/internal/storage/memoryStorage"
+	"clearway-test-task/internal/storage/sessionStorage"
+	"clearway-test-task/pkg/totp"
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recorder keeps recorded login events in memory
+type recorder struct {
+	mtx    sync.Mutex
+	events []storage.LoginEvent
+}
+
+func (r *recorder) Record(ev storage.LoginEvent) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.events = append(r.events, ev)
+}
+
+func (r *recorder) throttled() []storage.LoginEvent {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	var res []storage.LoginEvent
+	for _, ev := range r.events {
+		if ev.Reason == storage.LoginThrottled {
+			res = append(res, ev)
+		}
+	}
+	return res
+}
+
+// newHandler creates handler over memory storage with user bob, whose password is "bob-password"
+func newHandler(t *testing.T) (*AuthHandler, *recorder, *authStorage.AuthStorage) {
+	t.Helper()
+	lg := slog.New(slog.NewTextHandler(io.Discard, nil))
+	db := memoryStorage.NewMemoryStorage()
+	pv := authStorage.BcryptPasswordValidator{Cost: 4}
+	hash, err := pv.Hash("bob-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = db.CreateUser(context.Background(), "bob", hash); err != nil {
+		t.Fatal(err)
+	}
+	policy, err := authStorage.NewPasswordPolicy(8, 1, 0, 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := &recorder{}
+	auth := authStorage.NewAuthStorage(db, sessionStorage.NewShardedStorage(4), time.Second, pv, policy,
+		time.Hour, time.Hour, "0123456789abcdef", "test", nil, false, rec, lg)
+	// backoff blocks from the fourth failure on
+	limiter := limiterStorage.NewLimiterStorage(10, 100, time.Minute, time.Minute, time.Hour, lg)
+	t.Cleanup(func() {
+		_ = auth.Close()
+		_ = limiter.Close()
+	})
+	return NewAuthHandler(auth.GetToken, auth.ChangeExpiredPassword, limiter, rec), rec, auth
+}
+
+func serve(h http.Handler, method, path, login, password, body string) *httptest.ResponseRecorder {
+	return serveOtp(h, method, path, login, password, "", body)
+}
+
+func serveOtp(h http.Handler, method, path, login, password, otp, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	r.SetBasicAuth(login, password)
+	if otp != "" {
+		r.Header.Set(OtpHeader, otp)
+	}
+	r.Header.Set("User-Agent", "test-agent")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestAuthPost(t *testing.T) {
+	h, _, _ := newHandler(t)
+	if w := serve(h.AuthPost(), http.MethodPost, "/auth", "bob", "bob-password", ""); w.Code != http.StatusOK ||
+		!strings.Contains(w.Body.String(), `"token_type":"Bearer"`) {
+		t.Fatalf("got status %d, body %q", w.Code, w.Body.String())
+	}
+	// unknown login and wrong password are indistinguishable
+	for _, login := range []string{"bob", "eve"} {
+		if w := serve(h.AuthPost(), http.MethodPost, "/auth", login, "wrong-password", ""); w.Code != http.StatusUnauthorized {
+			t.Fatalf("%s: got status %d, want %d", login, w.Code, http.StatusUnauthorized)
+		}
+	}
+}
+
+func TestThrottled(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+	}{
+		{name: "login", method: http.MethodPost, path: "/auth"},
+		{name: "expired password change", method: http.MethodPut, path: "/auth/password", body: `{"new_password":"new-password"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, rec, _ := newHandler(t)
+			handler := h.AuthPost()
+			if tt.method == http.MethodPut {
+				handler = h.PasswordPut()
+			}
+			for i := 0; i < 4; i++ {
+				if w := serve(handler, tt.method, tt.path, "bob", "wrong-password", tt.body); w.Code != http.StatusUnauthorized {
+					t.Fatalf("attempt %d: got status %d, want %d", i+1, w.Code, http.StatusUnauthorized)
+				}
+			}
+
+			// correct password is rejected too until the backoff passes
+			w := serve(handler, tt.method, tt.path, "bob", "bob-password", tt.body)
+			if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+				t.Fatalf("got status %d, Retry-After %q, want %d", w.Code, w.Header().Get("Retry-After"), http.StatusTooManyRequests)
+			}
+			events := rec.throttled()
+			if len(events) != 1 {
+				t.Fatalf("got %d throttled events, want 1", len(events))
+			}
+			if ev := events[0]; ev.Login != "bob" || ev.Ip == "" || ev.UserAgent != "test-agent" {
+				t.Fatalf("unexpected throttled event %+v", ev)
+			}
+		})
+	}
+}
+
+func TestAuthPostOtp(t *testing.T) {
+	h, _, auth := newHandler(t)
+	ctx := context.Background()
+	secret, _, err := auth.EnrollTotp(ctx, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	step := totp.Step(time.Now())
+	code := func(step int64) string {
+		c, err := totp.Code(secret, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	if _, err = auth.ConfirmTotp(ctx, "bob", code(step)); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		password string
+		otp      string
+		status   int
+		body     string
+	}{
+		{name: "missing code", password: "bob-password", status: http.StatusUnauthorized, body: `{"error":"otp_required"}`},
+		{name: "wrong code", password: "bob-password", otp: "abcdef", status: http.StatusUnauthorized, body: `{"error":"otp_invalid"}`},
+		{name: "replayed code", password: "bob-password", otp: code(step), status: http.StatusUnauthorized, body: `{"error":"otp_invalid"}`},
+		// the code isn't checked, so doesn't reveal whether the password is right
+		{name: "wrong password", password: "wrong-password", otp: code(step + 1), status: http.StatusUnauthorized},
+		{name: "valid code", password: "bob-password", otp: code(step + 1), status: http.StatusOK, body: `"token_type":"Bearer"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serveOtp(h.AuthPost(), http.MethodPost, "/auth", "bob", tt.password, tt.otp, "")
+			if w.Code != tt.status || !strings.Contains(w.Body.String(), tt.body) {
+				t.Fatalf("got status %d, body %q, want %d, %q", w.Code, w.Body.String(), tt.status, tt.body)
+			}
+		})
+	}
+}
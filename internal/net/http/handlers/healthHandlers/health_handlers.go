@@ -0,0 +1,203 @@
+package healthHandlers
+
+import (
+	"clearway-test-task/internal/net/http/middleware/logMiddleware"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Check is a readiness condition, Run returns nil while it holds
+type Check struct {
+	Name string
+	Run  func(ctx context.Context) error
+}
+
+type checkResult struct {
+	Name       string  `json:"name"`
+	Status     string  `json:"status"`
+	Error      string  `json:"error,omitempty"`
+	DurationMs float64 `json:"duration_ms"`
+}
+
+type statusResponse struct {
+	Status        string         `json:"status"`
+	ShuttingDown  bool           `json:"shutting_down"`
+	UptimeSeconds int64          `json:"uptime_seconds"`
+	Checks        []checkResult  `json:"checks"`
+	Details       map[string]any `json:"details,omitempty"`
+}
+
+const (
+	statusOk   = "ok"
+	statusFail = "fail"
+)
+
+var errShuttingDown = errors.New("shutting down")
+
+type HealthHandler struct {
+	checks   []Check
+	details  func() map[string]any
+	timeout  time.Duration
+	cacheTtl time.Duration
+	started  time.Time
+	// draining is set on shutdown, readiness fails from then on
+	draining atomic.Bool
+
+	// mtx is held while checks run, so concurrent requests wait for one run instead of starting their own
+	mtx      sync.Mutex
+	cached   []checkResult
+	cachedAt time.Time
+}
+
+// NewHealthHandler runs checks within timeout, results are reused for cacheTtl. details, if set, adds operator
+// information to the status
+func NewHealthHandler(timeout, cacheTtl time.Duration, checks []Check, details func() map[string]any) *HealthHandler {
+	return &HealthHandler{checks: checks, details: details, timeout: timeout, cacheTtl: cacheTtl, started: time.Now()}
+}
+
+func RegHealthHandlers(healthz http.Handler, readyz http.Handler, status http.Handler) {
+	http.Handle("GET /healthz", healthz)
+	http.Handle("GET /readyz", readyz)
+	http.Handle("GET /status", status)
+}
+
+// Drain makes readiness fail, so the instance is taken out of rotation while it finishes serving
+func (h *HealthHandler) Drain() {
+	h.draining.Store(true)
+}
+
+// HealthzGet reports the process is alive, it depends on nothing else
+func (h *HealthHandler) HealthzGet() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		_, _ = w.Write([]byte("{\"status\":\"ok\"}"))
+	})
+}
+
+// ReadyzGet reports whether the instance can serve requests, 503 if any check fails or it is shutting down
+func (h *HealthHandler) ReadyzGet() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		const fn string = "ReadyzGet"
+		lg := logMiddleware.SetupLoggerFromContext(fn, r)
+
+		status := statusOk
+		if _, ok := h.run(r.Context()); !ok {
+			status = statusFail
+		}
+		h.write(w, status, map[string]string{"status": status})
+		if status != statusOk {
+			lg.Warn("not ready")
+		}
+	})
+}
+
+// StatusGet reports results of every check with details for operators, 503 if the instance isn't ready
+func (h *HealthHandler) StatusGet() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		const fn string = "StatusGet"
+		lg := logMiddleware.SetupLoggerFromContext(fn, r)
+
+		results, ok := h.run(r.Context())
+		res := statusResponse{
+			Status:        statusOk,
+			ShuttingDown:  h.draining.Load(),
+			UptimeSeconds: int64(time.Since(h.started).Seconds()),
+			Checks:        results,
+		}
+		if !ok {
+			res.Status = statusFail
+		}
+		if h.details != nil {
+			res.Details = h.details()
+		}
+		h.write(w, res.Status, res)
+		lg.Debug("success", "Status", res.Status)
+	})
+}
+
+// run returns results of the shutdown check and of other checks, which are run again once cached ones are older than
+// cacheTtl. Draining isn't cached, so it is reported at once
+func (h *HealthHandler) run(ctx context.Context) ([]checkResult, bool) {
+	shutdown := checkResult{Name: "shutdown", Status: statusOk}
+	if h.draining.Load() {
+		shutdown.Status = statusFail
+		shutdown.Error = errShuttingDown.Error()
+	}
+	results := append([]checkResult{shutdown}, h.cachedChecks(ctx)...)
+	ok := true
+	for _, res := range results {
+		ok = ok && res.Status == statusOk
+	}
+	return results, ok
+}
+
+func (h *HealthHandler) cachedChecks(ctx context.Context) []checkResult {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	if h.cached == nil || time.Since(h.cachedAt) >= h.cacheTtl {
+		// results are shared, so a canceled request doesn't fail checks of the others
+		h.cached = h.runChecks(context.WithoutCancel(ctx))
+		h.cachedAt = time.Now()
+	}
+	return h.cached
+}
+
+// runChecks runs all checks concurrently and returns once they finish or the timeout passes, checks still running
+// then are reported failed, so one hanging check doesn't delay reporting the others
+func (h *HealthHandler) runChecks(ctx context.Context) []checkResult {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	type indexed struct {
+		i   int
+		res checkResult
+	}
+	start := time.Now()
+	// buffered, so checks finishing after the timeout don't block
+	done := make(chan indexed, len(h.checks))
+	for i, c := range h.checks {
+		go func() {
+			err := c.Run(ctx)
+			res := checkResult{Name: c.Name, Status: statusOk, DurationMs: float64(time.Since(start).Microseconds()) / 1000}
+			if err != nil {
+				res.Status = statusFail
+				res.Error = err.Error()
+			}
+			done <- indexed{i: i, res: res}
+		}()
+	}
+
+	results := make([]checkResult, len(h.checks))
+	finished := make([]bool, len(h.checks))
+wait:
+	for range h.checks {
+		select {
+		case d := <-done:
+			results[d.i] = d.res
+			finished[d.i] = true
+		case <-ctx.Done():
+			break wait
+		}
+	}
+	for i, c := range h.checks {
+		if !finished[i] {
+			results[i] = checkResult{Name: c.Name, Status: statusFail, Error: ctx.Err().Error(),
+				DurationMs: float64(time.Since(start).Microseconds()) / 1000}
+		}
+	}
+	return results
+}
+
+func (h *HealthHandler) write(w http.ResponseWriter, status string, body any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if status != statusOk {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,108 @@
+package healthHandlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func readyz(t *testing.T, h *HealthHandler, ctx context.Context) int {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodGet, "/readyz", nil).WithContext(ctx)
+	w := httptest.NewRecorder()
+	h.ReadyzGet().ServeHTTP(w, r)
+	return w.Code
+}
+
+func TestChecksCached(t *testing.T) {
+	var runs atomic.Int32
+	h := NewHealthHandler(time.Second, time.Hour, []Check{{Name: "db", Run: func(context.Context) error {
+		runs.Add(1)
+		return nil
+	}}}, nil)
+
+	for i := 0; i < 5; i++ {
+		if code := readyz(t, h, context.Background()); code != http.StatusOK {
+			t.Fatalf("got status %d, want %d", code, http.StatusOK)
+		}
+	}
+	if n := runs.Load(); n != 1 {
+		t.Fatalf("check ran %d times, want once", n)
+	}
+
+	// draining isn't cached
+	h.Drain()
+	if code := readyz(t, h, context.Background()); code != http.StatusServiceUnavailable {
+		t.Fatalf("got status %d after drain, want %d", code, http.StatusServiceUnavailable)
+	}
+}
+
+func TestChecksRunAgainAfterTtl(t *testing.T) {
+	var runs atomic.Int32
+	h := NewHealthHandler(time.Second, 0, []Check{{Name: "db", Run: func(context.Context) error {
+		runs.Add(1)
+		return nil
+	}}}, nil)
+
+	readyz(t, h, context.Background())
+	readyz(t, h, context.Background())
+	if n := runs.Load(); n != 2 {
+		t.Fatalf("check ran %d times, want 2", n)
+	}
+}
+
+func TestCanceledRequestNotCached(t *testing.T) {
+	h := NewHealthHandler(time.Second, time.Hour, []Check{{Name: "db", Run: func(ctx context.Context) error {
+		return ctx.Err()
+	}}}, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	readyz(t, h, ctx)
+	if code := readyz(t, h, context.Background()); code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", code, http.StatusOK)
+	}
+}
+
+func TestHangingCheckFailsAtTimeout(t *testing.T) {
+	release := make(chan struct{})
+	t.Cleanup(func() { close(release) })
+	h := NewHealthHandler(50*time.Millisecond, time.Hour, []Check{
+		// ignores its context, like a driver call stuck in a syscall
+		{Name: "hanging", Run: func(context.Context) error {
+			<-release
+			return nil
+		}},
+		{Name: "db", Run: func(context.Context) error { return nil }},
+	}, nil)
+
+	start := time.Now()
+	w := httptest.NewRecorder()
+	h.StatusGet().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/status", nil))
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("status took %v with a hanging check", elapsed)
+	}
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+	var res statusResponse
+	if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"shutdown": statusOk, "hanging": statusFail, "db": statusOk}
+	if len(res.Checks) != len(want) {
+		t.Fatalf("got %d checks, want %d", len(res.Checks), len(want))
+	}
+	for _, c := range res.Checks {
+		if c.Status != want[c.Name] {
+			t.Fatalf("check %s: got status %s, want %s", c.Name, c.Status, want[c.Name])
+		}
+	}
+	if hanging := res.Checks[1]; hanging.Error != context.DeadlineExceeded.Error() {
+		t.Fatalf("got error %q of the hanging check", hanging.Error)
+	}
+}
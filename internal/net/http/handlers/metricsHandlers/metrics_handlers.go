@@ -0,0 +1,15 @@
+package metricsHandlers
+
+import (
+	"clearway-test-task/internal/metrics"
+	"net/http"
+)
+
+func RegMetricsHandlers(get http.Handler) {
+	http.Handle("GET /metrics", get)
+}
+
+// MetricsGet serves metrics in the Prometheus text format
+func MetricsGet() http.Handler {
+	return metrics.Registry.Handler()
+}
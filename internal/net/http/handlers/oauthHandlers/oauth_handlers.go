@@ -0,0 +1,334 @@
+package oauthHandlers
+
+import (
+	myerrors "clearway-test-task/internal/errors"
+	"clearway-test-task/internal/net/http/handlers/authHandlers"
+	"clearway-test-task/internal/net/http/middleware/logMiddleware"
+	"clearway-test-task/internal/storage"
+	"clearway-test-task/pkg"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Error codes of RFC 6749 section 5.2
+const (
+	errInvalidRequest       = "invalid_request"
+	errInvalidClient        = "invalid_client"
+	errInvalidGrant         = "invalid_grant"
+	errUnsupportedGrantType = "unsupported_grant_type"
+	errInvalidScope         = "invalid_scope"
+	errServerError          = "server_error"
+)
+
+var errMalformedClient = errors.New("malformed client credentials")
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+	Scope       string `json:"scope,omitempty"`
+}
+
+// introspectionResponse is defined by RFC 7662 section 2.2. Inactive tokens carry only active field
+type introspectionResponse struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientId  string `json:"client_id,omitempty"`
+	Username  string `json:"username,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	Exp       int64  `json:"exp,omitempty"`
+	Iat       int64  `json:"iat,omitempty"`
+	Sub       string `json:"sub,omitempty"`
+	Sid       string `json:"sid,omitempty"`
+}
+
+type errorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+type OauthHandler struct {
+	passwordGrant          func(ctx context.Context, login, password, otp, scope string, client *storage.Client) (string, int64, string, error)
+	clientCredentialsGrant func(ctx context.Context, client storage.Client, scope string) (string, int64, string, error)
+	authenticateClient     func(ctx context.Context, clientId, secret string) (storage.Client, error)
+	validateToken          func(token string) (storage.Claims, error)
+	limiter                storage.LoginLimiter
+	auditor                storage.Auditor
+}
+
+func NewOauthHandler(PasswordGrant func(ctx context.Context, login, password, otp, scope string, client *storage.Client) (string, int64, string, error),
+	ClientCredentialsGrant func(ctx context.Context, client storage.Client, scope string) (string, int64, string, error),
+	AuthenticateClient func(ctx context.Context, clientId, secret string) (storage.Client, error),
+	ValidateToken func(token string) (storage.Claims, error),
+	limiter storage.LoginLimiter,
+	auditor storage.Auditor) *OauthHandler {
+	return &OauthHandler{
+		passwordGrant:          PasswordGrant,
+		clientCredentialsGrant: ClientCredentialsGrant,
+		authenticateClient:     AuthenticateClient,
+		validateToken:          ValidateToken,
+		limiter:                limiter,
+		auditor:                auditor,
+	}
+}
+
+func RegOauthHandlers(token http.Handler, introspect http.Handler) {
+	http.Handle("POST /oauth/token", token)
+	http.Handle("POST /oauth/introspect", introspect)
+}
+
+func (o *OauthHandler) TokenPost() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() { _ = r.Body.Close() }()
+		const fn string = "OauthTokenPost"
+		lg := logMiddleware.SetupLoggerFromContext(fn, r)
+
+		if err := r.ParseForm(); err != nil {
+			lg.Error("error parsing form", "error", err)
+			writeError(w, lg, http.StatusBadRequest, errInvalidRequest, "malformed request body")
+			return
+		}
+		client, provided, basic, err := o.clientFromRequest(r)
+		if err != nil {
+			if !isClientError(err) {
+				lg.Error("failed to authenticate client", "error", err)
+				writeError(w, lg, http.StatusInternalServerError, errServerError, "")
+				return
+			}
+			lg.Error("client authentication failed", "error", err)
+			if r.PostForm.Get("grant_type") == "client_credentials" {
+				o.auditor.Record(storage.LoginEvent{
+					Login:     client.ClientId,
+					Method:    storage.LoginMethodClientCredentials,
+					Reason:    storage.LoginInvalidCredentials,
+					Ip:        authHandlers.ClientInfo(r).Ip,
+					UserAgent: r.UserAgent(),
+				})
+			}
+			if basic {
+				w.Header().Set("WWW-Authenticate", "Basic realm=\"oauth\"")
+			}
+			writeError(w, lg, http.StatusUnauthorized, errInvalidClient, "client authentication failed")
+			return
+		}
+
+		scope := r.PostForm.Get("scope")
+		switch grantType := r.PostForm.Get("grant_type"); grantType {
+		case "password":
+			var c *storage.Client
+			if provided {
+				c = &client
+			}
+			o.handlePasswordGrant(w, r, lg, c, scope)
+		case "client_credentials":
+			if !provided {
+				lg.Error("client authentication required", "error", "client authentication required")
+				writeError(w, lg, http.StatusUnauthorized, errInvalidClient, "client authentication required")
+				return
+			}
+			ctx := storage.WithClientInfo(r.Context(), authHandlers.ClientInfo(r))
+			t, exp, granted, err := o.clientCredentialsGrant(ctx, client, scope)
+			if err != nil {
+				handleGrantError(w, lg, err)
+				return
+			}
+			writeToken(w, lg, t, exp, granted)
+			lg.Info("success", "ClientId", client.ClientId, "GrantType", grantType)
+		case "":
+			lg.Error("grant type required", "error", "grant type required")
+			writeError(w, lg, http.StatusBadRequest, errInvalidRequest, "grant_type is required")
+		default:
+			lg.Error("unsupported grant type", "error", "unsupported grant type", "GrantType", grantType)
+			writeError(w, lg, http.StatusBadRequest, errUnsupportedGrantType, "")
+		}
+	})
+}
+
+// IntrospectPost implements RFC 7662 token introspection for authenticated clients
+func (o *OauthHandler) IntrospectPost() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() { _ = r.Body.Close() }()
+		const fn string = "OauthIntrospectPost"
+		lg := logMiddleware.SetupLoggerFromContext(fn, r)
+
+		if err := r.ParseForm(); err != nil {
+			lg.Error("error parsing form", "error", err)
+			writeError(w, lg, http.StatusBadRequest, errInvalidRequest, "malformed request body")
+			return
+		}
+		client, provided, basic, err := o.clientFromRequest(r)
+		if err != nil && !isClientError(err) {
+			lg.Error("failed to authenticate client", "error", err)
+			writeError(w, lg, http.StatusInternalServerError, errServerError, "")
+			return
+		}
+		if err != nil || !provided {
+			lg.Error("client authentication failed", "error", err)
+			if basic || !provided {
+				w.Header().Set("WWW-Authenticate", "Basic realm=\"oauth\"")
+			}
+			writeError(w, lg, http.StatusUnauthorized, errInvalidClient, "client authentication failed")
+			return
+		}
+		token := r.PostForm.Get("token")
+		if token == "" {
+			lg.Error("token required", "error", "token required")
+			writeError(w, lg, http.StatusBadRequest, errInvalidRequest, "token is required")
+			return
+		}
+		// access tokens are handed out base64 encoded, raw jwt is accepted as well
+		if decoded := pkg.Base64Decode(token); decoded != "" {
+			token = decoded
+		}
+
+		res := introspectionResponse{}
+		claims, err := o.validateToken(token)
+		if err != nil {
+			lg.Info("inactive token introspected", "error", err, "ClientId", client.ClientId)
+		} else {
+			res = introspectionResponse{
+				Active:    true,
+				Scope:     claims.Scope,
+				ClientId:  claims.ClientId,
+				Username:  claims.Login,
+				TokenType: "Bearer",
+				Exp:       claims.ExpireAt,
+				Iat:       claims.IssuedAt,
+				Sub:       claims.Subject,
+				Sid:       claims.SessionId,
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		if err = json.NewEncoder(w).Encode(res); err != nil {
+			lg.Error("error writing response", "error", err)
+			return
+		}
+		lg.Info("success", "ClientId", client.ClientId, "Active", res.Active)
+	})
+}
+
+func (o *OauthHandler) handlePasswordGrant(w http.ResponseWriter, r *http.Request, lg *slog.Logger, client *storage.Client, scope string) {
+	login, password := r.PostForm.Get("username"), r.PostForm.Get("password")
+	if login == "" || password == "" {
+		lg.Error("username and password required", "error", "username and password required")
+		writeError(w, lg, http.StatusBadRequest, errInvalidRequest, "username and password are required")
+		return
+	}
+
+	info := authHandlers.ClientInfo(r)
+	ip := info.Ip
+	if wait, ok := o.limiter.Allow(login, ip); !ok {
+		lg.Error("too many failed attempts", "error", "too many failed attempts", "Login", login, "Ip", ip)
+		authHandlers.RecordThrottled(o.auditor, login, storage.LoginMethodOauthPassword, info)
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		writeError(w, lg, http.StatusTooManyRequests, errInvalidGrant, "too many failed attempts")
+		return
+	}
+
+	ctx := storage.WithClientInfo(r.Context(), info)
+	t, exp, granted, err := o.passwordGrant(ctx, login, password, r.Header.Get(authHandlers.OtpHeader), scope, client)
+	if err != nil {
+		switch {
+		case errors.Is(err, myerrors.ErrOtpRequired):
+			lg.Info("one-time code required", "Login", login)
+			writeError(w, lg, http.StatusBadRequest, errInvalidGrant, "one-time code required")
+		case errors.Is(err, myerrors.ErrOtpInvalid), authHandlers.IsCredentialsError(err):
+			o.limiter.Failure(login, ip)
+			lg.Error("auth error", "error", err, "Login", login)
+			writeError(w, lg, http.StatusBadRequest, errInvalidGrant, "invalid resource owner credentials")
+		case errors.Is(err, myerrors.ErrPasswordExpired):
+			o.limiter.Success(login, ip)
+			lg.Info("password expired", "Login", login)
+			writeError(w, lg, http.StatusBadRequest, errInvalidGrant, "password expired")
+		default:
+			handleGrantError(w, lg, err)
+		}
+		return
+	}
+	o.limiter.Success(login, ip)
+	writeToken(w, lg, t, exp, granted)
+	lg.Info("success", "Login", login, "GrantType", "password")
+}
+
+// clientFromRequest authenticates client by HTTP Basic or by client_id and client_secret form parameters.
+// Returns whether client credentials were provided and whether Basic scheme was used.
+// On failure the client holds only the claimed client id
+func (o *OauthHandler) clientFromRequest(r *http.Request) (storage.Client, bool, bool, error) {
+	id, secret, basic := r.BasicAuth()
+	if basic {
+		// RFC 6749 section 2.3.1, credentials are form-urlencoded before Basic encoding
+		var err error
+		if id, err = url.QueryUnescape(id); err != nil {
+			return storage.Client{}, true, true, fmt.Errorf("%w: %w", errMalformedClient, err)
+		}
+		if secret, err = url.QueryUnescape(secret); err != nil {
+			return storage.Client{ClientId: id}, true, true, fmt.Errorf("%w: %w", errMalformedClient, err)
+		}
+	} else {
+		id, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+		if id == "" {
+			return storage.Client{}, false, false, nil
+		}
+	}
+	client, err := o.authenticateClient(r.Context(), id, secret)
+	if err != nil {
+		return storage.Client{ClientId: id}, true, basic, err
+	}
+	return client, true, basic, nil
+}
+
+// isClientError reports whether client authentication failed because of the credentials, not of storage
+func isClientError(err error) bool {
+	var clientErr myerrors.ErrClientNotFound
+	return errors.As(err, &clientErr) || errors.Is(err, errMalformedClient)
+}
+
+func handleGrantError(w http.ResponseWriter, lg *slog.Logger, err error) {
+	var userErr myerrors.ErrUserNotFound
+	switch {
+	case errors.Is(err, myerrors.ErrInvalidScope):
+		lg.Error("invalid scope", "error", err)
+		writeError(w, lg, http.StatusBadRequest, errInvalidScope, err.Error())
+	case errors.As(err, &userErr):
+		lg.Error("client owner not found", "error", err)
+		writeError(w, lg, http.StatusBadRequest, errInvalidGrant, "")
+	default:
+		lg.Error("failed to issue token", "error", err)
+		writeError(w, lg, http.StatusInternalServerError, errServerError, "")
+	}
+}
+
+func writeToken(w http.ResponseWriter, lg *slog.Logger, t string, exp int64, scope string) {
+	res := tokenResponse{
+		AccessToken: pkg.Base64Encode(t),
+		TokenType:   "Bearer",
+		ExpiresIn:   max(exp-time.Now().Unix(), 0),
+		Scope:       scope,
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		lg.Error("error writing response", "error", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, lg *slog.Logger, status int, code, description string) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(errorResponse{Error: code, ErrorDescription: description}); err != nil {
+		lg.Error("error writing response", "error", err)
+	}
+}
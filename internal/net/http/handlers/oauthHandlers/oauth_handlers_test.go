@@ -0,0 +1,274 @@
+package oauthHandlers
+
+import (
+	"clearway-test-task/internal/storage"
+	"clearway-test-task/internal/storage/authStorage"
+	"clearway-test-task/internal/storage/limiterStorage"
+	"clearway-test-task/internal/storage/memoryStorage"
+	"clearway-test-task/internal/storage/sessionStorage"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recorder keeps recorded login events in memory
+type recorder struct {
+	mtx    sync.Mutex
+	events []storage.LoginEvent
+}
+
+func (r *recorder) Record(ev storage.LoginEvent) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.events = append(r.events, ev)
+}
+
+type testClient struct {
+	id     string
+	secret string
+}
+
+type testServer struct {
+	mux  *http.ServeMux
+	auth *authStorage.AuthStorage
+	rec  *recorder
+	// svc may get asset scopes, rs only introspects tokens
+	svc testClient
+	rs  testClient
+}
+
+// newServer serves oauth routes over memory storage with user bob, whose password is "bob-password"
+func newServer(t *testing.T) *testServer {
+	t.Helper()
+	ctx := context.Background()
+	lg := slog.New(slog.NewTextHandler(io.Discard, nil))
+	db := memoryStorage.NewMemoryStorage()
+	pv := authStorage.BcryptPasswordValidator{Cost: 4}
+	hash, err := pv.Hash("bob-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = db.CreateUser(ctx, "bob", hash); err != nil {
+		t.Fatal(err)
+	}
+	policy, err := authStorage.NewPasswordPolicy(8, 1, 0, 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := &recorder{}
+	auth := authStorage.NewAuthStorage(db, sessionStorage.NewShardedStorage(4), time.Second, pv, policy,
+		time.Hour, time.Hour, "0123456789abcdef", "test", nil, false, rec, lg)
+	limiter := limiterStorage.NewLimiterStorage(10, 100, time.Minute, time.Second, time.Minute, lg)
+	t.Cleanup(func() {
+		_ = auth.Close()
+		_ = limiter.Close()
+	})
+
+	s := &testServer{mux: http.NewServeMux(), auth: auth, rec: rec}
+	for _, c := range []struct {
+		client *testClient
+		scope  string
+	}{{&s.svc, "asset:read asset:write"}, {&s.rs, "asset:read"}} {
+		secret, client, err := auth.CreateClient(ctx, "bob", c.scope)
+		if err != nil {
+			t.Fatal(err)
+		}
+		*c.client = testClient{id: client.ClientId, secret: secret}
+	}
+
+	h := NewOauthHandler(auth.PasswordGrant, auth.ClientCredentialsGrant, auth.AuthenticateClient, auth.ValidateToken, limiter, rec)
+	s.mux.Handle("POST /oauth/token", h.TokenPost())
+	s.mux.Handle("POST /oauth/introspect", h.IntrospectPost())
+	return s
+}
+
+// post sends form to path, authenticating with Basic scheme if basic isn't empty
+func (s *testServer) post(path string, form url.Values, basic testClient) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if basic.id != "" {
+		r.SetBasicAuth(basic.id, basic.secret)
+	}
+	w := httptest.NewRecorder()
+	s.mux.ServeHTTP(w, r)
+	return w
+}
+
+func decode[T any](t *testing.T, w *httptest.ResponseRecorder) T {
+	t.Helper()
+	var res T
+	if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+		t.Fatalf("status %d: %v", w.Code, err)
+	}
+	return res
+}
+
+// escaped percent-encodes every character, which is allowed before Basic encoding
+func escaped(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		fmt.Fprintf(&b, "%%%02X", s[i])
+	}
+	return b.String()
+}
+
+func TestTokenErrors(t *testing.T) {
+	s := newServer(t)
+	wrong := testClient{id: s.svc.id, secret: "wrong"}
+	tests := []struct {
+		name  string
+		form  url.Values
+		basic testClient
+		// status and error code of RFC 6749 section 5.2
+		status int
+		code   string
+		// challenge is whether WWW-Authenticate is set
+		challenge bool
+	}{
+		{name: "no grant type", form: url.Values{}, status: http.StatusBadRequest, code: errInvalidRequest},
+		{name: "unsupported grant type", form: url.Values{"grant_type": {"implicit"}}, status: http.StatusBadRequest,
+			code: errUnsupportedGrantType},
+		{name: "client credentials without client", form: url.Values{"grant_type": {"client_credentials"}},
+			status: http.StatusUnauthorized, code: errInvalidClient},
+		{name: "wrong basic secret", form: url.Values{"grant_type": {"client_credentials"}}, basic: wrong,
+			status: http.StatusUnauthorized, code: errInvalidClient, challenge: true},
+		{name: "wrong form secret", form: url.Values{"grant_type": {"client_credentials"}, "client_id": {s.svc.id},
+			"client_secret": {"wrong"}}, status: http.StatusUnauthorized, code: errInvalidClient},
+		{name: "malformed basic escaping", form: url.Values{"grant_type": {"client_credentials"}},
+			basic: testClient{id: s.svc.id, secret: "%zz"}, status: http.StatusUnauthorized, code: errInvalidClient, challenge: true},
+		{name: "unknown client", form: url.Values{"grant_type": {"client_credentials"}},
+			basic: testClient{id: "cwc_unknown", secret: s.svc.secret}, status: http.StatusUnauthorized, code: errInvalidClient, challenge: true},
+		{name: "scope beyond client", form: url.Values{"grant_type": {"client_credentials"}, "scope": {"admin"}}, basic: s.svc,
+			status: http.StatusBadRequest, code: errInvalidScope},
+		{name: "unknown scope", form: url.Values{"grant_type": {"client_credentials"}, "scope": {"everything"}}, basic: s.svc,
+			status: http.StatusBadRequest, code: errInvalidScope},
+		{name: "password grant without password", form: url.Values{"grant_type": {"password"}, "username": {"bob"}},
+			status: http.StatusBadRequest, code: errInvalidRequest},
+		{name: "wrong password", form: url.Values{"grant_type": {"password"}, "username": {"bob"}, "password": {"wrong-password"}},
+			status: http.StatusBadRequest, code: errInvalidGrant},
+		{name: "unknown user", form: url.Values{"grant_type": {"password"}, "username": {"eve"}, "password": {"eve-password"}},
+			status: http.StatusBadRequest, code: errInvalidGrant},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := s.post("/oauth/token", tt.form, tt.basic)
+			if w.Code != tt.status {
+				t.Fatalf("got status %d, want %d", w.Code, tt.status)
+			}
+			if w.Header().Get("Cache-Control") != "no-store" || !strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+				t.Fatalf("unexpected headers %v", w.Header())
+			}
+			if challenge := w.Header().Get("WWW-Authenticate") != ""; challenge != tt.challenge {
+				t.Fatalf("got WWW-Authenticate %q, want challenge %v", w.Header().Get("WWW-Authenticate"), tt.challenge)
+			}
+			if res := decode[errorResponse](t, w); res.Error != tt.code {
+				t.Fatalf("got error %q, want %q", res.Error, tt.code)
+			}
+		})
+	}
+
+	// failed client authentication of the client credentials grant is audited under the claimed id
+	var audited bool
+	s.rec.mtx.Lock()
+	for _, ev := range s.rec.events {
+		audited = audited || (ev.Login == s.svc.id && ev.Method == storage.LoginMethodClientCredentials && !ev.Success)
+	}
+	s.rec.mtx.Unlock()
+	if !audited {
+		t.Fatal("failed client authentication isn't audited")
+	}
+}
+
+func TestTokenGrant(t *testing.T) {
+	s := newServer(t)
+	clientCredentials := url.Values{"grant_type": {"client_credentials"}}
+	withScope := func(form url.Values, scope string) url.Values {
+		res := url.Values{"scope": {scope}}
+		for k, v := range form {
+			res[k] = v
+		}
+		return res
+	}
+	password := url.Values{"grant_type": {"password"}, "username": {"bob"}, "password": {"bob-password"}}
+	tests := []struct {
+		name  string
+		form  url.Values
+		basic testClient
+		scope string
+	}{
+		{name: "basic client auth", form: clientCredentials, basic: s.svc, scope: "asset:read asset:write"},
+		{name: "form client auth", form: withScope(url.Values{"grant_type": {"client_credentials"},
+			"client_id": {s.svc.id}, "client_secret": {s.svc.secret}}, "asset:read"), scope: "asset:read"},
+		{name: "escaped basic credentials", form: clientCredentials,
+			basic: testClient{id: escaped(s.svc.id), secret: escaped(s.svc.secret)}, scope: "asset:read asset:write"},
+		{name: "narrowed scope", form: withScope(clientCredentials, "asset:write"), basic: s.svc, scope: "asset:write"},
+		{name: "public client password grant", form: withScope(password, "asset:read apikey:manage"), scope: "asset:read apikey:manage"},
+		{name: "confidential client password grant", form: password, basic: s.rs, scope: "asset:read"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := s.post("/oauth/token", tt.form, tt.basic)
+			if w.Code != http.StatusOK {
+				t.Fatalf("got status %d, body %q", w.Code, w.Body.String())
+			}
+			res := decode[tokenResponse](t, w)
+			if res.TokenType != "Bearer" || res.AccessToken == "" || res.ExpiresIn <= 0 || res.Scope != tt.scope {
+				t.Fatalf("got response %+v, want scope %q", res, tt.scope)
+			}
+		})
+	}
+
+	// the password grant of a confidential client is limited by its scope
+	w := s.post("/oauth/token", withScope(password, "asset:write"), s.rs)
+	if w.Code != http.StatusBadRequest || decode[errorResponse](t, w).Error != errInvalidScope {
+		t.Fatalf("got status %d for scope beyond the client, want %s", w.Code, errInvalidScope)
+	}
+}
+
+func TestIntrospect(t *testing.T) {
+	s := newServer(t)
+	w := s.post("/oauth/token", url.Values{"grant_type": {"client_credentials"}, "scope": {"asset:read"}}, s.svc)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, body %q", w.Code, w.Body.String())
+	}
+	token := decode[tokenResponse](t, w).AccessToken
+
+	if w = s.post("/oauth/introspect", url.Values{"token": {token}}, testClient{}); w.Code != http.StatusUnauthorized ||
+		w.Header().Get("WWW-Authenticate") == "" {
+		t.Fatalf("got status %d without client authentication, want %d with challenge", w.Code, http.StatusUnauthorized)
+	}
+	if w = s.post("/oauth/introspect", url.Values{}, s.rs); w.Code != http.StatusBadRequest ||
+		decode[errorResponse](t, w).Error != errInvalidRequest {
+		t.Fatalf("got status %d without token, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	w = s.post("/oauth/introspect", url.Values{"token": {token}}, s.rs)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, body %q", w.Code, w.Body.String())
+	}
+	res := decode[introspectionResponse](t, w)
+	if !res.Active || res.Scope != "asset:read" || res.ClientId != s.svc.id || res.Username != "bob" ||
+		res.Sub != authStorage.ClientSubject(s.svc.id) || res.Sid == "" || res.Exp <= res.Iat {
+		t.Fatalf("unexpected introspection %+v", res)
+	}
+
+	if err := s.auth.RevokeSession(context.Background(), res.Sid); err != nil {
+		t.Fatal(err)
+	}
+	for _, tkn := range []string{token, "not-a-token"} {
+		w = s.post("/oauth/introspect", url.Values{"token": {tkn}}, s.rs)
+		// inactive tokens carry nothing but the flag
+		if w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != `{"active":false}` {
+			t.Fatalf("got status %d, body %q for inactive token", w.Code, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package oidcHandlers
+
+import (
+	myerrors "clearway-test-task/internal/errors"
+	"clearway-test-task/internal/net/http/handlers/authHandlers"
+	"clearway-test-task/internal/net/http/middleware/authMiddleware"
+	"clearway-test-task/internal/net/http/middleware/logMiddleware"
+	"clearway-test-task/internal/storage"
+	"clearway-test-task/pkg"
+	"clearway-test-task/pkg/oidc"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// stateCookie binds the authorization request to the browser which started it. It holds the sealed request,
+// so the callback can be served by any instance
+const stateCookie = "oidc_state"
+
+type token struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int64  `json:"expires_in"`
+	TokenType   string `json:"token_type"`
+}
+
+type linkRequest struct {
+	Subject string `json:"subject"`
+	Login   string `json:"login"`
+}
+
+type OidcHandler struct {
+	authCodeUrl   func(ctx context.Context) (string, string, error)
+	exchange      func(ctx context.Context, sealed, state, code string) (oidc.Identity, error)
+	externalLogin func(ctx context.Context, issuer, subject, preferredLogin string, provision bool) (string, int64, error)
+	linkIdentity  func(ctx context.Context, issuer, subject, login string) error
+	issuer        string
+	loginClaim    string
+	autoProvision bool
+}
+
+func NewOidcHandler(AuthCodeUrl func(ctx context.Context) (string, string, error),
+	Exchange func(ctx context.Context, sealed, state, code string) (oidc.Identity, error),
+	ExternalLogin func(ctx context.Context, issuer, subject, preferredLogin string, provision bool) (string, int64, error),
+	LinkIdentity func(ctx context.Context, issuer, subject, login string) error,
+	issuer string,
+	loginClaim string,
+	autoProvision bool) *OidcHandler {
+	return &OidcHandler{
+		authCodeUrl:   AuthCodeUrl,
+		exchange:      Exchange,
+		externalLogin: ExternalLogin,
+		linkIdentity:  LinkIdentity,
+		issuer:        issuer,
+		loginClaim:    loginClaim,
+		autoProvision: autoProvision,
+	}
+}
+
+func RegOidcHandlers(login http.Handler, callback http.Handler, link http.Handler) {
+	http.Handle("GET /oidc/login", login)
+	http.Handle("GET /oidc/callback", callback)
+	http.Handle("POST /admin/identities", link)
+}
+
+// LoginGet redirects to the provider authorization endpoint
+func (o *OidcHandler) LoginGet() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		const fn string = "OidcLoginGet"
+		lg := logMiddleware.SetupLoggerFromContext(fn, r)
+
+		u, sealed, err := o.authCodeUrl(r.Context())
+		if err != nil {
+			lg.Error("failed to start authorization", "error", err)
+			http.Error(w, "", http.StatusBadGateway)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     stateCookie,
+			Value:    sealed,
+			Path:     "/oidc",
+			MaxAge:   600,
+			Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+		w.Header().Set("Cache-Control", "no-store")
+		http.Redirect(w, r, u, http.StatusFound)
+		lg.Info("success")
+	})
+}
+
+// CallbackGet completes the authorization and issues a session token of this service
+func (o *OidcHandler) CallbackGet() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		const fn string = "OidcCallbackGet"
+		lg := logMiddleware.SetupLoggerFromContext(fn, r)
+
+		q := r.URL.Query()
+		if e := q.Get("error"); e != "" {
+			lg.Error("provider returned error", "error", e, "Description", q.Get("error_description"))
+			http.Error(w, "", http.StatusUnauthorized)
+			return
+		}
+		state, code := q.Get("state"), q.Get("code")
+		if state == "" || code == "" {
+			lg.Error("state and code required", "error", "state and code required")
+			http.Error(w, "", http.StatusBadRequest)
+			return
+		}
+		c, err := r.Cookie(stateCookie)
+		if err != nil {
+			lg.Error("state cookie required", "error", err)
+			http.Error(w, "", http.StatusBadRequest)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: stateCookie, Path: "/oidc", MaxAge: -1})
+
+		identity, err := o.exchange(r.Context(), c.Value, state, code)
+		if err != nil {
+			lg.Error("failed to complete authorization", "error", err)
+			http.Error(w, "", http.StatusUnauthorized)
+			return
+		}
+		preferred, _ := identity.Claims[o.loginClaim].(string)
+
+		ctx := storage.WithClientInfo(r.Context(), authHandlers.ClientInfo(r))
+		t, exp, err := o.externalLogin(ctx, identity.Issuer, identity.Subject, preferred, o.autoProvision)
+		if err != nil {
+			var identityErr myerrors.ErrIdentityNotFound
+			var existsErr myerrors.ErrUserExists
+			switch {
+			case errors.As(err, &identityErr):
+				lg.Error("identity is not linked to a user", "error", err)
+				http.Error(w, "", http.StatusForbidden)
+			case errors.As(err, &existsErr), errors.Is(err, myerrors.ErrInvalidArgument):
+				lg.Error("failed to provision user", "error", err, "Login", preferred)
+				http.Error(w, "", http.StatusConflict)
+			default:
+				lg.Error("failed to get auth token", "error", err)
+				http.Error(w, "", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		res := token{AccessToken: pkg.Base64Encode(t), ExpiresIn: exp, TokenType: "Bearer"}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if err = json.NewEncoder(w).Encode(res); err != nil {
+			lg.Error("error writing response", "error", err)
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		lg.Info("success", "Issuer", identity.Issuer, "Subject", identity.Subject)
+	})
+}
+
+// IdentityPost links a subject of the configured provider to an existing user
+func (o *OidcHandler) IdentityPost() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() { _ = r.Body.Close() }()
+		const fn string = "IdentityPost"
+		lg := logMiddleware.SetupLoggerFromContext(fn, r)
+
+		var req linkRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			lg.Error("error decoding body", "error", err)
+			http.Error(w, "", http.StatusBadRequest)
+			return
+		}
+		if req.Subject == "" {
+			lg.Error("subject required", "error", "subject required")
+			http.Error(w, "", http.StatusBadRequest)
+			return
+		}
+		if err := o.linkIdentity(r.Context(), o.issuer, req.Subject, req.Login); err != nil {
+			var userErr myerrors.ErrUserNotFound
+			switch {
+			case errors.As(err, &userErr):
+				lg.Error("user does not exist", "error", err, "Login", req.Login)
+				http.Error(w, "", http.StatusNotFound)
+			case errors.Is(err, myerrors.ErrInvalidArgument):
+				lg.Error("invalid identity", "error", err, "Subject", req.Subject)
+				http.Error(w, "", http.StatusConflict)
+			default:
+				lg.Error("error linking identity", "error", err)
+				http.Error(w, "", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusCreated)
+		if _, err := w.Write([]byte("{\"status\":\"ok\"}")); err != nil {
+			lg.Error("error writing response", "error", err)
+			return
+		}
+		admin, _ := authMiddleware.GetLoginFromContext(r.Context())
+		lg.Info("success", "Admin", admin, "Subject", req.Subject, "Login", req.Login)
+	})
+}
@@ -0,0 +1,157 @@
+package userHandlers
+
+import (
+	myerrors "clearway-test-task/internal/errors"
+	"clearway-test-task/internal/net/http/middleware/authMiddleware"
+	"clearway-test-task/internal/net/http/middleware/logMiddleware"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+)
+
+type codeRequest struct {
+	Code string `json:"code"`
+}
+
+type enrollResponse struct {
+	Secret string `json:"secret"`
+	Uri    string `json:"otpauth_uri"`
+}
+
+type confirmResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type TotpHandler struct {
+	enroll  func(ctx context.Context, login string) (string, string, error)
+	confirm func(ctx context.Context, login, code string) ([]string, error)
+	disable func(ctx context.Context, login, code string) error
+}
+
+func NewTotpHandler(EnrollTotp func(ctx context.Context, login string) (string, string, error),
+	ConfirmTotp func(ctx context.Context, login, code string) ([]string, error),
+	DisableTotp func(ctx context.Context, login, code string) error) *TotpHandler {
+	return &TotpHandler{
+		enroll:  EnrollTotp,
+		confirm: ConfirmTotp,
+		disable: DisableTotp,
+	}
+}
+
+func RegTotpHandlers(enroll http.Handler, confirm http.Handler, del http.Handler) {
+	http.Handle("POST /me/totp", enroll)
+	http.Handle("POST /me/totp/confirm", confirm)
+	http.Handle("DELETE /me/totp", del)
+}
+
+func (t *TotpHandler) TotpPost() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() { _ = r.Body.Close() }()
+		const fn string = "TotpPost"
+		lg := logMiddleware.SetupLoggerFromContext(fn, r)
+
+		login, ok := authMiddleware.GetLoginFromContext(r.Context())
+		if !ok {
+			lg.Error("login not found", "error", "login not found")
+			http.Error(w, "", http.StatusBadRequest)
+			return
+		}
+		secret, uri, err := t.enroll(r.Context(), login)
+		if err != nil {
+			handleTotpError(w, lg, err, login)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		if err = json.NewEncoder(w).Encode(enrollResponse{Secret: secret, Uri: uri}); err != nil {
+			lg.Error("error writing response", "error", err)
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		lg.Info("success", "Login", login)
+	})
+}
+
+func (t *TotpHandler) TotpConfirmPost() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() { _ = r.Body.Close() }()
+		const fn string = "TotpConfirmPost"
+		lg := logMiddleware.SetupLoggerFromContext(fn, r)
+
+		login, ok := authMiddleware.GetLoginFromContext(r.Context())
+		if !ok {
+			lg.Error("login not found", "error", "login not found")
+			http.Error(w, "", http.StatusBadRequest)
+			return
+		}
+		var req codeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			lg.Error("error decoding body", "error", err)
+			http.Error(w, "", http.StatusBadRequest)
+			return
+		}
+		codes, err := t.confirm(r.Context(), login, req.Code)
+		if err != nil {
+			handleTotpError(w, lg, err, login)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		if err = json.NewEncoder(w).Encode(confirmResponse{RecoveryCodes: codes}); err != nil {
+			lg.Error("error writing response", "error", err)
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		lg.Info("success", "Login", login)
+	})
+}
+
+func (t *TotpHandler) TotpDelete() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() { _ = r.Body.Close() }()
+		const fn string = "TotpDelete"
+		lg := logMiddleware.SetupLoggerFromContext(fn, r)
+
+		login, ok := authMiddleware.GetLoginFromContext(r.Context())
+		if !ok {
+			lg.Error("login not found", "error", "login not found")
+			http.Error(w, "", http.StatusBadRequest)
+			return
+		}
+		var req codeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			lg.Error("error decoding body", "error", err)
+			http.Error(w, "", http.StatusBadRequest)
+			return
+		}
+		if err := t.disable(r.Context(), login, req.Code); err != nil {
+			handleTotpError(w, lg, err, login)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		if _, err := w.Write([]byte("{\"status\":\"ok\"}")); err != nil {
+			lg.Error("error writing response", "error", err)
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		lg.Info("success", "Login", login)
+	})
+}
+
+func handleTotpError(w http.ResponseWriter, lg *slog.Logger, err error, login string) {
+	var userErr myerrors.ErrUserNotFound
+	switch {
+	case errors.As(err, &userErr):
+		lg.Error("user does not exist", "error", err, "Login", login)
+		http.Error(w, "", http.StatusNotFound)
+	case errors.Is(err, myerrors.ErrOtpInvalid):
+		lg.Error("invalid one-time code", "error", err, "Login", login)
+		http.Error(w, "", http.StatusForbidden)
+	case errors.Is(err, myerrors.ErrInvalidArgument):
+		lg.Error("invalid two-factor state", "error", err, "Login", login)
+		http.Error(w, "", http.StatusConflict)
+	default:
+		lg.Error("two-factor error", "error", err)
+		http.Error(w, "", http.StatusInternalServerError)
+	}
+}
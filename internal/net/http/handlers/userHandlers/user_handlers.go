@@ -0,0 +1,168 @@
+package userHandlers
+
+import (
+	myerrors "clearway-test-task/internal/errors"
+	"clearway-test-task/internal/net/http/handlers/authHandlers"
+	"clearway-test-task/internal/net/http/middleware/authMiddleware"
+	"clearway-test-task/internal/net/http/middleware/logMiddleware"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+type registerRequest struct {
+	Login    string `json:"login"`
+	Password string `json:"password"`
+}
+
+type changePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+type UserHandler struct {
+	register       func(ctx context.Context, login, password string) error
+	changePassword func(ctx context.Context, login, currentPassword, newPassword string) error
+	deleteUser     func(ctx context.Context, login string) error
+}
+
+func NewUserHandler(Register func(ctx context.Context, login, password string) error,
+	ChangePassword func(ctx context.Context, login, currentPassword, newPassword string) error,
+	DeleteUser func(ctx context.Context, login string) error) *UserHandler {
+	return &UserHandler{
+		register:       Register,
+		changePassword: ChangePassword,
+		deleteUser:     DeleteUser,
+	}
+}
+
+// RegUserHandlers registers account management routes. Registration route is skipped when register is nil
+func RegUserHandlers(register http.Handler, changePassword http.Handler, del http.Handler) {
+	if register != nil {
+		http.Handle("POST /users", register)
+	}
+	http.Handle("PUT /me/password", changePassword)
+	http.Handle("DELETE /me", del)
+}
+
+func (u *UserHandler) UserPost() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() { _ = r.Body.Close() }()
+		const fn string = "UserPost"
+		lg := logMiddleware.SetupLoggerFromContext(fn, r)
+
+		var req registerRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			lg.Error("error decoding body", "error", err)
+			http.Error(w, "", http.StatusBadRequest)
+			return
+		}
+		if err := u.register(r.Context(), req.Login, req.Password); err != nil {
+			var existsErr myerrors.ErrUserExists
+			var policyErr myerrors.ErrPasswordPolicy
+			switch {
+			case errors.As(err, &existsErr):
+				lg.Error("user already exists", "error", err, "Login", existsErr.Login)
+				http.Error(w, "", http.StatusConflict)
+			case errors.As(err, &policyErr):
+				lg.Info("password policy violated", "error", err, "Login", req.Login)
+				authHandlers.WritePolicyError(w, lg, policyErr)
+			case errors.Is(err, myerrors.ErrInvalidArgument):
+				lg.Error("invalid credentials format", "error", err)
+				http.Error(w, "", http.StatusBadRequest)
+			default:
+				lg.Error("error registering user", "error", err)
+				http.Error(w, "", http.StatusInternalServerError)
+			}
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusCreated)
+		if _, err := w.Write([]byte("{\"status\":\"ok\"}")); err != nil {
+			lg.Error("error writing response", "error", err)
+			return
+		}
+		lg.Info("success", "Login", req.Login)
+	})
+}
+
+func (u *UserHandler) PasswordPut() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() { _ = r.Body.Close() }()
+		const fn string = "PasswordPut"
+		lg := logMiddleware.SetupLoggerFromContext(fn, r)
+
+		login, ok := authMiddleware.GetLoginFromContext(r.Context())
+		if !ok {
+			lg.Error("login not found", "error", "login not found")
+			http.Error(w, "", http.StatusBadRequest)
+			return
+		}
+		var req changePasswordRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			lg.Error("error decoding body", "error", err)
+			http.Error(w, "", http.StatusBadRequest)
+			return
+		}
+		if err := u.changePassword(r.Context(), login, req.CurrentPassword, req.NewPassword); err != nil {
+			var userErr myerrors.ErrUserNotFound
+			var policyErr myerrors.ErrPasswordPolicy
+			switch {
+			case errors.As(err, &userErr), errors.Is(err, myerrors.ErrNotFound):
+				lg.Error("current password verification failed", "error", err, "Login", login)
+				http.Error(w, "", http.StatusForbidden)
+			case errors.As(err, &policyErr):
+				lg.Info("password policy violated", "error", err, "Login", login)
+				authHandlers.WritePolicyError(w, lg, policyErr)
+			case errors.Is(err, myerrors.ErrInvalidArgument):
+				lg.Error("invalid new password", "error", err)
+				http.Error(w, "", http.StatusBadRequest)
+			default:
+				lg.Error("error changing password", "error", err)
+				http.Error(w, "", http.StatusInternalServerError)
+			}
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		if _, err := w.Write([]byte("{\"status\":\"ok\"}")); err != nil {
+			lg.Error("error writing response", "error", err)
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		lg.Info("success", "Login", login)
+	})
+}
+
+func (u *UserHandler) MeDelete() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() { _ = r.Body.Close() }()
+		const fn string = "MeDelete"
+		lg := logMiddleware.SetupLoggerFromContext(fn, r)
+
+		login, ok := authMiddleware.GetLoginFromContext(r.Context())
+		if !ok {
+			lg.Error("login not found", "error", "login not found")
+			http.Error(w, "", http.StatusBadRequest)
+			return
+		}
+		if err := u.deleteUser(r.Context(), login); err != nil {
+			var userErr myerrors.ErrUserNotFound
+			if errors.As(err, &userErr) {
+				lg.Error("user does not exist", "error", err, "Login", login)
+				http.Error(w, "", http.StatusNotFound)
+				return
+			}
+			lg.Error("error deleting user", "error", err)
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		if _, err := w.Write([]byte("{\"status\":\"ok\"}")); err != nil {
+			lg.Error("error writing response", "error", err)
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		lg.Info("success", "Login", login)
+	})
+}
@@ -0,0 +1,131 @@
+package userHandlers
+
+import (
+	"clearway-test-task/internal/net/http/middleware/authMiddleware"
+	"clearway-test-task/internal/storage/authStorage"
+	"clearway-test-task/internal/storage/memoryStorage"
+	"clearway-test-task/internal/storage/sessionStorage"
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestAuth(t *testing.T) *authStorage.AuthStorage {
+	t.Helper()
+	lg := slog.New(slog.NewTextHandler(io.Discard, nil))
+	policy, err := authStorage.NewPasswordPolicy(8, 1, 0, 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := authStorage.NewAuthStorage(memoryStorage.NewMemoryStorage(), sessionStorage.NewShardedStorage(4), time.Second,
+		authStorage.BcryptPasswordValidator{Cost: 4}, policy, time.Hour, time.Hour, "0123456789abcdef", "test", nil, false, nil, lg)
+	t.Cleanup(func() { _ = auth.Close() })
+	return auth
+}
+
+// serve runs h as login, anonymously when login is empty
+func serve(h http.Handler, method, login, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, "/", strings.NewReader(body))
+	if login != "" {
+		r = r.WithContext(context.WithValue(r.Context(), authMiddleware.UserKey, login))
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+// login issues a token and checks it is valid
+func login(t *testing.T, auth *authStorage.AuthStorage, login, password string) string {
+	t.Helper()
+	token, _, err := auth.GetToken(context.Background(), login, password, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = auth.ValidateToken(token); err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestUserPost(t *testing.T) {
+	auth := newTestAuth(t)
+	h := NewUserHandler(auth.Register, auth.ChangePassword, auth.DeleteUser).UserPost()
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{name: "created", body: `{"login":"bob","password":"password1"}`, status: http.StatusCreated},
+		{name: "exists", body: `{"login":"bob","password":"password2"}`, status: http.StatusConflict},
+		{name: "invalid login", body: `{"login":"b!","password":"password1"}`, status: http.StatusBadRequest},
+		{name: "weak password", body: `{"login":"ann","password":"short"}`, status: http.StatusBadRequest},
+		{name: "malformed body", body: `{"login":`, status: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(h, http.MethodPost, "", tt.body)
+			if w.Code != tt.status {
+				t.Fatalf("got status %d, want %d", w.Code, tt.status)
+			}
+		})
+	}
+	login(t, auth, "bob", "password1")
+}
+
+func TestPasswordPut(t *testing.T) {
+	auth := newTestAuth(t)
+	if err := auth.Register(context.Background(), "bob", "password1"); err != nil {
+		t.Fatal(err)
+	}
+	token := login(t, auth, "bob", "password1")
+	h := NewUserHandler(auth.Register, auth.ChangePassword, auth.DeleteUser).PasswordPut()
+
+	if w := serve(h, http.MethodPut, "bob", `{"current_password":"wrong-one1","new_password":"password2"}`); w.Code != http.StatusForbidden {
+		t.Fatalf("got status %d for wrong current password, want %d", w.Code, http.StatusForbidden)
+	}
+	if _, err := auth.ValidateToken(token); err != nil {
+		t.Fatalf("session revoked by failed change: %v", err)
+	}
+	if w := serve(h, http.MethodPut, "", `{"current_password":"password1","new_password":"password2"}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("got status %d without login, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	if w := serve(h, http.MethodPut, "bob", `{"current_password":"password1","new_password":"password2"}`); w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusOK)
+	}
+	if _, err := auth.ValidateToken(token); err == nil {
+		t.Fatal("session valid after password change")
+	}
+	if _, _, err := auth.GetToken(context.Background(), "bob", "password1", ""); err == nil {
+		t.Fatal("old password accepted")
+	}
+	login(t, auth, "bob", "password2")
+}
+
+func TestMeDelete(t *testing.T) {
+	auth := newTestAuth(t)
+	if err := auth.Register(context.Background(), "bob", "password1"); err != nil {
+		t.Fatal(err)
+	}
+	token := login(t, auth, "bob", "password1")
+	h := NewUserHandler(auth.Register, auth.ChangePassword, auth.DeleteUser).MeDelete()
+
+	if w := serve(h, http.MethodDelete, "bob", ""); w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusOK)
+	}
+	if _, err := auth.ValidateToken(token); err == nil {
+		t.Fatal("session valid after deleting the user")
+	}
+	if _, _, err := auth.GetToken(context.Background(), "bob", "password1", ""); err == nil {
+		t.Fatal("deleted user logged in")
+	}
+	if w := serve(h, http.MethodDelete, "bob", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("got status %d deleting twice, want %d", w.Code, http.StatusNotFound)
+	}
+}
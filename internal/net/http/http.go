@@ -1,27 +1,47 @@
 package http
 
 import (
+	"clearway-test-task/internal/net/http/handlers/adminHandlers"
+	"clearway-test-task/internal/net/http/handlers/apiKeyHandlers"
 	"clearway-test-task/internal/net/http/handlers/assetHandlers"
 	"clearway-test-task/internal/net/http/handlers/authHandlers"
+	"clearway-test-task/internal/net/http/handlers/healthHandlers"
+	"clearway-test-task/internal/net/http/handlers/metricsHandlers"
+	"clearway-test-task/internal/net/http/handlers/oauthHandlers"
+	"clearway-test-task/internal/net/http/handlers/oidcHandlers"
+	"clearway-test-task/internal/net/http/handlers/userHandlers"
 	"clearway-test-task/internal/net/http/middleware/authMiddleware"
 	"clearway-test-task/internal/net/http/middleware/logMiddleware"
+	"clearway-test-task/internal/net/http/middleware/metricsMiddleware"
+	"clearway-test-task/internal/net/http/middleware/traceMiddleware"
+	"clearway-test-task/internal/net/http/middleware/unavailableMiddleware"
 	"clearway-test-task/internal/storage"
+	"clearway-test-task/pkg/oidc"
 	"context"
 	"log/slog"
 	"net/http"
+	"os"
 	"time"
 )
 
 type HttpServer struct {
 	svr     *http.Server
+	health  *healthHandlers.HealthHandler
 	timeout time.Duration
 }
 
 func NewHttpServer(host, port string, ReadTimeout, WriteTimeout, IdleTimeout time.Duration,
-	ValidateToken func(token string) (string, error),
-	GetToken func(ctx context.Context, login string, password string) (string, int64, error),
+	registrationEnabled bool,
+	auth storage.Auth,
+	limiter storage.LoginLimiter,
+	auditor storage.Auditor,
+	rp *oidc.RelyingParty,
+	oidcLoginClaim string,
+	oidcAutoProvision bool,
 	loggerForHandlers func() *slog.Logger,
-	db storage.Db) *HttpServer {
+	accessLogFormat string,
+	db storage.Db,
+	health *healthHandlers.HealthHandler) *HttpServer {
 	svr := &HttpServer{
 		svr: &http.Server{
 			Addr:         host + ":" + port,
@@ -29,26 +49,106 @@ func NewHttpServer(host, port string, ReadTimeout, WriteTimeout, IdleTimeout tim
 			WriteTimeout: WriteTimeout,
 			IdleTimeout:  IdleTimeout,
 		},
+		health:  health,
 		timeout: ReadTimeout,
 	}
 
 	assetH := assetHandlers.NewAssetHandler(db)
-	authH := authHandlers.NewAuthHandler(GetToken)
+	authH := authHandlers.NewAuthHandler(auth.GetToken, auth.ChangeExpiredPassword, limiter, auditor)
+	apiKeyH := apiKeyHandlers.NewApiKeyHandler(auth.CreateApiKey, auth.GetApiKeys, auth.RevokeApiKey)
+	userH := userHandlers.NewUserHandler(auth.Register, auth.ChangePassword, auth.DeleteUser)
+	totpH := userHandlers.NewTotpHandler(auth.EnrollTotp, auth.ConfirmTotp, auth.DisableTotp)
+	oauthH := oauthHandlers.NewOauthHandler(auth.PasswordGrant, auth.ClientCredentialsGrant, auth.AuthenticateClient,
+		auth.ValidateToken, limiter, auditor)
+	adminH := adminHandlers.NewAdminHandler(limiter, auth.CreateClient, auth.GetClients, auth.DeleteClient)
+	sessionH := adminHandlers.NewSessionHandler(auth.GetSessions, auth.RevokeSession, auth.RevokeSessionsByLogin,
+		auth.RevokeSessionsIssuedBefore)
+	auditH := adminHandlers.NewAuditHandler(auth.GetLoginEvents)
 
-	authM := authMiddleware.NewAuthMiddleware(ValidateToken)
+	authM := authMiddleware.NewAuthMiddleware(auth.ValidateToken, auth.ValidateApiKey, auth.IsAdmin)
 
-	AssetGet := logMiddleware.NewLoggerMiddleware(loggerForHandlers, authM.WithBasicAuth(assetH.AssetGet()))
-	AssetPost := logMiddleware.NewLoggerMiddleware(loggerForHandlers, authM.WithBasicAuth(assetH.AssetPost()))
-	AssetDelete := logMiddleware.NewLoggerMiddleware(loggerForHandlers, authM.WithBasicAuth(assetH.AssetDelete()))
+	accessLogM := logMiddleware.NewAccessLogMiddleware(accessLogFormat, os.Stdout)
+	withLogger := func(next http.Handler) http.Handler {
+		inner := metricsMiddleware.Handle(unavailableMiddleware.Handle(next))
+		return logMiddleware.NewLoggerMiddleware(loggerForHandlers, traceMiddleware.Handle(accessLogM.Handle(inner))).Handle()
+	}
+	withAuth := func(scope string, next http.Handler) http.Handler {
+		return withLogger(traceMiddleware.Span("WithBasicAuth", authM.WithBasicAuth(
+			traceMiddleware.Span("RequireScope", authMiddleware.RequireScope(scope, next)))))
+	}
+	withAdmin := func(next http.Handler) http.Handler {
+		return withAuth("admin", traceMiddleware.Span("WithAdmin", authM.WithAdmin(next)))
+	}
 
-	AuthPost := logMiddleware.NewLoggerMiddleware(loggerForHandlers, authH.AuthPost())
+	healthHandlers.RegHealthHandlers(
+		withLogger(health.HealthzGet()),
+		withLogger(health.ReadyzGet()),
+		withAdmin(health.StatusGet()),
+	)
+	metricsHandlers.RegMetricsHandlers(withLogger(metricsHandlers.MetricsGet()))
+	assetHandlers.RegAssetHandlers(
+		withAuth("asset:read", assetH.AssetGet()),
+		withAuth("asset:write", assetH.AssetPost()),
+		withAuth("asset:write", assetH.AssetDelete()),
+	)
+	authHandlers.RegAuthHandlers(withLogger(authH.AuthPost()), withLogger(authH.PasswordPut()))
+	oauthHandlers.RegOauthHandlers(withLogger(oauthH.TokenPost()), withLogger(oauthH.IntrospectPost()))
+	apiKeyHandlers.RegApiKeyHandlers(
+		withAuth("apikey:manage", apiKeyH.ApiKeyGet()),
+		withAuth("apikey:manage", apiKeyH.ApiKeyPost()),
+		withAuth("apikey:manage", apiKeyH.ApiKeyDelete()),
+	)
+
+	var register http.Handler
+	if registrationEnabled {
+		register = withLogger(userH.UserPost())
+	}
+	userHandlers.RegUserHandlers(
+		register,
+		withAuth("account:manage", userH.PasswordPut()),
+		withAuth("account:manage", userH.MeDelete()),
+	)
 
-	assetHandlers.RegAssetHandlers(AssetGet.Handle(), AssetPost.Handle(), AssetDelete.Handle())
-	authHandlers.RegAuthHandlers(AuthPost.Handle())
+	userHandlers.RegTotpHandlers(
+		withAuth("account:manage", totpH.TotpPost()),
+		withAuth("account:manage", totpH.TotpConfirmPost()),
+		withAuth("account:manage", totpH.TotpDelete()),
+	)
+	adminHandlers.RegAdminHandlers(
+		withAdmin(adminH.LockoutLoginDelete()),
+		withAdmin(adminH.LockoutIpDelete()),
+	)
+	adminHandlers.RegClientHandlers(
+		withAdmin(adminH.ClientsGet()),
+		withAdmin(adminH.ClientsPost()),
+		withAdmin(adminH.ClientDelete()),
+	)
+	adminHandlers.RegSessionHandlers(
+		withAdmin(sessionH.SessionsGet()),
+		withAdmin(sessionH.SessionsDelete()),
+		withAdmin(sessionH.SessionDelete()),
+	)
+	adminHandlers.RegAuditHandlers(withAdmin(auditH.LoginEventsGet()))
+
+	// external identity provider login is available only when configured
+	if rp != nil {
+		oidcH := oidcHandlers.NewOidcHandler(rp.AuthCodeUrl, rp.Exchange, auth.ExternalLogin, auth.LinkIdentity,
+			rp.Issuer(), oidcLoginClaim, oidcAutoProvision)
+		oidcHandlers.RegOidcHandlers(
+			withLogger(oidcH.LoginGet()),
+			withLogger(oidcH.CallbackGet()),
+			withAdmin(oidcH.IdentityPost()),
+		)
+	}
 
 	return svr
 }
 
+// Drain fails readiness checks while requests are still served, so the instance is taken out of rotation first
+func (s *HttpServer) Drain() {
+	s.health.Drain()
+}
+
 func (s *HttpServer) Close(lg *slog.Logger) error {
 	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
 	defer cancel()
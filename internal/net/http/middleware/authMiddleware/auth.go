@@ -1,7 +1,9 @@
 package authMiddleware
 
 import (
+	myerrors "clearway-test-task/internal/errors"
 	"clearway-test-task/internal/net/http/middleware/logMiddleware"
+	"clearway-test-task/internal/storage"
 	"clearway-test-task/pkg"
 	"clearway-test-task/pkg/validator"
 	"context"
@@ -12,13 +14,22 @@ import (
 
 const validateTokenTag string = "jwt"
 const UserKey string = "user"
+const ScopeKey string = "scope"
+
+// ApiKeyHeader carries an api key. Alternatively the key can be sent as "Authorization: ApiKey <key>"
+const ApiKeyHeader string = "X-API-Key"
+const apiKeyScheme string = "apikey"
 
 type AuthMiddleware struct {
-	validateToken func(token string) (string, error)
+	validateToken  func(token string) (storage.Claims, error)
+	validateApiKey func(ctx context.Context, key string) (string, string, error)
+	isAdmin        func(ctx context.Context, login string) (bool, error)
 }
 
-func NewAuthMiddleware(validateToken func(token string) (string, error)) *AuthMiddleware {
-	return &AuthMiddleware{validateToken: validateToken}
+func NewAuthMiddleware(validateToken func(token string) (storage.Claims, error),
+	validateApiKey func(ctx context.Context, key string) (string, string, error),
+	isAdmin func(ctx context.Context, login string) (bool, error)) *AuthMiddleware {
+	return &AuthMiddleware{validateToken: validateToken, validateApiKey: validateApiKey, isAdmin: isAdmin}
 }
 
 func (a *AuthMiddleware) WithBasicAuth(next http.Handler) http.Handler {
@@ -26,6 +37,21 @@ func (a *AuthMiddleware) WithBasicAuth(next http.Handler) http.Handler {
 		const fn string = "WithAuth"
 		lg := logMiddleware.SetupLoggerFromContext(fn, r)
 
+		if key, ok := getApiKeyFromRequest(r); ok {
+			login, scope, err := a.validateApiKey(r.Context(), key)
+			if err != nil {
+				lg.Error("api key authorization error", "error", err)
+				http.Error(w, "", http.StatusUnauthorized)
+				return
+			}
+			logMiddleware.SetLogin(r.Context(), login)
+			ctx := context.WithValue(r.Context(), UserKey, login)
+			ctx = context.WithValue(ctx, ScopeKey, scope)
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+
 		token, err := getTokenFromRequest(r)
 		if err != nil {
 			lg.Error("token error", "error", err)
@@ -33,19 +59,79 @@ func (a *AuthMiddleware) WithBasicAuth(next http.Handler) http.Handler {
 			return
 		}
 
-		uid, err := a.validateToken(token)
+		claims, err := a.validateToken(token)
 		if err != nil {
+			// sessions missing from the local store are read from db, which isn't done under the request context
+			var unavailable myerrors.ErrRetryAfter
+			if errors.As(err, &unavailable) {
+				storage.MarkUnavailable(r.Context(), unavailable.After)
+			}
 			lg.Error("authorization error", "error", err)
 			http.Error(w, "", http.StatusUnauthorized)
 			return
 		}
 
-		ctx := context.WithValue(r.Context(), UserKey, uid)
+		logMiddleware.SetLogin(r.Context(), claims.Login)
+		ctx := context.WithValue(r.Context(), UserKey, claims.Login)
+		ctx = context.WithValue(ctx, ScopeKey, claims.Scope)
 
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// WithAdmin rejects requests of non-admin users. Must be used after WithBasicAuth
+func (a *AuthMiddleware) WithAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		const fn string = "WithAdmin"
+		lg := logMiddleware.SetupLoggerFromContext(fn, r)
+
+		login, ok := GetLoginFromContext(r.Context())
+		if !ok {
+			lg.Error("login not found", "error", "login not found")
+			http.Error(w, "", http.StatusUnauthorized)
+			return
+		}
+		admin, err := a.isAdmin(r.Context(), login)
+		if err != nil {
+			lg.Error("failed to check user role", "error", err, "Login", login)
+			http.Error(w, "", http.StatusForbidden)
+			return
+		}
+		if !admin {
+			lg.Error("admin role required", "error", "admin role required", "Login", login)
+			http.Error(w, "", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireScope rejects requests whose credentials are restricted to scopes not including scope
+func RequireScope(scope string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		const fn string = "RequireScope"
+		if !HasScope(r.Context(), scope) {
+			lg := logMiddleware.SetupLoggerFromContext(fn, r)
+			lg.Error("insufficient scope", "error", "insufficient scope", "Scope", scope)
+			http.Error(w, "", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// getApiKeyFromRequest retrieves api key either from X-API-Key header or from ApiKey authorization scheme
+func getApiKeyFromRequest(r *http.Request) (string, bool) {
+	if key := r.Header.Get(ApiKeyHeader); key != "" {
+		return key, true
+	}
+	scheme, key, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if ok && strings.ToLower(scheme) == apiKeyScheme && key != "" {
+		return key, true
+	}
+	return "", false
+}
+
 // getTokenFromRequest retrieves the auth header, reads token from it and validates it
 func getTokenFromRequest(r *http.Request) (string, error) {
 	authHeader := r.Header.Get("Authorization")
@@ -75,3 +161,32 @@ func GetLoginFromContext(ctx context.Context) (string, bool) {
 	userID, ok := ctx.Value(UserKey).(string)
 	return userID, ok
 }
+
+// HasScope reports whether credentials in the context grant scope. Unrestricted credentials grant any scope
+func HasScope(ctx context.Context, scope string) bool {
+	granted, _ := ctx.Value(ScopeKey).(string)
+	if granted == "" {
+		return true
+	}
+	for _, s := range strings.Fields(granted) {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// RestrictScope checks that credentials in the context grant every requested scope. Empty request inherits their scope,
+// so credentials can't be used to mint broader ones
+func RestrictScope(ctx context.Context, requested string) (string, bool) {
+	granted, _ := ctx.Value(ScopeKey).(string)
+	if strings.TrimSpace(requested) == "" {
+		return granted, true
+	}
+	for _, s := range strings.Fields(requested) {
+		if !HasScope(ctx, s) {
+			return "", false
+		}
+	}
+	return requested, true
+}
@@ -0,0 +1,105 @@
+package logMiddleware
+
+import (
+	"clearway-test-task/internal/net/http/middleware/responseWriter"
+	"clearway-test-task/pkg"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Access log formats
+const (
+	AccessLogStructured = "structured"
+	AccessLogCommon     = "common"
+	AccessLogCombined   = "combined"
+	AccessLogOff        = "off"
+)
+
+const clfTime = "02/Jan/2006:15:04:05 -0700"
+
+type accessKey struct{}
+
+// access collects data known only to inner handlers
+type access struct {
+	mtx   sync.Mutex
+	login string
+}
+
+// SetLogin records the authenticated login for the access log of the request
+func SetLogin(ctx context.Context, login string) {
+	if a, ok := ctx.Value(accessKey{}).(*access); ok {
+		a.mtx.Lock()
+		a.login = login
+		a.mtx.Unlock()
+	}
+}
+
+// AccessLogMiddleware is shared by all routes, so lines written to out don't interleave
+type AccessLogMiddleware struct {
+	format string
+	// out receives Common and Combined Log Format lines, structured lines go to the request logger
+	out io.Writer
+	mtx sync.Mutex
+}
+
+func NewAccessLogMiddleware(format string, out io.Writer) *AccessLogMiddleware {
+	return &AccessLogMiddleware{format: format, out: out}
+}
+
+// Handle logs a line per request once next served it. Must be used after the logger middleware,
+// so structured lines carry the request id
+func (a *AccessLogMiddleware) Handle(next http.Handler) http.Handler {
+	if a.format == AccessLogOff {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		acc := &access{}
+		rw := responseWriter.NewResponseWriter(w)
+		next.ServeHTTP(rw, r.WithContext(context.WithValue(r.Context(), accessKey{}, acc)))
+
+		acc.mtx.Lock()
+		login := acc.login
+		acc.mtx.Unlock()
+		switch a.format {
+		case AccessLogCommon, AccessLogCombined:
+			a.writeClf(r, rw, login, start)
+		default:
+			lg, ok := GetLoggerFromContext(r.Context())
+			if !ok {
+				lg = pkg.DefaultLogger()
+			}
+			lg.Info("request served",
+				"Status", rw.Status(),
+				"Duration", time.Since(start),
+				"Bytes", rw.Bytes(),
+				"Login", login,
+				"RemoteAddr", pkg.RemoteIp(r),
+			)
+		}
+	})
+}
+
+// writeClf writes a line in Common Log Format, Combined adds referer and user agent
+func (a *AccessLogMiddleware) writeClf(r *http.Request, rw *responseWriter.ResponseWriter, login string, start time.Time) {
+	if login == "" {
+		login = "-"
+	}
+	size := "-"
+	if rw.Bytes() > 0 {
+		size = strconv.FormatInt(rw.Bytes(), 10)
+	}
+	line := fmt.Sprintf("%s - %s [%s] %q %d %s", pkg.RemoteIp(r), login, start.Format(clfTime),
+		r.Method+" "+r.RequestURI+" "+r.Proto, rw.Status(), size)
+	if a.format == AccessLogCombined {
+		line += fmt.Sprintf(" %q %q", r.Referer(), r.UserAgent())
+	}
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	_, _ = io.WriteString(a.out, line+"\n")
+}
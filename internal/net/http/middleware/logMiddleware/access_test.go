@@ -0,0 +1,125 @@
+package logMiddleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+)
+
+// clfTimeRe matches the time of a Common Log Format line, tests replace it with [T]
+var clfTimeRe = regexp.MustCompile(`\[\d{2}/[A-Z][a-z]{2}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}\]`)
+
+func TestAccessLogClf(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		handler http.HandlerFunc
+		want    string
+	}{
+		{
+			name:   "common",
+			format: AccessLogCommon,
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				SetLogin(r.Context(), "bob")
+				w.WriteHeader(http.StatusCreated)
+				_, _ = w.Write([]byte("hello"))
+				_, _ = w.Write([]byte(" world"))
+			},
+			want: `192.0.2.1 - bob [T] "POST /users?x=1 HTTP/1.1" 201 11`,
+		},
+		{
+			name:   "empty body and login",
+			format: AccessLogCommon,
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			},
+			want: `192.0.2.1 - - [T] "POST /users?x=1 HTTP/1.1" 204 -`,
+		},
+		{
+			name:    "nothing written",
+			format:  AccessLogCommon,
+			handler: func(w http.ResponseWriter, r *http.Request) {},
+			want:    `192.0.2.1 - - [T] "POST /users?x=1 HTTP/1.1" 200 -`,
+		},
+		{
+			name:   "implicit status",
+			format: AccessLogCommon,
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte("ok"))
+				// ignored by net/http once the body is written
+				w.WriteHeader(http.StatusInternalServerError)
+			},
+			want: `192.0.2.1 - - [T] "POST /users?x=1 HTTP/1.1" 200 2`,
+		},
+		{
+			name:   "combined",
+			format: AccessLogCombined,
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "", http.StatusForbidden)
+			},
+			want: `192.0.2.1 - - [T] "POST /users?x=1 HTTP/1.1" 403 1 "https://example.com/" "curl/8.0 \"quoted\""`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			h := NewAccessLogMiddleware(tt.format, &out).Handle(tt.handler)
+			r := httptest.NewRequest(http.MethodPost, "/users?x=1", nil)
+			r.Header.Set("Referer", "https://example.com/")
+			r.Header.Set("User-Agent", `curl/8.0 "quoted"`)
+			h.ServeHTTP(httptest.NewRecorder(), r)
+
+			got := clfTimeRe.ReplaceAllString(out.String(), "[T]")
+			if got != tt.want+"\n" {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAccessLogStructured(t *testing.T) {
+	var buf bytes.Buffer
+	lg := slog.New(slog.NewJSONHandler(&buf, nil))
+	var out bytes.Buffer
+	h := NewAccessLogMiddleware(AccessLogStructured, &out).Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		SetLogin(r.Context(), "bob")
+		w.WriteHeader(http.StatusAccepted)
+		_, _ = w.Write([]byte("queued"))
+	}))
+	r := httptest.NewRequest(http.MethodGet, "/assets", nil)
+	h.ServeHTTP(httptest.NewRecorder(), r.WithContext(context.WithValue(r.Context(), LoggerKey, lg)))
+
+	if out.Len() != 0 {
+		t.Fatalf("structured line written to out: %q", out.String())
+	}
+	var line struct {
+		Msg        string `json:"msg"`
+		Status     int
+		Bytes      int64
+		Login      string
+		RemoteAddr string
+		Duration   int64
+	}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("invalid log line %q: %v", buf.String(), err)
+	}
+	if line.Msg != "request served" || line.Status != http.StatusAccepted || line.Bytes != 6 || line.Login != "bob" ||
+		line.RemoteAddr != "192.0.2.1" {
+		t.Fatalf("unexpected log line %s", buf.String())
+	}
+}
+
+func TestAccessLogOff(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	var out bytes.Buffer
+	h := NewAccessLogMiddleware(AccessLogOff, &out).Handle(next)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if out.Len() != 0 {
+		t.Fatalf("got %q with access log off", out.String())
+	}
+}
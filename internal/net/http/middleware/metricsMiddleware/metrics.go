@@ -0,0 +1,28 @@
+package metricsMiddleware
+
+import (
+	"clearway-test-task/internal/metrics"
+	"clearway-test-task/internal/net/http/middleware/responseWriter"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Handle counts requests and observes their latency by the route pattern they matched,
+// so path values like asset names don't make a series each
+func Handle(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rw := responseWriter.NewResponseWriter(w)
+		next.ServeHTTP(rw, r)
+
+		route := r.Pattern
+		if _, path, ok := strings.Cut(route, " "); ok {
+			route = path
+		}
+		code := strconv.Itoa(rw.Status())
+		metrics.HttpRequests.WithLabelValues(route, r.Method, code).Inc()
+		metrics.HttpDuration.WithLabelValues(route, r.Method, code).Observe(time.Since(start).Seconds())
+	})
+}
@@ -0,0 +1,62 @@
+package traceMiddleware
+
+import (
+	"clearway-test-task/internal/net/http/middleware/logMiddleware"
+	"clearway-test-task/internal/net/http/middleware/responseWriter"
+	"clearway-test-task/pkg"
+	"context"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"strings"
+)
+
+var tracer = otel.Tracer("clearway-test-task/internal/net/http")
+
+// Handle starts the server span of the request, continuing the trace of the W3C traceparent header if present.
+// Trace and span ids are added to the request logger, so must be used after the logger middleware
+func Handle(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		route := r.Pattern
+		if _, path, ok := strings.Cut(route, " "); ok {
+			route = path
+		}
+		ctx, span := tracer.Start(ctx, r.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("http.route", route),
+				attribute.String("url.path", r.URL.Path),
+				attribute.String("client.address", pkg.RemoteIp(r)),
+				attribute.String("user_agent.original", r.UserAgent()),
+			))
+		defer span.End()
+
+		if sc := span.SpanContext(); sc.IsValid() {
+			if lg, ok := logMiddleware.GetLoggerFromContext(ctx); ok {
+				ctx = context.WithValue(ctx, logMiddleware.LoggerKey,
+					lg.With("TraceId", sc.TraceID().String(), "SpanId", sc.SpanID().String()))
+			}
+		}
+
+		rw := responseWriter.NewResponseWriter(w)
+		next.ServeHTTP(rw, r.WithContext(ctx))
+		span.SetAttributes(attribute.Int("http.response.status_code", rw.Status()))
+		if rw.Status() >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(rw.Status()))
+		}
+	})
+}
+
+// Span wraps next, usually a middleware, in a span named name
+func Span(name string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := tracer.Start(r.Context(), name)
+		defer span.End()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
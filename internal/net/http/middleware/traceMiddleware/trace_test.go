@@ -0,0 +1,132 @@
+package traceMiddleware
+
+import (
+	"bufio"
+	"bytes"
+	"clearway-test-task/internal/net/http/middleware/logMiddleware"
+	"clearway-test-task/pkg/otlpfile"
+	"context"
+	"encoding/json"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	traceId      = "4bf92f3577b34da6a3ce929d0e0e4736"
+	parentSpanId = "00f067aa0ba902b7"
+)
+
+// exportedSpan holds the fields of an OTLP/JSON span the test checks
+type exportedSpan struct {
+	TraceId      string `json:"traceId"`
+	SpanId       string `json:"spanId"`
+	ParentSpanId string `json:"parentSpanId"`
+	Name         string `json:"name"`
+	Kind         int    `json:"kind"`
+}
+
+// readSpans returns spans of every line of the otlpfile output by name
+func readSpans(t *testing.T, path string) map[string]exportedSpan {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = f.Close() }()
+
+	res := make(map[string]exportedSpan)
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var req struct {
+			ResourceSpans []struct {
+				ScopeSpans []struct {
+					Spans []exportedSpan `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}
+		if err = json.Unmarshal(sc.Bytes(), &req); err != nil {
+			t.Fatalf("line %q: %v", sc.Text(), err)
+		}
+		for _, rs := range req.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				for _, s := range ss.Spans {
+					res[s.Name] = s
+				}
+			}
+		}
+	}
+	if err = sc.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestOtlpFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spans.json")
+	exporter, err := otlpfile.New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	prevProvider, prevPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(prevProvider)
+		otel.SetTextMapPropagator(prevPropagator)
+	})
+
+	var logs bytes.Buffer
+	loggerForHandlers := func() *slog.Logger { return slog.New(slog.NewTextHandler(&logs, nil)) }
+	mux := http.NewServeMux()
+	mux.Handle("GET /asset/{assetName}", logMiddleware.NewLoggerMiddleware(loggerForHandlers,
+		Handle(Span("WithBasicAuth", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			logMiddleware.SetupLoggerFromContext("AssetGet", r).Info("served")
+		})))).Handle())
+
+	r := httptest.NewRequest(http.MethodGet, "/asset/notes", nil)
+	r.Header.Set("traceparent", "00-"+traceId+"-"+parentSpanId+"-01")
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusOK)
+	}
+	// flushes and closes the file
+	if err = provider.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := readSpans(t, path)
+	if len(spans) != 2 {
+		t.Fatalf("got spans %+v, want 2", spans)
+	}
+	server, ok := spans["GET /asset/{assetName}"]
+	if !ok {
+		t.Fatalf("no server span named by route in %+v", spans)
+	}
+	inner, ok := spans["WithBasicAuth"]
+	if !ok {
+		t.Fatalf("no WithBasicAuth span in %+v", spans)
+	}
+	// the server span continues the incoming trace, the middleware span is its child
+	if server.TraceId != traceId || server.ParentSpanId != parentSpanId {
+		t.Fatalf("server span %+v doesn't continue traceparent", server)
+	}
+	if server.Kind != 2 {
+		t.Fatalf("got server span kind %d, want 2", server.Kind)
+	}
+	if inner.TraceId != traceId || inner.ParentSpanId != server.SpanId {
+		t.Fatalf("span %+v isn't a child of server span %+v", inner, server)
+	}
+	if !strings.Contains(logs.String(), "TraceId="+traceId) || !strings.Contains(logs.String(), "SpanId="+server.SpanId) {
+		t.Fatalf("handler log %q lacks trace ids", logs.String())
+	}
+}
@@ -0,0 +1,37 @@
+package unavailableMiddleware
+
+import (
+	"clearway-test-task/internal/storage"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+// Handle answers 503 with Retry-After instead of the error status of the handler when storage rejected
+// the request because the database is down
+func Handle(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, u := storage.WithUnavailable(r.Context())
+		next.ServeHTTP(&responseWriter{ResponseWriter: w, u: u}, r.WithContext(ctx))
+	})
+}
+
+type responseWriter struct {
+	http.ResponseWriter
+	u *storage.Unavailable
+}
+
+func (w *responseWriter) WriteHeader(status int) {
+	if status >= http.StatusBadRequest {
+		if after, ok := w.u.RetryAfter(); ok {
+			w.Header().Set("Retry-After", strconv.Itoa(max(int(math.Ceil(after.Seconds())), 1)))
+			status = http.StatusServiceUnavailable
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,127 @@
+package auditStorage
+
+import (
+	"clearway-test-task/internal/metrics"
+	"clearway-test-task/internal/storage"
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const maxBatch = 100
+
+// maxLoginLen and maxUserAgentLen bound client supplied values stored in the audit trail
+const (
+	maxLoginLen     = 256
+	maxUserAgentLen = 512
+)
+
+// AuditStorage writes login events in background batches, so recording never waits for db.
+// Events are dropped when the buffer is full
+type AuditStorage struct {
+	db            storage.Db
+	events        chan storage.LoginEvent
+	flushInterval time.Duration
+	writeTimeout  time.Duration
+	closer        chan struct{}
+	done          chan struct{}
+	once          sync.Once
+	lg            *slog.Logger
+}
+
+func NewAuditStorage(db storage.Db, bufferSize int, flushInterval, writeTimeout time.Duration, lg *slog.Logger) *AuditStorage {
+	a := &AuditStorage{
+		db:            db,
+		events:        make(chan storage.LoginEvent, bufferSize),
+		flushInterval: flushInterval,
+		writeTimeout:  writeTimeout,
+		closer:        make(chan struct{}),
+		done:          make(chan struct{}),
+		lg:            lg,
+	}
+	go a.writer()
+	return a
+}
+
+// Record counts the attempt in metrics even if the event is dropped
+func (a *AuditStorage) Record(ev storage.LoginEvent) {
+	if ev.CreatedAt == 0 {
+		ev.CreatedAt = time.Now().Unix()
+	}
+	ev.Login = truncate(ev.Login, maxLoginLen)
+	ev.UserAgent = truncate(ev.UserAgent, maxUserAgentLen)
+	result := "failure"
+	if ev.Success {
+		result = "success"
+	}
+	metrics.AuthAttempts.WithLabelValues(ev.Method, result, ev.Reason).Inc()
+	select {
+	case a.events <- ev:
+	default:
+		a.lg.Warn("audit buffer is full, login event dropped", "Login", ev.Login, "Reason", ev.Reason)
+	}
+}
+
+// Close flushes buffered events
+func (a *AuditStorage) Close() error {
+	a.once.Do(func() {
+		close(a.closer)
+		<-a.done
+	})
+	a.lg.Debug("audit storage closed")
+	return nil
+}
+
+func (a *AuditStorage) writer() {
+	defer close(a.done)
+	ticker := time.NewTicker(a.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]storage.LoginEvent, 0, maxBatch)
+	for {
+		select {
+		case ev := <-a.events:
+			batch = append(batch, ev)
+			if len(batch) >= maxBatch {
+				batch = a.flush(batch)
+			}
+		case <-ticker.C:
+			batch = a.flush(batch)
+		case <-a.closer:
+			for {
+				select {
+				case ev := <-a.events:
+					batch = append(batch, ev)
+				default:
+					a.flush(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+func (a *AuditStorage) flush(batch []storage.LoginEvent) []storage.LoginEvent {
+	if len(batch) == 0 {
+		return batch
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), a.writeTimeout)
+	defer cancel()
+	if err := a.db.CreateLoginEvents(ctx, batch); err != nil {
+		a.lg.Error("failed to write login events", "error", err, "Count", len(batch))
+	}
+	return batch[:0]
+}
+
+// truncate cuts s to at most n bytes without splitting a multibyte character
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
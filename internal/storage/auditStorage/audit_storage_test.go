@@ -0,0 +1,41 @@
+package auditStorage
+
+import (
+	"clearway-test-task/internal/storage"
+	"clearway-test-task/internal/storage/memoryStorage"
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestRecordTruncates(t *testing.T) {
+	db := memoryStorage.NewMemoryStorage()
+	a := NewAuditStorage(db, 10, time.Hour, time.Second, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	login := strings.Repeat("l", maxLoginLen+10)
+	// multibyte characters straddle the limit
+	ua := strings.Repeat("ы", maxUserAgentLen)
+	a.Record(storage.LoginEvent{Login: login, Method: storage.LoginMethodPassword, Reason: storage.LoginThrottled, UserAgent: ua})
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := db.GetLoginEvents(context.Background(), "", 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 {
+		t.Fatalf("got %d events, want 1", len(events))
+	}
+	ev := events[0]
+	if ev.Login != login[:maxLoginLen] {
+		t.Fatalf("got login of %d bytes, want %d", len(ev.Login), maxLoginLen)
+	}
+	if len(ev.UserAgent) > maxUserAgentLen || !utf8.ValidString(ev.UserAgent) || !strings.HasPrefix(ua, ev.UserAgent) {
+		t.Fatalf("user agent truncated to %d bytes, valid utf-8 %v", len(ev.UserAgent), utf8.ValidString(ev.UserAgent))
+	}
+}
@@ -0,0 +1,135 @@
+package authStorage
+
+import (
+	myerrors "clearway-test-task/internal/errors"
+	"clearway-test-task/internal/storage"
+	"clearway-test-task/pkg"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	apiKeyPrefix = "cwk"
+	// random bytes used for the lookup part and the secret part of the key
+	apiKeyLookupLen = 6
+	apiKeySecretLen = 32
+	// last_used_at is written at most once per interval to keep the hot path read-only
+	apiKeyLastUsedInterval = 60
+)
+
+// Scopes lists scopes api keys and tokens can be restricted to. Empty scope grants everything the owner can do
+var Scopes = map[string]struct{}{
+	"asset:read":     {},
+	"asset:write":    {},
+	"apikey:manage":  {},
+	"account:manage": {},
+	"admin":          {},
+}
+
+// CreateApiKey generates a new key for login. The plain key is returned only once, storage keeps its hash
+func (a *AuthStorage) CreateApiKey(ctx context.Context, login, scope string, expiresIn int64) (string, storage.ApiKey, error) {
+	scope, err := normalizeScope(scope)
+	if err != nil {
+		return "", storage.ApiKey{}, err
+	}
+	if expiresIn < 0 {
+		return "", storage.ApiKey{}, myerrors.NewInvalidArgumentError("negative api key expiry")
+	}
+
+	lookup := make([]byte, apiKeyLookupLen)
+	secret := make([]byte, apiKeySecretLen)
+	if _, err = rand.Read(lookup); err != nil {
+		return "", storage.ApiKey{}, fmt.Errorf("failed to generate api key: %w", err)
+	}
+	if _, err = rand.Read(secret); err != nil {
+		return "", storage.ApiKey{}, fmt.Errorf("failed to generate api key: %w", err)
+	}
+	prefix := apiKeyPrefix + "_" + hex.EncodeToString(lookup)
+	plain := prefix + "_" + base64.RawURLEncoding.EncodeToString(secret)
+
+	key := storage.ApiKey{
+		Login:     login,
+		Prefix:    prefix,
+		Hash:      hashSecret(plain),
+		Scope:     scope,
+		CreatedAt: time.Now().Unix(),
+	}
+	if expiresIn > 0 {
+		key.ExpireAt = key.CreatedAt + expiresIn
+	}
+
+	if key.Id, err = a.db.CreateApiKey(ctx, key); err != nil {
+		return "", storage.ApiKey{}, err
+	}
+
+	return plain, key, nil
+}
+
+func (a *AuthStorage) GetApiKeys(ctx context.Context, login string) ([]storage.ApiKey, error) {
+	return a.db.GetApiKeysByLogin(ctx, login)
+}
+
+func (a *AuthStorage) RevokeApiKey(ctx context.Context, login string, id int64) error {
+	return a.db.DeleteApiKey(ctx, id, login)
+}
+
+// ValidateApiKey checks the key against its stored hash and expiry. Returns owner login and key scope
+func (a *AuthStorage) ValidateApiKey(ctx context.Context, plain string) (string, string, error) {
+	prefix, ok := parseApiKey(plain)
+	if !ok {
+		return "", "", errors.New("malformed api key")
+	}
+
+	key, err := a.db.GetApiKeyByPrefix(ctx, prefix)
+	if err != nil {
+		return "", "", err
+	}
+	if subtle.ConstantTimeCompare(pkg.ConvertStrToBytes(key.Hash), pkg.ConvertStrToBytes(hashSecret(plain))) != 1 {
+		return "", "", errors.New("api key hash mismatch")
+	}
+
+	now := time.Now().Unix()
+	if key.ExpireAt != 0 && key.ExpireAt < now {
+		return "", "", errors.New("api key expired")
+	}
+	if now-key.LastUsedAt >= apiKeyLastUsedInterval {
+		if err = a.db.UpdateApiKeyLastUsed(ctx, key.Id, now); err != nil {
+			a.lg.Error("failed to update api key last used", "error", err, "Prefix", key.Prefix)
+		}
+	}
+
+	return key.Login, key.Scope, nil
+}
+
+// parseApiKey returns lookup prefix of the key in format cwk_<lookup>_<secret>
+func parseApiKey(plain string) (string, bool) {
+	parts := strings.SplitN(plain, "_", 3)
+	if len(parts) != 3 || parts[0] != apiKeyPrefix || parts[1] == "" || parts[2] == "" {
+		return "", false
+	}
+	return parts[0] + "_" + parts[1], true
+}
+
+// hashSecret uses plain sha256 since api keys and client secrets are high-entropy random strings
+func hashSecret(plain string) string {
+	sum := sha256.Sum256(pkg.ConvertStrToBytes(plain))
+	return hex.EncodeToString(sum[:])
+}
+
+func normalizeScope(scope string) (string, error) {
+	fields := strings.Fields(scope)
+	for _, f := range fields {
+		if _, ok := Scopes[f]; !ok {
+			return "", myerrors.NewInvalidScopeError(fmt.Sprintf("unknown scope %q", f))
+		}
+	}
+	return strings.Join(fields, " "), nil
+}
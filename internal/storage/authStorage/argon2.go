@@ -0,0 +1,104 @@
+package authStorage
+
+import (
+	"clearway-test-task/pkg"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/argon2"
+	"strings"
+)
+
+const argon2idPrefix = "$argon2id$"
+
+const (
+	argon2SaltLen = 16
+	argon2KeyLen  = 32
+)
+
+var errArgon2Mismatch = errors.New("argon2id: hashedPassword is not the hash of the given password")
+
+// Argon2idPasswordValidator stores hashes in PHC string format: $argon2id$v=19$m=<KiB>,t=<iterations>,p=<threads>$<salt>$<key>
+type Argon2idPasswordValidator struct {
+	// Time is the number of iterations
+	Time uint32
+	// Memory in KiB
+	Memory  uint32
+	Threads uint8
+}
+
+type argon2Params struct {
+	version int
+	memory  uint32
+	time    uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+func (v Argon2idPasswordValidator) Validate(hashedPassword, plainPassword string) error {
+	p, err := parseArgon2id(hashedPassword)
+	if err != nil {
+		return err
+	}
+	key := argon2.IDKey(pkg.ConvertStrToBytes(plainPassword), p.salt, p.time, p.memory, p.threads, uint32(len(p.key)))
+	if subtle.ConstantTimeCompare(key, p.key) != 1 {
+		return errArgon2Mismatch
+	}
+	return nil
+}
+
+func (v Argon2idPasswordValidator) Hash(plainPassword string) (string, error) {
+	salt := make([]byte, argon2SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("failed to generate salt: %w", err)
+	}
+	key := argon2.IDKey(pkg.ConvertStrToBytes(plainPassword), salt, v.Time, v.Memory, v.Threads, argon2KeyLen)
+
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2idPrefix, argon2.Version, v.Memory, v.Time, v.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// NeedsRehash reports whether the hash was produced with parameters different from the configured ones
+func (v Argon2idPasswordValidator) NeedsRehash(hashedPassword string) bool {
+	p, err := parseArgon2id(hashedPassword)
+	if err != nil {
+		return true
+	}
+	return p.version != argon2.Version || p.memory != v.Memory || p.time != v.Time || p.threads != v.Threads ||
+		len(p.key) != argon2KeyLen
+}
+
+func parseArgon2id(hashedPassword string) (argon2Params, error) {
+	var p argon2Params
+	// "", "argon2id", "v=19", "m=..,t=..,p=..", salt, key
+	parts := strings.Split(hashedPassword, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return p, errors.New("argon2id: invalid hash format")
+	}
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &p.version); err != nil {
+		return p, fmt.Errorf("argon2id: invalid version: %w", err)
+	}
+	if p.version != argon2.Version {
+		return p, fmt.Errorf("argon2id: unsupported version %d", p.version)
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.time, &p.threads); err != nil {
+		return p, fmt.Errorf("argon2id: invalid parameters: %w", err)
+	}
+	var err error
+	if p.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return p, fmt.Errorf("argon2id: invalid salt: %w", err)
+	}
+	if p.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return p, fmt.Errorf("argon2id: invalid key: %w", err)
+	}
+	if len(p.key) == 0 {
+		return p, errors.New("argon2id: empty key")
+	}
+	return p, nil
+}
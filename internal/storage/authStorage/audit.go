@@ -0,0 +1,48 @@
+package authStorage
+
+import (
+	myerrors "clearway-test-task/internal/errors"
+	"clearway-test-task/internal/storage"
+	"context"
+	"errors"
+)
+
+// audit records the outcome of a login attempt with client info from ctx
+func (a *AuthStorage) audit(ctx context.Context, login, method, sessionId string, err error) {
+	if a.auditor == nil {
+		return
+	}
+	info := storage.ClientInfoFromContext(ctx)
+	a.auditor.Record(storage.LoginEvent{
+		Login:     login,
+		Method:    method,
+		Success:   err == nil,
+		Reason:    loginReason(err),
+		Ip:        info.Ip,
+		UserAgent: info.UserAgent,
+		SessionId: sessionId,
+	})
+}
+
+// GetLoginEvents returns audit trail page, newest first
+func (a *AuthStorage) GetLoginEvents(ctx context.Context, login string, beforeId int64, limit int) ([]storage.LoginEvent, error) {
+	return a.db.GetLoginEvents(ctx, login, beforeId, limit)
+}
+
+func loginReason(err error) string {
+	var userErr myerrors.ErrUserNotFound
+	switch {
+	case err == nil:
+		return storage.LoginOk
+	case errors.Is(err, myerrors.ErrOtpRequired):
+		return storage.LoginOtpRequired
+	case errors.Is(err, myerrors.ErrOtpInvalid):
+		return storage.LoginOtpInvalid
+	case errors.Is(err, myerrors.ErrPasswordExpired):
+		return storage.LoginPasswordExpired
+	case errors.As(err, &userErr), errors.Is(err, myerrors.ErrNotFound):
+		return storage.LoginInvalidCredentials
+	default:
+		return storage.LoginError
+	}
+}
@@ -2,14 +2,17 @@ package authStorage
 
 import (
 	myerrors "clearway-test-task/internal/errors"
+	"clearway-test-task/internal/metrics"
 	"clearway-test-task/internal/storage"
 	"clearway-test-task/pkg"
 	"context"
 	"errors"
 	"fmt"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 	"log/slog"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -17,120 +20,260 @@ type AuthStorage struct {
 	db                   storage.Db
 	DeleteSessionTimeout time.Duration
 	pv                   storage.PasswordValidator
-	// sync.Map will be better at large request amount
-	cache map[string]storage.Token
-	// RW mutex because each request checks token
-	cacheMtx    sync.RWMutex
-	cacheTicker *time.Ticker
-	closer      chan struct{}
-	once        sync.Once
-	tokenTTL    time.Duration
-	hmacSecret  string
-	lg          *slog.Logger
-}
-
-func NewAuthStorage(db storage.Db, DeleteSessionTimeout time.Duration, validator storage.PasswordValidator, cacheCleanupInterval time.Duration, tokenTTL time.Duration, hmacSecret string, lg *slog.Logger) *AuthStorage {
+	policy               *PasswordPolicy
+	sessions             storage.SessionStore
+	cacheLoaded          atomic.Bool
+	cacheTicker          *time.Ticker
+	closer               chan struct{}
+	// stopListener cancels the session events listener
+	stopListener context.CancelFunc
+	once         sync.Once
+	tokenTTL     time.Duration
+	hmacSecret   string
+	totpIssuer   string
+	// directory is checked before local password hashes if set
+	directory               storage.Directory
+	provisionDirectoryUsers bool
+	auditor                 storage.Auditor
+	lg                      *slog.Logger
+	// hash of a random password compared against when login doesn't exist
+	dummyHash     string
+	dummyHashOnce sync.Once
+}
+
+func NewAuthStorage(db storage.Db, sessions storage.SessionStore, DeleteSessionTimeout time.Duration, validator storage.PasswordValidator, policy *PasswordPolicy, cacheCleanupInterval time.Duration, tokenTTL time.Duration, hmacSecret, totpIssuer string, directory storage.Directory, provisionDirectoryUsers bool, auditor storage.Auditor, lg *slog.Logger) *AuthStorage {
 	st := &AuthStorage{
-		db:                   db,
-		DeleteSessionTimeout: DeleteSessionTimeout,
-		pv:                   validator,
-		cache:                make(map[string]storage.Token),
-		cacheTicker:          time.NewTicker(cacheCleanupInterval),
-		closer:               make(chan struct{}),
-		tokenTTL:             tokenTTL,
-		hmacSecret:           hmacSecret,
-		lg:                   lg,
-	}
-	cache, err := loadCache(db, DeleteSessionTimeout)
-	if err != nil {
+		db:                      db,
+		DeleteSessionTimeout:    DeleteSessionTimeout,
+		pv:                      validator,
+		policy:                  policy,
+		sessions:                sessions,
+		cacheTicker:             time.NewTicker(cacheCleanupInterval),
+		closer:                  make(chan struct{}),
+		tokenTTL:                tokenTTL,
+		hmacSecret:              hmacSecret,
+		totpIssuer:              totpIssuer,
+		directory:               directory,
+		provisionDirectoryUsers: provisionDirectoryUsers,
+		auditor:                 auditor,
+		lg:                      lg,
+	}
+	if err := st.sessions.Reload(st.loadSessions); err != nil {
 		st.lg.Error("failed to load the cache", "error", err)
 	} else {
-		st.cache = cache
+		st.cacheLoaded.Store(true)
 	}
 	go st.cacheCleaner()
+
+	var listenerCtx context.Context
+	listenerCtx, st.stopListener = context.WithCancel(context.Background())
+	go st.sessionListener(listenerCtx)
 	return st
 }
 
-func loadCache(db storage.Db, DeleteSessionTimeout time.Duration) (map[string]storage.Token, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), DeleteSessionTimeout)
+// CacheLoaded reports whether sessions were loaded, until then valid tokens are rejected. The cache is retried
+// on every reconnect of the session events listener
+func (a *AuthStorage) CacheLoaded() bool {
+	return a.cacheLoaded.Load()
+}
+
+// CacheSize is the number of sessions kept in memory
+func (a *AuthStorage) CacheSize() int {
+	return a.sessions.Len()
+}
+
+func (a *AuthStorage) loadSessions() (map[string]storage.Token, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), a.DeleteSessionTimeout)
 	defer cancel()
-	return db.GetActiveSessions(ctx)
+	return a.db.GetActiveSessions(ctx)
+}
+
+// loadSessionsFromPrimary is loadSessions for resyncs, which follow changes of other instances a replica may lag behind
+func (a *AuthStorage) loadSessionsFromPrimary() (map[string]storage.Token, error) {
+	ctx, cancel := context.WithTimeout(storage.WithPrimary(context.Background()), a.DeleteSessionTimeout)
+	defer cancel()
+	return a.db.GetActiveSessions(ctx)
 }
 
 func (a *AuthStorage) auth(ctx context.Context, login, password string) error {
+	if a.directory != nil {
+		err := a.directoryAuth(ctx, login, password)
+		if err == nil {
+			return nil
+		}
+		var userErr myerrors.ErrUserNotFound
+		if errors.As(err, &userErr) {
+			return err
+		}
+		// local users, like break-glass admins, keep working when directory rejects them or is down
+		if !errors.Is(err, myerrors.ErrNotFound) {
+			a.lg.Error("directory auth failed", "error", err, "Login", login)
+		}
+	}
+
 	hash, err := a.db.GetUserPwdHashByLogin(ctx, login)
 	if err != nil {
+		var userErr myerrors.ErrUserNotFound
+		if errors.As(err, &userErr) {
+			// spend the same time as for a wrong password, so response time doesn't reveal whether login exists
+			_ = a.pv.Validate(a.getDummyHash(), password)
+		}
 		return err
 	}
 
 	if err = a.pv.Validate(hash, password); err != nil {
 		return fmt.Errorf("%w: invalid credentials: %w", myerrors.ErrNotFound, err)
 	}
+	if a.pv.NeedsRehash(hash) {
+		a.rehash(ctx, login, password)
+	}
 
 	return nil
 }
 
-func (a *AuthStorage) GetToken(ctx context.Context, login, password string) (string, int64, error) {
-	if err := a.auth(ctx, login, password); err != nil {
+// rehash migrates the stored hash to the current algorithm and cost. Failure doesn't affect the login
+func (a *AuthStorage) rehash(ctx context.Context, login, password string) {
+	hash, err := a.pv.Hash(password)
+	if err != nil {
+		a.lg.Error("failed to rehash password", "error", err, "Login", login)
+		return
+	}
+	if err = a.db.UpdateUserPwd(ctx, login, hash); err != nil {
+		a.lg.Error("failed to store rehashed password", "error", err, "Login", login)
+		return
+	}
+	a.lg.Debug("password rehashed", "Login", login)
+}
+
+func (a *AuthStorage) getDummyHash() string {
+	a.dummyHashOnce.Do(func() {
+		hash, err := a.pv.Hash(uuid.NewString())
+		if err != nil {
+			a.lg.Error("failed to create dummy hash", "error", err)
+			return
+		}
+		a.dummyHash = hash
+	})
+	return a.dummyHash
+}
+
+// GetToken issues a session token. otp is required only if the user has two-factor auth enabled
+func (a *AuthStorage) GetToken(ctx context.Context, login, password, otp string) (string, int64, error) {
+	session, err := a.passwordLogin(ctx, login, password, otp, "", "")
+	a.audit(ctx, login, storage.LoginMethodPassword, session.Id, err)
+	if err != nil {
 		return "", 0, err
 	}
+	return session.Token, session.ExpireAt, nil
+}
 
+func (a *AuthStorage) passwordLogin(ctx context.Context, login, password, otp, clientId, scope string) (storage.Session, error) {
+	if err := a.auth(ctx, login, password); err != nil {
+		return storage.Session{}, err
+	}
+	if err := a.secondFactor(ctx, login, otp); err != nil {
+		return storage.Session{}, err
+	}
+	if err := a.checkPasswordAge(ctx, login); err != nil {
+		return storage.Session{}, err
+	}
+	return a.issueToken(ctx, login, login, clientId, scope)
+}
+
+// issueToken signs a token for subject acting on behalf of login and replaces the active session of the subject
+func (a *AuthStorage) issueToken(ctx context.Context, subject, login, clientId, scope string) (storage.Session, error) {
+	sid := uuid.NewString()
 	iat := time.Now().Unix()
 	exp := time.Unix(iat, 0).Add(a.tokenTTL).Unix()
-	t := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+	claims := jwt.MapClaims{
+		"jti":   sid,
+		"sub":   subject,
 		"login": login,
 		"iat":   iat,
 		"exp":   exp,
-	})
+	}
+	if clientId != "" {
+		claims["client_id"] = clientId
+	}
+	if scope != "" {
+		claims["scope"] = scope
+	}
+	t := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 
 	signedToken, err := t.SignedString(pkg.ConvertStrToBytes(a.hmacSecret))
 	if err != nil {
-		return "", 0, err
+		return storage.Session{}, err
 	}
 
-	if err = a.db.UpdateSession(ctx, login, signedToken, iat, exp); err != nil {
-		return "", 0, err
+	session := storage.Session{
+		Id:       sid,
+		Subject:  subject,
+		Login:    login,
+		Token:    signedToken,
+		Scope:    scope,
+		IssuedAt: iat,
+		ExpireAt: exp,
 	}
-	a.setTokenWithLock(login, signedToken, exp)
-
-	return signedToken, exp, nil
-}
+	if err = a.db.UpdateSession(ctx, session); err != nil {
+		return storage.Session{}, err
+	}
+	a.sessions.Set(subject, storage.Token{Token: signedToken, ExpireAt: exp, Login: login, SessionId: sid})
 
-func (a *AuthStorage) setTokenWithLock(login, signedToken string, exp int64) {
-	a.cacheMtx.Lock()
-	defer a.cacheMtx.Unlock()
-	a.cache[login] = storage.Token{Token: signedToken, ExpireAt: exp}
+	return session, nil
 }
 
-func (a *AuthStorage) ValidateToken(decToken string) (string, error) {
+func (a *AuthStorage) ValidateToken(decToken string) (storage.Claims, error) {
 	// validate token
 	tkn, err := a.validateToken(decToken)
 	if err != nil {
-		return "", err
+		return storage.Claims{}, err
 	}
 	// validate claims
 	claims, ok := tkn.Claims.(jwt.MapClaims)
 	if !ok {
-		return "", errors.New("token claims are not accessible")
+		return storage.Claims{}, errors.New("token claims are not accessible")
 	}
 	if err = a.validateClaims(claims); err != nil {
-		return "", err
+		return storage.Claims{}, err
 	}
+	res := toClaims(claims)
 	// validate cache
-	login := claims["login"].(string)
-	if err = a.validateCache(login, decToken); err != nil {
-		return "", err
+	cached, err := a.validateCache(res.Subject, decToken)
+	if err != nil {
+		return storage.Claims{}, err
 	}
+	res.SessionId = cached.SessionId
 
-	return login, nil
+	return res, nil
+}
+
+// toClaims converts already validated claims. Tokens issued before subjects were introduced have login as subject
+func toClaims(claims jwt.MapClaims) storage.Claims {
+	res := storage.Claims{
+		Login:    claims["login"].(string),
+		IssuedAt: int64(claims["iat"].(float64)),
+		ExpireAt: int64(claims["exp"].(float64)),
+	}
+	res.Subject, _ = claims["sub"].(string)
+	if res.Subject == "" {
+		res.Subject = res.Login
+	}
+	res.ClientId, _ = claims["client_id"].(string)
+	res.Scope, _ = claims["scope"].(string)
+	return res
 }
 
-func (a *AuthStorage) validateCache(login, decToken string) error {
-	cachedToken, ok := a.getCachedTokenWithRLock(login)
+func (a *AuthStorage) validateCache(subject, decToken string) (storage.Token, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), a.DeleteSessionTimeout)
+	defer cancel()
+	cachedToken, ok, err := a.sessions.Get(ctx, subject)
+	if err != nil {
+		return storage.Token{}, fmt.Errorf("failed to get session: %w", err)
+	}
 	if !ok || cachedToken.Token != decToken {
-		return errors.New("token not registered")
+		return storage.Token{}, errors.New("token not registered")
 	}
-	return nil
+	return cachedToken, nil
 }
 
 func (a *AuthStorage) validateToken(decToken string) (*jwt.Token, error) {
@@ -180,17 +323,11 @@ func (a *AuthStorage) validateClaims(claims jwt.MapClaims) error {
 	return nil
 }
 
-func (a *AuthStorage) getCachedTokenWithRLock(login string) (storage.Token, bool) {
-	a.cacheMtx.RLock()
-	defer a.cacheMtx.RUnlock()
-	cachedToken, ok := a.cache[login]
-	return cachedToken, ok
-}
-
 func (a *AuthStorage) Close() error {
 	a.once.Do(func() {
 		close(a.closer)
 		a.cacheTicker.Stop()
+		a.stopListener()
 	})
 	a.lg.Debug("auth cache closed")
 	return nil
@@ -200,30 +337,23 @@ func (a *AuthStorage) cacheCleaner() {
 	for {
 		select {
 		case <-a.cacheTicker.C:
-			a.cacheMtx.RLock()
-			for login, tkn := range a.cache {
-				if time.Now().Unix() > tkn.ExpireAt {
-					a.cacheMtx.RUnlock()
-					err := a.deleteExpiredSessionFromDb(login)
-					if err != nil {
-						a.lg.Error("failed to delete expired session from db", "error", err)
-						continue
-					}
-					a.cacheMtx.Lock()
-					delete(a.cache, login)
-					a.cacheMtx.Unlock()
-					a.cacheMtx.RLock()
+			metrics.CacheCleanerRuns.Inc()
+			for _, subject := range a.sessions.Expired(time.Now().Unix()) {
+				if err := a.deleteExpiredSessionFromDb(subject); err != nil {
+					a.lg.Error("failed to delete expired session from db", "error", err)
+					continue
 				}
+				a.sessions.Delete(subject)
+				metrics.CacheCleanerRemoved.Inc()
 			}
-			a.cacheMtx.RUnlock()
 		case <-a.closer:
 			return
 		}
 	}
 }
 
-func (a *AuthStorage) deleteExpiredSessionFromDb(login string) error {
+func (a *AuthStorage) deleteExpiredSessionFromDb(subject string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), a.DeleteSessionTimeout)
 	defer cancel()
-	return a.db.DeleteSessionByLogin(ctx, login)
+	return a.db.DeleteSessionBySubject(ctx, subject)
 }
@@ -6,8 +6,36 @@ import (
 )
 
 type BcryptPasswordValidator struct {
+	// Cost for new hashes. bcrypt.DefaultCost is used if zero
+	Cost int
 }
 
 func (v BcryptPasswordValidator) Validate(hashedPassword, plainPassword string) error {
 	return bcrypt.CompareHashAndPassword(pkg.ConvertStrToBytes(hashedPassword), pkg.ConvertStrToBytes(plainPassword))
 }
+
+func (v BcryptPasswordValidator) Hash(plainPassword string) (string, error) {
+	b, err := bcrypt.GenerateFromPassword(pkg.ConvertStrToBytes(plainPassword), v.cost())
+	if err != nil {
+		return "", err
+	}
+	return pkg.ConvertBytesToString(b), nil
+}
+
+// NeedsRehash reports whether the hash was produced with a cost different from the configured one
+func (v BcryptPasswordValidator) NeedsRehash(hashedPassword string) bool {
+	cost, err := bcrypt.Cost(pkg.ConvertStrToBytes(hashedPassword))
+	return err != nil || cost != v.cost()
+}
+
+// MaxPasswordLen is 72 bytes, bcrypt ignores everything after it
+func (v BcryptPasswordValidator) MaxPasswordLen() int {
+	return 72
+}
+
+func (v BcryptPasswordValidator) cost() int {
+	if v.Cost == 0 {
+		return bcrypt.DefaultCost
+	}
+	return v.Cost
+}
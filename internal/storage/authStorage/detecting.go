@@ -0,0 +1,69 @@
+package authStorage
+
+import (
+	"clearway-test-task/internal/storage"
+	"errors"
+	"strings"
+)
+
+// DetectingPasswordValidator validates hashes of any supported algorithm by their prefix
+// and produces new hashes with the current one
+type DetectingPasswordValidator struct {
+	current storage.PasswordValidator
+	bcrypt  BcryptPasswordValidator
+	argon2  Argon2idPasswordValidator
+}
+
+// NewDetectingPasswordValidator creates validator hashing with algo, which is either "bcrypt" or "argon2id"
+func NewDetectingPasswordValidator(algo string, bcrypt BcryptPasswordValidator, argon2 Argon2idPasswordValidator) *DetectingPasswordValidator {
+	v := &DetectingPasswordValidator{
+		current: argon2,
+		bcrypt:  bcrypt,
+		argon2:  argon2,
+	}
+	if algo == "bcrypt" {
+		v.current = bcrypt
+	}
+	return v
+}
+
+func (v *DetectingPasswordValidator) Validate(hashedPassword, plainPassword string) error {
+	pv, ok := v.detect(hashedPassword)
+	if !ok {
+		return errors.New("unknown password hash format")
+	}
+	return pv.Validate(hashedPassword, plainPassword)
+}
+
+func (v *DetectingPasswordValidator) Hash(plainPassword string) (string, error) {
+	return v.current.Hash(plainPassword)
+}
+
+// NeedsRehash reports whether the hash uses other than current algorithm or outdated cost parameters
+func (v *DetectingPasswordValidator) NeedsRehash(hashedPassword string) bool {
+	pv, ok := v.detect(hashedPassword)
+	if !ok || pv != v.current {
+		return true
+	}
+	return pv.NeedsRehash(hashedPassword)
+}
+
+// MaxPasswordLen is the longest password the current algorithm hashes in full
+func (v *DetectingPasswordValidator) MaxPasswordLen() int {
+	if l, ok := v.current.(passwordLimit); ok {
+		return l.MaxPasswordLen()
+	}
+	return maxPasswordLen
+}
+
+func (v *DetectingPasswordValidator) detect(hashedPassword string) (storage.PasswordValidator, bool) {
+	switch {
+	case strings.HasPrefix(hashedPassword, argon2idPrefix):
+		return v.argon2, true
+	case strings.HasPrefix(hashedPassword, "$2a$"),
+		strings.HasPrefix(hashedPassword, "$2b$"),
+		strings.HasPrefix(hashedPassword, "$2y$"):
+		return v.bcrypt, true
+	}
+	return nil, false
+}
@@ -0,0 +1,96 @@
+package authStorage
+
+import (
+	"clearway-test-task/internal/storage/memoryStorage"
+	"clearway-test-task/internal/storage/sessionStorage"
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testArgon2 = Argon2idPasswordValidator{Time: 1, Memory: 64, Threads: 1}
+
+func TestDetectingValidator(t *testing.T) {
+	v := NewDetectingPasswordValidator("argon2id", BcryptPasswordValidator{Cost: 4}, testArgon2)
+	bcryptHash, err := BcryptPasswordValidator{Cost: 4}.Hash("password1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	argonHash, err := v.Hash("password1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale, err := Argon2idPasswordValidator{Time: 2, Memory: 64, Threads: 1}.Hash("password1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		hash   string
+		valid  bool
+		rehash bool
+	}{
+		{name: "current", hash: argonHash, valid: true},
+		{name: "bcrypt", hash: bcryptHash, valid: true, rehash: true},
+		{name: "outdated parameters", hash: stale, valid: true, rehash: true},
+		{name: "unknown format", hash: "plain", rehash: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := v.Validate(tt.hash, "password1"); (err == nil) != tt.valid {
+				t.Fatalf("got error %v, want valid %v", err, tt.valid)
+			}
+			if tt.valid {
+				if err := v.Validate(tt.hash, "password2"); err == nil {
+					t.Fatal("wrong password accepted")
+				}
+			}
+			if got := v.NeedsRehash(tt.hash); got != tt.rehash {
+				t.Fatalf("got rehash %v, want %v", got, tt.rehash)
+			}
+		})
+	}
+}
+
+func TestRehashOnLogin(t *testing.T) {
+	ctx := context.Background()
+	db := memoryStorage.NewMemoryStorage()
+	createTestUser(t, db, "bob", "password1")
+	policy, err := NewPasswordPolicy(8, 1, 0, 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pv := NewDetectingPasswordValidator("argon2id", BcryptPasswordValidator{Cost: 4}, testArgon2)
+	a := NewAuthStorage(db, sessionStorage.NewShardedStorage(4), time.Second, pv, policy,
+		time.Hour, time.Hour, "0123456789abcdef", "test", nil, false, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	t.Cleanup(func() { _ = a.Close() })
+
+	// a failed login must not rehash
+	if _, _, err = a.GetToken(ctx, "bob", "password2", ""); err == nil {
+		t.Fatal("wrong password accepted")
+	}
+	hash, err := db.GetUserPwdHashByLogin(ctx, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash, "$2a$") {
+		t.Fatalf("hash %q replaced after failed login", hash)
+	}
+
+	if _, _, err = a.GetToken(ctx, "bob", "password1", ""); err != nil {
+		t.Fatal(err)
+	}
+	if hash, err = db.GetUserPwdHashByLogin(ctx, "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash, argon2idPrefix) {
+		t.Fatalf("bcrypt hash not replaced after login, got %q", hash)
+	}
+	if _, _, err = a.GetToken(ctx, "bob", "password1", ""); err != nil {
+		t.Fatalf("login with rehashed password: %v", err)
+	}
+}
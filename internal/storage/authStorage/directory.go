@@ -0,0 +1,58 @@
+package authStorage
+
+import (
+	myerrors "clearway-test-task/internal/errors"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+// directoryPwdPrefix marks password hashes of users provisioned from directory. No validator accepts it,
+// so their password is checked only by the directory
+const directoryPwdPrefix = "!directory!"
+
+// directoryAuth checks password against the directory and syncs the local user with it
+func (a *AuthStorage) directoryAuth(ctx context.Context, login, password string) error {
+	role, err := a.directory.Authenticate(ctx, login, password)
+	if err != nil {
+		return err
+	}
+
+	current, err := a.db.GetUserRole(ctx, login)
+	if err != nil {
+		var userErr myerrors.ErrUserNotFound
+		if !errors.As(err, &userErr) || !a.provisionDirectoryUsers {
+			return err
+		}
+		if err = a.provisionDirectoryUser(ctx, login); err != nil {
+			return err
+		}
+		current = roleUser
+	}
+	if role != "" && role != current {
+		if err = a.db.UpdateUserRole(ctx, login, role); err != nil {
+			return err
+		}
+		a.lg.Info("user role synced from directory", "Login", login, "Role", role)
+	}
+	return nil
+}
+
+func (a *AuthStorage) provisionDirectoryUser(ctx context.Context, login string) error {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	if err := a.db.CreateUser(ctx, login, directoryPwdPrefix+hex.EncodeToString(b)); err != nil {
+		return err
+	}
+	a.lg.Info("directory user provisioned", "Login", login)
+	return nil
+}
+
+// isManagedPassword reports whether the password of the user is owned by an external provider
+func isManagedPassword(hash string) bool {
+	return strings.HasPrefix(hash, directoryPwdPrefix) || strings.HasPrefix(hash, externalPwdPrefix)
+}
@@ -0,0 +1,89 @@
+package authStorage
+
+import (
+	myerrors "clearway-test-task/internal/errors"
+	"clearway-test-task/internal/storage"
+	"clearway-test-task/pkg/validator"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"unicode"
+)
+
+// externalPwdPrefix marks password hashes of provisioned external users. No validator accepts it,
+// so such users can't log in with a password
+const externalPwdPrefix = "!external!"
+
+// ExternalLogin issues a session for a subject authenticated by an external identity provider.
+// Unlinked subject gets a new local user named after preferredLogin if provision is set.
+// Returns token and its expiration timestamp
+func (a *AuthStorage) ExternalLogin(ctx context.Context, issuer, subject, preferredLogin string, provision bool) (string, int64, error) {
+	login, err := a.db.GetLoginByIdentity(ctx, issuer, subject)
+	if err != nil {
+		var identityErr myerrors.ErrIdentityNotFound
+		if !errors.As(err, &identityErr) || !provision {
+			return "", 0, err
+		}
+		if login, err = a.provisionExternalUser(ctx, issuer, subject, preferredLogin); err != nil {
+			return "", 0, err
+		}
+	}
+
+	session, err := a.issueToken(ctx, login, login, "", "")
+	a.audit(ctx, login, storage.LoginMethodOidc, session.Id, err)
+	if err != nil {
+		return "", 0, err
+	}
+	return session.Token, session.ExpireAt, nil
+}
+
+// LinkIdentity maps an external subject to an existing local user
+func (a *AuthStorage) LinkIdentity(ctx context.Context, issuer, subject, login string) error {
+	if issuer == "" || subject == "" {
+		return myerrors.NewInvalidArgumentError("issuer and subject are required")
+	}
+	if _, err := a.db.GetUserRole(ctx, login); err != nil {
+		return err
+	}
+	return a.db.CreateIdentity(ctx, issuer, subject, login)
+}
+
+// provisionExternalUser creates a user without usable password. Existing local user is never taken over,
+// it has to be linked explicitly by an admin
+func (a *AuthStorage) provisionExternalUser(ctx context.Context, issuer, subject, preferredLogin string) (string, error) {
+	login := sanitizeLogin(preferredLogin)
+	if err := validator.ValInstance.ValidateWithTag(login, loginValidationTag); err != nil {
+		return "", myerrors.NewInvalidArgumentError("external login is not usable as a local login")
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	if err := a.db.CreateExternalUser(ctx, login, externalPwdPrefix+hex.EncodeToString(b), issuer, subject); err != nil {
+		// a concurrent callback of the same subject may have provisioned it first
+		if linked, linkErr := a.db.GetLoginByIdentity(ctx, issuer, subject); linkErr == nil {
+			return linked, nil
+		}
+		return "", err
+	}
+	a.lg.Info("external user provisioned", "Login", login, "Issuer", issuer)
+	return login, nil
+}
+
+// sanitizeLogin drops an email domain and characters not allowed in logins
+func sanitizeLogin(s string) string {
+	s, _, _ = strings.Cut(s, "@")
+	s = strings.Map(func(r rune) rune {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return r
+		}
+		return -1
+	}, s)
+	if len(s) > 32 {
+		s = s[:32]
+	}
+	return s
+}
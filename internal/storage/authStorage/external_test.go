@@ -0,0 +1,52 @@
+package authStorage
+
+import (
+	myerrors "clearway-test-task/internal/errors"
+	"clearway-test-task/internal/storage/memoryStorage"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestExternalLoginProvision(t *testing.T) {
+	ctx := context.Background()
+	db := memoryStorage.NewMemoryStorage()
+	createTestUser(t, db, "bob", "local-password")
+	a := newTestAuth(t, db, nil, false)
+
+	// concurrent callbacks of a new subject all log in as the same provisioned user
+	var wg sync.WaitGroup
+	errs := make([]error, 8)
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _, errs[i] = a.ExternalLogin(ctx, "iss", "ann-sub", "ann@example.com", true)
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if login, err := db.GetLoginByIdentity(ctx, "iss", "ann-sub"); err != nil || login != "ann" {
+		t.Fatalf("got identity of %q, error %v, want ann", login, err)
+	}
+
+	// existing local user isn't taken over and nothing is left half-created
+	_, _, err := a.ExternalLogin(ctx, "iss", "bob-sub", "bob", true)
+	var existsErr myerrors.ErrUserExists
+	if !errors.As(err, &existsErr) {
+		t.Fatalf("got error %v, want user exists", err)
+	}
+	var identityErr myerrors.ErrIdentityNotFound
+	if _, err = db.GetLoginByIdentity(ctx, "iss", "bob-sub"); !errors.As(err, &identityErr) {
+		t.Fatalf("got error %v, want identity not found", err)
+	}
+
+	if _, _, err = a.ExternalLogin(ctx, "iss", "eve-sub", "eve", false); !errors.As(err, &identityErr) {
+		t.Fatalf("got error %v without provisioning, want identity not found", err)
+	}
+}
@@ -0,0 +1,147 @@
+package authStorage
+
+import (
+	myerrors "clearway-test-task/internal/errors"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/go-ldap/ldap/v3"
+	"net"
+	"strings"
+	"time"
+)
+
+// LdapConn is the subset of *ldap.Conn used by the directory, so a stand-in server can be plugged in
+type LdapConn interface {
+	Bind(username, password string) error
+	Search(searchRequest *ldap.SearchRequest) (*ldap.SearchResult, error)
+	Close() error
+}
+
+// GroupRole assigns Role to members of the group Dn
+type GroupRole struct {
+	Dn   *ldap.DN
+	Role string
+}
+
+// LdapDirectory checks passwords by binding to LDAP server as the user
+type LdapDirectory struct {
+	dial           func(ctx context.Context) (LdapConn, error)
+	bindDnTemplate string
+	groupRoles     []GroupRole
+}
+
+// NewLdapDirectory creates directory connecting to url, which is ldap:// or ldaps://. bindDnTemplate contains %s
+// replaced by escaped login. startTls upgrades plain ldap:// connection. tlsConfig is used by both ldaps and StartTLS
+func NewLdapDirectory(url, bindDnTemplate string, startTls bool, tlsConfig *tls.Config, timeout time.Duration, groupRoles []GroupRole) *LdapDirectory {
+	dial := func(ctx context.Context) (LdapConn, error) {
+		conn, err := ldap.DialURL(url,
+			ldap.DialWithDialer(&net.Dialer{Timeout: timeout}),
+			ldap.DialWithTLSConfig(tlsConfig),
+		)
+		if err != nil {
+			return nil, err
+		}
+		conn.SetTimeout(timeout)
+		if startTls {
+			if err = conn.StartTLS(tlsConfig); err != nil {
+				_ = conn.Close()
+				return nil, err
+			}
+		}
+		return conn, nil
+	}
+	return NewLdapDirectoryWithDialer(dial, bindDnTemplate, groupRoles)
+}
+
+// NewLdapDirectoryWithDialer creates directory using connections created by dial
+func NewLdapDirectoryWithDialer(dial func(ctx context.Context) (LdapConn, error), bindDnTemplate string, groupRoles []GroupRole) *LdapDirectory {
+	return &LdapDirectory{
+		dial:           dial,
+		bindDnTemplate: bindDnTemplate,
+		groupRoles:     groupRoles,
+	}
+}
+
+// ParseGroupRoles parses semicolon separated "role:group dn" pairs. Earlier pairs take precedence
+func ParseGroupRoles(s string) ([]GroupRole, error) {
+	res := make([]GroupRole, 0)
+	for _, pair := range strings.Split(s, ";") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		role, groupDn, ok := strings.Cut(pair, ":")
+		if !ok || (role != roleUser && role != roleAdmin) {
+			return nil, fmt.Errorf("invalid group role mapping %q", pair)
+		}
+		dn, err := ldap.ParseDN(strings.TrimSpace(groupDn))
+		if err != nil {
+			return nil, fmt.Errorf("invalid group dn %q: %w", groupDn, err)
+		}
+		res = append(res, GroupRole{Dn: dn, Role: role})
+	}
+	return res, nil
+}
+
+// Authenticate binds as the user. Returns role mapped from groups of the user,
+// or empty role if no mapping is configured
+func (d *LdapDirectory) Authenticate(ctx context.Context, login, password string) (string, error) {
+	// unauthenticated bind with empty password succeeds on most servers
+	if password == "" {
+		return "", fmt.Errorf("%w: empty password", myerrors.ErrNotFound)
+	}
+	conn, err := d.dial(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to connect to ldap: %w", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	userDn := fmt.Sprintf(d.bindDnTemplate, ldap.EscapeDN(login))
+	if err = conn.Bind(userDn, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return "", fmt.Errorf("%w: invalid credentials: %w", myerrors.ErrNotFound, err)
+		}
+		return "", fmt.Errorf("failed to bind: %w", err)
+	}
+	if len(d.groupRoles) == 0 {
+		return "", nil
+	}
+
+	groups, err := d.memberOf(conn, userDn)
+	if err != nil {
+		return "", err
+	}
+	for _, gr := range d.groupRoles {
+		for _, g := range groups {
+			if gr.Dn.EqualFold(g) {
+				return gr.Role, nil
+			}
+		}
+	}
+	return roleUser, nil
+}
+
+// memberOf reads groups of the user from its memberOf attribute
+func (d *LdapDirectory) memberOf(conn LdapConn, userDn string) ([]*ldap.DN, error) {
+	res, err := conn.Search(ldap.NewSearchRequest(userDn,
+		ldap.ScopeBaseObject, ldap.NeverDerefAliases, 1, 0, false,
+		"(objectClass=*)", []string{"memberOf"}, nil,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read user groups: %w", err)
+	}
+	if len(res.Entries) == 0 {
+		return nil, errors.New("user entry not found")
+	}
+	groups := make([]*ldap.DN, 0)
+	for _, v := range res.Entries[0].GetAttributeValues("memberOf") {
+		dn, err := ldap.ParseDN(v)
+		if err != nil {
+			continue
+		}
+		groups = append(groups, dn)
+	}
+	return groups, nil
+}
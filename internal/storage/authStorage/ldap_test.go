@@ -0,0 +1,284 @@
+package authStorage
+
+import (
+	myerrors "clearway-test-task/internal/errors"
+	"clearway-test-task/internal/storage"
+	"clearway-test-task/internal/storage/memoryStorage"
+	"clearway-test-task/internal/storage/sessionStorage"
+	"context"
+	"errors"
+	"github.com/go-ldap/ldap/v3"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+const (
+	testBindDn   = "uid=%s,ou=people,dc=example,dc=org"
+	adminsDn     = "cn=admins,ou=groups,dc=example,dc=org"
+	staffDn      = "cn=staff,ou=groups,dc=example,dc=org"
+	testPassword = "Passw0rd!Passw0rd"
+)
+
+// fakeLdap is an in-process LDAP stand-in holding passwords and groups by user dn
+type fakeLdap struct {
+	passwords map[string]string
+	groups    map[string][]string
+	// down makes dial fail, as if the server is unreachable
+	down  bool
+	dials int
+}
+
+type fakeConn struct {
+	dir   *fakeLdap
+	bound string
+}
+
+func (f *fakeLdap) dial(context.Context) (LdapConn, error) {
+	f.dials++
+	if f.down {
+		return nil, errors.New("connection refused")
+	}
+	return &fakeConn{dir: f}, nil
+}
+
+func (c *fakeConn) Bind(username, password string) error {
+	if pwd, ok := c.dir.passwords[username]; !ok || pwd != password {
+		return ldap.NewError(ldap.LDAPResultInvalidCredentials, errors.New("invalid credentials"))
+	}
+	c.bound = username
+	return nil
+}
+
+func (c *fakeConn) Search(req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	if c.bound != req.BaseDN {
+		return nil, ldap.NewError(ldap.LDAPResultInsufficientAccessRights, errors.New("insufficient access"))
+	}
+	entry := ldap.NewEntry(req.BaseDN, map[string][]string{"memberOf": c.dir.groups[req.BaseDN]})
+	return &ldap.SearchResult{Entries: []*ldap.Entry{entry}}, nil
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+func newFakeLdap() *fakeLdap {
+	return &fakeLdap{
+		passwords: map[string]string{
+			"uid=ann,ou=people,dc=example,dc=org":  testPassword,
+			"uid=bob,ou=people,dc=example,dc=org":  testPassword,
+			"uid=carl,ou=people,dc=example,dc=org": testPassword,
+		},
+		groups: map[string][]string{
+			"uid=ann,ou=people,dc=example,dc=org": {staffDn, adminsDn},
+			"uid=bob,ou=people,dc=example,dc=org": {staffDn},
+		},
+	}
+}
+
+func mustGroupRoles(t *testing.T, s string) []GroupRole {
+	t.Helper()
+	roles, err := ParseGroupRoles(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return roles
+}
+
+func TestLdapAuthenticate(t *testing.T) {
+	tests := []struct {
+		name       string
+		groupRoles string
+		login      string
+		password   string
+		role       string
+		// err is nil for success, credentials errors are expected to wrap ErrNotFound
+		err error
+	}{
+		{name: "bind without mapping", login: "bob", password: testPassword, role: ""},
+		{name: "wrong password", login: "bob", password: "wrong", err: myerrors.ErrNotFound},
+		{name: "unknown user", login: "eve", password: testPassword, err: myerrors.ErrNotFound},
+		{name: "empty password", login: "bob", password: "", err: myerrors.ErrNotFound},
+		{name: "first matching group wins", groupRoles: "admin:" + adminsDn + ";user:" + staffDn,
+			login: "ann", password: testPassword, role: roleAdmin},
+		{name: "earlier mapping takes precedence", groupRoles: "user:" + staffDn + ";admin:" + adminsDn,
+			login: "ann", password: testPassword, role: roleUser},
+		{name: "group dn compared case insensitively", groupRoles: "admin:CN=Admins,OU=Groups,DC=example,DC=org",
+			login: "ann", password: testPassword, role: roleAdmin},
+		{name: "not a member of mapped groups", groupRoles: "admin:" + adminsDn,
+			login: "carl", password: testPassword, role: roleUser},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeLdap()
+			d := NewLdapDirectoryWithDialer(f.dial, testBindDn, mustGroupRoles(t, tt.groupRoles))
+
+			role, err := d.Authenticate(context.Background(), tt.login, tt.password)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("got error %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if role != tt.role {
+				t.Fatalf("got role %q, want %q", role, tt.role)
+			}
+		})
+	}
+}
+
+func TestLdapEmptyPasswordNotSent(t *testing.T) {
+	f := newFakeLdap()
+	d := NewLdapDirectoryWithDialer(f.dial, testBindDn, nil)
+	if _, err := d.Authenticate(context.Background(), "bob", ""); err == nil {
+		t.Fatal("empty password accepted")
+	}
+	if f.dials != 0 {
+		t.Fatal("empty password sent to the directory")
+	}
+}
+
+func TestLdapLoginEscaped(t *testing.T) {
+	f := newFakeLdap()
+	d := NewLdapDirectoryWithDialer(f.dial, testBindDn, nil)
+	// unescaped login would bind as bob
+	if _, err := d.Authenticate(context.Background(), "bob,ou=people,dc=example,dc=org", testPassword); !errors.Is(err, myerrors.ErrNotFound) {
+		t.Fatalf("got error %v, want credentials error", err)
+	}
+}
+
+func TestParseGroupRolesInvalid(t *testing.T) {
+	for _, s := range []string{"root:" + adminsDn, "admin", "admin:not a dn"} {
+		if _, err := ParseGroupRoles(s); err == nil {
+			t.Fatalf("%q accepted", s)
+		}
+	}
+}
+
+// newTestAuth creates auth storage over memory storage, closed with the test
+func newTestAuth(t *testing.T, db storage.Db, directory storage.Directory, provision bool) *AuthStorage {
+	t.Helper()
+	policy, err := NewPasswordPolicy(8, 1, 0, 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lg := slog.New(slog.NewTextHandler(io.Discard, nil))
+	a := NewAuthStorage(db, sessionStorage.NewShardedStorage(4), time.Second, BcryptPasswordValidator{Cost: 4}, policy,
+		time.Hour, time.Hour, "0123456789abcdef", "test", directory, provision, nil, lg)
+	t.Cleanup(func() { _ = a.Close() })
+	return a
+}
+
+func createTestUser(t *testing.T, db storage.Db, login, password string) {
+	t.Helper()
+	hash, err := BcryptPasswordValidator{Cost: 4}.Hash(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = db.CreateUser(context.Background(), login, hash); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDirectoryAutoProvision(t *testing.T) {
+	ctx := context.Background()
+	groupRoles := "admin:" + adminsDn
+
+	t.Run("enabled", func(t *testing.T) {
+		db := memoryStorage.NewMemoryStorage()
+		d := NewLdapDirectoryWithDialer(newFakeLdap().dial, testBindDn, mustGroupRoles(t, groupRoles))
+		a := newTestAuth(t, db, d, true)
+
+		if _, _, err := a.GetToken(ctx, "ann", testPassword, ""); err != nil {
+			t.Fatal(err)
+		}
+		role, err := db.GetUserRole(ctx, "ann")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if role != roleAdmin {
+			t.Fatalf("got role %q, want %q", role, roleAdmin)
+		}
+		// provisioned password is owned by the directory, no local password matches it
+		hash, err := db.GetUserPwdHashByLogin(ctx, "ann")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !isManagedPassword(hash) {
+			t.Fatalf("provisioned user has local password hash %q", hash)
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		db := memoryStorage.NewMemoryStorage()
+		d := NewLdapDirectoryWithDialer(newFakeLdap().dial, testBindDn, mustGroupRoles(t, groupRoles))
+		a := newTestAuth(t, db, d, false)
+
+		_, _, err := a.GetToken(ctx, "ann", testPassword, "")
+		var userErr myerrors.ErrUserNotFound
+		if !errors.As(err, &userErr) {
+			t.Fatalf("got error %v, want user not found", err)
+		}
+		if _, err = db.GetUserRole(ctx, "ann"); err == nil {
+			t.Fatal("user provisioned")
+		}
+	})
+}
+
+func TestDirectoryRoleSync(t *testing.T) {
+	ctx := context.Background()
+	db := memoryStorage.NewMemoryStorage()
+	createTestUser(t, db, "bob", "local-password")
+	if err := db.UpdateUserRole(ctx, "bob", roleAdmin); err != nil {
+		t.Fatal(err)
+	}
+	d := NewLdapDirectoryWithDialer(newFakeLdap().dial, testBindDn, mustGroupRoles(t, "admin:"+adminsDn))
+	a := newTestAuth(t, db, d, false)
+
+	if _, _, err := a.GetToken(ctx, "bob", testPassword, ""); err != nil {
+		t.Fatal(err)
+	}
+	role, err := db.GetUserRole(ctx, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if role != roleUser {
+		t.Fatalf("got role %q, want %q", role, roleUser)
+	}
+}
+
+func TestDirectoryFallbackToLocalHash(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name     string
+		down     bool
+		password string
+		ok       bool
+	}{
+		{name: "directory down, local password", down: true, password: "local-password", ok: true},
+		{name: "directory down, wrong password", down: true, password: "wrong-password"},
+		{name: "directory rejects, local password", password: "local-password", ok: true},
+		{name: "directory accepts", password: testPassword, ok: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := memoryStorage.NewMemoryStorage()
+			createTestUser(t, db, "bob", "local-password")
+			f := newFakeLdap()
+			f.down = tt.down
+			a := newTestAuth(t, db, NewLdapDirectoryWithDialer(f.dial, testBindDn, nil), false)
+
+			_, _, err := a.GetToken(ctx, "bob", tt.password, "")
+			if tt.ok && err != nil {
+				t.Fatal(err)
+			}
+			if !tt.ok && !errors.Is(err, myerrors.ErrNotFound) {
+				t.Fatalf("got error %v, want credentials error", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package authStorage
+
+import (
+	myerrors "clearway-test-task/internal/errors"
+	"clearway-test-task/internal/storage"
+	"clearway-test-task/pkg"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	clientIdPrefix  = "cwc"
+	clientIdLen     = 8
+	clientSecretLen = 32
+	// clientSubjectPrefix distinguishes client sessions from user sessions. Logins are alphanumeric, so they never collide
+	clientSubjectPrefix = "client:"
+)
+
+// PasswordGrant implements OAuth2 resource owner password credentials grant. client is nil for public clients.
+// Returns token, its expiration timestamp and granted scope
+func (a *AuthStorage) PasswordGrant(ctx context.Context, login, password, otp, scope string, client *storage.Client) (string, int64, string, error) {
+	allowed := ""
+	clientId := ""
+	if client != nil {
+		allowed = client.Scope
+		clientId = client.ClientId
+	}
+	granted, err := grantScope(scope, allowed)
+	if err != nil {
+		return "", 0, "", err
+	}
+	session, err := a.passwordLogin(ctx, login, password, otp, clientId, granted)
+	a.audit(ctx, login, storage.LoginMethodOauthPassword, session.Id, err)
+	if err != nil {
+		return "", 0, "", err
+	}
+	return session.Token, session.ExpireAt, granted, nil
+}
+
+// ClientCredentialsGrant issues a token for an authenticated client acting on behalf of its owner.
+// Returns token, its expiration timestamp and granted scope
+func (a *AuthStorage) ClientCredentialsGrant(ctx context.Context, client storage.Client, scope string) (string, int64, string, error) {
+	granted, err := grantScope(scope, client.Scope)
+	if err != nil {
+		return "", 0, "", err
+	}
+
+	session, err := a.issueToken(ctx, ClientSubject(client.ClientId), client.Login, client.ClientId, granted)
+	a.audit(ctx, client.ClientId, storage.LoginMethodClientCredentials, session.Id, err)
+	if err != nil {
+		return "", 0, "", err
+	}
+	return session.Token, session.ExpireAt, granted, nil
+}
+
+// AuthenticateClient checks client secret. Unknown client and wrong secret produce the same error
+func (a *AuthStorage) AuthenticateClient(ctx context.Context, clientId, secret string) (storage.Client, error) {
+	client, err := a.db.GetClient(ctx, clientId)
+	if err != nil {
+		return storage.Client{}, err
+	}
+	if subtle.ConstantTimeCompare(pkg.ConvertStrToBytes(client.SecretHash), pkg.ConvertStrToBytes(hashSecret(secret))) != 1 {
+		return storage.Client{}, myerrors.NewErrClientNotFound(clientId)
+	}
+	return client, nil
+}
+
+// CreateClient registers a confidential client for login. The plain secret is returned only once.
+// Scope is required, so tokens of the client never carry all rights of the owner
+func (a *AuthStorage) CreateClient(ctx context.Context, login, scope string) (string, storage.Client, error) {
+	scope, err := normalizeScope(scope)
+	if err != nil {
+		return "", storage.Client{}, err
+	}
+	if scope == "" {
+		return "", storage.Client{}, myerrors.NewInvalidArgumentError("client scope is required")
+	}
+	// soft-deleted users still satisfy the foreign key, so check the owner explicitly
+	if _, err = a.db.GetUserRole(ctx, login); err != nil {
+		return "", storage.Client{}, err
+	}
+
+	id := make([]byte, clientIdLen)
+	secret := make([]byte, clientSecretLen)
+	if _, err = rand.Read(id); err != nil {
+		return "", storage.Client{}, fmt.Errorf("failed to generate client id: %w", err)
+	}
+	if _, err = rand.Read(secret); err != nil {
+		return "", storage.Client{}, fmt.Errorf("failed to generate client secret: %w", err)
+	}
+	plain := base64.RawURLEncoding.EncodeToString(secret)
+
+	client := storage.Client{
+		ClientId:   clientIdPrefix + "_" + hex.EncodeToString(id),
+		SecretHash: hashSecret(plain),
+		Login:      login,
+		Scope:      scope,
+		CreatedAt:  time.Now().Unix(),
+	}
+	if client.Id, err = a.db.CreateClient(ctx, client); err != nil {
+		return "", storage.Client{}, err
+	}
+	return plain, client, nil
+}
+
+func (a *AuthStorage) GetClients(ctx context.Context) ([]storage.Client, error) {
+	return a.db.GetClients(ctx)
+}
+
+// DeleteClient removes the client and revokes its session
+func (a *AuthStorage) DeleteClient(ctx context.Context, clientId string) error {
+	if err := a.db.DeleteClient(ctx, clientId); err != nil {
+		return err
+	}
+	subject := ClientSubject(clientId)
+	if err := a.db.DeleteSessionBySubject(ctx, subject); err != nil {
+		return err
+	}
+	a.sessions.Delete(subject)
+	return nil
+}
+
+// ClientSubject returns session subject of the client
+func ClientSubject(clientId string) string {
+	return clientSubjectPrefix + clientId
+}
+
+// grantScope checks requested scope against allowed one. Empty allowed scope, that of public clients, means any known scope,
+// empty requested scope means everything allowed
+func grantScope(requested, allowed string) (string, error) {
+	requested, err := normalizeScope(requested)
+	if err != nil {
+		return "", err
+	}
+	if allowed == "" {
+		return requested, nil
+	}
+	if requested == "" {
+		return allowed, nil
+	}
+	allowedSet := strings.Fields(allowed)
+	for _, s := range strings.Fields(requested) {
+		if !slices.Contains(allowedSet, s) {
+			return "", myerrors.NewInvalidScopeError(fmt.Sprintf("scope %q is not allowed", s))
+		}
+	}
+	return requested, nil
+}
@@ -0,0 +1,223 @@
+package authStorage
+
+import (
+	"bufio"
+	myerrors "clearway-test-task/internal/errors"
+	"clearway-test-task/pkg"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxPasswordLen bounds passwords hashed by validators accepting any length, so hashing stays cheap
+const maxPasswordLen = 1024
+
+// passwordLimit is implemented by validators hashing only a prefix of long passwords
+type passwordLimit interface {
+	MaxPasswordLen() int
+}
+
+const (
+	violationTooShort     = "too_short"
+	violationTooLong      = "too_long"
+	violationTooSimple    = "too_simple"
+	violationContainsUser = "contains_login"
+	violationBreached     = "breached"
+	violationReused       = "reused"
+)
+
+// PasswordPolicy is applied to passwords chosen by users at registration and password change
+type PasswordPolicy struct {
+	// MinLength in characters
+	MinLength int
+	// MinClasses is the number of character classes required: lowercase, uppercase, digits and symbols
+	MinClasses int
+	// MaxAge of a password before login requires changing it, 0 disables expiration
+	MaxAge time.Duration
+	// History is the number of most recent passwords, including the current one, which can't be reused
+	History int
+	// breached holds upper case hex SHA-1 of known breached passwords
+	breached map[string]struct{}
+}
+
+// NewPasswordPolicy creates policy. breachedFile is optional, it holds one password or hex SHA-1 per line,
+// lines of Have I Been Pwned format HASH:COUNT are accepted as well
+func NewPasswordPolicy(minLength, minClasses int, maxAge time.Duration, history int, breachedFile string) (*PasswordPolicy, error) {
+	p := &PasswordPolicy{
+		MinLength:  minLength,
+		MinClasses: minClasses,
+		MaxAge:     maxAge,
+		History:    history,
+	}
+	if breachedFile != "" {
+		f, err := os.Open(breachedFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open breached passwords file: %w", err)
+		}
+		defer func() { _ = f.Close() }()
+		if p.breached, err = readBreached(f); err != nil {
+			return nil, fmt.Errorf("failed to read breached passwords file: %w", err)
+		}
+	}
+	return p, nil
+}
+
+func readBreached(r io.Reader) (map[string]struct{}, error) {
+	breached := make(map[string]struct{})
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := strings.TrimRight(sc.Text(), "\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if h, _, _ := strings.Cut(line, ":"); isSha1Hex(h) {
+			breached[strings.ToUpper(h)] = struct{}{}
+			continue
+		}
+		breached[sha1Hex(line)] = struct{}{}
+	}
+	return breached, sc.Err()
+}
+
+func isSha1Hex(s string) bool {
+	if len(s) != sha1.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+func sha1Hex(s string) string {
+	sum := sha1.Sum(pkg.ConvertStrToBytes(s))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+// Check returns all rules the password fails, empty if it's acceptable
+func (p *PasswordPolicy) Check(login, password string) []myerrors.PolicyViolation {
+	var violations []myerrors.PolicyViolation
+	if n := utf8.RuneCountInString(password); n < p.MinLength {
+		violations = append(violations, myerrors.PolicyViolation{
+			Code:    violationTooShort,
+			Message: fmt.Sprintf("password must be at least %d characters long", p.MinLength),
+		})
+	}
+	if characterClasses(password) < p.MinClasses {
+		violations = append(violations, myerrors.PolicyViolation{
+			Code:    violationTooSimple,
+			Message: fmt.Sprintf("password must contain at least %d of: lowercase letters, uppercase letters, digits, symbols", p.MinClasses),
+		})
+	}
+	if login != "" && strings.Contains(strings.ToLower(password), strings.ToLower(login)) {
+		violations = append(violations, myerrors.PolicyViolation{
+			Code:    violationContainsUser,
+			Message: "password must not contain the login",
+		})
+	}
+	if _, ok := p.breached[sha1Hex(password)]; ok {
+		violations = append(violations, myerrors.PolicyViolation{
+			Code:    violationBreached,
+			Message: "password appears in a list of breached passwords",
+		})
+	}
+	return violations
+}
+
+func characterClasses(password string) int {
+	var lower, upper, digit, other int
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			other = 1
+		}
+	}
+	return lower + upper + digit + other
+}
+
+// Expired reports whether password changed at unix time changedAt has to be changed
+func (p *PasswordPolicy) Expired(changedAt int64) bool {
+	return p.MaxAge > 0 && time.Since(time.Unix(changedAt, 0)) > p.MaxAge
+}
+
+// checkNewPassword applies the policy to a new password of the user. currentHash is empty at registration
+func (a *AuthStorage) checkNewPassword(ctx context.Context, login, password, currentHash string) error {
+	violations := a.policy.Check(login, password)
+	maxLen := maxPasswordLen
+	if l, ok := a.pv.(passwordLimit); ok {
+		maxLen = l.MaxPasswordLen()
+	}
+	if len(password) > maxLen {
+		violations = append(violations, myerrors.PolicyViolation{
+			Code:    violationTooLong,
+			Message: fmt.Sprintf("password must be at most %d bytes long", maxLen),
+		})
+	} else if currentHash != "" && a.policy.History > 0 {
+		reused, err := a.isReused(ctx, login, password, currentHash)
+		if err != nil {
+			return err
+		}
+		if reused {
+			violations = append(violations, myerrors.PolicyViolation{
+				Code:    violationReused,
+				Message: fmt.Sprintf("password must differ from the last %d passwords", a.policy.History),
+			})
+		}
+	}
+	if len(violations) > 0 {
+		return myerrors.NewErrPasswordPolicy(violations)
+	}
+	return nil
+}
+
+func (a *AuthStorage) isReused(ctx context.Context, login, password, currentHash string) (bool, error) {
+	if a.pv.Validate(currentHash, password) == nil {
+		return true, nil
+	}
+	if a.policy.History < 2 {
+		return false, nil
+	}
+	hashes, err := a.db.GetPwdHistory(ctx, login, a.policy.History-1)
+	if err != nil {
+		return false, err
+	}
+	for _, h := range hashes {
+		if a.pv.Validate(h, password) == nil {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// checkPasswordAge rejects login of users whose local password is older than policy allows
+func (a *AuthStorage) checkPasswordAge(ctx context.Context, login string) error {
+	if a.policy.MaxAge <= 0 {
+		return nil
+	}
+	hash, err := a.db.GetUserPwdHashByLogin(ctx, login)
+	if err != nil {
+		return err
+	}
+	if isManagedPassword(hash) {
+		return nil
+	}
+	changedAt, err := a.db.GetUserPwdChangedAt(ctx, login)
+	if err != nil {
+		return err
+	}
+	if a.policy.Expired(changedAt) {
+		return myerrors.ErrPasswordExpired
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package authStorage
+
+import (
+	myerrors "clearway-test-task/internal/errors"
+	"clearway-test-task/internal/storage/memoryStorage"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestPasswordMaxLengthFollowsAlgorithm(t *testing.T) {
+	bcrypt := BcryptPasswordValidator{Cost: 4}
+	argon2 := Argon2idPasswordValidator{Time: 1, Memory: 8192, Threads: 1}
+	tests := []struct {
+		algo     string
+		password string
+		tooLong  bool
+	}{
+		{algo: "bcrypt", password: strings.Repeat("a", 72)},
+		{algo: "bcrypt", password: strings.Repeat("a", 73), tooLong: true},
+		{algo: "argon2id", password: strings.Repeat("a", 73)},
+		{algo: "argon2id", password: strings.Repeat("a", maxPasswordLen+1), tooLong: true},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s %d bytes", tt.algo, len(tt.password)), func(t *testing.T) {
+			a := newTestAuth(t, memoryStorage.NewMemoryStorage(), nil, false)
+			a.pv = NewDetectingPasswordValidator(tt.algo, bcrypt, argon2)
+
+			err := a.checkNewPassword(context.Background(), "bob", tt.password, "")
+			var policyErr myerrors.ErrPasswordPolicy
+			tooLong := errors.As(err, &policyErr) && hasViolation(policyErr, violationTooLong)
+			if tooLong != tt.tooLong {
+				t.Fatalf("%d bytes: got error %v, want too long %v", len(tt.password), err, tt.tooLong)
+			}
+		})
+	}
+}
+
+func hasViolation(err myerrors.ErrPasswordPolicy, code string) bool {
+	for _, v := range err.Violations {
+		if v.Code == code {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,78 @@
+package authStorage
+
+import (
+	"clearway-test-task/internal/storage"
+	"context"
+	"time"
+)
+
+const (
+	listenRetryMin = time.Second
+	listenRetryMax = 30 * time.Second
+)
+
+// sessionListener keeps the cache coherent with sessions issued and revoked by other instances.
+// The cache is fully reloaded on every (re)connect, because events sent while disconnected are lost
+func (a *AuthStorage) sessionListener(ctx context.Context) {
+	retry := listenRetryMin
+	for {
+		err := a.db.ListenSessionEvents(ctx, func() {
+			retry = listenRetryMin
+			a.resyncCache()
+		}, a.applySessionEvent)
+		if ctx.Err() != nil {
+			return
+		}
+		a.lg.Error("session events listener stopped", "error", err, "Retry", retry)
+
+		select {
+		case <-time.After(retry):
+		case <-ctx.Done():
+			return
+		}
+		retry = min(retry*2, listenRetryMax)
+	}
+}
+
+func (a *AuthStorage) resyncCache() {
+	if err := a.sessions.Reload(a.loadSessionsFromPrimary); err != nil {
+		a.lg.Error("failed to resync the cache", "error", err)
+		return
+	}
+	a.cacheLoaded.Store(true)
+	a.lg.Debug("auth cache resynced")
+}
+
+// applySessionEvent reloads affected sessions from db instead of trusting event order
+func (a *AuthStorage) applySessionEvent(ev storage.SessionEvent) {
+	ctx, cancel := context.WithTimeout(context.Background(), a.DeleteSessionTimeout)
+	defer cancel()
+
+	var sessions map[string]storage.Token
+	var err error
+	switch ev.Op {
+	case storage.SessionCreated, storage.SessionRevoked:
+		sessions, err = a.db.GetActiveSessionsBySubject(ctx, ev.Subject)
+	case storage.LoginSessionsRevoked:
+		sessions, err = a.db.GetActiveSessionsByLogin(ctx, ev.Login)
+	case storage.SessionsRevoked:
+		a.resyncCache()
+		return
+	default:
+		return
+	}
+	if err != nil {
+		a.lg.Error("failed to apply session event", "error", err, "Op", ev.Op)
+		return
+	}
+
+	switch ev.Op {
+	case storage.SessionCreated, storage.SessionRevoked:
+		a.sessions.Delete(ev.Subject)
+	case storage.LoginSessionsRevoked:
+		a.sessions.DeleteByLogin(ev.Login)
+	}
+	for subject, tkn := range sessions {
+		a.sessions.Set(subject, tkn)
+	}
+}
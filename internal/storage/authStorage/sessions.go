@@ -0,0 +1,42 @@
+package authStorage
+
+import (
+	"clearway-test-task/internal/storage"
+	"context"
+)
+
+// GetSessions returns active sessions of login, or of everyone if login is empty
+func (a *AuthStorage) GetSessions(ctx context.Context, login string) ([]storage.Session, error) {
+	return a.db.GetSessions(ctx, login)
+}
+
+// RevokeSession revokes a single session by its id
+func (a *AuthStorage) RevokeSession(ctx context.Context, id string) error {
+	subject, err := a.db.DeleteSessionById(ctx, id)
+	if err != nil {
+		return err
+	}
+	a.sessions.Delete(subject)
+	return nil
+}
+
+// RevokeSessionsByLogin revokes the user session and sessions of clients acting on behalf of login
+func (a *AuthStorage) RevokeSessionsByLogin(ctx context.Context, login string) error {
+	if err := a.db.DeleteSessionByLogin(ctx, login); err != nil {
+		return err
+	}
+	a.sessions.DeleteByLogin(login)
+	return nil
+}
+
+// RevokeSessionsIssuedBefore revokes sessions issued before the unix timestamp. Returns number of revoked sessions
+func (a *AuthStorage) RevokeSessionsIssuedBefore(ctx context.Context, before int64) (int64, error) {
+	subjects, err := a.db.DeleteSessionsIssuedBefore(ctx, before)
+	if err != nil {
+		return 0, err
+	}
+	for _, subject := range subjects {
+		a.sessions.Delete(subject)
+	}
+	return int64(len(subjects)), nil
+}
@@ -0,0 +1,79 @@
+package authStorage
+
+import (
+	"clearway-test-task/internal/storage"
+	"clearway-test-task/internal/storage/memoryStorage"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRevokeSessionsIssuedBefore(t *testing.T) {
+	ctx := context.Background()
+	db := memoryStorage.NewMemoryStorage()
+	createTestUser(t, db, "bob", "local-password")
+	a := newTestAuth(t, db, nil, false)
+
+	now := time.Now().Unix()
+	for subject, iat := range map[string]int64{"old": now - 100, "new": now} {
+		session := storage.Session{Id: subject, Subject: subject, Login: "bob", Token: subject, IssuedAt: iat, ExpireAt: now + 100}
+		if err := db.UpdateSession(ctx, session); err != nil {
+			t.Fatal(err)
+		}
+		a.sessions.Set(subject, storage.Token{Token: subject, ExpireAt: session.ExpireAt, Login: "bob", SessionId: subject})
+	}
+
+	n, err := a.RevokeSessionsIssuedBefore(ctx, now-50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("got %d revoked sessions, want 1", n)
+	}
+	if _, ok, _ := a.sessions.Get(ctx, "old"); ok {
+		t.Fatal("revoked session still cached")
+	}
+	if _, ok, _ := a.sessions.Get(ctx, "new"); !ok {
+		t.Fatal("session issued later dropped from the cache")
+	}
+}
+
+// laggingDb reads active sessions from a snapshot, like a replica behind the primary, unless the primary is required
+type laggingDb struct {
+	storage.Db
+	snapshot map[string]storage.Token
+}
+
+func (l *laggingDb) GetActiveSessions(ctx context.Context) (map[string]storage.Token, error) {
+	if storage.PrimaryRequired(ctx) {
+		return l.Db.GetActiveSessions(ctx)
+	}
+	return l.snapshot, nil
+}
+
+func TestResyncReadsPrimary(t *testing.T) {
+	ctx := context.Background()
+	mem := memoryStorage.NewMemoryStorage()
+	createTestUser(t, mem, "bob", "local-password")
+	db := &laggingDb{Db: mem}
+	a := newTestAuth(t, db, nil, false)
+
+	now := time.Now().Unix()
+	session := storage.Session{Id: "old", Subject: "old", Login: "bob", Token: "old", IssuedAt: now - 100, ExpireAt: now + 100}
+	if err := mem.UpdateSession(ctx, session); err != nil {
+		t.Fatal(err)
+	}
+	var err error
+	if db.snapshot, err = mem.GetActiveSessions(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// another instance revokes the session and announces it, the replica hasn't caught up yet
+	if _, err = mem.DeleteSessionsIssuedBefore(ctx, now); err != nil {
+		t.Fatal(err)
+	}
+	a.applySessionEvent(storage.SessionEvent{Op: storage.SessionsRevoked})
+
+	if _, ok, _ := a.sessions.Get(ctx, "old"); ok {
+		t.Fatal("session revoked on the primary is cached after resync")
+	}
+}
@@ -0,0 +1,148 @@
+package authStorage
+
+import (
+	myerrors "clearway-test-task/internal/errors"
+	"clearway-test-task/pkg"
+	"clearway-test-task/pkg/totp"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// accepted clock drift in time steps
+	totpSkew          = 1
+	recoveryCodeCount = 10
+	recoveryCodeLen   = 10
+)
+
+// EnrollTotp generates a new secret for the user. Two-factor auth is enabled only after ConfirmTotp.
+// Returns the secret and otpauth uri
+func (a *AuthStorage) EnrollTotp(ctx context.Context, login string) (string, string, error) {
+	t, err := a.db.GetUserTotp(ctx, login)
+	if err != nil {
+		return "", "", err
+	}
+	if t.Enabled {
+		return "", "", myerrors.NewInvalidArgumentError("two-factor auth already enabled")
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate totp secret: %w", err)
+	}
+	if err = a.db.SetUserTotpSecret(ctx, login, secret); err != nil {
+		return "", "", err
+	}
+	return secret, totp.Uri(a.totpIssuer, login, secret), nil
+}
+
+// ConfirmTotp enables two-factor auth if code matches the enrolled secret. Returns plain recovery codes
+func (a *AuthStorage) ConfirmTotp(ctx context.Context, login, code string) ([]string, error) {
+	t, err := a.db.GetUserTotp(ctx, login)
+	if err != nil {
+		return nil, err
+	}
+	if t.Enabled || t.Secret == "" {
+		return nil, myerrors.NewInvalidArgumentError("two-factor auth is not pending confirmation")
+	}
+	step, ok := totp.Validate(t.Secret, code, time.Now(), totpSkew)
+	if !ok {
+		return nil, myerrors.ErrOtpInvalid
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		c, err := generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, c)
+		hashes = append(hashes, a.hashRecoveryCode(c))
+	}
+	if err = a.db.EnableUserTotp(ctx, login, step, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// DisableTotp turns two-factor auth off. Requires a valid code or an unused recovery code
+func (a *AuthStorage) DisableTotp(ctx context.Context, login, code string) error {
+	t, err := a.db.GetUserTotp(ctx, login)
+	if err != nil {
+		return err
+	}
+	if !t.Enabled {
+		return myerrors.NewInvalidArgumentError("two-factor auth is not enabled")
+	}
+	if err = a.verifyOtp(ctx, login, t.Secret, code); err != nil {
+		return err
+	}
+	return a.db.DisableUserTotp(ctx, login)
+}
+
+// secondFactor checks one-time code of the user if two-factor auth is enabled
+func (a *AuthStorage) secondFactor(ctx context.Context, login, code string) error {
+	t, err := a.db.GetUserTotp(ctx, login)
+	if err != nil {
+		return err
+	}
+	if !t.Enabled {
+		return nil
+	}
+	if code == "" {
+		return myerrors.ErrOtpRequired
+	}
+	return a.verifyOtp(ctx, login, t.Secret, code)
+}
+
+// verifyOtp accepts either totp code or recovery code. Each of them can be used only once
+func (a *AuthStorage) verifyOtp(ctx context.Context, login, secret, code string) error {
+	if step, ok := totp.Validate(secret, code, time.Now(), totpSkew); ok {
+		fresh, err := a.db.UpdateUserTotpStep(ctx, login, step)
+		if err != nil {
+			return err
+		}
+		if !fresh {
+			return fmt.Errorf("%w: code already used", myerrors.ErrOtpInvalid)
+		}
+		return nil
+	}
+
+	used, err := a.db.UseRecoveryCode(ctx, login, a.hashRecoveryCode(code))
+	if err != nil {
+		return err
+	}
+	if !used {
+		return myerrors.ErrOtpInvalid
+	}
+	a.lg.Info("recovery code used", "Login", login)
+	return nil
+}
+
+// hashRecoveryCode uses keyed hash, so codes can be looked up directly and can't be brute-forced from a db dump
+func (a *AuthStorage) hashRecoveryCode(code string) string {
+	mac := hmac.New(sha256.New, pkg.ConvertStrToBytes(a.hmacSecret))
+	mac.Write(pkg.ConvertStrToBytes(normalizeRecoveryCode(code)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func generateRecoveryCode() (string, error) {
+	b := make([]byte, recoveryCodeLen)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate recovery code: %w", err)
+	}
+	c := base32.StdEncoding.EncodeToString(b)[:recoveryCodeLen]
+	return c[:recoveryCodeLen/2] + "-" + c[recoveryCodeLen/2:], nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
@@ -0,0 +1,106 @@
+package authStorage
+
+import (
+	myerrors "clearway-test-task/internal/errors"
+	"clearway-test-task/internal/storage/memoryStorage"
+	"clearway-test-task/pkg/totp"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func totpCode(t *testing.T, secret string, step int64) string {
+	t.Helper()
+	code, err := totp.Code(secret, step)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+// enableTotp enrolls bob and confirms with the code of the current step, returns the secret, the confirmed step
+// and recovery codes
+func enableTotp(t *testing.T, a *AuthStorage) (string, int64, []string) {
+	t.Helper()
+	ctx := context.Background()
+	secret, uri, err := a.EnrollTotp(ctx, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(uri, "secret="+secret) {
+		t.Fatalf("uri %q lacks the secret", uri)
+	}
+	if _, err = a.ConfirmTotp(ctx, "bob", "abcdef"); !errors.Is(err, myerrors.ErrOtpInvalid) {
+		t.Fatalf("got error %v confirming invalid code, want otp invalid", err)
+	}
+	step := totp.Step(time.Now())
+	codes, err := a.ConfirmTotp(ctx, "bob", totpCode(t, secret, step))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != recoveryCodeCount {
+		t.Fatalf("got %d recovery codes, want %d", len(codes), recoveryCodeCount)
+	}
+	return secret, step, codes
+}
+
+func TestTotpLogin(t *testing.T) {
+	ctx := context.Background()
+	db := memoryStorage.NewMemoryStorage()
+	createTestUser(t, db, "bob", "local-password")
+	a := newTestAuth(t, db, nil, false)
+	secret, step, _ := enableTotp(t, a)
+
+	tests := []struct {
+		name string
+		otp  string
+		err  error
+	}{
+		{name: "missing code", err: myerrors.ErrOtpRequired},
+		{name: "wrong code", otp: "abcdef", err: myerrors.ErrOtpInvalid},
+		// the confirming code can't be replayed
+		{name: "confirmed step", otp: totpCode(t, secret, step), err: myerrors.ErrOtpInvalid},
+		{name: "next step", otp: totpCode(t, secret, step+1)},
+		{name: "replayed step", otp: totpCode(t, secret, step+1), err: myerrors.ErrOtpInvalid},
+		// steps before the last used one are rejected even within the skew window
+		{name: "earlier step", otp: totpCode(t, secret, step), err: myerrors.ErrOtpInvalid},
+		{name: "beyond skew", otp: totpCode(t, secret, step+3), err: myerrors.ErrOtpInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := a.GetToken(ctx, "bob", "local-password", tt.otp)
+			if !errors.Is(err, tt.err) || (err == nil) != (tt.err == nil) {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestTotpRecoveryCode(t *testing.T) {
+	ctx := context.Background()
+	db := memoryStorage.NewMemoryStorage()
+	createTestUser(t, db, "bob", "local-password")
+	a := newTestAuth(t, db, nil, false)
+	_, _, codes := enableTotp(t, a)
+
+	// codes are accepted regardless of case, spaces and dashes
+	relaxed := " " + strings.ToLower(strings.ReplaceAll(codes[0], "-", "")) + " "
+	if _, _, err := a.GetToken(ctx, "bob", "local-password", relaxed); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := a.GetToken(ctx, "bob", "local-password", codes[0]); !errors.Is(err, myerrors.ErrOtpInvalid) {
+		t.Fatalf("got error %v reusing recovery code, want otp invalid", err)
+	}
+
+	if err := a.DisableTotp(ctx, "bob", "wrong"); !errors.Is(err, myerrors.ErrOtpInvalid) {
+		t.Fatalf("got error %v disabling with wrong code, want otp invalid", err)
+	}
+	if err := a.DisableTotp(ctx, "bob", codes[1]); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := a.GetToken(ctx, "bob", "local-password", ""); err != nil {
+		t.Fatalf("got error %v after disabling two-factor auth", err)
+	}
+}
@@ -0,0 +1,219 @@
+package authStorage
+
+import (
+	"clearway-test-task/internal/storage"
+	"context"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("clearway-test-task/internal/storage/authStorage")
+
+// TracedAuth traces every call of storage.Auth as a child of the request span
+type TracedAuth struct {
+	next storage.Auth
+}
+
+func NewTracedAuth(next storage.Auth) *TracedAuth {
+	return &TracedAuth{next: next}
+}
+
+func startSpan(ctx context.Context, name string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "auth."+name)
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func (t *TracedAuth) GetToken(ctx context.Context, login, password, otp string) (string, int64, error) {
+	ctx, span := startSpan(ctx, "GetToken")
+	token, exp, err := t.next.GetToken(ctx, login, password, otp)
+	endSpan(span, err)
+	return token, exp, err
+}
+
+// ValidateToken isn't traced, it gets no context to link the span to. The calling middleware is traced instead
+func (t *TracedAuth) ValidateToken(token string) (storage.Claims, error) {
+	return t.next.ValidateToken(token)
+}
+
+func (t *TracedAuth) ValidateApiKey(ctx context.Context, key string) (string, string, error) {
+	ctx, span := startSpan(ctx, "ValidateApiKey")
+	login, scope, err := t.next.ValidateApiKey(ctx, key)
+	endSpan(span, err)
+	return login, scope, err
+}
+
+func (t *TracedAuth) CreateApiKey(ctx context.Context, login, scope string, expiresIn int64) (string, storage.ApiKey, error) {
+	ctx, span := startSpan(ctx, "CreateApiKey")
+	plain, key, err := t.next.CreateApiKey(ctx, login, scope, expiresIn)
+	endSpan(span, err)
+	return plain, key, err
+}
+
+func (t *TracedAuth) GetApiKeys(ctx context.Context, login string) ([]storage.ApiKey, error) {
+	ctx, span := startSpan(ctx, "GetApiKeys")
+	keys, err := t.next.GetApiKeys(ctx, login)
+	endSpan(span, err)
+	return keys, err
+}
+
+func (t *TracedAuth) RevokeApiKey(ctx context.Context, login string, id int64) error {
+	ctx, span := startSpan(ctx, "RevokeApiKey")
+	err := t.next.RevokeApiKey(ctx, login, id)
+	endSpan(span, err)
+	return err
+}
+
+func (t *TracedAuth) Register(ctx context.Context, login, password string) error {
+	ctx, span := startSpan(ctx, "Register")
+	err := t.next.Register(ctx, login, password)
+	endSpan(span, err)
+	return err
+}
+
+func (t *TracedAuth) ChangePassword(ctx context.Context, login, currentPassword, newPassword string) error {
+	ctx, span := startSpan(ctx, "ChangePassword")
+	err := t.next.ChangePassword(ctx, login, currentPassword, newPassword)
+	endSpan(span, err)
+	return err
+}
+
+func (t *TracedAuth) ChangeExpiredPassword(ctx context.Context, login, currentPassword, otp, newPassword string) error {
+	ctx, span := startSpan(ctx, "ChangeExpiredPassword")
+	err := t.next.ChangeExpiredPassword(ctx, login, currentPassword, otp, newPassword)
+	endSpan(span, err)
+	return err
+}
+
+func (t *TracedAuth) DeleteUser(ctx context.Context, login string) error {
+	ctx, span := startSpan(ctx, "DeleteUser")
+	err := t.next.DeleteUser(ctx, login)
+	endSpan(span, err)
+	return err
+}
+
+func (t *TracedAuth) IsAdmin(ctx context.Context, login string) (bool, error) {
+	ctx, span := startSpan(ctx, "IsAdmin")
+	admin, err := t.next.IsAdmin(ctx, login)
+	endSpan(span, err)
+	return admin, err
+}
+
+func (t *TracedAuth) EnrollTotp(ctx context.Context, login string) (string, string, error) {
+	ctx, span := startSpan(ctx, "EnrollTotp")
+	secret, uri, err := t.next.EnrollTotp(ctx, login)
+	endSpan(span, err)
+	return secret, uri, err
+}
+
+func (t *TracedAuth) ConfirmTotp(ctx context.Context, login, code string) ([]string, error) {
+	ctx, span := startSpan(ctx, "ConfirmTotp")
+	codes, err := t.next.ConfirmTotp(ctx, login, code)
+	endSpan(span, err)
+	return codes, err
+}
+
+func (t *TracedAuth) DisableTotp(ctx context.Context, login, code string) error {
+	ctx, span := startSpan(ctx, "DisableTotp")
+	err := t.next.DisableTotp(ctx, login, code)
+	endSpan(span, err)
+	return err
+}
+
+func (t *TracedAuth) PasswordGrant(ctx context.Context, login, password, otp, scope string, client *storage.Client) (string, int64, string, error) {
+	ctx, span := startSpan(ctx, "PasswordGrant")
+	token, exp, scope, err := t.next.PasswordGrant(ctx, login, password, otp, scope, client)
+	endSpan(span, err)
+	return token, exp, scope, err
+}
+
+func (t *TracedAuth) ClientCredentialsGrant(ctx context.Context, client storage.Client, scope string) (string, int64, string, error) {
+	ctx, span := startSpan(ctx, "ClientCredentialsGrant")
+	token, exp, scope, err := t.next.ClientCredentialsGrant(ctx, client, scope)
+	endSpan(span, err)
+	return token, exp, scope, err
+}
+
+func (t *TracedAuth) AuthenticateClient(ctx context.Context, clientId, secret string) (storage.Client, error) {
+	ctx, span := startSpan(ctx, "AuthenticateClient")
+	client, err := t.next.AuthenticateClient(ctx, clientId, secret)
+	endSpan(span, err)
+	return client, err
+}
+
+func (t *TracedAuth) CreateClient(ctx context.Context, login, scope string) (string, storage.Client, error) {
+	ctx, span := startSpan(ctx, "CreateClient")
+	secret, client, err := t.next.CreateClient(ctx, login, scope)
+	endSpan(span, err)
+	return secret, client, err
+}
+
+func (t *TracedAuth) GetClients(ctx context.Context) ([]storage.Client, error) {
+	ctx, span := startSpan(ctx, "GetClients")
+	clients, err := t.next.GetClients(ctx)
+	endSpan(span, err)
+	return clients, err
+}
+
+func (t *TracedAuth) DeleteClient(ctx context.Context, clientId string) error {
+	ctx, span := startSpan(ctx, "DeleteClient")
+	err := t.next.DeleteClient(ctx, clientId)
+	endSpan(span, err)
+	return err
+}
+
+func (t *TracedAuth) ExternalLogin(ctx context.Context, issuer, subject, preferredLogin string, provision bool) (string, int64, error) {
+	ctx, span := startSpan(ctx, "ExternalLogin")
+	token, exp, err := t.next.ExternalLogin(ctx, issuer, subject, preferredLogin, provision)
+	endSpan(span, err)
+	return token, exp, err
+}
+
+func (t *TracedAuth) LinkIdentity(ctx context.Context, issuer, subject, login string) error {
+	ctx, span := startSpan(ctx, "LinkIdentity")
+	err := t.next.LinkIdentity(ctx, issuer, subject, login)
+	endSpan(span, err)
+	return err
+}
+
+func (t *TracedAuth) GetSessions(ctx context.Context, login string) ([]storage.Session, error) {
+	ctx, span := startSpan(ctx, "GetSessions")
+	sessions, err := t.next.GetSessions(ctx, login)
+	endSpan(span, err)
+	return sessions, err
+}
+
+func (t *TracedAuth) RevokeSession(ctx context.Context, id string) error {
+	ctx, span := startSpan(ctx, "RevokeSession")
+	err := t.next.RevokeSession(ctx, id)
+	endSpan(span, err)
+	return err
+}
+
+func (t *TracedAuth) RevokeSessionsByLogin(ctx context.Context, login string) error {
+	ctx, span := startSpan(ctx, "RevokeSessionsByLogin")
+	err := t.next.RevokeSessionsByLogin(ctx, login)
+	endSpan(span, err)
+	return err
+}
+
+func (t *TracedAuth) RevokeSessionsIssuedBefore(ctx context.Context, before int64) (int64, error) {
+	ctx, span := startSpan(ctx, "RevokeSessionsIssuedBefore")
+	revoked, err := t.next.RevokeSessionsIssuedBefore(ctx, before)
+	endSpan(span, err)
+	return revoked, err
+}
+
+func (t *TracedAuth) GetLoginEvents(ctx context.Context, login string, beforeId int64, limit int) ([]storage.LoginEvent, error) {
+	ctx, span := startSpan(ctx, "GetLoginEvents")
+	events, err := t.next.GetLoginEvents(ctx, login, beforeId, limit)
+	endSpan(span, err)
+	return events, err
+}
@@ -0,0 +1,94 @@
+package authStorage
+
+import (
+	myerrors "clearway-test-task/internal/errors"
+	"clearway-test-task/pkg/validator"
+	"context"
+)
+
+const (
+	loginValidationTag = "alphanum,min=3,max=32"
+
+	roleUser  = "user"
+	roleAdmin = "admin"
+)
+
+// Register creates a new user with the given credentials
+func (a *AuthStorage) Register(ctx context.Context, login, password string) error {
+	if err := validator.ValInstance.ValidateWithTag(login, loginValidationTag); err != nil {
+		return myerrors.NewInvalidArgumentError("invalid login")
+	}
+	if err := a.checkNewPassword(ctx, login, password, ""); err != nil {
+		return err
+	}
+
+	hash, err := a.pv.Hash(password)
+	if err != nil {
+		return err
+	}
+	return a.db.CreateUser(ctx, login, hash)
+}
+
+// ChangePassword verifies the current password, stores the new one and revokes sessions of the user
+func (a *AuthStorage) ChangePassword(ctx context.Context, login, currentPassword, newPassword string) error {
+	if err := a.auth(ctx, login, currentPassword); err != nil {
+		return err
+	}
+	return a.setPassword(ctx, login, newPassword)
+}
+
+// ChangeExpiredPassword is ChangePassword for users who can't log in because their password expired,
+// so it checks the second factor as well
+func (a *AuthStorage) ChangeExpiredPassword(ctx context.Context, login, currentPassword, otp, newPassword string) error {
+	if err := a.auth(ctx, login, currentPassword); err != nil {
+		return err
+	}
+	if err := a.secondFactor(ctx, login, otp); err != nil {
+		return err
+	}
+	return a.setPassword(ctx, login, newPassword)
+}
+
+func (a *AuthStorage) setPassword(ctx context.Context, login, password string) error {
+	hash, err := a.db.GetUserPwdHashByLogin(ctx, login)
+	if err != nil {
+		return err
+	}
+	if isManagedPassword(hash) {
+		return myerrors.NewInvalidArgumentError("password is managed by external provider")
+	}
+	if err = a.checkNewPassword(ctx, login, password, hash); err != nil {
+		return err
+	}
+
+	if hash, err = a.pv.Hash(password); err != nil {
+		return err
+	}
+	if err = a.db.ChangeUserPwd(ctx, login, hash, max(a.policy.History-1, 0)); err != nil {
+		return err
+	}
+	if err = a.db.DeleteSessionByLogin(ctx, login); err != nil {
+		return err
+	}
+	a.sessions.DeleteByLogin(login)
+
+	return nil
+}
+
+// DeleteUser soft-deletes the user and revokes its sessions, api keys and clients
+func (a *AuthStorage) DeleteUser(ctx context.Context, login string) error {
+	if err := a.db.DeleteUser(ctx, login); err != nil {
+		return err
+	}
+	a.sessions.DeleteByLogin(login)
+
+	return nil
+}
+
+func (a *AuthStorage) IsAdmin(ctx context.Context, login string) (bool, error) {
+	role, err := a.db.GetUserRole(ctx, login)
+	if err != nil {
+		return false, err
+	}
+	return role == roleAdmin, nil
+}
@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type clientInfoKey struct{}
+
+// ClientInfo describes the party performing the request, it is passed down to storage for auditing
+type ClientInfo struct {
+	Ip        string
+	UserAgent string
+}
+
+func WithClientInfo(ctx context.Context, info ClientInfo) context.Context {
+	return context.WithValue(ctx, clientInfoKey{}, info)
+}
+
+// ClientInfoFromContext returns client info or zero value if not set
+func ClientInfoFromContext(ctx context.Context) ClientInfo {
+	info, _ := ctx.Value(clientInfoKey{}).(ClientInfo)
+	return info
+}
+
+type unavailableKey struct{}
+
+// Unavailable is set by storage rejecting an operation of the request because the database is down
+type Unavailable struct {
+	mtx        sync.Mutex
+	set        bool
+	retryAfter time.Duration
+}
+
+// WithUnavailable lets storage report the request it failed fast, handlers keep returning their usual errors
+func WithUnavailable(ctx context.Context) (context.Context, *Unavailable) {
+	u := &Unavailable{}
+	return context.WithValue(ctx, unavailableKey{}, u), u
+}
+
+// MarkUnavailable records that the request may succeed after retryAfter. No-op if ctx isn't from WithUnavailable
+func MarkUnavailable(ctx context.Context, retryAfter time.Duration) {
+	u, ok := ctx.Value(unavailableKey{}).(*Unavailable)
+	if !ok {
+		return
+	}
+	u.mtx.Lock()
+	defer u.mtx.Unlock()
+	u.set = true
+	u.retryAfter = max(u.retryAfter, retryAfter)
+}
+
+// RetryAfter returns the longest time reported by MarkUnavailable, false if storage didn't fail fast
+func (u *Unavailable) RetryAfter() (time.Duration, bool) {
+	u.mtx.Lock()
+	defer u.mtx.Unlock()
+	return u.retryAfter, u.set
+}
+
+type primaryKey struct{}
+
+// WithPrimary makes reads done with ctx skip replicas. Reloads triggered by other instances need it,
+// replicas may not have the changes those instances announced yet
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryKey{}, true)
+}
+
+// PrimaryRequired reports whether ctx is from WithPrimary
+func PrimaryRequired(ctx context.Context) bool {
+	primary, _ := ctx.Value(primaryKey{}).(bool)
+	return primary
+}
@@ -0,0 +1,198 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Breaker configures failing fast while the primary is unreachable
+type Breaker struct {
+	// Threshold is the number of consecutive operations failed to reach the database which opens the breaker,
+	// 0 disables it
+	Threshold int
+	// OpenTimeout is how long operations are rejected before a probe query checks the database again
+	OpenTimeout time.Duration
+}
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// breaker rejects operations while open. Once OpenTimeout passes it goes half-open and runs a probe query,
+// operations are still rejected until the probe succeeds and closes it
+type breaker struct {
+	cfg   Breaker
+	probe func(ctx context.Context) error
+
+	mtx      sync.Mutex
+	state    breakerState
+	failures int
+	// until is when the next probe is due, or is expected to finish once running
+	until  time.Time
+	timer  *time.Timer
+	closed bool
+	lg     *slog.Logger
+}
+
+func newBreaker(cfg Breaker, probe func(ctx context.Context) error, lg *slog.Logger) *breaker {
+	if cfg.Threshold <= 0 {
+		return nil
+	}
+	return &breaker{cfg: cfg, probe: probe, lg: lg}
+}
+
+// isUnavailable reports whether err means the database couldn't be reached, unlike errors of the operation itself
+func isUnavailable(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case pgerrcode.AdminShutdown, pgerrcode.CrashShutdown, pgerrcode.CannotConnectNow, pgerrcode.TooManyConnections:
+			return true
+		}
+		return pgerrcode.IsConnectionException(pgErr.Code)
+	}
+	var connErr *pgconn.ConnectError
+	return errors.As(err, &connErr) || pgconn.SafeToRetry(err)
+}
+
+type acquireKey struct{}
+
+// withAcquire lets queryTracer report that the pool failed to hand out a connection for operations run with ctx
+func withAcquire(ctx context.Context) (context.Context, *atomic.Bool) {
+	failed := &atomic.Bool{}
+	return context.WithValue(ctx, acquireKey{}, failed), failed
+}
+
+func markAcquireFailed(ctx context.Context) {
+	if failed, ok := ctx.Value(acquireKey{}).(*atomic.Bool); ok {
+		failed.Store(true)
+	}
+}
+
+type outcome int
+
+const (
+	// reached means the database responded, even if with an error of the operation
+	reached outcome = iota
+	unreachable
+	// unknown errors, like a query canceled mid-flight, tell nothing about the database
+	unknown
+)
+
+// classify tells what err of an operation run with ctx says about the database. A hanging database makes the
+// pool wait for a connection until the deadline, which counts unless the caller gave up first
+func classify(ctx context.Context, err error, acquireFailed bool) outcome {
+	switch {
+	case err == nil:
+		return reached
+	case isUnavailable(err):
+		return unreachable
+	case errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled):
+		if acquireFailed && errors.Is(err, context.DeadlineExceeded) && !errors.Is(ctx.Err(), context.Canceled) {
+			return unreachable
+		}
+		return unknown
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) || errors.Is(err, pgx.ErrNoRows) {
+		return reached
+	}
+	return unknown
+}
+
+// allow returns false with the time left until the breaker may close if operations are rejected
+func (b *breaker) allow() (time.Duration, bool) {
+	if b == nil {
+		return 0, true
+	}
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if b.state == breakerClosed {
+		return 0, true
+	}
+	return max(time.Until(b.until), 0), false
+}
+
+// record counts the outcome of an allowed operation, err is the one it failed with if any.
+// Operations finished while open don't change the state, only the probe closes the breaker
+func (b *breaker) record(o outcome, err error) {
+	if b == nil {
+		return
+	}
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if b.state != breakerClosed {
+		return
+	}
+	switch o {
+	case reached:
+		b.failures = 0
+		return
+	case unknown:
+		return
+	}
+	b.failures++
+	if b.failures >= b.cfg.Threshold {
+		b.lg.Error("db circuit breaker opened", "Failures", b.failures, "error", err)
+		b.open()
+	}
+}
+
+// open must be called with mtx held
+func (b *breaker) open() {
+	b.state = breakerOpen
+	b.failures = 0
+	b.until = time.Now().Add(b.cfg.OpenTimeout)
+	if !b.closed {
+		b.timer = time.AfterFunc(b.cfg.OpenTimeout, b.halfOpen)
+	}
+}
+
+func (b *breaker) halfOpen() {
+	b.mtx.Lock()
+	if b.closed {
+		b.mtx.Unlock()
+		return
+	}
+	b.state = breakerHalfOpen
+	b.until = time.Now().Add(b.cfg.OpenTimeout)
+	b.mtx.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), connectTimeout)
+	err := b.probe(ctx)
+	cancel()
+
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if err != nil {
+		b.lg.Warn("db circuit breaker probe failed", "error", err)
+		b.open()
+		return
+	}
+	b.state = breakerClosed
+	b.lg.Info("db circuit breaker closed")
+}
+
+// close stops probing, a probe already running finishes without scheduling another one
+func (b *breaker) close() {
+	if b == nil {
+		return
+	}
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	b.closed = true
+	if b.timer != nil {
+		b.timer.Stop()
+	}
+}
@@ -0,0 +1,164 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"io"
+	"log/slog"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var errConnect = &pgconn.PgError{Code: pgerrcode.CannotConnectNow}
+
+func waitState(t *testing.T, b *breaker, want breakerState) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		b.mtx.Lock()
+		state := b.state
+		b.mtx.Unlock()
+		if state == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("breaker state is %d, want %d", state, want)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestBreaker(t *testing.T) {
+	lg := slog.New(slog.NewTextHandler(io.Discard, nil))
+	var probeErr atomic.Pointer[error]
+	probed := make(chan struct{}, 10)
+	b := newBreaker(Breaker{Threshold: 3, OpenTimeout: 20 * time.Millisecond}, func(context.Context) error {
+		probed <- struct{}{}
+		if err := probeErr.Load(); err != nil {
+			return *err
+		}
+		return nil
+	}, lg)
+	t.Cleanup(b.close)
+	failing := error(errConnect)
+	probeErr.Store(&failing)
+
+	for i := 0; i < 2; i++ {
+		b.record(unreachable, errConnect)
+	}
+	// unknown outcomes keep the count, a success resets it
+	b.record(unknown, context.Canceled)
+	if _, ok := b.allow(); !ok {
+		t.Fatal("breaker opened below the threshold")
+	}
+	b.record(reached, nil)
+	for i := 0; i < 2; i++ {
+		b.record(unreachable, errConnect)
+	}
+	if _, ok := b.allow(); !ok {
+		t.Fatal("success didn't reset the failures")
+	}
+
+	b.record(unreachable, errConnect)
+	if after, ok := b.allow(); ok || after <= 0 {
+		t.Fatalf("breaker isn't open after the threshold, allow returned %v, %v", after, ok)
+	}
+	// the first probe fails and opens the breaker again
+	<-probed
+	waitState(t, b, breakerOpen)
+	if _, ok := b.allow(); ok {
+		t.Fatal("failed probe closed the breaker")
+	}
+
+	probeErr.Store(nil)
+	<-probed
+	waitState(t, b, breakerClosed)
+	if _, ok := b.allow(); !ok {
+		t.Fatal("successful probe didn't close the breaker")
+	}
+}
+
+func TestBreakerHalfOpenRejects(t *testing.T) {
+	lg := slog.New(slog.NewTextHandler(io.Discard, nil))
+	release := make(chan struct{})
+	b := newBreaker(Breaker{Threshold: 1, OpenTimeout: time.Millisecond}, func(context.Context) error {
+		<-release
+		return nil
+	}, lg)
+	t.Cleanup(b.close)
+
+	b.record(unreachable, errConnect)
+	waitState(t, b, breakerHalfOpen)
+	// operations finished meanwhile don't close it, only the probe does
+	b.record(reached, nil)
+	if _, ok := b.allow(); ok {
+		t.Fatal("half-open breaker allowed an operation")
+	}
+	close(release)
+	waitState(t, b, breakerClosed)
+}
+
+func TestBreakerDisabled(t *testing.T) {
+	b := newBreaker(Breaker{}, nil, nil)
+	b.record(unreachable, errConnect)
+	if _, ok := b.allow(); !ok {
+		t.Fatal("disabled breaker rejected an operation")
+	}
+}
+
+func TestClassify(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		name          string
+		ctx           context.Context
+		err           error
+		acquireFailed bool
+		want          outcome
+	}{
+		{name: "success", err: nil, want: reached},
+		{name: "connection exception", err: &pgconn.PgError{Code: pgerrcode.ConnectionFailure}, want: unreachable},
+		{name: "connect error", err: &pgconn.ConnectError{}, want: unreachable},
+		{name: "operation error", err: &pgconn.PgError{Code: pgerrcode.UniqueViolation}, want: reached},
+		{name: "no rows", err: pgx.ErrNoRows, want: reached},
+		{name: "acquire timed out", err: context.DeadlineExceeded, acquireFailed: true, want: unreachable},
+		{name: "query timed out", err: context.DeadlineExceeded, want: unknown},
+		{name: "caller canceled acquire", ctx: canceled, err: context.Canceled, acquireFailed: true, want: unknown},
+		{name: "other error", err: errors.New("failed"), want: unknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := tt.ctx
+			if ctx == nil {
+				ctx = context.Background()
+			}
+			if got := classify(ctx, tt.err, tt.acquireFailed); got != tt.want {
+				t.Fatalf("got %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestRetryOpensBreakerOnAcquireTimeout simulates a hanging database: the pool times out handing out connections
+func TestRetryOpensBreakerOnAcquireTimeout(t *testing.T) {
+	d := newTestDb(Retry{Attempts: 1}, Breaker{Threshold: 2, OpenTimeout: time.Hour})
+	var runs int
+	op := func(ctx context.Context) error {
+		runs++
+		queryTracer{}.TraceAcquireEnd(ctx, nil, pgxpool.TraceAcquireEndData{Err: context.DeadlineExceeded})
+		return context.DeadlineExceeded
+	}
+	for i := 0; i < 2; i++ {
+		if err := d.retry(context.Background(), "Op", op); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("got %v, want deadline exceeded", err)
+		}
+	}
+	if err := d.retry(context.Background(), "Op", op); err == nil || runs != 2 {
+		t.Fatalf("open breaker ran the operation, got %v after %d runs", err, runs)
+	}
+}
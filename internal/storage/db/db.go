@@ -4,17 +4,81 @@ import (
 	myerrors "clearway-test-task/internal/errors"
 	"clearway-test-task/internal/storage"
 	"context"
-	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
-	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/google/uuid"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"log/slog"
+	"strconv"
+	"strings"
 	"time"
 )
 
 const queryGetUserPwdHash = `
     SELECT pwd FROM "users"
-    WHERE login = $1;
+    WHERE login = $1 AND deleted_at =0;
+`
+const queryGetUserRole = `
+    SELECT role FROM "users"
+    WHERE login = $1 AND deleted_at =0;
+`
+const queryCreateUser = `
+    INSERT INTO "users" (login, pwd, created_at)
+    VALUES ($1, $2, EXTRACT(EPOCH FROM NOW()));
+`
+const queryUpdateUserPwd = `
+    UPDATE "users"
+    SET pwd = $2, updated_at = EXTRACT(EPOCH FROM NOW())
+    WHERE login = $1 AND deleted_at =0;
+`
+const queryLockUserPwd = `
+    SELECT pwd FROM "users"
+    WHERE login = $1 AND deleted_at =0
+    FOR UPDATE;
+`
+const queryChangeUserPwd = `
+    UPDATE "users"
+    SET pwd = $2, pwd_changed_at = EXTRACT(EPOCH FROM NOW()), updated_at = EXTRACT(EPOCH FROM NOW())
+    WHERE login = $1 AND deleted_at =0;
+`
+const queryGetUserPwdChangedAt = `
+    SELECT pwd_changed_at FROM "users"
+    WHERE login = $1 AND deleted_at =0;
+`
+const queryInsertPwdHistory = `
+    INSERT INTO "password_history" (user_login, pwd)
+    VALUES ($1, $2);
+`
+const queryPrunePwdHistory = `
+    DELETE FROM "password_history"
+    WHERE user_login = $1 AND id NOT IN (
+        SELECT id FROM "password_history" WHERE user_login = $1 ORDER BY id DESC LIMIT $2
+    );
+`
+const queryGetPwdHistory = `
+    SELECT pwd FROM "password_history"
+    WHERE user_login = $1
+    ORDER BY id DESC
+    LIMIT $2;
+`
+const queryUpdateUserRole = `
+    UPDATE "users"
+    SET role = $2, updated_at = EXTRACT(EPOCH FROM NOW())
+    WHERE login = $1 AND deleted_at =0;
+`
+const queryDeleteUser = `
+    UPDATE "users"
+    SET deleted_at = EXTRACT(EPOCH FROM NOW())
+    WHERE login = $1 AND deleted_at =0;
+`
+const queryDeleteApiKeysByLogin = `
+    UPDATE "api_keys"
+    SET deleted_at = EXTRACT(EPOCH FROM NOW())
+    WHERE user_login = $1 AND deleted_at =0;
 `
 const queryGetDataByAssetName = `
     SELECT data, content_type FROM "files" 
@@ -38,19 +102,69 @@ const queryDeleteDataByAssetName = `
 `
 
 const queryGetActiveSession = `
-    SELECT user_login, token, exp FROM "sessions"
+    SELECT sid, subject, user_login, token, exp FROM "sessions"
     WHERE deleted_at =0;
 `
 
+const queryGetActiveSessionBySubject = `
+    SELECT sid, subject, user_login, token, exp FROM "sessions"
+    WHERE subject = $1 AND deleted_at =0;
+`
+
+const queryGetActiveSessionByLogin = `
+    SELECT sid, subject, user_login, token, exp FROM "sessions"
+    WHERE user_login = $1 AND deleted_at =0;
+`
+
+const queryGetSessions = `
+    SELECT sid, subject, user_login, scope, iat, exp FROM "sessions"
+    WHERE ($1 = '' OR user_login = $1) AND deleted_at =0 AND exp > EXTRACT(EPOCH FROM NOW())
+    ORDER BY iat DESC;
+`
+
+const queryDeleteSessionById = `
+    UPDATE "sessions"
+    SET deleted_at = EXTRACT(EPOCH FROM NOW())
+    WHERE sid = $1 AND deleted_at =0
+    RETURNING subject;
+`
+
+const queryDeleteSessionsIssuedBefore = `
+    UPDATE "sessions"
+    SET deleted_at = EXTRACT(EPOCH FROM NOW())
+    WHERE iat < $1 AND deleted_at =0
+    RETURNING subject;
+`
+
+const queryProbe = `
+    SELECT 1;
+`
+
+// connectTimeout bounds opening the pool at startup
+const connectTimeout = 30 * time.Second
+
+// sessionsChannel delivers session events to every instance. Notifications are sent on commit
+const sessionsChannel = "sessions"
+
+const queryNotifySession = `
+    SELECT pg_notify('` + sessionsChannel + `', $1);
+`
+
 const querySetSessionUpdate = `
     UPDATE "sessions"
 	SET deleted_at = EXTRACT(EPOCH FROM NOW())
-	WHERE user_login = $1 AND deleted_at =0;
+	WHERE subject = $1 AND deleted_at =0;
 `
 
 const querySetSessionInsert = `
-    INSERT INTO "sessions" (user_login, token, iat, exp, created_at)
-	VALUES ($1, $2, $3, $4, EXTRACT(EPOCH FROM NOW()));
+    INSERT INTO "sessions" (sid, subject, user_login, token, scope, iat, exp, created_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, EXTRACT(EPOCH FROM NOW()));
+`
+
+const queryDeleteSessionBySubject = `
+    UPDATE "sessions"
+    SET deleted_at = EXTRACT(EPOCH FROM NOW())
+    WHERE subject = $1 AND deleted_at =0;
 `
 
 const queryDeleteSessionByLogin = `
@@ -59,101 +173,401 @@ const queryDeleteSessionByLogin = `
     WHERE user_login = $1 AND deleted_at =0;
 `
 
+const queryCreateApiKey = `
+    INSERT INTO "api_keys" (user_login, prefix, key_hash, scope, expire_at, created_at)
+    VALUES ($1, $2, $3, $4, $5, EXTRACT(EPOCH FROM NOW()))
+    RETURNING id;
+`
+
+const queryGetApiKeyByPrefix = `
+    SELECT id, user_login, prefix, key_hash, scope, expire_at, last_used_at, created_at FROM "api_keys"
+    WHERE prefix = $1 AND deleted_at =0;
+`
+
+const queryGetApiKeysByLogin = `
+    SELECT id, user_login, prefix, key_hash, scope, expire_at, last_used_at, created_at FROM "api_keys"
+    WHERE user_login = $1 AND deleted_at =0
+    ORDER BY id;
+`
+
+const queryDeleteApiKey = `
+    UPDATE "api_keys"
+    SET deleted_at = EXTRACT(EPOCH FROM NOW())
+    WHERE id = $1 AND user_login = $2 AND deleted_at =0;
+`
+
+const queryUpdateApiKeyLastUsed = `
+    UPDATE "api_keys"
+    SET last_used_at = $2
+    WHERE id = $1 AND deleted_at =0;
+`
+
+const queryGetUserTotp = `
+    SELECT totp_secret, totp_enabled, totp_last_step FROM "users"
+    WHERE login = $1 AND deleted_at =0;
+`
+
+const querySetUserTotpSecret = `
+    UPDATE "users"
+    SET totp_secret = $2, totp_enabled = false, totp_last_step = 0, updated_at = EXTRACT(EPOCH FROM NOW())
+    WHERE login = $1 AND deleted_at =0;
+`
+
+const queryEnableUserTotp = `
+    UPDATE "users"
+    SET totp_enabled = true, totp_last_step = $2, updated_at = EXTRACT(EPOCH FROM NOW())
+    WHERE login = $1 AND deleted_at =0 AND totp_secret <> '';
+`
+
+const queryDisableUserTotp = `
+    UPDATE "users"
+    SET totp_secret = '', totp_enabled = false, totp_last_step = 0, updated_at = EXTRACT(EPOCH FROM NOW())
+    WHERE login = $1 AND deleted_at =0;
+`
+
+const queryUpdateUserTotpStep = `
+    UPDATE "users"
+    SET totp_last_step = $2
+    WHERE login = $1 AND deleted_at =0 AND totp_last_step < $2;
+`
+
+const queryDeleteRecoveryCodes = `
+    UPDATE "recovery_codes"
+    SET deleted_at = EXTRACT(EPOCH FROM NOW())
+    WHERE user_login = $1 AND deleted_at =0;
+`
+
+const queryInsertRecoveryCode = `
+    INSERT INTO "recovery_codes" (user_login, code_hash, created_at)
+    VALUES ($1, $2, EXTRACT(EPOCH FROM NOW()));
+`
+
+const queryUseRecoveryCode = `
+    UPDATE "recovery_codes"
+    SET used_at = EXTRACT(EPOCH FROM NOW())
+    WHERE user_login = $1 AND code_hash = $2 AND used_at =0 AND deleted_at =0;
+`
+
+const queryCreateClient = `
+    INSERT INTO "oauth_clients" (client_id, secret_hash, user_login, scope, created_at)
+    VALUES ($1, $2, $3, $4, EXTRACT(EPOCH FROM NOW()))
+    RETURNING id;
+`
+
+const queryGetClient = `
+    SELECT c.id, c.client_id, c.secret_hash, c.user_login, c.scope, c.created_at FROM "oauth_clients" c
+    JOIN "users" u ON u.login = c.user_login AND u.deleted_at =0
+    WHERE c.client_id = $1 AND c.deleted_at =0;
+`
+
+const queryGetClients = `
+    SELECT id, client_id, secret_hash, user_login, scope, created_at FROM "oauth_clients"
+    WHERE deleted_at =0
+    ORDER BY id;
+`
+
+const queryDeleteClient = `
+    UPDATE "oauth_clients"
+    SET deleted_at = EXTRACT(EPOCH FROM NOW())
+    WHERE client_id = $1 AND deleted_at =0;
+`
+
+const queryDeleteClientsByLogin = `
+    UPDATE "oauth_clients"
+    SET deleted_at = EXTRACT(EPOCH FROM NOW())
+    WHERE user_login = $1 AND deleted_at =0;
+`
+
+const queryGetLoginByIdentity = `
+    SELECT i.user_login FROM "user_identities" i
+    JOIN "users" u ON u.login = i.user_login AND u.deleted_at =0
+    WHERE i.issuer = $1 AND i.subject = $2 AND i.deleted_at =0;
+`
+
+const queryCreateIdentity = `
+    INSERT INTO "user_identities" (issuer, subject, user_login, created_at)
+    VALUES ($1, $2, $3, EXTRACT(EPOCH FROM NOW()));
+`
+
+const queryDeleteIdentitiesByLogin = `
+    UPDATE "user_identities"
+    SET deleted_at = EXTRACT(EPOCH FROM NOW())
+    WHERE user_login = $1 AND deleted_at =0;
+`
+
+var loginEventColumns = []string{"login", "method", "success", "reason", "ip", "user_agent", "sid", "created_at"}
+
+const queryGetLoginEvents = `
+    SELECT id, login, method, success, reason, ip, user_agent, sid, created_at FROM "login_audit"
+    WHERE ($1 = '' OR login = $1) AND ($2 = 0 OR id < $2)
+    ORDER BY id DESC
+    LIMIT $3;
+`
+
+// statements are all queries run by Db, checked by CheckStatements
+var statements = []string{
+	queryGetUserPwdHash, queryGetUserRole, queryCreateUser, queryUpdateUserPwd, queryLockUserPwd, queryChangeUserPwd,
+	queryGetUserPwdChangedAt, queryInsertPwdHistory, queryPrunePwdHistory, queryGetPwdHistory, queryUpdateUserRole,
+	queryDeleteUser, queryDeleteApiKeysByLogin, queryGetDataByAssetName, querySetDataByAssetName,
+	queryDeleteDataByAssetName, queryGetActiveSession, queryGetActiveSessionBySubject, queryGetActiveSessionByLogin,
+	queryGetSessions, queryDeleteSessionById, queryDeleteSessionsIssuedBefore, queryNotifySession,
+	querySetSessionUpdate, querySetSessionInsert, queryDeleteSessionBySubject, queryDeleteSessionByLogin,
+	queryCreateApiKey, queryGetApiKeyByPrefix, queryGetApiKeysByLogin, queryDeleteApiKey, queryUpdateApiKeyLastUsed,
+	queryGetUserTotp, querySetUserTotpSecret, queryEnableUserTotp, queryDisableUserTotp, queryUpdateUserTotpStep,
+	queryDeleteRecoveryCodes, queryInsertRecoveryCode, queryUseRecoveryCode, queryCreateClient, queryGetClient,
+	queryGetClients, queryDeleteClient, queryDeleteClientsByLogin, queryGetLoginByIdentity, queryCreateIdentity,
+	queryDeleteIdentitiesByLogin, queryGetLoginEvents,
+}
+
 type Db struct {
-	sql               *sql.DB
-	stmtGetPwd        *sql.Stmt
-	stmtGetData       *sql.Stmt
-	stmtSetData       *sql.Stmt
-	stmtDeleteData    *sql.Stmt
-	stmtDeleteSession *sql.Stmt
+	// pool caches prepared statements per connection and uses the binary protocol for their results
+	pool *pgxpool.Pool
+	// replicas serve reads which tolerate replication lag, nil if there are none
+	replicas *replicaSet
+	// instance marks events published by this process, they are already applied locally
+	instance    string
+	retryPolicy Retry
+	// breaker rejects operations while the primary is unreachable, nil if disabled
+	breaker *breaker
+	lg      *slog.Logger
 }
 
-func NewDb(dsn string, ConnMax, ConnMaxIdle int, ConnMaxReuse time.Duration) (*Db, error) {
-	db, err := sql.Open("pgx", dsn)
+// NewDb connects the pool. ConnMaxIdle connections are kept open, idle ones above it are closed after ConnMaxIdleTime,
+// idle connections are checked every HealthCheckPeriod. Replicas get pools of the same settings.
+// Operations failed with transient errors are run again as retry allows, while the primary is unreachable they fail
+// fast as configured by breaker
+func NewDb(dsn string, ConnMax, ConnMaxIdle int, ConnMaxReuse, ConnMaxIdleTime, HealthCheckPeriod time.Duration, replicas Replicas, retry Retry, breaker Breaker, lg *slog.Logger) (*Db, error) {
+	newPool := func(dsn string) (*pgxpool.Pool, error) {
+		cfg, err := pgxpool.ParseConfig(dsn)
+		if err != nil {
+			return nil, err
+		}
+		cfg.MaxConns = int32(ConnMax)
+		cfg.MinConns = int32(min(ConnMaxIdle, ConnMax))
+		cfg.MaxConnLifetime = ConnMaxReuse
+		cfg.MaxConnIdleTime = ConnMaxIdleTime
+		cfg.HealthCheckPeriod = HealthCheckPeriod
+		cfg.ConnConfig.Tracer = queryTracer{host: cfg.ConnConfig.Host}
+		cfg.BeforeAcquire = func(_ context.Context, conn *pgx.Conn) bool {
+			return !conn.IsClosed()
+		}
+		return pgxpool.NewWithConfig(context.Background(), cfg)
+	}
+
+	pool, err := newPool(dsn)
 	if err != nil {
 		return nil, err
 	}
-	if err = db.Ping(); err != nil {
+	ctx, cancel := context.WithTimeout(context.Background(), connectTimeout)
+	defer cancel()
+	if err = pool.Ping(ctx); err != nil {
+		pool.Close()
 		return nil, fmt.Errorf("failed to connect to the database: %w", err)
 	}
 
-	db.SetMaxOpenConns(ConnMax)         // Maximum number of open connections
-	db.SetMaxIdleConns(ConnMaxIdle)     // Maximum number of idle connections
-	db.SetConnMaxLifetime(ConnMaxReuse) // Maximum connection lifetime
+	d := &Db{
+		pool:        pool,
+		instance:    uuid.NewString(),
+		retryPolicy: retry,
+		lg:          lg,
+	}
+	d.breaker = newBreaker(breaker, func(ctx context.Context) error {
+		_, err := pool.Exec(ctx, queryProbe)
+		return err
+	}, lg)
+	if len(replicas.Dsns) > 0 {
+		// unreachable replicas don't prevent startup, they join once healthy
+		if d.replicas, err = newReplicaSet(replicas, newPool, lg); err != nil {
+			pool.Close()
+			return nil, fmt.Errorf("failed to configure replicas: %w", err)
+		}
+	}
+	return d, nil
+}
+
+func (d *Db) Close(lg *slog.Logger) error {
+	d.breaker.close()
+	d.replicas.close()
+	d.pool.Close()
+	lg.Debug("closed db")
+	return nil
+}
+
+// Ping checks the database is reachable through a pooled connection
+func (d *Db) Ping(ctx context.Context) error {
+	return d.pool.Ping(ctx)
+}
+
+// Stat is a snapshot of the connection pool
+func (d *Db) Stat() *pgxpool.Stat {
+	return d.pool.Stat()
+}
 
-	stmtGetPwd, err := db.Prepare(queryGetUserPwdHash)
+// CheckStatements prepares every query against the current schema, so a missing migration or a schema change
+// breaking cached statements is found before requests fail
+func (d *Db) CheckStatements(ctx context.Context) error {
+	conn, err := d.pool.Acquire(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to prepare stmtGetPwd: %w", err)
+		return err
+	}
+	defer conn.Release()
+	for _, sql := range statements {
+		// the unnamed statement is replaced by each next one, the statement cache of the connection is untouched
+		if _, err = conn.Conn().PgConn().Prepare(ctx, "", sql, nil); err != nil {
+			return fmt.Errorf("failed to prepare %q: %w", strings.Join(strings.Fields(sql), " "), err)
+		}
 	}
+	return nil
+}
 
-	stmtGetData, err := db.Prepare(queryGetDataByAssetName)
-	if err != nil {
-		return nil, fmt.Errorf("failed to prepare stmtGetData: %w", err)
+func (d *Db) GetUserPwdHashByLogin(ctx context.Context, login string) (string, error) {
+	var hash string
+	if err := d.queryRow(ctx, "GetUserPwdHashByLogin", queryGetUserPwdHash, login).Scan(&hash); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", myerrors.NewErrUserNotFound(login)
+		}
+		return "", err
 	}
+	return hash, nil
+}
 
-	stmtSetData, err := db.Prepare(querySetDataByAssetName)
-	if err != nil {
-		return nil, fmt.Errorf("failed to prepare stmtSetData: %w", err)
+func (d *Db) GetUserRole(ctx context.Context, login string) (string, error) {
+	var role string
+	if err := d.queryRow(ctx, "GetUserRole", queryGetUserRole, login).Scan(&role); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", myerrors.NewErrUserNotFound(login)
+		}
+		return "", err
 	}
+	return role, nil
+}
 
-	stmtDeleteData, err := db.Prepare(queryDeleteDataByAssetName)
-	if err != nil {
-		return nil, fmt.Errorf("failed to prepare stmtSetData: %w", err)
+func (d *Db) CreateUser(ctx context.Context, login, pwdHash string) error {
+	if _, err := d.exec(ctx, "CreateUser", queryCreateUser, login, pwdHash); err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+			return myerrors.NewErrUserExists(login)
+		}
+		return fmt.Errorf("failed to create user: %w", err)
 	}
+	return nil
+}
 
-	stmtDeleteSession, err := db.Prepare(queryDeleteSessionByLogin)
+func (d *Db) UpdateUserPwd(ctx context.Context, login, pwdHash string) error {
+	res, err := d.exec(ctx, "UpdateUserPwd", queryUpdateUserPwd, login, pwdHash)
 	if err != nil {
-		return nil, fmt.Errorf("failed to prepare stmtSetData: %w", err)
+		return fmt.Errorf("failed to update user password: %w", err)
 	}
+	if res.RowsAffected() == 0 {
+		return myerrors.NewErrUserNotFound(login)
+	}
+	return nil
+}
 
-	return &Db{
-		sql:               db,
-		stmtGetPwd:        stmtGetPwd,
-		stmtGetData:       stmtGetData,
-		stmtSetData:       stmtSetData,
-		stmtDeleteData:    stmtDeleteData,
-		stmtDeleteSession: stmtDeleteSession,
-	}, nil
+// ChangeUserPwd sets password chosen by the user, the replaced hash is kept in history trimmed to keepHistory entries
+func (d *Db) ChangeUserPwd(ctx context.Context, login, pwdHash string, keepHistory int) error {
+	return d.inTx(ctx, "ChangeUserPwd", pgx.TxOptions{}, func(ctx context.Context, tx pgx.Tx) error {
+		var prev string
+		if err := tx.QueryRow(ctx, queryLockUserPwd, login).Scan(&prev); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return myerrors.NewErrUserNotFound(login)
+			}
+			return fmt.Errorf("failed to get user password: %w", err)
+		}
+		if _, err := tx.Exec(ctx, queryChangeUserPwd, login, pwdHash); err != nil {
+			return fmt.Errorf("failed to change user password: %w", err)
+		}
+		if keepHistory > 0 {
+			if _, err := tx.Exec(ctx, queryInsertPwdHistory, login, prev); err != nil {
+				return fmt.Errorf("failed to insert password history: %w", err)
+			}
+		}
+		if _, err := tx.Exec(ctx, queryPrunePwdHistory, login, keepHistory); err != nil {
+			return fmt.Errorf("failed to prune password history: %w", err)
+		}
+		return nil
+	})
 }
 
-func (d *Db) Close(lg *slog.Logger) error {
-	if d.stmtGetPwd != nil {
-		_ = d.stmtGetPwd.Close()
+func (d *Db) GetUserPwdChangedAt(ctx context.Context, login string) (int64, error) {
+	var changedAt int64
+	if err := d.queryRow(ctx, "GetUserPwdChangedAt", queryGetUserPwdChangedAt, login).Scan(&changedAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, myerrors.NewErrUserNotFound(login)
+		}
+		return 0, err
 	}
-	if d.stmtGetData != nil {
-		_ = d.stmtGetData.Close()
+	return changedAt, nil
+}
+
+// GetPwdHistory returns previous password hashes of the user, most recent first
+func (d *Db) GetPwdHistory(ctx context.Context, login string, limit int) ([]string, error) {
+	rows, err := d.query(ctx, "GetPwdHistory", queryGetPwdHistory, login, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get password history: %w", err)
 	}
-	if d.stmtSetData != nil {
-		_ = d.stmtSetData.Close()
+	defer rows.Close()
+
+	var hashes []string
+	for rows.Next() {
+		var h string
+		if err = rows.Scan(&h); err != nil {
+			return nil, fmt.Errorf("failed to scan password history: %w", err)
+		}
+		hashes = append(hashes, h)
 	}
-	if d.stmtDeleteData != nil {
-		_ = d.stmtDeleteData.Close()
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate password history: %w", err)
 	}
-	err := d.sql.Close()
+	return hashes, nil
+}
+
+func (d *Db) UpdateUserRole(ctx context.Context, login, role string) error {
+	res, err := d.exec(ctx, "UpdateUserRole", queryUpdateUserRole, login, role)
 	if err != nil {
-		lg.Error("failed to close the database", "error", err)
-		return err
+		return fmt.Errorf("failed to update user role: %w", err)
+	}
+	if res.RowsAffected() == 0 {
+		return myerrors.NewErrUserNotFound(login)
 	}
-	lg.Debug("closed db")
 	return nil
 }
 
-func (d *Db) GetUserPwdHashByLogin(ctx context.Context, login string) (string, error) {
-	var hash string
-	if err := d.stmtGetPwd.QueryRowContext(ctx, login).Scan(&hash); err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return "", myerrors.NewErrUserNotFound(login)
+// DeleteUser soft-deletes the user and revokes all of its sessions, api keys, clients, recovery codes and external identities
+func (d *Db) DeleteUser(ctx context.Context, login string) error {
+	d.replicas.wrote(login)
+	return d.inTx(ctx, "DeleteUser", pgx.TxOptions{}, func(ctx context.Context, tx pgx.Tx) error {
+		res, err := tx.Exec(ctx, queryDeleteUser, login)
+		if err != nil {
+			return fmt.Errorf("failed to delete user: %w", err)
 		}
-		return "", err
-	}
-	return hash, nil
+		if res.RowsAffected() == 0 {
+			return myerrors.NewErrUserNotFound(login)
+		}
+		if _, err = tx.Exec(ctx, queryDeleteSessionByLogin, login); err != nil {
+			return fmt.Errorf("failed to delete user sessions: %w", err)
+		}
+		if _, err = tx.Exec(ctx, queryDeleteApiKeysByLogin, login); err != nil {
+			return fmt.Errorf("failed to delete user api keys: %w", err)
+		}
+		if _, err = tx.Exec(ctx, queryDeleteRecoveryCodes, login); err != nil {
+			return fmt.Errorf("failed to delete user recovery codes: %w", err)
+		}
+		if _, err = tx.Exec(ctx, queryDeleteClientsByLogin, login); err != nil {
+			return fmt.Errorf("failed to delete user clients: %w", err)
+		}
+		if _, err = tx.Exec(ctx, queryDeleteIdentitiesByLogin, login); err != nil {
+			return fmt.Errorf("failed to delete user identities: %w", err)
+		}
+		return d.notifySession(ctx, tx, storage.SessionEvent{Op: storage.LoginSessionsRevoked, Login: login})
+	})
 }
 
 func (d *Db) GetDataByAssetName(ctx context.Context, assetName, login string) ([]byte, string, error) {
 	var data []byte
 	var ct string
-	if err := d.stmtGetData.QueryRowContext(ctx, assetName, login).Scan(&data, &ct); err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
+	if err := d.readQueryRow(ctx, "GetDataByAssetName", login, queryGetDataByAssetName, assetName, login).Scan(&data, &ct); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, "", myerrors.NewErrAssetNotFound(login, assetName)
 		}
 		return nil, "", err
@@ -162,81 +576,488 @@ func (d *Db) GetDataByAssetName(ctx context.Context, assetName, login string) ([
 }
 
 func (d *Db) SetDataByAssetName(ctx context.Context, assetName, login, contentType string, data []byte) error {
-	if _, err := d.stmtSetData.ExecContext(ctx, assetName, login, contentType, data); err != nil {
+	d.replicas.wrote(login)
+	if _, err := d.exec(ctx, "SetDataByAssetName", querySetDataByAssetName, assetName, login, contentType, data); err != nil {
 		return fmt.Errorf("failed to upsert data by asset name: %w", err)
 	}
 	return nil
 }
 
 func (d *Db) DeleteDataByAssetName(ctx context.Context, assetName, login string) error {
-	if _, err := d.stmtDeleteData.ExecContext(ctx, assetName, login); err != nil {
+	d.replicas.wrote(login)
+	if _, err := d.exec(ctx, "DeleteDataByAssetName", queryDeleteDataByAssetName, assetName, login); err != nil {
 		return fmt.Errorf("failed to delete data by asset name: %w", err)
 	}
 	return nil
 }
 
-func (d *Db) UpdateSession(ctx context.Context, login, token string, iat, exp int64) error {
-	tx, err := d.sql.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead})
+// UpdateSession revokes active session of the subject and stores the new one
+func (d *Db) UpdateSession(ctx context.Context, session storage.Session) error {
+	d.replicas.wrote(session.Login)
+	return d.inTx(ctx, "UpdateSession", pgx.TxOptions{IsoLevel: pgx.RepeatableRead}, func(ctx context.Context, tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, querySetSessionUpdate, session.Subject); err != nil {
+			return fmt.Errorf("failed to execute tx update statement: %w", err)
+		}
+		if _, err := tx.Exec(ctx, querySetSessionInsert, session.Id, session.Subject, session.Login, session.Token, session.Scope,
+			session.IssuedAt, session.ExpireAt); err != nil {
+			return fmt.Errorf("failed to execute tx insert statement: %w", err)
+		}
+		return d.notifySession(ctx, tx, storage.SessionEvent{Op: storage.SessionCreated, Subject: session.Subject})
+	})
+}
+
+func (d *Db) DeleteSessionByLogin(ctx context.Context, login string) error {
+	d.replicas.wrote(login)
+	return d.inTx(ctx, "DeleteSessionByLogin", pgx.TxOptions{}, func(ctx context.Context, tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, queryDeleteSessionByLogin, login); err != nil {
+			return fmt.Errorf("failed to delete session by login: %w", err)
+		}
+		return d.notifySession(ctx, tx, storage.SessionEvent{Op: storage.LoginSessionsRevoked, Login: login})
+	})
+}
+
+func (d *Db) DeleteSessionBySubject(ctx context.Context, subject string) error {
+	d.replicas.wrote("")
+	return d.inTx(ctx, "DeleteSessionBySubject", pgx.TxOptions{}, func(ctx context.Context, tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, queryDeleteSessionBySubject, subject); err != nil {
+			return fmt.Errorf("failed to delete session by subject: %w", err)
+		}
+		return d.notifySession(ctx, tx, storage.SessionEvent{Op: storage.SessionRevoked, Subject: subject})
+	})
+}
+
+// GetActiveSessions may read a replica unless ctx is from storage.WithPrimary
+func (d *Db) GetActiveSessions(ctx context.Context) (map[string]storage.Token, error) {
+	return scanSessions(d.readQuery(ctx, "GetActiveSessions", "", queryGetActiveSession))
+}
+
+func (d *Db) GetActiveSessionsBySubject(ctx context.Context, subject string) (map[string]storage.Token, error) {
+	return scanSessions(d.query(ctx, "GetActiveSessionsBySubject", queryGetActiveSessionBySubject, subject))
+}
+
+func (d *Db) GetActiveSessionsByLogin(ctx context.Context, login string) (map[string]storage.Token, error) {
+	return scanSessions(d.query(ctx, "GetActiveSessionsByLogin", queryGetActiveSessionByLogin, login))
+}
+
+// scanSessions reads active sessions keyed by subject
+func scanSessions(rows pgx.Rows, err error) (map[string]storage.Token, error) {
 	if err != nil {
-		return fmt.Errorf("failed to begin tx: %w", err)
+		return nil, fmt.Errorf("failed to query active sessions: %w", err)
+	}
+	defer rows.Close()
+
+	cache := make(map[string]storage.Token)
+	for rows.Next() {
+		var sid, subject, userLogin, token string
+		var exp int64
+		if err = rows.Scan(&sid, &subject, &userLogin, &token, &exp); err != nil {
+			return nil, fmt.Errorf("failed to scan row of active sessions: %w", err)
+		}
+		cache[subject] = storage.Token{Token: token, ExpireAt: exp, Login: userLogin, SessionId: sid}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate active sessions: %w", err)
 	}
-	defer func() { _ = tx.Rollback() }()
 
-	stmtUpdate, err := tx.PrepareContext(ctx, querySetSessionUpdate)
+	return cache, nil
+}
+
+// GetSessions returns unexpired active sessions of login, or of everyone if login is empty. Tokens are not returned
+func (d *Db) GetSessions(ctx context.Context, login string) ([]storage.Session, error) {
+	rows, err := d.readQuery(ctx, "GetSessions", login, queryGetSessions, login)
 	if err != nil {
-		return fmt.Errorf("failed to prepare tx update statement: %w", err)
+		return nil, fmt.Errorf("failed to query sessions: %w", err)
+	}
+	defer rows.Close()
+
+	sessions := make([]storage.Session, 0)
+	for rows.Next() {
+		var s storage.Session
+		if err = rows.Scan(&s.Id, &s.Subject, &s.Login, &s.Scope, &s.IssuedAt, &s.ExpireAt); err != nil {
+			return nil, fmt.Errorf("failed to scan session: %w", err)
+		}
+		sessions = append(sessions, s)
 	}
-	defer func() { _ = stmtUpdate.Close() }()
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate sessions: %w", err)
+	}
+	return sessions, nil
+}
 
-	stmtInsert, err := tx.PrepareContext(ctx, querySetSessionInsert)
+// DeleteSessionById revokes the session and returns its subject
+func (d *Db) DeleteSessionById(ctx context.Context, id string) (string, error) {
+	d.replicas.wrote("")
+	var subject string
+	err := d.inTx(ctx, "DeleteSessionById", pgx.TxOptions{}, func(ctx context.Context, tx pgx.Tx) error {
+		if err := tx.QueryRow(ctx, queryDeleteSessionById, id).Scan(&subject); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return myerrors.NewErrSessionNotFound(id)
+			}
+			return fmt.Errorf("failed to delete session: %w", err)
+		}
+		return d.notifySession(ctx, tx, storage.SessionEvent{Op: storage.SessionRevoked, Subject: subject})
+	})
+	if err != nil {
+		return "", err
+	}
+	return subject, nil
+}
+
+// DeleteSessionsIssuedBefore revokes sessions issued before the unix timestamp. Returns subjects of revoked sessions
+func (d *Db) DeleteSessionsIssuedBefore(ctx context.Context, before int64) ([]string, error) {
+	d.replicas.wrote("")
+	var subjects []string
+	err := d.inTx(ctx, "DeleteSessionsIssuedBefore", pgx.TxOptions{}, func(ctx context.Context, tx pgx.Tx) error {
+		rows, err := tx.Query(ctx, queryDeleteSessionsIssuedBefore, before)
+		if err != nil {
+			return fmt.Errorf("failed to delete sessions: %w", err)
+		}
+		if subjects, err = pgx.CollectRows(rows, pgx.RowTo[string]); err != nil {
+			return fmt.Errorf("failed to delete sessions: %w", err)
+		}
+		// subjects may not fit in a notification, other instances resync instead
+		return d.notifySession(ctx, tx, storage.SessionEvent{Op: storage.SessionsRevoked})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return subjects, nil
+}
+
+type sessionNotification struct {
+	storage.SessionEvent
+	Instance string `json:"instance"`
+}
+
+// notifySession publishes event in the transaction of the change, so it is delivered only if the change commits
+func (d *Db) notifySession(ctx context.Context, tx pgx.Tx, ev storage.SessionEvent) error {
+	payload, err := json.Marshal(sessionNotification{SessionEvent: ev, Instance: d.instance})
+	if err != nil {
+		return err
+	}
+	if _, err = tx.Exec(ctx, queryNotifySession, string(payload)); err != nil {
+		return fmt.Errorf("failed to notify session event: %w", err)
+	}
+	return nil
+}
+
+// ListenSessionEvents holds a dedicated connection listening for session events of other instances until ctx is done
+// or the connection fails. onConnect is called once listening started, so missed events can be resynced
+func (d *Db) ListenSessionEvents(ctx context.Context, onConnect func(), onEvent func(storage.SessionEvent)) error {
+	conn, err := d.pool.Acquire(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to prepare tx insert statement: %w", err)
+		return fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer conn.Release()
+
+	if _, err = conn.Exec(ctx, "LISTEN "+sessionsChannel); err != nil {
+		return fmt.Errorf("failed to listen: %w", err)
+	}
+	// the connection goes back to the pool
+	defer func() {
+		unlistenCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_, _ = conn.Exec(unlistenCtx, "UNLISTEN "+sessionsChannel)
+	}()
+	onConnect()
+
+	for {
+		n, err := conn.Conn().WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		var ev sessionNotification
+		if err = json.Unmarshal([]byte(n.Payload), &ev); err != nil || ev.Instance == d.instance {
+			continue
+		}
+		onEvent(ev.SessionEvent)
 	}
-	defer func() { _ = stmtInsert.Close() }()
+}
 
-	if _, err = stmtUpdate.ExecContext(ctx, login); err != nil {
-		return fmt.Errorf("failed to execute tx update statement: %w", err)
+func (d *Db) CreateApiKey(ctx context.Context, key storage.ApiKey) (int64, error) {
+	d.replicas.wrote(key.Login)
+	var id int64
+	if err := d.queryRow(ctx, "CreateApiKey", queryCreateApiKey, key.Login, key.Prefix, key.Hash, key.Scope, key.ExpireAt).Scan(&id); err != nil {
+		return 0, fmt.Errorf("failed to create api key: %w", err)
 	}
-	if _, err = stmtInsert.ExecContext(ctx, login, token, iat, exp); err != nil {
-		return fmt.Errorf("failed to execute tx insert statement: %w", err)
+	return id, nil
+}
+
+func (d *Db) GetApiKeyByPrefix(ctx context.Context, prefix string) (storage.ApiKey, error) {
+	var k storage.ApiKey
+	err := d.queryRow(ctx, "GetApiKeyByPrefix", queryGetApiKeyByPrefix, prefix).
+		Scan(&k.Id, &k.Login, &k.Prefix, &k.Hash, &k.Scope, &k.ExpireAt, &k.LastUsedAt, &k.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return storage.ApiKey{}, myerrors.NewErrApiKeyNotFound(prefix)
+		}
+		return storage.ApiKey{}, err
 	}
+	return k, nil
+}
 
-	if err = tx.Commit(); err != nil {
-		return fmt.Errorf("failed to commit tx: %w", err)
+func (d *Db) GetApiKeysByLogin(ctx context.Context, login string) ([]storage.ApiKey, error) {
+	rows, err := d.readQuery(ctx, "GetApiKeysByLogin", login, queryGetApiKeysByLogin, login)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query api keys: %w", err)
 	}
+	defer rows.Close()
 
+	keys := make([]storage.ApiKey, 0)
+	for rows.Next() {
+		var k storage.ApiKey
+		if err = rows.Scan(&k.Id, &k.Login, &k.Prefix, &k.Hash, &k.Scope, &k.ExpireAt, &k.LastUsedAt, &k.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan api key: %w", err)
+		}
+		keys = append(keys, k)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate api keys: %w", err)
+	}
+	return keys, nil
+}
+
+func (d *Db) DeleteApiKey(ctx context.Context, id int64, login string) error {
+	d.replicas.wrote(login)
+	res, err := d.exec(ctx, "DeleteApiKey", queryDeleteApiKey, id, login)
+	if err != nil {
+		return fmt.Errorf("failed to delete api key: %w", err)
+	}
+	if res.RowsAffected() == 0 {
+		return myerrors.NewErrApiKeyNotFound(strconv.FormatInt(id, 10))
+	}
 	return nil
 }
 
-func (d *Db) DeleteSessionByLogin(ctx context.Context, login string) error {
-	if _, err := d.stmtDeleteSession.ExecContext(ctx, login); err != nil {
-		return fmt.Errorf("failed to delete session by login: %w", err)
+func (d *Db) UpdateApiKeyLastUsed(ctx context.Context, id, lastUsedAt int64) error {
+	if _, err := d.exec(ctx, "UpdateApiKeyLastUsed", queryUpdateApiKeyLastUsed, id, lastUsedAt); err != nil {
+		return fmt.Errorf("failed to update api key last used: %w", err)
 	}
 	return nil
 }
 
-func (d *Db) GetActiveSessions(ctx context.Context) (map[string]storage.Token, error) {
-	cache := make(map[string]storage.Token)
+func (d *Db) GetUserTotp(ctx context.Context, login string) (storage.Totp, error) {
+	var t storage.Totp
+	if err := d.queryRow(ctx, "GetUserTotp", queryGetUserTotp, login).Scan(&t.Secret, &t.Enabled, &t.LastStep); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return storage.Totp{}, myerrors.NewErrUserNotFound(login)
+		}
+		return storage.Totp{}, err
+	}
+	return t, nil
+}
+
+func (d *Db) SetUserTotpSecret(ctx context.Context, login, secret string) error {
+	res, err := d.exec(ctx, "SetUserTotpSecret", querySetUserTotpSecret, login, secret)
+	if err != nil {
+		return fmt.Errorf("failed to set totp secret: %w", err)
+	}
+	if res.RowsAffected() == 0 {
+		return myerrors.NewErrUserNotFound(login)
+	}
+	return nil
+}
+
+// EnableUserTotp enables two-factor auth and replaces recovery codes of the user
+func (d *Db) EnableUserTotp(ctx context.Context, login string, step int64, codeHashes []string) error {
+	return d.inTx(ctx, "EnableUserTotp", pgx.TxOptions{}, func(ctx context.Context, tx pgx.Tx) error {
+		res, err := tx.Exec(ctx, queryEnableUserTotp, login, step)
+		if err != nil {
+			return fmt.Errorf("failed to enable totp: %w", err)
+		}
+		if res.RowsAffected() == 0 {
+			return myerrors.NewErrUserNotFound(login)
+		}
+		if _, err = tx.Exec(ctx, queryDeleteRecoveryCodes, login); err != nil {
+			return fmt.Errorf("failed to delete recovery codes: %w", err)
+		}
+
+		batch := &pgx.Batch{}
+		for _, h := range codeHashes {
+			batch.Queue(queryInsertRecoveryCode, login, h)
+		}
+		if err = tx.SendBatch(ctx, batch).Close(); err != nil {
+			return fmt.Errorf("failed to insert recovery codes: %w", err)
+		}
+		return nil
+	})
+}
+
+func (d *Db) DisableUserTotp(ctx context.Context, login string) error {
+	return d.inTx(ctx, "DisableUserTotp", pgx.TxOptions{}, func(ctx context.Context, tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, queryDisableUserTotp, login); err != nil {
+			return fmt.Errorf("failed to disable totp: %w", err)
+		}
+		if _, err := tx.Exec(ctx, queryDeleteRecoveryCodes, login); err != nil {
+			return fmt.Errorf("failed to delete recovery codes: %w", err)
+		}
+		return nil
+	})
+}
+
+// UpdateUserTotpStep stores the last accepted step. Returns false if the step was already used
+func (d *Db) UpdateUserTotpStep(ctx context.Context, login string, step int64) (bool, error) {
+	res, err := d.exec(ctx, "UpdateUserTotpStep", queryUpdateUserTotpStep, login, step)
+	if err != nil {
+		return false, fmt.Errorf("failed to update totp step: %w", err)
+	}
+	n := res.RowsAffected()
+	return n == 1, nil
+}
+
+// UseRecoveryCode marks the code as used. Returns false if there is no such unused code
+func (d *Db) UseRecoveryCode(ctx context.Context, login, codeHash string) (bool, error) {
+	res, err := d.exec(ctx, "UseRecoveryCode", queryUseRecoveryCode, login, codeHash)
+	if err != nil {
+		return false, fmt.Errorf("failed to use recovery code: %w", err)
+	}
+	n := res.RowsAffected()
+	return n == 1, nil
+}
+
+func (d *Db) CreateClient(ctx context.Context, client storage.Client) (int64, error) {
+	d.replicas.wrote(client.Login)
+	var id int64
+	err := d.queryRow(ctx, "CreateClient", queryCreateClient, client.ClientId, client.SecretHash, client.Login, client.Scope).Scan(&id)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.ForeignKeyViolation {
+			return 0, myerrors.NewErrUserNotFound(client.Login)
+		}
+		return 0, fmt.Errorf("failed to create client: %w", err)
+	}
+	return id, nil
+}
 
-	stmt, err := d.sql.Prepare(queryGetActiveSession)
+func (d *Db) GetClient(ctx context.Context, clientId string) (storage.Client, error) {
+	var c storage.Client
+	err := d.queryRow(ctx, "GetClient", queryGetClient, clientId).
+		Scan(&c.Id, &c.ClientId, &c.SecretHash, &c.Login, &c.Scope, &c.CreatedAt)
 	if err != nil {
-		return nil, fmt.Errorf("failed to prepare queryGetActiveSession: %w", err)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return storage.Client{}, myerrors.NewErrClientNotFound(clientId)
+		}
+		return storage.Client{}, err
 	}
+	return c, nil
+}
 
-	rows, err := stmt.QueryContext(ctx)
+func (d *Db) GetClients(ctx context.Context) ([]storage.Client, error) {
+	rows, err := d.readQuery(ctx, "GetClients", "", queryGetClients)
 	if err != nil {
-		return nil, fmt.Errorf("failed to query queryGetActiveSession: %w", err)
+		return nil, fmt.Errorf("failed to query clients: %w", err)
 	}
-	defer func() { _ = rows.Close() }()
+	defer rows.Close()
 
+	clients := make([]storage.Client, 0)
 	for rows.Next() {
-		var userLogin, token string
-		var exp int64
-		if err = rows.Scan(&userLogin, &token, &exp); err != nil {
-			return nil, fmt.Errorf("failed to scan row of queryGetActiveSession: %w", err)
+		var c storage.Client
+		if err = rows.Scan(&c.Id, &c.ClientId, &c.SecretHash, &c.Login, &c.Scope, &c.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan client: %w", err)
 		}
-		cache[userLogin] = storage.Token{Token: token, ExpireAt: exp}
+		clients = append(clients, c)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate clients: %w", err)
 	}
+	return clients, nil
+}
 
-	return cache, nil
+func (d *Db) DeleteClient(ctx context.Context, clientId string) error {
+	d.replicas.wrote("")
+	res, err := d.exec(ctx, "DeleteClient", queryDeleteClient, clientId)
+	if err != nil {
+		return fmt.Errorf("failed to delete client: %w", err)
+	}
+	if res.RowsAffected() == 0 {
+		return myerrors.NewErrClientNotFound(clientId)
+	}
+	return nil
+}
+
+func (d *Db) GetLoginByIdentity(ctx context.Context, issuer, subject string) (string, error) {
+	var login string
+	if err := d.queryRow(ctx, "GetLoginByIdentity", queryGetLoginByIdentity, issuer, subject).Scan(&login); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", myerrors.NewErrIdentityNotFound(issuer, subject)
+		}
+		return "", err
+	}
+	return login, nil
+}
+
+func (d *Db) CreateIdentity(ctx context.Context, issuer, subject, login string) error {
+	if _, err := d.exec(ctx, "CreateIdentity", queryCreateIdentity, issuer, subject, login); err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) {
+			switch pgErr.Code {
+			case pgerrcode.ForeignKeyViolation:
+				return myerrors.NewErrUserNotFound(login)
+			case pgerrcode.UniqueViolation:
+				return myerrors.NewInvalidArgumentError("identity is already linked")
+			}
+		}
+		return fmt.Errorf("failed to create identity: %w", err)
+	}
+	return nil
+}
+
+// CreateExternalUser creates user linked to the external identity, neither is created if the other fails
+func (d *Db) CreateExternalUser(ctx context.Context, login, pwdHash, issuer, subject string) error {
+	return d.inTx(ctx, "CreateExternalUser", pgx.TxOptions{}, func(ctx context.Context, tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, queryCreateUser, login, pwdHash); err != nil {
+			var pgErr *pgconn.PgError
+			if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+				return myerrors.NewErrUserExists(login)
+			}
+			return fmt.Errorf("failed to create user: %w", err)
+		}
+		if _, err := tx.Exec(ctx, queryCreateIdentity, issuer, subject, login); err != nil {
+			var pgErr *pgconn.PgError
+			if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+				return myerrors.NewInvalidArgumentError("identity is already linked")
+			}
+			return fmt.Errorf("failed to create identity: %w", err)
+		}
+		return nil
+	})
+}
+
+// CreateLoginEvents appends events to the audit trail with a single COPY
+func (d *Db) CreateLoginEvents(ctx context.Context, events []storage.LoginEvent) error {
+	err := d.retry(ctx, "CreateLoginEvents", func(ctx context.Context) error {
+		_, err := d.pool.CopyFrom(ctx, pgx.Identifier{"login_audit"}, loginEventColumns,
+			pgx.CopyFromSlice(len(events), func(i int) ([]any, error) {
+				ev := events[i]
+				return []any{ev.Login, ev.Method, ev.Success, ev.Reason, ev.Ip, ev.UserAgent, ev.SessionId, ev.CreatedAt}, nil
+			}))
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create login events: %w", err)
+	}
+	return nil
+}
+
+// GetLoginEvents returns up to limit events older than beforeId, newest first. Zero beforeId starts from the newest
+func (d *Db) GetLoginEvents(ctx context.Context, login string, beforeId int64, limit int) ([]storage.LoginEvent, error) {
+	rows, err := d.readQuery(ctx, "GetLoginEvents", login, queryGetLoginEvents, login, beforeId, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query login events: %w", err)
+	}
+	defer rows.Close()
+
+	events := make([]storage.LoginEvent, 0)
+	for rows.Next() {
+		var ev storage.LoginEvent
+		if err = rows.Scan(&ev.Id, &ev.Login, &ev.Method, &ev.Success, &ev.Reason, &ev.Ip, &ev.UserAgent,
+			&ev.SessionId, &ev.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan login event: %w", err)
+		}
+		events = append(events, ev)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate login events: %w", err)
+	}
+	return events, nil
 }
@@ -0,0 +1,191 @@
+package db
+
+import (
+	"clearway-test-task/internal/storage"
+	"clearway-test-task/internal/storage/migrate"
+	"clearway-test-task/internal/storage/storagetest"
+	"context"
+	"database/sql"
+	"fmt"
+	// registers pgx driver for database/sql, migrations run through it
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testDsnEnv names the variable holding dsn of a postgres database the tests may create schemas in
+const testDsnEnv = "TEST_DB_DSN"
+
+var schemas atomic.Int64
+
+// withSearchPath returns dsn connecting to schema, dsn is either an url or key=value pairs
+func withSearchPath(dsn, schema string) string {
+	switch {
+	case !strings.Contains(dsn, "://"):
+		return dsn + " search_path=" + schema
+	case strings.Contains(dsn, "?"):
+		return dsn + "&search_path=" + schema
+	default:
+		return dsn + "?search_path=" + schema
+	}
+}
+
+// testAdmin returns dsn of the test database and a connection to it, skipping the test if it isn't configured
+func testAdmin(t *testing.T) (string, *sql.DB) {
+	t.Helper()
+	dsn := os.Getenv(testDsnEnv)
+	if dsn == "" {
+		t.Skip(testDsnEnv + " is not set")
+	}
+	admin, err := sql.Open("pgx", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = admin.Close() })
+	return dsn, admin
+}
+
+// newSchema creates a schema with all migrations applied, dropped once the test ends, and returns dsn connecting to it
+func newSchema(t *testing.T, dsn string, admin *sql.DB, lg *slog.Logger) string {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	schema := fmt.Sprintf("storagetest_%d_%d", os.Getpid(), schemas.Add(1))
+	if _, err := admin.ExecContext(ctx, "CREATE SCHEMA "+schema); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _, _ = admin.Exec("DROP SCHEMA " + schema + " CASCADE") })
+	schemaDsn := withSearchPath(dsn, schema)
+
+	conn, err := sql.Open("pgx", schemaDsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+	m, err := migrate.NewMigrator(conn, migrate.Postgres, Migrations, "migrations", lg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	return schemaDsn
+}
+
+func TestDb(t *testing.T) {
+	dsn, admin := testAdmin(t)
+	lg := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	storagetest.Run(t, func(t *testing.T) storage.Db {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		// every case gets own schema
+		schemaDsn := newSchema(t, dsn, admin, lg)
+		d, err := NewDb(schemaDsn, 4, 1, time.Hour, time.Minute, time.Minute, Replicas{}, Retry{}, Breaker{}, lg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = d.Close(lg) })
+		// the migrated schema has every column the queries use
+		if err = d.CheckStatements(ctx); err != nil {
+			t.Fatal(err)
+		}
+		return d
+	})
+}
+
+// TestLaggingReplica uses a separate schema as the replica, it never receives writes of the primary
+func TestLaggingReplica(t *testing.T) {
+	dsn, admin := testAdmin(t)
+	lg := slog.New(slog.NewTextHandler(io.Discard, nil))
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	primaryDsn, replicaDsn := newSchema(t, dsn, admin, lg), newSchema(t, dsn, admin, lg)
+	now := time.Now().Unix()
+	session := storage.Session{Id: "sid", Subject: "alice", Login: "alice", Token: "token", IssuedAt: now - 100, ExpireAt: now + 100}
+	// the replica still has the session the primary has revoked
+	for _, schemaDsn := range []string{primaryDsn, replicaDsn} {
+		d, err := NewDb(schemaDsn, 2, 0, time.Hour, time.Minute, time.Minute, Replicas{}, Retry{}, Breaker{}, lg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = d.UpdateSession(ctx, session); err != nil {
+			t.Fatal(err)
+		}
+		_ = d.Close(lg)
+	}
+
+	d, err := NewDb(primaryDsn, 2, 0, time.Hour, time.Minute, time.Minute,
+		Replicas{Dsns: []string{replicaDsn}, CheckInterval: time.Hour}, Retry{}, Breaker{}, lg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = d.Close(lg) })
+	if _, err = d.DeleteSessionsIssuedBefore(ctx, now); err != nil {
+		t.Fatal(err)
+	}
+	// the sticky window is zero, so reads of everyone go to the replica despite the write
+	active, err := d.GetActiveSessions(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := active["alice"]; !ok {
+		t.Fatal("read didn't go to the replica")
+	}
+	if active, err = d.GetActiveSessions(storage.WithPrimary(ctx)); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := active["alice"]; ok {
+		t.Fatal("read required from the primary returned the revoked session")
+	}
+}
+
+func TestRevokeNotifies(t *testing.T) {
+	dsn, admin := testAdmin(t)
+	lg := slog.New(slog.NewTextHandler(io.Discard, nil))
+	schemaDsn := newSchema(t, dsn, admin, lg)
+	newDb := func() *Db {
+		d, err := NewDb(schemaDsn, 2, 0, time.Hour, time.Minute, time.Minute, Replicas{}, Retry{}, Breaker{}, lg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = d.Close(lg) })
+		return d
+	}
+	listener, revoker := newDb(), newDb()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	listening := make(chan struct{})
+	events := make(chan storage.SessionEvent, 10)
+	go func() {
+		_ = listener.ListenSessionEvents(ctx, func() { close(listening) }, func(ev storage.SessionEvent) { events <- ev })
+	}()
+	<-listening
+
+	if err := revoker.DeleteSessionByLogin(ctx, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := revoker.DeleteSessionBySubject(ctx, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []storage.SessionEvent{
+		{Op: storage.LoginSessionsRevoked, Login: "alice"},
+		{Op: storage.SessionRevoked, Subject: "alice"},
+	} {
+		select {
+		case ev := <-events:
+			if ev != want {
+				t.Fatalf("got event %+v, want %+v", ev, want)
+			}
+		case <-ctx.Done():
+			t.Fatalf("no event %+v", want)
+		}
+	}
+}
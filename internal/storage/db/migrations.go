@@ -0,0 +1,8 @@
+package db
+
+import "embed"
+
+// Migrations holds numbered schema migrations in migrations directory
+//
+//go:embed migrations/*.sql
+var Migrations embed.FS
@@ -1,3 +1,6 @@
+-- baseline schema of the former scripts/init.sql. Objects are created only if missing, so databases created by it are adopted as is.
+-- Later changes are made by the following migrations only
+
 CREATE TABLE IF NOT EXISTS "users" (
     "login" text NOT NULL UNIQUE,
     "pwd" text NOT NULL,
@@ -32,7 +35,4 @@ CREATE TABLE IF NOT EXISTS "files" (
     CONSTRAINT unique_asset_user_active UNIQUE (asset_name, user_login, deleted_at)
 );
 
-CREATE INDEX idx_asset_user ON files (asset_name, user_login);
-
--- password: secret
-insert into "users" values ('alice', '$2a$04$zkIAKg6l2DAuOMDDkRI9wuK43PjfONy41pgFqI6m8P2lueM13Rg1i') on conflict do nothing ;
\ No newline at end of file
+CREATE INDEX IF NOT EXISTS idx_asset_user ON files (asset_name, user_login);
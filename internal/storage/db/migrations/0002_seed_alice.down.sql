@@ -0,0 +1,4 @@
+-- later migrations are reverted by now, so only baseline tables can reference alice
+DELETE FROM "sessions" WHERE user_login = 'alice';
+DELETE FROM "files" WHERE user_login = 'alice';
+DELETE FROM "users" WHERE login = 'alice';
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS "api_keys" (
+    "id" serial PRIMARY KEY,
+    "user_login" text NOT NULL,
+    "prefix" text NOT NULL UNIQUE,
+    "key_hash" text NOT NULL,
+    "scope" text NOT NULL DEFAULT '',
+    "expire_at" bigint NOT NULL DEFAULT 0, -- unix timestamp in sec, 0 means no expiry
+    "last_used_at" bigint NOT NULL DEFAULT 0, -- unix timestamp in sec
+    "created_at" bigint NOT NULL default EXTRACT(EPOCH FROM now()),
+    "updated_at" bigint NOT NULL DEFAULT 0, -- unix timestamp in sec
+    "deleted_at" bigint NOT NULL DEFAULT 0, -- unix timestamp in sec
+    CONSTRAINT fk_user_login FOREIGN KEY ("user_login") REFERENCES "users"("login")
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_keys_user ON api_keys (user_login);
@@ -0,0 +1,16 @@
+ALTER TABLE "users" ADD COLUMN IF NOT EXISTS "totp_secret" text NOT NULL DEFAULT ''; -- base32 encoded, empty if not enrolled
+ALTER TABLE "users" ADD COLUMN IF NOT EXISTS "totp_enabled" boolean NOT NULL DEFAULT false; -- set after enrollment is confirmed
+ALTER TABLE "users" ADD COLUMN IF NOT EXISTS "totp_last_step" bigint NOT NULL DEFAULT 0; -- last accepted time step, prevents code replay
+
+CREATE TABLE IF NOT EXISTS "recovery_codes" (
+    "id" serial PRIMARY KEY,
+    "user_login" text NOT NULL,
+    "code_hash" text NOT NULL,
+    "used_at" bigint NOT NULL DEFAULT 0, -- unix timestamp in sec
+    "created_at" bigint NOT NULL default EXTRACT(EPOCH FROM now()),
+    "updated_at" bigint NOT NULL DEFAULT 0, -- unix timestamp in sec
+    "deleted_at" bigint NOT NULL DEFAULT 0, -- unix timestamp in sec
+    CONSTRAINT fk_user_login FOREIGN KEY ("user_login") REFERENCES "users"("login")
+);
+
+CREATE INDEX IF NOT EXISTS idx_recovery_codes_user ON recovery_codes (user_login);
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS "oauth_clients";
+
+DROP INDEX IF EXISTS idx_sessions_subject;
+ALTER TABLE "sessions" DROP COLUMN IF EXISTS "scope";
+ALTER TABLE "sessions" DROP COLUMN IF EXISTS "subject";
@@ -0,0 +1,19 @@
+-- sessions issued before clients existed belong to their user
+ALTER TABLE "sessions" ADD COLUMN IF NOT EXISTS "subject" text; -- user login or client:<client_id>
+UPDATE "sessions" SET subject = user_login WHERE subject IS NULL;
+ALTER TABLE "sessions" ALTER COLUMN "subject" SET NOT NULL;
+ALTER TABLE "sessions" ADD COLUMN IF NOT EXISTS "scope" text NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS "oauth_clients" (
+    "id" serial PRIMARY KEY,
+    "client_id" text NOT NULL UNIQUE,
+    "secret_hash" text NOT NULL,
+    "user_login" text NOT NULL, -- owner the client acts on behalf of
+    "scope" text NOT NULL CHECK ("scope" <> ''), -- allowed scopes, required so client tokens never carry all rights of the owner
+    "created_at" bigint NOT NULL default EXTRACT(EPOCH FROM now()),
+    "updated_at" bigint NOT NULL DEFAULT 0, -- unix timestamp in sec
+    "deleted_at" bigint NOT NULL DEFAULT 0, -- unix timestamp in sec
+    CONSTRAINT fk_user_login FOREIGN KEY ("user_login") REFERENCES "users"("login")
+);
+
+CREATE INDEX IF NOT EXISTS idx_sessions_subject ON sessions (subject);
@@ -0,0 +1,6 @@
+-- sessions issued before session ids get random ones, their tokens carry no jti anyway
+ALTER TABLE "sessions" ADD COLUMN IF NOT EXISTS "sid" text; -- public session id, jti claim of the token
+UPDATE "sessions" SET sid = gen_random_uuid()::text WHERE sid IS NULL;
+ALTER TABLE "sessions" ALTER COLUMN "sid" SET NOT NULL;
+-- same name as the index of an inline UNIQUE constraint, so databases already having it are left as is
+CREATE UNIQUE INDEX IF NOT EXISTS sessions_sid_key ON sessions (sid);
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS "user_identities" (
+    "id" serial PRIMARY KEY,
+    "issuer" text NOT NULL, -- external identity provider
+    "subject" text NOT NULL, -- subject at the provider
+    "user_login" text NOT NULL,
+    "created_at" bigint NOT NULL default EXTRACT(EPOCH FROM now()),
+    "updated_at" bigint NOT NULL DEFAULT 0, -- unix timestamp in sec
+    "deleted_at" bigint NOT NULL DEFAULT 0, -- unix timestamp in sec
+    CONSTRAINT fk_user_login FOREIGN KEY ("user_login") REFERENCES "users"("login")
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_user_identities_subject ON user_identities (issuer, subject) WHERE deleted_at =0;
@@ -0,0 +1,16 @@
+-- append-only, rows are never updated or deleted
+CREATE TABLE IF NOT EXISTS "login_audit" (
+    "id" bigserial PRIMARY KEY,
+    "login" text NOT NULL,
+    "method" text NOT NULL,
+    "success" boolean NOT NULL,
+    "reason" text NOT NULL,
+    "ip" text NOT NULL DEFAULT '',
+    "user_agent" text NOT NULL DEFAULT '',
+    "sid" text NOT NULL DEFAULT '', -- issued session id
+    "created_at" bigint NOT NULL default EXTRACT(EPOCH FROM now())
+);
+CREATE OR REPLACE RULE login_audit_no_update AS ON UPDATE TO login_audit DO INSTEAD NOTHING;
+CREATE OR REPLACE RULE login_audit_no_delete AS ON DELETE TO login_audit DO INSTEAD NOTHING;
+
+CREATE INDEX IF NOT EXISTS idx_login_audit_login ON login_audit (login, id);
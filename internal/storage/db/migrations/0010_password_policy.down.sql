@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS "password_history";
+
+ALTER TABLE "users" DROP COLUMN IF EXISTS "pwd_changed_at";
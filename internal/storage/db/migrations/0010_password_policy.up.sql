@@ -0,0 +1,13 @@
+-- existing passwords are considered changed when the migration runs, so they don't expire at once
+ALTER TABLE "users" ADD COLUMN IF NOT EXISTS "pwd_changed_at" bigint NOT NULL DEFAULT EXTRACT(EPOCH FROM now()); -- unix timestamp in sec, not updated by rehash
+
+-- previous password hashes, most recent are kept to prevent reuse
+CREATE TABLE IF NOT EXISTS "password_history" (
+    "id" bigserial PRIMARY KEY,
+    "user_login" text NOT NULL,
+    "pwd" text NOT NULL,
+    "created_at" bigint NOT NULL default EXTRACT(EPOCH FROM now()),
+    CONSTRAINT fk_user_login FOREIGN KEY ("user_login") REFERENCES "users"("login")
+);
+
+CREATE INDEX IF NOT EXISTS idx_password_history_user ON password_history (user_login, id);
@@ -0,0 +1,210 @@
+package db
+
+import (
+	"clearway-test-task/internal/storage"
+	"context"
+	"errors"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Replicas configures read-only replicas of the primary
+type Replicas struct {
+	Dsns []string
+	// CheckInterval is the period of pinging replicas, unhealthy ones get no reads until a ping succeeds
+	CheckInterval time.Duration
+	// StickyWindow is how long reads of a login written by this instance stay on the primary, it should exceed replication lag
+	StickyWindow time.Duration
+}
+
+type replica struct {
+	pool    *pgxpool.Pool
+	host    string
+	healthy atomic.Bool
+}
+
+// replicaSet balances reads over healthy replicas and keeps reads of recently written logins on the primary
+type replicaSet struct {
+	list   []*replica
+	next   atomic.Uint64
+	window time.Duration
+
+	mtx       sync.Mutex
+	written   map[string]time.Time
+	lastWrite time.Time
+
+	closer chan struct{}
+	done   chan struct{}
+	lg     *slog.Logger
+}
+
+func newReplicaSet(cfg Replicas, pool func(dsn string) (*pgxpool.Pool, error), lg *slog.Logger) (*replicaSet, error) {
+	rs := &replicaSet{
+		window:  cfg.StickyWindow,
+		written: make(map[string]time.Time),
+		closer:  make(chan struct{}),
+		done:    make(chan struct{}),
+		lg:      lg,
+	}
+	for _, dsn := range cfg.Dsns {
+		p, err := pool(dsn)
+		if err != nil {
+			rs.closePools()
+			return nil, err
+		}
+		rs.list = append(rs.list, &replica{pool: p, host: p.Config().ConnConfig.Host})
+	}
+	rs.check()
+	go rs.checker(cfg.CheckInterval)
+	return rs, nil
+}
+
+func (rs *replicaSet) checker(interval time.Duration) {
+	defer close(rs.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-rs.closer:
+			return
+		case <-ticker.C:
+			rs.check()
+		}
+	}
+}
+
+func (rs *replicaSet) check() {
+	for _, r := range rs.list {
+		ctx, cancel := context.WithTimeout(context.Background(), connectTimeout)
+		err := r.pool.Ping(ctx)
+		cancel()
+		rs.setHealthy(r, err)
+	}
+}
+
+// setHealthy records result of the last use of the replica, logging only changes
+func (rs *replicaSet) setHealthy(r *replica, err error) {
+	if err == nil {
+		if !r.healthy.Swap(true) {
+			rs.lg.Info("db replica is healthy", "Host", r.host)
+		}
+		return
+	}
+	if r.healthy.Swap(false) {
+		rs.lg.Warn("db replica is unhealthy", "Host", r.host, "error", err)
+	}
+}
+
+// pick returns a healthy replica to read data of login from, nil if the read must go to the primary.
+// Empty login means data of everyone
+func (rs *replicaSet) pick(ctx context.Context, login string) *replica {
+	if rs == nil || storage.PrimaryRequired(ctx) || rs.recentlyWritten(login) {
+		return nil
+	}
+	n := uint64(len(rs.list))
+	start := rs.next.Add(1)
+	for i := range n {
+		if r := rs.list[(start+i)%n]; r.healthy.Load() {
+			return r
+		}
+	}
+	return nil
+}
+
+// wrote marks login as written by this instance. Writers call it before writing, so reads racing the write see it too.
+// Empty login marks a write of unknown logins, only reads of everyone's data go to the primary then
+func (rs *replicaSet) wrote(login string) {
+	if rs == nil {
+		return
+	}
+	now := time.Now()
+	rs.mtx.Lock()
+	defer rs.mtx.Unlock()
+	rs.lastWrite = now
+	if login != "" {
+		rs.written[login] = now
+	}
+	// expired entries are swept once the map grows, so it stays bounded by logins written within the window
+	if len(rs.written) > 1024 {
+		for l, at := range rs.written {
+			if now.Sub(at) > rs.window {
+				delete(rs.written, l)
+			}
+		}
+	}
+}
+
+func (rs *replicaSet) recentlyWritten(login string) bool {
+	rs.mtx.Lock()
+	defer rs.mtx.Unlock()
+	at := rs.lastWrite
+	if login != "" {
+		at = rs.written[login]
+	}
+	return time.Since(at) <= rs.window
+}
+
+func (rs *replicaSet) close() {
+	if rs == nil {
+		return
+	}
+	close(rs.closer)
+	<-rs.done
+	rs.closePools()
+}
+
+func (rs *replicaSet) closePools() {
+	for _, r := range rs.list {
+		r.pool.Close()
+	}
+}
+
+// readQuery runs a read-only query of login data on a replica, see replicaSet.pick.
+// Falls back to the primary if there is no healthy replica or it fails to run the query
+func (d *Db) readQuery(ctx context.Context, op, login, sql string, args ...any) (pgx.Rows, error) {
+	if r := d.replicas.pick(ctx, login); r != nil {
+		rows, err := r.pool.Query(ctx, sql, args...)
+		if err == nil || ctx.Err() != nil {
+			return rows, err
+		}
+		d.replicaFailed(r, op, err)
+	}
+	return d.query(ctx, op, sql, args...)
+}
+
+func (d *Db) readQueryRow(ctx context.Context, op, login, sql string, args ...any) pgx.Row {
+	return replicaRow{d: d, ctx: ctx, op: op, login: login, sql: sql, args: args}
+}
+
+// replicaRow runs the query on Scan, so the primary can scan the row when the replica fails
+type replicaRow struct {
+	d     *Db
+	ctx   context.Context
+	op    string
+	login string
+	sql   string
+	args  []any
+}
+
+func (r replicaRow) Scan(dest ...any) error {
+	if rp := r.d.replicas.pick(r.ctx, r.login); rp != nil {
+		err := rp.pool.QueryRow(r.ctx, r.sql, r.args...).Scan(dest...)
+		if err == nil || errors.Is(err, pgx.ErrNoRows) || r.ctx.Err() != nil {
+			return err
+		}
+		r.d.replicaFailed(rp, r.op, err)
+	}
+	return r.d.queryRow(r.ctx, r.op, r.sql, r.args...).Scan(dest...)
+}
+
+// replicaFailed takes the replica out of rotation on connection failures, other errors only fall back to the primary
+func (d *Db) replicaFailed(r *replica, op string, err error) {
+	if isRetryable(err) {
+		d.replicas.setHealthy(r, err)
+	}
+	d.lg.Warn("db replica read failed, reading from primary", "Op", op, "Host", r.host, "error", err)
+}
@@ -0,0 +1,77 @@
+package db
+
+import (
+	"clearway-test-task/internal/storage"
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+// newTestReplicaSet has replicas without pools, enough to test routing
+func newTestReplicaSet(window time.Duration, healthy ...bool) *replicaSet {
+	rs := &replicaSet{
+		window:  window,
+		written: make(map[string]time.Time),
+		lg:      slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	for _, h := range healthy {
+		r := &replica{}
+		r.healthy.Store(h)
+		rs.list = append(rs.list, r)
+	}
+	return rs
+}
+
+func TestReplicaPick(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name    string
+		healthy []bool
+		ctx     context.Context
+		wrote   string
+		login   string
+		primary bool
+	}{
+		{name: "healthy replica", healthy: []bool{true}, ctx: ctx, login: "bob"},
+		{name: "no healthy replica", healthy: []bool{false, false}, ctx: ctx, login: "bob", primary: true},
+		{name: "primary required", healthy: []bool{true}, ctx: storage.WithPrimary(ctx), login: "bob", primary: true},
+		{name: "login written", healthy: []bool{true}, ctx: ctx, wrote: "bob", login: "bob", primary: true},
+		{name: "other login written", healthy: []bool{true}, ctx: ctx, wrote: "ann", login: "bob"},
+		{name: "everyone after a write", healthy: []bool{true}, ctx: ctx, wrote: "ann", primary: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rs := newTestReplicaSet(time.Minute, tt.healthy...)
+			if tt.wrote != "" {
+				rs.wrote(tt.wrote)
+			}
+			r := rs.pick(tt.ctx, tt.login)
+			if (r == nil) != tt.primary {
+				t.Fatalf("got replica %v, want primary %v", r, tt.primary)
+			}
+			if r != nil && !r.healthy.Load() {
+				t.Fatal("picked unhealthy replica")
+			}
+		})
+	}
+}
+
+func TestReplicaPickSkipsUnhealthy(t *testing.T) {
+	rs := newTestReplicaSet(time.Minute, false, true, false)
+	for i := 0; i < 6; i++ {
+		if r := rs.pick(context.Background(), "bob"); r != rs.list[1] {
+			t.Fatalf("pick %d: got %v, want the healthy replica", i, r)
+		}
+	}
+}
+
+func TestReplicaStickyWindowExpires(t *testing.T) {
+	rs := newTestReplicaSet(0, true)
+	rs.wrote("bob")
+	time.Sleep(time.Millisecond)
+	if rs.pick(context.Background(), "bob") == nil {
+		t.Fatal("read went to the primary after the sticky window")
+	}
+}
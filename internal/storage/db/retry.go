@@ -0,0 +1,161 @@
+package db
+
+import (
+	myerrors "clearway-test-task/internal/errors"
+	"clearway-test-task/internal/storage"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"math/rand/v2"
+	"time"
+)
+
+// Retry is the policy of running database operations again after transient errors
+type Retry struct {
+	// Attempts is the total number of runs, 1 disables retries
+	Attempts int
+	// Base delay before the first retry, doubled on each next one
+	Base time.Duration
+	// Max delay between retries
+	Max time.Duration
+}
+
+// retryableCodes are SQLSTATEs after which nothing of the operation is left applied: the transaction was rolled back
+// or the server refused to run it. Connection exceptions, class 08, are retryable as well
+var retryableCodes = map[string]struct{}{
+	pgerrcode.SerializationFailure: {},
+	pgerrcode.DeadlockDetected:     {},
+	pgerrcode.AdminShutdown:        {},
+	pgerrcode.CrashShutdown:        {},
+	pgerrcode.CannotConnectNow:     {},
+	pgerrcode.TooManyConnections:   {},
+}
+
+// isRetryable reports whether err is transient. A failed network round trip is retried only if the query surely
+// didn't reach the server, otherwise its outcome is unknown and running it again may apply it twice
+func isRetryable(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		if _, ok := retryableCodes[pgErr.Code]; ok {
+			return true
+		}
+		return pgerrcode.IsConnectionException(pgErr.Code)
+	}
+	return pgconn.SafeToRetry(err)
+}
+
+// delay before retry number n, starting from 1. Half of it is random so concurrent retries don't collide again
+func (r Retry) delay(n int) time.Duration {
+	d := r.Max
+	if n < 32 && r.Base<<(n-1) < r.Max {
+		d = r.Base << (n - 1)
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+// retry runs f until it succeeds, fails with an error which isn't transient or attempts are exhausted.
+// No retry is started which would sleep past the ctx deadline. While the breaker is open f isn't run at all.
+// f gets ctx of the operation span, so its statements are traced as children of it
+func (d *Db) retry(ctx context.Context, op string, f func(ctx context.Context) error) (err error) {
+	ctx, span := tracer.Start(ctx, "db."+op, trace.WithSpanKind(trace.SpanKindClient))
+	defer func() { endSpan(span, err) }()
+
+	if after, ok := d.breaker.allow(); !ok {
+		storage.MarkUnavailable(ctx, after)
+		return fmt.Errorf("%s: %w", op, myerrors.NewErrRetryAfter(after))
+	}
+	var retries int
+	ctx, acquireFailed := withAcquire(ctx)
+	err = f(ctx)
+	for err != nil && isRetryable(err) && retries+1 < d.retryPolicy.Attempts {
+		delay := d.retryPolicy.delay(retries + 1)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			break
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return errors.Join(err, ctx.Err())
+		case <-timer.C:
+		}
+		retries++
+		span.AddEvent("retry", trace.WithAttributes(attribute.Int("retry", retries)))
+		acquireFailed.Store(false)
+		err = f(ctx)
+	}
+	d.breaker.record(classify(ctx, err, acquireFailed.Load()), err)
+	if retries > 0 {
+		if err != nil {
+			d.lg.Warn("db operation failed after retries", "Op", op, "Retries", retries, "error", err)
+		} else {
+			d.lg.Warn("db operation succeeded after retries", "Op", op, "Retries", retries)
+		}
+	}
+	return err
+}
+
+func (d *Db) exec(ctx context.Context, op, sql string, args ...any) (pgconn.CommandTag, error) {
+	var tag pgconn.CommandTag
+	err := d.retry(ctx, op, func(ctx context.Context) error {
+		var err error
+		tag, err = d.pool.Exec(ctx, sql, args...)
+		return err
+	})
+	return tag, err
+}
+
+// query retries only sending the query, errors while reading rows are returned as is
+func (d *Db) query(ctx context.Context, op, sql string, args ...any) (pgx.Rows, error) {
+	var rows pgx.Rows
+	err := d.retry(ctx, op, func(ctx context.Context) error {
+		var err error
+		rows, err = d.pool.Query(ctx, sql, args...)
+		return err
+	})
+	return rows, err
+}
+
+func (d *Db) queryRow(ctx context.Context, op, sql string, args ...any) pgx.Row {
+	return retryRow{d: d, ctx: ctx, op: op, sql: sql, args: args}
+}
+
+// retryRow runs the query on Scan, so a failed run is scanned again
+type retryRow struct {
+	d    *Db
+	ctx  context.Context
+	op   string
+	sql  string
+	args []any
+}
+
+func (r retryRow) Scan(dest ...any) error {
+	return r.d.retry(r.ctx, r.op, func(ctx context.Context) error {
+		return r.d.pool.QueryRow(ctx, r.sql, r.args...).Scan(dest...)
+	})
+}
+
+// inTx runs f in a transaction and commits it. The whole transaction is run again on transient errors,
+// so f must not have side effects outside of it
+func (d *Db) inTx(ctx context.Context, op string, opts pgx.TxOptions, f func(ctx context.Context, tx pgx.Tx) error) error {
+	return d.retry(ctx, op, func(ctx context.Context) error {
+		tx, err := d.pool.BeginTx(ctx, opts)
+		if err != nil {
+			return fmt.Errorf("failed to begin tx: %w", err)
+		}
+		defer func() { _ = tx.Rollback(ctx) }()
+
+		if err = f(ctx, tx); err != nil {
+			return err
+		}
+		if err = tx.Commit(ctx); err != nil {
+			return fmt.Errorf("failed to commit tx: %w", err)
+		}
+		return nil
+	})
+}
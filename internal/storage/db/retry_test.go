@@ -0,0 +1,80 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+// newTestDb has no pools, enough to run retry
+func newTestDb(retry Retry, breaker Breaker) *Db {
+	lg := slog.New(slog.NewTextHandler(io.Discard, nil))
+	d := &Db{retryPolicy: retry, lg: lg}
+	d.breaker = newBreaker(breaker, func(context.Context) error { return nil }, lg)
+	return d
+}
+
+func TestRetryDelay(t *testing.T) {
+	r := Retry{Base: 10 * time.Millisecond, Max: 50 * time.Millisecond}
+	for n, full := range map[int]time.Duration{1: 10 * time.Millisecond, 2: 20 * time.Millisecond,
+		3: 40 * time.Millisecond, 4: 50 * time.Millisecond, 64: 50 * time.Millisecond} {
+		for i := 0; i < 100; i++ {
+			if d := r.delay(n); d < full/2 || d > full {
+				t.Fatalf("delay of retry %d is %v, want within [%v, %v]", n, d, full/2, full)
+			}
+		}
+	}
+}
+
+func TestRetry(t *testing.T) {
+	serialization := &pgconn.PgError{Code: pgerrcode.SerializationFailure}
+	tests := []struct {
+		name string
+		// errs are returned by consecutive runs, then runs succeed
+		errs []error
+		runs int
+		fail bool
+	}{
+		{name: "success", runs: 1},
+		{name: "transient error", errs: []error{serialization, serialization}, runs: 3},
+		{name: "attempts exhausted", errs: []error{serialization, serialization, serialization}, runs: 3, fail: true},
+		{name: "operation error", errs: []error{&pgconn.PgError{Code: pgerrcode.UniqueViolation}}, runs: 1, fail: true},
+		{name: "unknown outcome", errs: []error{errors.New("connection reset")}, runs: 1, fail: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newTestDb(Retry{Attempts: 3, Base: time.Millisecond, Max: time.Millisecond}, Breaker{})
+			var runs int
+			err := d.retry(context.Background(), "Op", func(context.Context) error {
+				runs++
+				if runs <= len(tt.errs) {
+					return tt.errs[runs-1]
+				}
+				return nil
+			})
+			if runs != tt.runs || (err != nil) != tt.fail {
+				t.Fatalf("got %d runs and error %v, want %d runs and failure %v", runs, err, tt.runs, tt.fail)
+			}
+		})
+	}
+}
+
+func TestRetryStopsBeforeDeadline(t *testing.T) {
+	d := newTestDb(Retry{Attempts: 5, Base: time.Hour, Max: time.Hour}, Breaker{})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	var runs int
+	start := time.Now()
+	err := d.retry(ctx, "Op", func(context.Context) error {
+		runs++
+		return &pgconn.PgError{Code: pgerrcode.DeadlockDetected}
+	})
+	if err == nil || runs != 1 || time.Since(start) > 500*time.Millisecond {
+		t.Fatalf("got %d runs, error %v after %v, want one run failing at once", runs, err, time.Since(start))
+	}
+}
@@ -1,27 +1,225 @@
 package storage
 
-import "context"
+import (
+	"context"
+	"time"
+)
 
 type Auth interface {
-	GetToken(ctx context.Context, login, password string) (string, int64, error)
-	ValidateToken(token string) (string, error)
+	GetToken(ctx context.Context, login, password, otp string) (string, int64, error)
+	ValidateToken(token string) (Claims, error)
+	ValidateApiKey(ctx context.Context, key string) (string, string, error)
+	CreateApiKey(ctx context.Context, login, scope string, expiresIn int64) (string, ApiKey, error)
+	GetApiKeys(ctx context.Context, login string) ([]ApiKey, error)
+	RevokeApiKey(ctx context.Context, login string, id int64) error
+	Register(ctx context.Context, login, password string) error
+	ChangePassword(ctx context.Context, login, currentPassword, newPassword string) error
+	ChangeExpiredPassword(ctx context.Context, login, currentPassword, otp, newPassword string) error
+	DeleteUser(ctx context.Context, login string) error
+	IsAdmin(ctx context.Context, login string) (bool, error)
+	EnrollTotp(ctx context.Context, login string) (string, string, error)
+	ConfirmTotp(ctx context.Context, login, code string) ([]string, error)
+	DisableTotp(ctx context.Context, login, code string) error
+	PasswordGrant(ctx context.Context, login, password, otp, scope string, client *Client) (string, int64, string, error)
+	ClientCredentialsGrant(ctx context.Context, client Client, scope string) (string, int64, string, error)
+	AuthenticateClient(ctx context.Context, clientId, secret string) (Client, error)
+	CreateClient(ctx context.Context, login, scope string) (string, Client, error)
+	GetClients(ctx context.Context) ([]Client, error)
+	DeleteClient(ctx context.Context, clientId string) error
+	ExternalLogin(ctx context.Context, issuer, subject, preferredLogin string, provision bool) (string, int64, error)
+	LinkIdentity(ctx context.Context, issuer, subject, login string) error
+	GetSessions(ctx context.Context, login string) ([]Session, error)
+	RevokeSession(ctx context.Context, id string) error
+	RevokeSessionsByLogin(ctx context.Context, login string) error
+	RevokeSessionsIssuedBefore(ctx context.Context, before int64) (int64, error)
+	GetLoginEvents(ctx context.Context, login string, beforeId int64, limit int) ([]LoginEvent, error)
+}
+
+// LoginLimiter throttles failed login attempts
+type LoginLimiter interface {
+	Allow(login, ip string) (time.Duration, bool)
+	Failure(login, ip string)
+	Success(login, ip string)
+	Unlock(login string)
+	UnlockIp(ip string)
 }
 
 type PasswordValidator interface {
 	Validate(hashedPassword, plainPassword string) error
+	Hash(plainPassword string) (string, error)
+	NeedsRehash(hashedPassword string) bool
+}
+
+// Auditor records login attempts. Record must not block the caller
+type Auditor interface {
+	Record(ev LoginEvent)
+}
+
+// SessionStore holds active sessions keyed by subject. Db remains the source of truth,
+// the store decides how much of it is kept in memory
+type SessionStore interface {
+	Get(ctx context.Context, subject string) (Token, bool, error)
+	Set(subject string, token Token)
+	Delete(subject string)
+	DeleteByLogin(login string)
+	// Reload replaces the content, load is called only by stores keeping all sessions
+	Reload(load func() (map[string]Token, error)) error
+	// Expired returns subjects of sessions expired before now. Stores not keeping all sessions return nothing
+	Expired(now int64) []string
+	// Len is the number of sessions kept in memory
+	Len() int
+}
+
+// Directory checks passwords against an external user directory. Returns role of the user, empty if not managed
+type Directory interface {
+	Authenticate(ctx context.Context, login, password string) (string, error)
 }
 
 type Db interface {
 	GetUserPwdHashByLogin(ctx context.Context, login string) (string, error)
+	GetUserRole(ctx context.Context, login string) (string, error)
+	CreateUser(ctx context.Context, login, pwdHash string) error
+	UpdateUserPwd(ctx context.Context, login, pwdHash string) error
+	ChangeUserPwd(ctx context.Context, login, pwdHash string, keepHistory int) error
+	GetUserPwdChangedAt(ctx context.Context, login string) (int64, error)
+	GetPwdHistory(ctx context.Context, login string, limit int) ([]string, error)
+	UpdateUserRole(ctx context.Context, login, role string) error
+	DeleteUser(ctx context.Context, login string) error
 	GetDataByAssetName(ctx context.Context, id, login string) ([]byte, string, error)
 	SetDataByAssetName(ctx context.Context, assetName, login, contentType string, data []byte) error
 	DeleteDataByAssetName(ctx context.Context, assetName, login string) error
-	UpdateSession(ctx context.Context, login, token string, iat, exp int64) error
+	UpdateSession(ctx context.Context, session Session) error
 	DeleteSessionByLogin(ctx context.Context, login string) error
+	DeleteSessionBySubject(ctx context.Context, subject string) error
 	GetActiveSessions(ctx context.Context) (map[string]Token, error)
+	GetActiveSessionsBySubject(ctx context.Context, subject string) (map[string]Token, error)
+	GetActiveSessionsByLogin(ctx context.Context, login string) (map[string]Token, error)
+	GetSessions(ctx context.Context, login string) ([]Session, error)
+	DeleteSessionById(ctx context.Context, id string) (string, error)
+	DeleteSessionsIssuedBefore(ctx context.Context, before int64) ([]string, error)
+	ListenSessionEvents(ctx context.Context, onConnect func(), onEvent func(SessionEvent)) error
+	CreateApiKey(ctx context.Context, key ApiKey) (int64, error)
+	GetApiKeyByPrefix(ctx context.Context, prefix string) (ApiKey, error)
+	GetApiKeysByLogin(ctx context.Context, login string) ([]ApiKey, error)
+	DeleteApiKey(ctx context.Context, id int64, login string) error
+	UpdateApiKeyLastUsed(ctx context.Context, id, lastUsedAt int64) error
+	GetUserTotp(ctx context.Context, login string) (Totp, error)
+	SetUserTotpSecret(ctx context.Context, login, secret string) error
+	EnableUserTotp(ctx context.Context, login string, step int64, codeHashes []string) error
+	DisableUserTotp(ctx context.Context, login string) error
+	UpdateUserTotpStep(ctx context.Context, login string, step int64) (bool, error)
+	UseRecoveryCode(ctx context.Context, login, codeHash string) (bool, error)
+	CreateClient(ctx context.Context, client Client) (int64, error)
+	GetClient(ctx context.Context, clientId string) (Client, error)
+	GetClients(ctx context.Context) ([]Client, error)
+	DeleteClient(ctx context.Context, clientId string) error
+	GetLoginByIdentity(ctx context.Context, issuer, subject string) (string, error)
+	CreateIdentity(ctx context.Context, issuer, subject, login string) error
+	CreateExternalUser(ctx context.Context, login, pwdHash, issuer, subject string) error
+	CreateLoginEvents(ctx context.Context, events []LoginEvent) error
+	GetLoginEvents(ctx context.Context, login string, beforeId int64, limit int) ([]LoginEvent, error)
 }
 
+// Token is a cached active session. Sessions are keyed by subject, which is either user login or client subject
 type Token struct {
+	Token     string
+	ExpireAt  int64
+	Login     string
+	SessionId string
+}
+
+// Session is a persisted token issued for the subject on behalf of login
+type Session struct {
+	Id       string
+	Subject  string
+	Login    string
 	Token    string
+	Scope    string
+	IssuedAt int64
 	ExpireAt int64
 }
+
+// Session event kinds
+const (
+	SessionCreated       = "create"
+	SessionRevoked       = "revoke"
+	LoginSessionsRevoked = "revoke_login"
+	SessionsRevoked      = "revoke_all"
+)
+
+// SessionEvent notifies other instances that sessions of Subject or of Login changed. SessionsRevoked affects any session
+type SessionEvent struct {
+	Op      string `json:"op"`
+	Subject string `json:"subject,omitempty"`
+	Login   string `json:"login,omitempty"`
+}
+
+// Login methods and outcomes recorded in the audit trail
+const (
+	LoginMethodPassword          = "password"
+	LoginMethodOauthPassword     = "oauth_password"
+	LoginMethodOidc              = "oidc"
+	LoginMethodClientCredentials = "client_credentials"
+
+	LoginOk                 = "ok"
+	LoginInvalidCredentials = "invalid_credentials"
+	LoginOtpRequired        = "otp_required"
+	LoginOtpInvalid         = "otp_invalid"
+	LoginPasswordExpired    = "password_expired"
+	LoginThrottled          = "throttled"
+	LoginError              = "error"
+)
+
+// LoginEvent is a login attempt. SessionId is set only for successful attempts, Login holds client id
+// for client credentials grant
+type LoginEvent struct {
+	Id        int64
+	Login     string
+	Method    string
+	Success   bool
+	Reason    string
+	Ip        string
+	UserAgent string
+	SessionId string
+	CreatedAt int64
+}
+
+// Claims are the validated claims of a session token
+type Claims struct {
+	SessionId string
+	Subject   string
+	Login     string
+	ClientId  string
+	Scope     string
+	IssuedAt  int64
+	ExpireAt  int64
+}
+
+// Client is a registered confidential OAuth2 client acting on behalf of its owner login
+type Client struct {
+	Id         int64
+	ClientId   string
+	SecretHash string
+	Login      string
+	Scope      string
+	CreatedAt  int64
+}
+
+// ApiKey is a long-lived credential. Only the hash of the key is stored, the prefix is used for lookup
+type ApiKey struct {
+	Id         int64
+	Login      string
+	Prefix     string
+	Hash       string
+	Scope      string
+	ExpireAt   int64
+	LastUsedAt int64
+	CreatedAt  int64
+}
+
+// Totp is the two-factor state of a user
+type Totp struct {
+	Secret   string
+	Enabled  bool
+	LastStep int64
+}
@@ -0,0 +1,204 @@
+package limiterStorage
+
+import (
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// freeAttempts is the number of failures allowed before backoff kicks in
+const freeAttempts = 3
+
+// knownIpTtl is how long an ip stays known to a login after a successful login from it
+const knownIpTtl = 30 * 24 * time.Hour
+
+type attempts struct {
+	failures     int
+	lastFailure  time.Time
+	blockedUntil time.Time
+}
+
+// LimiterStorage tracks failed login attempts per login and per remote ip.
+// Each failure after freeAttempts blocks the key with exponentially growing backoff,
+// reaching the threshold locks the key for the lockout duration.
+// Anyone can fail logins of a known account, so ips the login recently succeeded from are counted
+// separately and aren't affected by the lockout of the login
+type LimiterStorage struct {
+	loginThreshold  int
+	ipThreshold     int
+	lockoutDuration time.Duration
+	backoffBase     time.Duration
+	backoffMax      time.Duration
+
+	mtx    sync.Mutex
+	logins map[string]*attempts
+	ips    map[string]*attempts
+	// known holds time of the last successful login per login and ip pair, pairs counts failures of known pairs
+	known map[string]time.Time
+	pairs map[string]*attempts
+
+	// now is time.Now, tests replace it
+	now func() time.Time
+
+	cleanupTicker *time.Ticker
+	closer        chan struct{}
+	once          sync.Once
+	lg            *slog.Logger
+}
+
+func NewLimiterStorage(loginThreshold, ipThreshold int, lockoutDuration, backoffBase, backoffMax time.Duration, lg *slog.Logger) *LimiterStorage {
+	l := &LimiterStorage{
+		loginThreshold:  loginThreshold,
+		ipThreshold:     ipThreshold,
+		lockoutDuration: lockoutDuration,
+		backoffBase:     backoffBase,
+		backoffMax:      backoffMax,
+		logins:          make(map[string]*attempts),
+		ips:             make(map[string]*attempts),
+		known:           make(map[string]time.Time),
+		pairs:           make(map[string]*attempts),
+		now:             time.Now,
+		cleanupTicker:   time.NewTicker(lockoutDuration),
+		closer:          make(chan struct{}),
+		lg:              lg,
+	}
+	go l.cleaner()
+	return l
+}
+
+// Allow reports whether login attempt is allowed. If not, returns time to wait before the next attempt
+func (l *LimiterStorage) Allow(login, ip string) (time.Duration, bool) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	now := l.now()
+
+	var wait time.Duration
+	logins, key := l.loginKey(login, ip, now)
+	if a, ok := logins[key]; ok && a.blockedUntil.After(now) {
+		wait = a.blockedUntil.Sub(now)
+	}
+	if a, ok := l.ips[ip]; ok && a.blockedUntil.After(now) {
+		wait = max(wait, a.blockedUntil.Sub(now))
+	}
+	return wait, wait == 0
+}
+
+// Failure registers failed attempt for both login and ip
+func (l *LimiterStorage) Failure(login, ip string) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	now := l.now()
+
+	logins, key := l.loginKey(login, ip, now)
+	l.fail(logins, key, l.loginThreshold, now)
+	l.fail(l.ips, ip, l.ipThreshold, now)
+}
+
+// Success resets failures of the login and makes ip known to it. Ip failures are kept, so one valid account
+// can't be used to reset the counter of an address guessing other accounts
+func (l *LimiterStorage) Success(login, ip string) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	delete(l.logins, login)
+	delete(l.pairs, pairKey(login, ip))
+	l.known[pairKey(login, ip)] = l.now()
+}
+
+// Unlock removes lockout and failures of the login, including ones counted for its known ips
+func (l *LimiterStorage) Unlock(login string) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	delete(l.logins, login)
+	prefix := pairKey(login, "")
+	for k := range l.pairs {
+		if strings.HasPrefix(k, prefix) {
+			delete(l.pairs, k)
+		}
+	}
+}
+
+// UnlockIp removes lockout and failures of the ip
+func (l *LimiterStorage) UnlockIp(ip string) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	delete(l.ips, ip)
+}
+
+// loginKey returns the map and the key counting failures of login from ip
+func (l *LimiterStorage) loginKey(login, ip string, now time.Time) (map[string]*attempts, string) {
+	key := pairKey(login, ip)
+	if at, ok := l.known[key]; ok && now.Sub(at) < knownIpTtl {
+		return l.pairs, key
+	}
+	return l.logins, login
+}
+
+func pairKey(login, ip string) string {
+	return login + "\x00" + ip
+}
+
+func (l *LimiterStorage) fail(m map[string]*attempts, key string, threshold int, now time.Time) {
+	a, ok := m[key]
+	if !ok || now.Sub(a.lastFailure) > l.lockoutDuration {
+		a = &attempts{}
+		m[key] = a
+	}
+	a.failures++
+	a.lastFailure = now
+
+	switch {
+	case a.failures >= threshold:
+		a.blockedUntil = now.Add(l.lockoutDuration)
+		l.lg.Warn("login attempts locked out", "Key", key, "Failures", a.failures)
+	case a.failures > freeAttempts:
+		a.blockedUntil = now.Add(l.backoff(a.failures - freeAttempts))
+	}
+}
+
+func (l *LimiterStorage) backoff(n int) time.Duration {
+	d := l.backoffBase
+	for i := 1; i < n && d < l.backoffMax; i++ {
+		d *= 2
+	}
+	return min(d, l.backoffMax)
+}
+
+func (l *LimiterStorage) Close() error {
+	l.once.Do(func() {
+		close(l.closer)
+		l.cleanupTicker.Stop()
+	})
+	l.lg.Debug("login limiter closed")
+	return nil
+}
+
+// cleaner drops entries which are neither blocked nor recently failed
+func (l *LimiterStorage) cleaner() {
+	for {
+		select {
+		case <-l.cleanupTicker.C:
+			l.cleanup()
+		case <-l.closer:
+			return
+		}
+	}
+}
+
+func (l *LimiterStorage) cleanup() {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	now := l.now()
+	for k, at := range l.known {
+		if now.Sub(at) >= knownIpTtl {
+			delete(l.known, k)
+		}
+	}
+	for _, m := range []map[string]*attempts{l.logins, l.ips, l.pairs} {
+		for k, a := range m {
+			if now.After(a.blockedUntil) && now.Sub(a.lastFailure) > l.lockoutDuration {
+				delete(m, k)
+			}
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package limiterStorage
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+const (
+	lockout     = time.Hour
+	backoffBase = time.Second
+	backoffMax  = 4 * time.Second
+)
+
+// clock is a manually advanced time source
+type clock struct {
+	t time.Time
+}
+
+func (c *clock) now() time.Time {
+	return c.t
+}
+
+func (c *clock) advance(d time.Duration) {
+	c.t = c.t.Add(d)
+}
+
+func newTestLimiter(t *testing.T, loginThreshold, ipThreshold int) (*LimiterStorage, *clock) {
+	t.Helper()
+	l := NewLimiterStorage(loginThreshold, ipThreshold, lockout, backoffBase, backoffMax, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	t.Cleanup(func() { _ = l.Close() })
+	c := &clock{t: time.Unix(1_700_000_000, 0)}
+	l.now = c.now
+	return l, c
+}
+
+func wantWait(t *testing.T, l *LimiterStorage, login, ip string, want time.Duration) {
+	t.Helper()
+	wait, ok := l.Allow(login, ip)
+	if wait != want || ok != (want == 0) {
+		t.Fatalf("%s from %s: got wait %v, allowed %v, want wait %v", login, ip, wait, ok, want)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		failures int
+		wait     time.Duration
+	}{
+		{failures: 1},
+		{failures: freeAttempts},
+		{failures: freeAttempts + 1, wait: backoffBase},
+		{failures: freeAttempts + 2, wait: 2 * backoffBase},
+		{failures: freeAttempts + 3, wait: backoffMax},
+		{failures: freeAttempts + 5, wait: backoffMax},
+		{failures: 10, wait: lockout},
+		{failures: 12, wait: lockout},
+	}
+	for _, tt := range tests {
+		l, _ := newTestLimiter(t, 10, 100)
+		for range tt.failures {
+			l.Failure("bob", "10.0.0.1")
+		}
+		wantWait(t, l, "bob", "10.0.0.1", tt.wait)
+		// the login is blocked from any address
+		wantWait(t, l, "bob", "10.0.0.2", tt.wait)
+		wantWait(t, l, "ann", "10.0.0.2", 0)
+	}
+}
+
+func TestBlockExpires(t *testing.T) {
+	l, c := newTestLimiter(t, 5, 100)
+	for range 5 {
+		l.Failure("bob", "10.0.0.1")
+	}
+	wantWait(t, l, "bob", "10.0.0.1", lockout)
+	c.advance(lockout / 2)
+	wantWait(t, l, "bob", "10.0.0.1", lockout/2)
+	c.advance(lockout / 2)
+	wantWait(t, l, "bob", "10.0.0.1", 0)
+
+	// failures older than the lockout duration are forgotten
+	c.advance(lockout + time.Second)
+	l.Failure("bob", "10.0.0.1")
+	wantWait(t, l, "bob", "10.0.0.1", 0)
+}
+
+func TestSuccessResetsLogin(t *testing.T) {
+	l, _ := newTestLimiter(t, 10, 100)
+	for range freeAttempts {
+		l.Failure("bob", "10.0.0.1")
+	}
+	l.Success("bob", "10.0.0.1")
+	l.Failure("bob", "10.0.0.2")
+	wantWait(t, l, "bob", "10.0.0.2", 0)
+}
+
+func TestIpThreshold(t *testing.T) {
+	l, _ := newTestLimiter(t, 100, 5)
+	for _, login := range []string{"a", "b", "c", "d", "e"} {
+		l.Failure(login, "10.0.0.1")
+	}
+	wantWait(t, l, "ann", "10.0.0.1", lockout)
+	wantWait(t, l, "ann", "10.0.0.2", 0)
+
+	// a valid account doesn't reset failures of the address
+	l.Success("ann", "10.0.0.1")
+	wantWait(t, l, "ann", "10.0.0.1", lockout)
+
+	l.UnlockIp("10.0.0.1")
+	wantWait(t, l, "ann", "10.0.0.1", 0)
+}
+
+func TestKnownIp(t *testing.T) {
+	l, c := newTestLimiter(t, 5, 100)
+	l.Success("bob", "10.0.0.1")
+	for range 5 {
+		l.Failure("bob", "10.0.0.66")
+	}
+	wantWait(t, l, "bob", "10.0.0.66", lockout)
+	// the lockout of the login doesn't block the address it succeeded from
+	wantWait(t, l, "bob", "10.0.0.1", 0)
+
+	// failures from the known address are counted for the pair
+	for range 5 {
+		l.Failure("bob", "10.0.0.1")
+	}
+	wantWait(t, l, "bob", "10.0.0.1", lockout)
+
+	// admin unlock clears the pairs too, backoff of the addresses is kept
+	l.Unlock("bob")
+	wantWait(t, l, "bob", "10.0.0.1", 2*backoffBase)
+	l.UnlockIp("10.0.0.1")
+	l.UnlockIp("10.0.0.66")
+	wantWait(t, l, "bob", "10.0.0.1", 0)
+	wantWait(t, l, "bob", "10.0.0.66", 0)
+
+	// known addresses expire
+	c.advance(knownIpTtl)
+	for range 5 {
+		l.Failure("bob", "10.0.0.66")
+	}
+	wantWait(t, l, "bob", "10.0.0.1", lockout)
+}
+
+func TestCleanup(t *testing.T) {
+	l, c := newTestLimiter(t, 5, 5)
+	l.Success("ann", "10.0.0.2")
+	for range 5 {
+		l.Failure("bob", "10.0.0.1")
+	}
+	c.advance(lockout)
+	l.cleanup()
+	if len(l.logins) != 1 || len(l.ips) != 1 {
+		t.Fatalf("blocked entries removed, %d logins and %d ips left", len(l.logins), len(l.ips))
+	}
+
+	c.advance(time.Second)
+	l.cleanup()
+	if len(l.logins) != 0 || len(l.ips) != 0 || len(l.known) != 1 {
+		t.Fatalf("got %d logins, %d ips and %d known pairs after cleanup, want only the known pair",
+			len(l.logins), len(l.ips), len(l.known))
+	}
+	c.advance(knownIpTtl)
+	l.cleanup()
+	if len(l.known) != 0 {
+		t.Fatal("expired known pair kept")
+	}
+}
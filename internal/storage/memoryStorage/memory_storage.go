@@ -0,0 +1,628 @@
+package memoryStorage
+
+import (
+	myerrors "clearway-test-task/internal/errors"
+	"clearway-test-task/internal/storage"
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const defaultRole = "user"
+
+type user struct {
+	pwd          string
+	pwdChangedAt int64
+	role         string
+	totp         storage.Totp
+	deletedAt    int64
+}
+
+type fileKey struct {
+	assetName string
+	login     string
+}
+
+type file struct {
+	contentType string
+	data        []byte
+}
+
+type apiKey struct {
+	storage.ApiKey
+	deletedAt int64
+}
+
+type client struct {
+	storage.Client
+	deletedAt int64
+}
+
+type recoveryCode struct {
+	hash string
+	used bool
+}
+
+type identityKey struct {
+	issuer  string
+	subject string
+}
+
+// MemoryStorage is storage.Db kept in process memory, for tests and demos. Soft-deleted rows are kept only
+// where they still matter, like logins and api key prefixes which can't be reused. Nothing survives a restart
+type MemoryStorage struct {
+	mu         sync.RWMutex
+	users      map[string]*user
+	pwdHistory map[string][]string
+	files      map[fileKey]file
+	// active sessions by subject
+	sessions      map[string]storage.Session
+	apiKeys       map[string]*apiKey
+	nextKeyId     int64
+	recoveryCodes map[string][]recoveryCode
+	clients       map[string]*client
+	nextClientId  int64
+	identities    map[identityKey]string
+	loginEvents   []storage.LoginEvent
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		users:         make(map[string]*user),
+		pwdHistory:    make(map[string][]string),
+		files:         make(map[fileKey]file),
+		sessions:      make(map[string]storage.Session),
+		apiKeys:       make(map[string]*apiKey),
+		recoveryCodes: make(map[string][]recoveryCode),
+		clients:       make(map[string]*client),
+		identities:    make(map[identityKey]string),
+	}
+}
+
+func (m *MemoryStorage) Close(lg *slog.Logger) error {
+	lg.Debug("closed memory storage")
+	return nil
+}
+
+// Ping always succeeds, there is nothing to reach
+func (m *MemoryStorage) Ping(context.Context) error {
+	return nil
+}
+
+func now() int64 {
+	return time.Now().Unix()
+}
+
+// activeUser must be called with mu held
+func (m *MemoryStorage) activeUser(login string) (*user, bool) {
+	u, ok := m.users[login]
+	if !ok || u.deletedAt != 0 {
+		return nil, false
+	}
+	return u, true
+}
+
+func (m *MemoryStorage) GetUserPwdHashByLogin(_ context.Context, login string) (string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	u, ok := m.activeUser(login)
+	if !ok {
+		return "", myerrors.NewErrUserNotFound(login)
+	}
+	return u.pwd, nil
+}
+
+func (m *MemoryStorage) GetUserRole(_ context.Context, login string) (string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	u, ok := m.activeUser(login)
+	if !ok {
+		return "", myerrors.NewErrUserNotFound(login)
+	}
+	return u.role, nil
+}
+
+func (m *MemoryStorage) CreateUser(_ context.Context, login, pwdHash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// deleted users keep their login
+	if _, ok := m.users[login]; ok {
+		return myerrors.NewErrUserExists(login)
+	}
+	m.users[login] = &user{pwd: pwdHash, pwdChangedAt: now(), role: defaultRole}
+	return nil
+}
+
+func (m *MemoryStorage) UpdateUserPwd(_ context.Context, login, pwdHash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.activeUser(login)
+	if !ok {
+		return myerrors.NewErrUserNotFound(login)
+	}
+	u.pwd = pwdHash
+	return nil
+}
+
+func (m *MemoryStorage) ChangeUserPwd(_ context.Context, login, pwdHash string, keepHistory int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.activeUser(login)
+	if !ok {
+		return myerrors.NewErrUserNotFound(login)
+	}
+	history := m.pwdHistory[login]
+	if keepHistory > 0 {
+		history = append([]string{u.pwd}, history...)
+	}
+	if len(history) > keepHistory {
+		history = history[:keepHistory]
+	}
+	m.pwdHistory[login] = history
+	u.pwd = pwdHash
+	u.pwdChangedAt = now()
+	return nil
+}
+
+func (m *MemoryStorage) GetUserPwdChangedAt(_ context.Context, login string) (int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	u, ok := m.activeUser(login)
+	if !ok {
+		return 0, myerrors.NewErrUserNotFound(login)
+	}
+	return u.pwdChangedAt, nil
+}
+
+// GetPwdHistory returns previous password hashes of the user, most recent first
+func (m *MemoryStorage) GetPwdHistory(_ context.Context, login string, limit int) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	history := m.pwdHistory[login]
+	if len(history) > limit {
+		history = history[:limit]
+	}
+	return slices.Clone(history), nil
+}
+
+func (m *MemoryStorage) UpdateUserRole(_ context.Context, login, role string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.activeUser(login)
+	if !ok {
+		return myerrors.NewErrUserNotFound(login)
+	}
+	u.role = role
+	return nil
+}
+
+// DeleteUser soft-deletes the user and revokes all of its sessions, api keys, clients, recovery codes and external identities
+func (m *MemoryStorage) DeleteUser(_ context.Context, login string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.activeUser(login)
+	if !ok {
+		return myerrors.NewErrUserNotFound(login)
+	}
+	t := now()
+	u.deletedAt = t
+	m.deleteSessions(func(s storage.Session) bool { return s.Login == login })
+	for _, k := range m.apiKeys {
+		if k.Login == login && k.deletedAt == 0 {
+			k.deletedAt = t
+		}
+	}
+	delete(m.recoveryCodes, login)
+	for _, c := range m.clients {
+		if c.Login == login && c.deletedAt == 0 {
+			c.deletedAt = t
+		}
+	}
+	for k, l := range m.identities {
+		if l == login {
+			delete(m.identities, k)
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStorage) GetDataByAssetName(_ context.Context, assetName, login string) ([]byte, string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	f, ok := m.files[fileKey{assetName: assetName, login: login}]
+	if !ok {
+		return nil, "", myerrors.NewErrAssetNotFound(login, assetName)
+	}
+	return slices.Clone(f.data), f.contentType, nil
+}
+
+func (m *MemoryStorage) SetDataByAssetName(_ context.Context, assetName, login, contentType string, data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[login]; !ok {
+		return fmt.Errorf("failed to upsert data by asset name: user %s does not exist", login)
+	}
+	m.files[fileKey{assetName: assetName, login: login}] = file{contentType: contentType, data: slices.Clone(data)}
+	return nil
+}
+
+func (m *MemoryStorage) DeleteDataByAssetName(_ context.Context, assetName, login string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.files, fileKey{assetName: assetName, login: login})
+	return nil
+}
+
+// UpdateSession revokes active session of the subject and stores the new one
+func (m *MemoryStorage) UpdateSession(_ context.Context, session storage.Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[session.Login]; !ok {
+		return fmt.Errorf("failed to store session: user %s does not exist", session.Login)
+	}
+	m.sessions[session.Subject] = session
+	return nil
+}
+
+// deleteSessions revokes sessions matching f and returns their subjects. It must be called with mu held
+func (m *MemoryStorage) deleteSessions(f func(s storage.Session) bool) []string {
+	subjects := make([]string, 0)
+	for subject, s := range m.sessions {
+		if f(s) {
+			delete(m.sessions, subject)
+			subjects = append(subjects, subject)
+		}
+	}
+	return subjects
+}
+
+func (m *MemoryStorage) DeleteSessionByLogin(_ context.Context, login string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.deleteSessions(func(s storage.Session) bool { return s.Login == login })
+	return nil
+}
+
+func (m *MemoryStorage) DeleteSessionBySubject(_ context.Context, subject string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.sessions, subject)
+	return nil
+}
+
+func (m *MemoryStorage) GetActiveSessions(context.Context) (map[string]storage.Token, error) {
+	return m.getSessions(func(storage.Session) bool { return true }), nil
+}
+
+func (m *MemoryStorage) GetActiveSessionsBySubject(_ context.Context, subject string) (map[string]storage.Token, error) {
+	return m.getSessions(func(s storage.Session) bool { return s.Subject == subject }), nil
+}
+
+func (m *MemoryStorage) GetActiveSessionsByLogin(_ context.Context, login string) (map[string]storage.Token, error) {
+	return m.getSessions(func(s storage.Session) bool { return s.Login == login }), nil
+}
+
+func (m *MemoryStorage) getSessions(f func(s storage.Session) bool) map[string]storage.Token {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	cache := make(map[string]storage.Token)
+	for subject, s := range m.sessions {
+		if f(s) {
+			cache[subject] = storage.Token{Token: s.Token, ExpireAt: s.ExpireAt, Login: s.Login, SessionId: s.Id}
+		}
+	}
+	return cache
+}
+
+// GetSessions returns unexpired active sessions of login, or of everyone if login is empty. Tokens are not returned
+func (m *MemoryStorage) GetSessions(_ context.Context, login string) ([]storage.Session, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	t := now()
+	sessions := make([]storage.Session, 0)
+	for _, s := range m.sessions {
+		if (login == "" || s.Login == login) && s.ExpireAt > t {
+			s.Token = ""
+			sessions = append(sessions, s)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].IssuedAt > sessions[j].IssuedAt })
+	return sessions, nil
+}
+
+// DeleteSessionById revokes the session and returns its subject
+func (m *MemoryStorage) DeleteSessionById(_ context.Context, id string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for subject, s := range m.sessions {
+		if s.Id == id {
+			delete(m.sessions, subject)
+			return subject, nil
+		}
+	}
+	return "", myerrors.NewErrSessionNotFound(id)
+}
+
+// DeleteSessionsIssuedBefore revokes sessions issued before the unix timestamp. Returns subjects of revoked sessions
+func (m *MemoryStorage) DeleteSessionsIssuedBefore(_ context.Context, before int64) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.deleteSessions(func(s storage.Session) bool { return s.IssuedAt < before }), nil
+}
+
+// ListenSessionEvents has no other instances to hear from, it only waits until ctx is done
+func (m *MemoryStorage) ListenSessionEvents(ctx context.Context, onConnect func(), _ func(storage.SessionEvent)) error {
+	onConnect()
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (m *MemoryStorage) CreateApiKey(_ context.Context, key storage.ApiKey) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[key.Login]; !ok {
+		return 0, fmt.Errorf("failed to create api key: user %s does not exist", key.Login)
+	}
+	if _, ok := m.apiKeys[key.Prefix]; ok {
+		return 0, fmt.Errorf("failed to create api key: prefix %s already exists", key.Prefix)
+	}
+	m.nextKeyId++
+	key.Id = m.nextKeyId
+	key.LastUsedAt = 0
+	key.CreatedAt = now()
+	m.apiKeys[key.Prefix] = &apiKey{ApiKey: key}
+	return key.Id, nil
+}
+
+func (m *MemoryStorage) GetApiKeyByPrefix(_ context.Context, prefix string) (storage.ApiKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	k, ok := m.apiKeys[prefix]
+	if !ok || k.deletedAt != 0 {
+		return storage.ApiKey{}, myerrors.NewErrApiKeyNotFound(prefix)
+	}
+	return k.ApiKey, nil
+}
+
+func (m *MemoryStorage) GetApiKeysByLogin(_ context.Context, login string) ([]storage.ApiKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	keys := make([]storage.ApiKey, 0)
+	for _, k := range m.apiKeys {
+		if k.Login == login && k.deletedAt == 0 {
+			keys = append(keys, k.ApiKey)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Id < keys[j].Id })
+	return keys, nil
+}
+
+func (m *MemoryStorage) DeleteApiKey(_ context.Context, id int64, login string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, k := range m.apiKeys {
+		if k.Id == id && k.Login == login && k.deletedAt == 0 {
+			k.deletedAt = now()
+			return nil
+		}
+	}
+	return myerrors.NewErrApiKeyNotFound(strconv.FormatInt(id, 10))
+}
+
+func (m *MemoryStorage) UpdateApiKeyLastUsed(_ context.Context, id, lastUsedAt int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, k := range m.apiKeys {
+		if k.Id == id && k.deletedAt == 0 {
+			k.LastUsedAt = lastUsedAt
+			return nil
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStorage) GetUserTotp(_ context.Context, login string) (storage.Totp, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	u, ok := m.activeUser(login)
+	if !ok {
+		return storage.Totp{}, myerrors.NewErrUserNotFound(login)
+	}
+	return u.totp, nil
+}
+
+func (m *MemoryStorage) SetUserTotpSecret(_ context.Context, login, secret string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.activeUser(login)
+	if !ok {
+		return myerrors.NewErrUserNotFound(login)
+	}
+	u.totp = storage.Totp{Secret: secret}
+	return nil
+}
+
+// EnableUserTotp enables two-factor auth and replaces recovery codes of the user
+func (m *MemoryStorage) EnableUserTotp(_ context.Context, login string, step int64, codeHashes []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.activeUser(login)
+	if !ok || u.totp.Secret == "" {
+		return myerrors.NewErrUserNotFound(login)
+	}
+	u.totp.Enabled = true
+	u.totp.LastStep = step
+	codes := make([]recoveryCode, 0, len(codeHashes))
+	for _, h := range codeHashes {
+		codes = append(codes, recoveryCode{hash: h})
+	}
+	m.recoveryCodes[login] = codes
+	return nil
+}
+
+func (m *MemoryStorage) DisableUserTotp(_ context.Context, login string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if u, ok := m.activeUser(login); ok {
+		u.totp = storage.Totp{}
+	}
+	delete(m.recoveryCodes, login)
+	return nil
+}
+
+// UpdateUserTotpStep stores the last accepted step. Returns false if the step was already used
+func (m *MemoryStorage) UpdateUserTotpStep(_ context.Context, login string, step int64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.activeUser(login)
+	if !ok || u.totp.LastStep >= step {
+		return false, nil
+	}
+	u.totp.LastStep = step
+	return true, nil
+}
+
+// UseRecoveryCode marks the code as used. Returns false if there is no such unused code
+func (m *MemoryStorage) UseRecoveryCode(_ context.Context, login, codeHash string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	codes := m.recoveryCodes[login]
+	for i := range codes {
+		if codes[i].hash == codeHash && !codes[i].used {
+			codes[i].used = true
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *MemoryStorage) CreateClient(_ context.Context, c storage.Client) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[c.Login]; !ok {
+		return 0, myerrors.NewErrUserNotFound(c.Login)
+	}
+	if c.Scope == "" {
+		return 0, myerrors.NewInvalidArgumentError("client scope is required")
+	}
+	if _, ok := m.clients[c.ClientId]; ok {
+		return 0, fmt.Errorf("failed to create client: client id %s already exists", c.ClientId)
+	}
+	m.nextClientId++
+	c.Id = m.nextClientId
+	c.CreatedAt = now()
+	m.clients[c.ClientId] = &client{Client: c}
+	return c.Id, nil
+}
+
+func (m *MemoryStorage) GetClient(_ context.Context, clientId string) (storage.Client, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	c, ok := m.clients[clientId]
+	if !ok || c.deletedAt != 0 {
+		return storage.Client{}, myerrors.NewErrClientNotFound(clientId)
+	}
+	if _, ok = m.activeUser(c.Login); !ok {
+		return storage.Client{}, myerrors.NewErrClientNotFound(clientId)
+	}
+	return c.Client, nil
+}
+
+func (m *MemoryStorage) GetClients(context.Context) ([]storage.Client, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	clients := make([]storage.Client, 0)
+	for _, c := range m.clients {
+		if c.deletedAt == 0 {
+			clients = append(clients, c.Client)
+		}
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i].Id < clients[j].Id })
+	return clients, nil
+}
+
+func (m *MemoryStorage) DeleteClient(_ context.Context, clientId string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c, ok := m.clients[clientId]
+	if !ok || c.deletedAt != 0 {
+		return myerrors.NewErrClientNotFound(clientId)
+	}
+	c.deletedAt = now()
+	return nil
+}
+
+func (m *MemoryStorage) GetLoginByIdentity(_ context.Context, issuer, subject string) (string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	login, ok := m.identities[identityKey{issuer: issuer, subject: subject}]
+	if !ok {
+		return "", myerrors.NewErrIdentityNotFound(issuer, subject)
+	}
+	if _, ok = m.activeUser(login); !ok {
+		return "", myerrors.NewErrIdentityNotFound(issuer, subject)
+	}
+	return login, nil
+}
+
+func (m *MemoryStorage) CreateIdentity(_ context.Context, issuer, subject, login string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[login]; !ok {
+		return myerrors.NewErrUserNotFound(login)
+	}
+	key := identityKey{issuer: issuer, subject: subject}
+	if _, ok := m.identities[key]; ok {
+		return myerrors.NewInvalidArgumentError("identity is already linked")
+	}
+	m.identities[key] = login
+	return nil
+}
+
+func (m *MemoryStorage) CreateExternalUser(_ context.Context, login, pwdHash, issuer, subject string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[login]; ok {
+		return myerrors.NewErrUserExists(login)
+	}
+	key := identityKey{issuer: issuer, subject: subject}
+	if _, ok := m.identities[key]; ok {
+		return myerrors.NewInvalidArgumentError("identity is already linked")
+	}
+	m.users[login] = &user{pwd: pwdHash, pwdChangedAt: now(), role: defaultRole}
+	m.identities[key] = login
+	return nil
+}
+
+// CreateLoginEvents appends events to the audit trail
+func (m *MemoryStorage) CreateLoginEvents(_ context.Context, events []storage.LoginEvent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, ev := range events {
+		ev.Id = int64(len(m.loginEvents)) + 1
+		m.loginEvents = append(m.loginEvents, ev)
+	}
+	return nil
+}
+
+// GetLoginEvents returns up to limit events older than beforeId, newest first. Zero beforeId starts from the newest
+func (m *MemoryStorage) GetLoginEvents(_ context.Context, login string, beforeId int64, limit int) ([]storage.LoginEvent, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	end := int64(len(m.loginEvents))
+	if beforeId > 0 && beforeId-1 < end {
+		end = beforeId - 1
+	}
+	events := make([]storage.LoginEvent, 0)
+	for i := end - 1; i >= 0 && len(events) < limit; i-- {
+		if login == "" || m.loginEvents[i].Login == login {
+			events = append(events, m.loginEvents[i])
+		}
+	}
+	return events, nil
+}
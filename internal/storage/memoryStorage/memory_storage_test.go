@@ -0,0 +1,13 @@
+package memoryStorage
+
+import (
+	"clearway-test-task/internal/storage"
+	"clearway-test-task/internal/storage/storagetest"
+	"testing"
+)
+
+func TestMemoryStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Db {
+		return NewMemoryStorage()
+	})
+}
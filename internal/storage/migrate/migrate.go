@@ -0,0 +1,301 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// lockKey is the advisory lock held while migrating, so concurrent runners apply each migration once
+const lockKey int64 = 0x636c656172776179
+
+const unlockTimeout = 5 * time.Second
+
+// Dialect holds database specific statements of the migrator
+type Dialect struct {
+	// Lock and Unlock guard migrations against concurrent runners. Empty if the database serializes them by itself
+	Lock            string
+	Unlock          string
+	CreateTable     string
+	GetApplied      string
+	InsertMigration string
+	DeleteMigration string
+}
+
+var Postgres = Dialect{
+	Lock:   "SELECT pg_advisory_lock(" + strconv.FormatInt(lockKey, 10) + ");",
+	Unlock: "SELECT pg_advisory_unlock(" + strconv.FormatInt(lockKey, 10) + ");",
+	CreateTable: `
+    CREATE TABLE IF NOT EXISTS "schema_migrations" (
+        "version" bigint PRIMARY KEY,
+        "name" text NOT NULL,
+        "applied_at" bigint NOT NULL DEFAULT EXTRACT(EPOCH FROM now())
+    );
+`,
+	GetApplied: `
+    SELECT version, applied_at FROM "schema_migrations";
+`,
+	InsertMigration: `
+    INSERT INTO "schema_migrations" (version, name)
+    VALUES ($1, $2);
+`,
+	DeleteMigration: `
+    DELETE FROM "schema_migrations"
+    WHERE version = $1;
+`,
+}
+
+// Sqlite has no advisory locks. A concurrent runner fails on the version primary key and its transaction is rolled back
+var Sqlite = Dialect{
+	CreateTable: `
+    CREATE TABLE IF NOT EXISTS "schema_migrations" (
+        "version" integer PRIMARY KEY,
+        "name" text NOT NULL,
+        "applied_at" integer NOT NULL DEFAULT (unixepoch())
+    );
+`,
+	GetApplied: `
+    SELECT version, applied_at FROM "schema_migrations";
+`,
+	InsertMigration: `
+    INSERT INTO "schema_migrations" (version, name)
+    VALUES (?1, ?2);
+`,
+	DeleteMigration: `
+    DELETE FROM "schema_migrations"
+    WHERE version = ?1;
+`,
+}
+
+// Migration is a schema change read from <version>_<name>.up.sql and <version>_<name>.down.sql
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status of a known migration, AppliedAt is 0 if it's pending
+type Status struct {
+	Version   int64
+	Name      string
+	AppliedAt int64
+}
+
+type Migrator struct {
+	db         *sql.DB
+	dialect    Dialect
+	migrations []Migration
+	lg         *slog.Logger
+}
+
+// NewMigrator reads migrations from dir of fsys. Each migration runs in its own transaction
+func NewMigrator(db *sql.DB, dialect Dialect, fsys fs.FS, dir string, lg *slog.Logger) (*Migrator, error) {
+	migrations, err := Load(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{
+		db:         db,
+		dialect:    dialect,
+		migrations: migrations,
+		lg:         lg,
+	}, nil
+}
+
+// Load returns migrations of dir sorted by version. Every version must have both up and down files
+func Load(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+	byVersion := make(map[int64]*Migration)
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".sql") {
+			continue
+		}
+		base, direction, ok := strings.Cut(strings.TrimSuffix(e.Name(), ".sql"), ".")
+		if !ok || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("invalid migration file name: %s", e.Name())
+		}
+		v, name, ok := strings.Cut(base, "_")
+		version, err := strconv.ParseInt(v, 10, 64)
+		if !ok || err != nil || version <= 0 {
+			return nil, fmt.Errorf("invalid migration version: %s", e.Name())
+		}
+		body, err := fs.ReadFile(fsys, path.Join(dir, e.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %w", e.Name(), err)
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		}
+		if m.Name != name {
+			return nil, fmt.Errorf("migration %d has different names: %s and %s", version, m.Name, name)
+		}
+		if direction == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s must have both up and down files", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Up applies all pending migrations in version order. Returns the number of applied ones
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	var n int
+	err := m.locked(ctx, func(conn *sql.Conn) error {
+		applied, err := m.getApplied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, mg := range m.migrations {
+			if _, ok := applied[mg.Version]; ok {
+				continue
+			}
+			if err = m.apply(ctx, conn, mg, mg.Up, m.dialect.InsertMigration, mg.Version, mg.Name); err != nil {
+				return err
+			}
+			m.lg.Info("migration applied", "Version", mg.Version, "Name", mg.Name)
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
+
+// Down reverts up to steps most recent applied migrations. Returns the number of reverted ones
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	var n int
+	err := m.locked(ctx, func(conn *sql.Conn) error {
+		applied, err := m.getApplied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for i := len(m.migrations) - 1; i >= 0 && n < steps; i-- {
+			mg := m.migrations[i]
+			if _, ok := applied[mg.Version]; !ok {
+				continue
+			}
+			if err = m.apply(ctx, conn, mg, mg.Down, m.dialect.DeleteMigration, mg.Version); err != nil {
+				return err
+			}
+			m.lg.Info("migration reverted", "Version", mg.Version, "Name", mg.Name)
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
+
+// Status lists known migrations in version order
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	var res []Status
+	err := m.locked(ctx, func(conn *sql.Conn) error {
+		applied, err := m.getApplied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		res = make([]Status, 0, len(m.migrations))
+		for _, mg := range m.migrations {
+			res = append(res, Status{Version: mg.Version, Name: mg.Name, AppliedAt: applied[mg.Version]})
+		}
+		return nil
+	})
+	return res, err
+}
+
+// locked runs f on a single connection holding the migration lock
+func (m *Migrator) locked(ctx context.Context, f func(conn *sql.Conn) error) (err error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	if m.dialect.Lock != "" {
+		start := time.Now()
+		if _, err = conn.ExecContext(ctx, m.dialect.Lock); err != nil {
+			return fmt.Errorf("failed to acquire migration lock: %w", err)
+		}
+		m.lg.Debug("migration lock acquired", "Wait", time.Since(start))
+		defer func() {
+			unlockCtx, cancel := context.WithTimeout(context.Background(), unlockTimeout)
+			defer cancel()
+			if _, unlockErr := conn.ExecContext(unlockCtx, m.dialect.Unlock); unlockErr != nil {
+				// the lock is released when the session ends, so the connection is discarded instead of returned to the pool
+				_ = conn.Raw(func(any) error { return driver.ErrBadConn })
+				err = errors.Join(err, fmt.Errorf("failed to release migration lock: %w", unlockErr))
+			}
+		}()
+	}
+
+	if _, err = conn.ExecContext(ctx, m.dialect.CreateTable); err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+	return f(conn)
+}
+
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, mg Migration, script, query string, args ...any) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin tx: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err = tx.ExecContext(ctx, script); err != nil {
+		return fmt.Errorf("migration %d_%s failed: %w", mg.Version, mg.Name, err)
+	}
+	if _, err = tx.ExecContext(ctx, query, args...); err != nil {
+		return fmt.Errorf("failed to record migration %d_%s: %w", mg.Version, mg.Name, err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit tx: %w", err)
+	}
+	return nil
+}
+
+// getApplied returns applied versions with their unix time
+func (m *Migrator) getApplied(ctx context.Context, conn *sql.Conn) (map[int64]int64, error) {
+	rows, err := conn.QueryContext(ctx, m.dialect.GetApplied)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get applied migrations: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	applied := make(map[int64]int64)
+	for rows.Next() {
+		var version, at int64
+		if err = rows.Scan(&version, &at); err != nil {
+			return nil, fmt.Errorf("failed to scan applied migration: %w", err)
+		}
+		applied[version] = at
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate applied migrations: %w", err)
+	}
+	return applied, nil
+}
@@ -0,0 +1,157 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	// registers sqlite driver for database/sql
+	_ "modernc.org/sqlite"
+)
+
+var testMigrations = fstest.MapFS{
+	"migrations/0001_users.up.sql":    {Data: []byte(`CREATE TABLE "users" ("login" text PRIMARY KEY);`)},
+	"migrations/0001_users.down.sql":  {Data: []byte(`DROP TABLE "users";`)},
+	"migrations/0002_seed.up.sql":     {Data: []byte(`INSERT INTO "users" VALUES ('alice');`)},
+	"migrations/0002_seed.down.sql":   {Data: []byte(`DELETE FROM "users" WHERE "login" = 'alice';`)},
+	"migrations/0003_assets.up.sql":   {Data: []byte(`CREATE TABLE "assets" ("name" text PRIMARY KEY);`)},
+	"migrations/0003_assets.down.sql": {Data: []byte(`DROP TABLE "assets";`)},
+	"migrations/README.md":            {Data: []byte("not a migration")},
+}
+
+func newTestMigrator(t *testing.T, fsys fstest.MapFS) (*Migrator, *sql.DB) {
+	t.Helper()
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	m, err := NewMigrator(db, Sqlite, fsys, "migrations", slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m, db
+}
+
+// wantApplied checks which of the test migrations are applied
+func wantApplied(t *testing.T, m *Migrator, applied ...bool) {
+	t.Helper()
+	st, err := m.Status(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(st) != len(applied) {
+		t.Fatalf("got %d migrations, want %d", len(st), len(applied))
+	}
+	for i, s := range st {
+		if s.Version != int64(i+1) {
+			t.Fatalf("got version %d at %d", s.Version, i)
+		}
+		if (s.AppliedAt != 0) != applied[i] {
+			t.Fatalf("migration %d_%s: got applied at %d, want applied %v", s.Version, s.Name, s.AppliedAt, applied[i])
+		}
+	}
+}
+
+func tableExists(t *testing.T, db *sql.DB, name string) bool {
+	t.Helper()
+	var n int
+	if err := db.QueryRow(`SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = ?1`, name).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n == 1
+}
+
+func TestUpDownStatus(t *testing.T) {
+	ctx := context.Background()
+	m, db := newTestMigrator(t, testMigrations)
+	wantApplied(t, m, false, false, false)
+
+	n, err := m.Up(ctx)
+	if err != nil || n != 3 {
+		t.Fatalf("got %d applied, error %v, want 3", n, err)
+	}
+	wantApplied(t, m, true, true, true)
+	var login string
+	if err = db.QueryRow(`SELECT "login" FROM "users"`).Scan(&login); err != nil || login != "alice" {
+		t.Fatalf("got seeded login %q, error %v", login, err)
+	}
+	if n, err = m.Up(ctx); err != nil || n != 0 {
+		t.Fatalf("got %d applied twice, error %v", n, err)
+	}
+
+	if n, err = m.Down(ctx, 2); err != nil || n != 2 {
+		t.Fatalf("got %d reverted, error %v, want 2", n, err)
+	}
+	wantApplied(t, m, true, false, false)
+	if tableExists(t, db, "assets") {
+		t.Fatal("assets table kept after revert")
+	}
+	if err = db.QueryRow(`SELECT "login" FROM "users"`).Scan(&login); err != sql.ErrNoRows {
+		t.Fatalf("got error %v reading reverted seed, want no rows", err)
+	}
+
+	// steps beyond the applied ones revert everything
+	if n, err = m.Down(ctx, 5); err != nil || n != 1 {
+		t.Fatalf("got %d reverted, error %v, want 1", n, err)
+	}
+	wantApplied(t, m, false, false, false)
+	if tableExists(t, db, "users") {
+		t.Fatal("users table kept after revert")
+	}
+
+	if n, err = m.Up(ctx); err != nil || n != 3 {
+		t.Fatalf("got %d applied again, error %v, want 3", n, err)
+	}
+}
+
+func TestFailedMigrationRollsBack(t *testing.T) {
+	ctx := context.Background()
+	fsys := fstest.MapFS{
+		"migrations/0001_users.up.sql":    testMigrations["migrations/0001_users.up.sql"],
+		"migrations/0001_users.down.sql":  testMigrations["migrations/0001_users.down.sql"],
+		"migrations/0002_broken.up.sql":   {Data: []byte(`CREATE TABLE "tags" ("name" text); INSERT INTO "missing" VALUES (1);`)},
+		"migrations/0002_broken.down.sql": {Data: []byte(`DROP TABLE "tags";`)},
+	}
+	m, db := newTestMigrator(t, fsys)
+
+	n, err := m.Up(ctx)
+	if err == nil || !strings.Contains(err.Error(), "2_broken") {
+		t.Fatalf("got error %v, want failure of 2_broken", err)
+	}
+	if n != 1 {
+		t.Fatalf("got %d applied, want 1", n)
+	}
+	wantApplied(t, m, true, false)
+	if tableExists(t, db, "tags") {
+		t.Fatal("failed migration not rolled back")
+	}
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name  string
+		files []string
+	}{
+		{name: "missing down", files: []string{"0001_users.up.sql"}},
+		{name: "name mismatch", files: []string{"0001_users.up.sql", "0001_people.down.sql"}},
+		{name: "invalid direction", files: []string{"0001_users.sideways.sql"}},
+		{name: "invalid version", files: []string{"v1_users.up.sql", "v1_users.down.sql"}},
+		{name: "zero version", files: []string{"0000_users.up.sql", "0000_users.down.sql"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys := fstest.MapFS{}
+			for _, f := range tt.files {
+				fsys["migrations/"+f] = &fstest.MapFile{Data: []byte("SELECT 1;")}
+			}
+			if _, err := Load(fsys, "migrations"); err == nil {
+				t.Fatal("invalid migrations loaded")
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package sessionStorage
+
+import (
+	"clearway-test-task/internal/storage"
+	"context"
+)
+
+// DbStorage reads sessions from db on every validation. Nothing is kept locally,
+// so it is always coherent across instances at the cost of a query per request
+type DbStorage struct {
+	db storage.Db
+}
+
+func NewDbStorage(db storage.Db) *DbStorage {
+	return &DbStorage{db: db}
+}
+
+func (s *DbStorage) Get(ctx context.Context, subject string) (storage.Token, bool, error) {
+	tokens, err := s.db.GetActiveSessionsBySubject(ctx, subject)
+	if err != nil {
+		return storage.Token{}, false, err
+	}
+	t, ok := tokens[subject]
+	return t, ok, nil
+}
+
+// Set is a no-op, sessions are written to db by the caller
+func (s *DbStorage) Set(string, storage.Token) {}
+
+func (s *DbStorage) Delete(string) {}
+
+func (s *DbStorage) DeleteByLogin(string) {}
+
+func (s *DbStorage) Reload(func() (map[string]storage.Token, error)) error {
+	return nil
+}
+
+// Expired returns nothing, expired tokens are rejected by their exp claim
+func (s *DbStorage) Expired(int64) []string {
+	return nil
+}
+
+// Len is always 0, nothing is kept in memory
+func (s *DbStorage) Len() int {
+	return 0
+}
@@ -0,0 +1,134 @@
+package sessionStorage
+
+import (
+	"clearway-test-task/internal/storage"
+	"container/list"
+	"context"
+	"sync"
+)
+
+type entry struct {
+	subject string
+	token   storage.Token
+}
+
+// LruStorage keeps at most size recently used sessions and reads missing ones from db
+type LruStorage struct {
+	db   storage.Db
+	size int
+
+	mtx     sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+	// gen changes on every removal, so a session revoked while being read through isn't cached
+	gen uint64
+}
+
+func NewLruStorage(db storage.Db, size int) *LruStorage {
+	return &LruStorage{
+		db:      db,
+		size:    max(size, 1),
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+func (s *LruStorage) Get(ctx context.Context, subject string) (storage.Token, bool, error) {
+	s.mtx.Lock()
+	if el, ok := s.entries[subject]; ok {
+		s.order.MoveToFront(el)
+		t := el.Value.(*entry).token
+		s.mtx.Unlock()
+		return t, true, nil
+	}
+	gen := s.gen
+	s.mtx.Unlock()
+
+	tokens, err := s.db.GetActiveSessionsBySubject(ctx, subject)
+	if err != nil {
+		return storage.Token{}, false, err
+	}
+	t, ok := tokens[subject]
+	if ok {
+		s.mtx.Lock()
+		if s.gen == gen {
+			s.set(subject, t)
+		}
+		s.mtx.Unlock()
+	}
+	return t, ok, nil
+}
+
+func (s *LruStorage) Set(subject string, token storage.Token) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.set(subject, token)
+}
+
+func (s *LruStorage) set(subject string, token storage.Token) {
+	if el, ok := s.entries[subject]; ok {
+		el.Value.(*entry).token = token
+		s.order.MoveToFront(el)
+		return
+	}
+	s.entries[subject] = s.order.PushFront(&entry{subject: subject, token: token})
+	for s.order.Len() > s.size {
+		s.remove(s.order.Back())
+	}
+}
+
+func (s *LruStorage) Delete(subject string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.gen++
+	if el, ok := s.entries[subject]; ok {
+		s.remove(el)
+	}
+}
+
+func (s *LruStorage) DeleteByLogin(login string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.gen++
+	for el := s.order.Front(); el != nil; {
+		next := el.Next()
+		if el.Value.(*entry).token.Login == login {
+			s.remove(el)
+		}
+		el = next
+	}
+}
+
+// Reload drops everything, sessions are read through again on demand
+func (s *LruStorage) Reload(func() (map[string]storage.Token, error)) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.gen++
+	s.order.Init()
+	s.entries = make(map[string]*list.Element)
+	return nil
+}
+
+// Expired returns expired sessions still kept in memory
+func (s *LruStorage) Expired(now int64) []string {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	res := make([]string, 0)
+	for subject, el := range s.entries {
+		if now > el.Value.(*entry).token.ExpireAt {
+			res = append(res, subject)
+		}
+	}
+	return res
+}
+
+func (s *LruStorage) remove(el *list.Element) {
+	s.order.Remove(el)
+	delete(s.entries, el.Value.(*entry).subject)
+}
+
+func (s *LruStorage) Len() int {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.order.Len()
+}
@@ -0,0 +1,113 @@
+package sessionStorage
+
+import (
+	"clearway-test-task/internal/storage"
+	"clearway-test-task/internal/storage/memoryStorage"
+	"context"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// readHookDb runs onRead after sessions are read from db, before the store gets them
+type readHookDb struct {
+	storage.Db
+	onRead func()
+}
+
+func (d *readHookDb) GetActiveSessionsBySubject(ctx context.Context, subject string) (map[string]storage.Token, error) {
+	tokens, err := d.Db.GetActiveSessionsBySubject(ctx, subject)
+	if d.onRead != nil {
+		d.onRead()
+	}
+	return tokens, err
+}
+
+// newLruDb returns db holding sessions subject-0..subject-n-1 of bob
+func newLruDb(t *testing.T, n int) *readHookDb {
+	t.Helper()
+	ctx := context.Background()
+	db := memoryStorage.NewMemoryStorage()
+	if err := db.CreateUser(ctx, "bob", "hash"); err != nil {
+		t.Fatal(err)
+	}
+	expireAt := time.Now().Add(time.Hour).Unix()
+	for i := range n {
+		session := storage.Session{Id: strconv.Itoa(i), Subject: "subject-" + strconv.Itoa(i), Login: "bob", Token: "token", ExpireAt: expireAt}
+		if err := db.UpdateSession(ctx, session); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &readHookDb{Db: db}
+}
+
+func cached(s *LruStorage, subject string) bool {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	_, ok := s.entries[subject]
+	return ok
+}
+
+func TestLruEviction(t *testing.T) {
+	ctx := context.Background()
+	s := NewLruStorage(newLruDb(t, 4), 3)
+	for _, subject := range []string{"subject-0", "subject-1", "subject-2"} {
+		if _, ok, err := s.Get(ctx, subject); err != nil || !ok {
+			t.Fatalf("%s: got %v, %v", subject, ok, err)
+		}
+	}
+	// subject-0 becomes the most recently used, so subject-1 is evicted
+	if _, ok, _ := s.Get(ctx, "subject-0"); !ok {
+		t.Fatal("subject-0 missing")
+	}
+	if _, ok, _ := s.Get(ctx, "subject-3"); !ok {
+		t.Fatal("subject-3 missing")
+	}
+	if s.Len() != 3 {
+		t.Fatalf("got %d cached sessions, want 3", s.Len())
+	}
+	for subject, want := range map[string]bool{"subject-0": true, "subject-1": false, "subject-2": true, "subject-3": true} {
+		if got := cached(s, subject); got != want {
+			t.Fatalf("%s: got cached %v, want %v", subject, got, want)
+		}
+	}
+
+	// evicted sessions are still valid, read through again
+	if _, ok, _ := s.Get(ctx, "subject-1"); !ok {
+		t.Fatal("evicted session not read from db")
+	}
+	if _, ok, _ := s.Get(ctx, "subject-9"); ok || cached(s, "subject-9") {
+		t.Fatal("unknown session found")
+	}
+}
+
+func TestLruRevokedDuringRead(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name   string
+		revoke func(s *LruStorage)
+	}{
+		{name: "delete", revoke: func(s *LruStorage) { s.Delete("subject-0") }},
+		{name: "delete by login", revoke: func(s *LruStorage) { s.DeleteByLogin("bob") }},
+		{name: "reload", revoke: func(s *LruStorage) { _ = s.Reload(nil) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newLruDb(t, 1)
+			s := NewLruStorage(db, 4)
+			// the session is revoked after db returned it, the read result must not be cached
+			db.onRead = func() { tt.revoke(s) }
+			if _, ok, err := s.Get(ctx, "subject-0"); err != nil || !ok {
+				t.Fatalf("got %v, %v", ok, err)
+			}
+			if cached(s, "subject-0") {
+				t.Fatal("session revoked during read is cached")
+			}
+
+			db.onRead = nil
+			if _, ok, _ := s.Get(ctx, "subject-0"); !ok || !cached(s, "subject-0") {
+				t.Fatal("session not cached by the next read")
+			}
+		})
+	}
+}
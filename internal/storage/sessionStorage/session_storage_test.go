@@ -0,0 +1,142 @@
+package sessionStorage
+
+import (
+	"clearway-test-task/internal/storage"
+	"clearway-test-task/internal/storage/memoryStorage"
+	"context"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestShardedReloadKeepsConcurrentSet(t *testing.T) {
+	s := NewShardedStorage(4)
+	s.Set("stale", storage.Token{Token: "old", Login: "ann"})
+
+	err := s.Reload(func() (map[string]storage.Token, error) {
+		// session issued by this instance after the load read the db
+		s.Set("fresh", storage.Token{Token: "new", Login: "bob"})
+		return map[string]storage.Token{"loaded": {Token: "loaded", Login: "carl"}}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for subject, want := range map[string]string{"fresh": "new", "loaded": "loaded"} {
+		if got, ok, _ := s.Get(context.Background(), subject); !ok || got.Token != want {
+			t.Fatalf("%s: got %q, %v, want %q", subject, got.Token, ok, want)
+		}
+	}
+	if _, ok, _ := s.Get(context.Background(), "stale"); ok {
+		t.Fatal("session missing in db survived reload")
+	}
+}
+
+func TestShardedReloadKeepsConcurrentDelete(t *testing.T) {
+	s := NewShardedStorage(4)
+	loaded := map[string]storage.Token{
+		"revoked":  {Token: "a", Login: "ann"},
+		"client":   {Token: "b", Login: "bob"},
+		"bob":      {Token: "c", Login: "bob"},
+		"reissued": {Token: "d", Login: "bob"},
+		"kept":     {Token: "e", Login: "carl"},
+	}
+
+	err := s.Reload(func() (map[string]storage.Token, error) {
+		// sessions revoked after the load read the db
+		s.Delete("revoked")
+		s.DeleteByLogin("bob")
+		s.Set("reissued", storage.Token{Token: "f", Login: "bob"})
+		return loaded, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, subject := range []string{"revoked", "client", "bob"} {
+		if _, ok, _ := s.Get(context.Background(), subject); ok {
+			t.Fatalf("revoked session %s brought back by reload", subject)
+		}
+	}
+	if got, _, _ := s.Get(context.Background(), "reissued"); got.Token != "f" {
+		t.Fatalf("got reissued token %q, want %q", got.Token, "f")
+	}
+	if _, ok, _ := s.Get(context.Background(), "kept"); !ok {
+		t.Fatal("loaded session missing")
+	}
+
+	// removals made during the previous reload don't affect the next one
+	if err = s.Reload(func() (map[string]storage.Token, error) { return loaded, nil }); err != nil {
+		t.Fatal(err)
+	}
+	if s.Len() != len(loaded) {
+		t.Fatalf("got %d sessions, want %d", s.Len(), len(loaded))
+	}
+}
+
+const benchSessions = 10000
+
+func benchSubject(i int) string {
+	return "subject-" + strconv.Itoa(i%benchSessions)
+}
+
+// benchStores returns the stores filled with benchSessions sessions. Stores reading through db read them from memory
+// storage, so "db-memory" measures the overhead of the store itself, not the query a Postgres backed one makes per request
+func benchStores(b *testing.B) map[string]storage.SessionStore {
+	b.Helper()
+	ctx := context.Background()
+	db := memoryStorage.NewMemoryStorage()
+	if err := db.CreateUser(ctx, "bench", "hash"); err != nil {
+		b.Fatal(err)
+	}
+	sharded := NewShardedStorage(32)
+	expireAt := time.Now().Add(time.Hour).Unix()
+	for i := 0; i < benchSessions; i++ {
+		session := storage.Session{Id: strconv.Itoa(i), Subject: benchSubject(i), Login: "bench", Token: "token", ExpireAt: expireAt}
+		if err := db.UpdateSession(ctx, session); err != nil {
+			b.Fatal(err)
+		}
+		sharded.Set(session.Subject, storage.Token{Token: session.Token, ExpireAt: expireAt, Login: "bench", SessionId: session.Id})
+	}
+	return map[string]storage.SessionStore{
+		"sharded":   sharded,
+		"lru":       NewLruStorage(db, benchSessions/2),
+		"db-memory": NewDbStorage(db),
+	}
+}
+
+func BenchmarkSessionStoreGet(b *testing.B) {
+	for name, s := range benchStores(b) {
+		b.Run(name, func(b *testing.B) {
+			var n atomic.Int64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				ctx := context.Background()
+				i := int(n.Add(benchSessions / 7))
+				for pb.Next() {
+					if _, _, err := s.Get(ctx, benchSubject(i)); err != nil {
+						b.Error(err)
+						return
+					}
+					i++
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkSessionStoreSet(b *testing.B) {
+	for name, s := range benchStores(b) {
+		b.Run(name, func(b *testing.B) {
+			var n atomic.Int64
+			tkn := storage.Token{Token: "token", ExpireAt: time.Now().Add(time.Hour).Unix(), Login: "bench"}
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := int(n.Add(benchSessions / 7))
+				for pb.Next() {
+					s.Set(benchSubject(i), tkn)
+					i++
+				}
+			})
+		})
+	}
+}
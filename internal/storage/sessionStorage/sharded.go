@@ -0,0 +1,174 @@
+package sessionStorage
+
+import (
+	"clearway-test-task/internal/storage"
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+type stamped struct {
+	token storage.Token
+	// gen is the store generation the session was set at
+	gen uint64
+}
+
+type shard struct {
+	mtx    sync.RWMutex
+	tokens map[string]stamped
+	// deleted and deletedLogins hold removals made while reloading, so they aren't undone by loaded sessions
+	deleted       map[string]struct{}
+	deletedLogins map[string]struct{}
+}
+
+// ShardedStorage keeps all active sessions in memory. Subjects are spread over shards
+// with own locks, so concurrent validations of different subjects don't contend
+type ShardedStorage struct {
+	shards []*shard
+	gen    atomic.Uint64
+
+	// reloadMtx serializes reloads, reloading is set while one is in progress
+	reloadMtx sync.Mutex
+	reloading atomic.Bool
+}
+
+func NewShardedStorage(shards int) *ShardedStorage {
+	s := &ShardedStorage{shards: make([]*shard, max(shards, 1))}
+	for i := range s.shards {
+		s.shards[i] = &shard{
+			tokens:        make(map[string]stamped),
+			deleted:       make(map[string]struct{}),
+			deletedLogins: make(map[string]struct{}),
+		}
+	}
+	return s
+}
+
+func (s *ShardedStorage) shard(subject string) *shard {
+	return s.shards[s.index(subject)]
+}
+
+// index hashes subject with FNV-1a, inlined to avoid allocation on every lookup
+func (s *ShardedStorage) index(subject string) uint32 {
+	h := uint32(2166136261)
+	for i := 0; i < len(subject); i++ {
+		h ^= uint32(subject[i])
+		h *= 16777619
+	}
+	return h % uint32(len(s.shards))
+}
+
+func (s *ShardedStorage) Get(_ context.Context, subject string) (storage.Token, bool, error) {
+	sh := s.shard(subject)
+	sh.mtx.RLock()
+	defer sh.mtx.RUnlock()
+	e, ok := sh.tokens[subject]
+	return e.token, ok, nil
+}
+
+func (s *ShardedStorage) Set(subject string, token storage.Token) {
+	sh := s.shard(subject)
+	sh.mtx.Lock()
+	defer sh.mtx.Unlock()
+	sh.tokens[subject] = stamped{token: token, gen: s.gen.Add(1)}
+}
+
+func (s *ShardedStorage) Delete(subject string) {
+	sh := s.shard(subject)
+	sh.mtx.Lock()
+	defer sh.mtx.Unlock()
+	delete(sh.tokens, subject)
+	if s.reloading.Load() {
+		sh.deleted[subject] = struct{}{}
+	}
+}
+
+// DeleteByLogin drops the user session and sessions of all clients acting on behalf of login
+func (s *ShardedStorage) DeleteByLogin(login string) {
+	for _, sh := range s.shards {
+		sh.mtx.Lock()
+		for subject, e := range sh.tokens {
+			if e.token.Login == login {
+				delete(sh.tokens, subject)
+			}
+		}
+		if s.reloading.Load() {
+			sh.deletedLogins[login] = struct{}{}
+		}
+		sh.mtx.Unlock()
+	}
+}
+
+// Reload replaces the content with loaded sessions. Load isn't done under lock, so sessions set
+// after it started are kept and ones deleted meanwhile aren't brought back
+func (s *ShardedStorage) Reload(load func() (map[string]storage.Token, error)) error {
+	s.reloadMtx.Lock()
+	defer s.reloadMtx.Unlock()
+	s.reloading.Store(true)
+	defer s.reloading.Store(false)
+	// removals left from the previous reload were made before this load and are seen by it
+	for _, sh := range s.shards {
+		sh.mtx.Lock()
+		clear(sh.deleted)
+		clear(sh.deletedLogins)
+		sh.mtx.Unlock()
+	}
+	start := s.gen.Load()
+
+	tokens, err := load()
+	if err != nil {
+		return err
+	}
+	parts := make([]map[string]stamped, len(s.shards))
+	for i := range parts {
+		parts[i] = make(map[string]stamped)
+	}
+	for subject, t := range tokens {
+		parts[s.index(subject)][subject] = stamped{token: t}
+	}
+	for i, sh := range s.shards {
+		sh.mtx.Lock()
+		part := parts[i]
+		for subject, e := range part {
+			if _, ok := sh.deleted[subject]; ok {
+				delete(part, subject)
+			} else if _, ok = sh.deletedLogins[e.token.Login]; ok {
+				delete(part, subject)
+			}
+		}
+		for subject, e := range sh.tokens {
+			if e.gen > start {
+				part[subject] = e
+			}
+		}
+		sh.tokens = part
+		clear(sh.deleted)
+		clear(sh.deletedLogins)
+		sh.mtx.Unlock()
+	}
+	return nil
+}
+
+func (s *ShardedStorage) Expired(now int64) []string {
+	res := make([]string, 0)
+	for _, sh := range s.shards {
+		sh.mtx.RLock()
+		for subject, e := range sh.tokens {
+			if now > e.token.ExpireAt {
+				res = append(res, subject)
+			}
+		}
+		sh.mtx.RUnlock()
+	}
+	return res
+}
+
+func (s *ShardedStorage) Len() int {
+	var n int
+	for _, sh := range s.shards {
+		sh.mtx.RLock()
+		n += len(sh.tokens)
+		sh.mtx.RUnlock()
+	}
+	return n
+}
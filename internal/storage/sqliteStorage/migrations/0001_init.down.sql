@@ -0,0 +1,9 @@
+DROP TABLE IF EXISTS "login_audit";
+DROP TABLE IF EXISTS "password_history";
+DROP TABLE IF EXISTS "user_identities";
+DROP TABLE IF EXISTS "recovery_codes";
+DROP TABLE IF EXISTS "oauth_clients";
+DROP TABLE IF EXISTS "api_keys";
+DROP TABLE IF EXISTS "files";
+DROP TABLE IF EXISTS "sessions";
+DROP TABLE IF EXISTS "users";
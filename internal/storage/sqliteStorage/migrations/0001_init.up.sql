@@ -0,0 +1,121 @@
+-- same schema as postgres. Booleans are stored as 0 and 1, timestamps as unix seconds
+CREATE TABLE IF NOT EXISTS "users" (
+    "login" text NOT NULL PRIMARY KEY,
+    "pwd" text NOT NULL,
+    "pwd_changed_at" integer NOT NULL DEFAULT (unixepoch()), -- unix timestamp in sec, not updated by rehash
+    "role" text NOT NULL DEFAULT 'user', -- user or admin
+    "totp_secret" text NOT NULL DEFAULT '', -- base32 encoded, empty if not enrolled
+    "totp_enabled" boolean NOT NULL DEFAULT 0, -- set after enrollment is confirmed
+    "totp_last_step" integer NOT NULL DEFAULT 0, -- last accepted time step, prevents code replay
+    "created_at" integer NOT NULL DEFAULT (unixepoch()),
+    "updated_at" integer NOT NULL DEFAULT 0, -- unix timestamp in sec
+    "deleted_at" integer NOT NULL DEFAULT 0 -- unix timestamp in sec
+);
+
+CREATE TABLE IF NOT EXISTS "sessions" (
+    "id" integer PRIMARY KEY AUTOINCREMENT,
+    "sid" text NOT NULL UNIQUE, -- public session id, jti claim of the token
+    "subject" text NOT NULL, -- user login or client:<client_id>
+    "user_login" text NOT NULL,
+    "token" text NOT NULL UNIQUE,
+    "scope" text NOT NULL DEFAULT '',
+    "iat" integer NOT NULL,
+    "exp" integer NOT NULL,
+    "created_at" integer NOT NULL DEFAULT (unixepoch()),
+    "updated_at" integer NOT NULL DEFAULT 0, -- unix timestamp in sec
+    "deleted_at" integer NOT NULL DEFAULT 0, -- unix timestamp in sec
+    CONSTRAINT fk_user_login FOREIGN KEY ("user_login") REFERENCES "users"("login")
+);
+
+CREATE TABLE IF NOT EXISTS "files" (
+    "id" integer PRIMARY KEY AUTOINCREMENT,
+    "asset_name" text NOT NULL,
+    "user_login" text NOT NULL,
+    "content_type" text,
+    "data" blob,
+    "created_at" integer NOT NULL DEFAULT (unixepoch()),
+    "updated_at" integer NOT NULL DEFAULT 0, -- unix timestamp in sec
+    "deleted_at" integer NOT NULL DEFAULT 0, -- unix timestamp in sec
+    CONSTRAINT fk_user_login FOREIGN KEY ("user_login") REFERENCES "users"("login"),
+    CONSTRAINT unique_asset_user_active UNIQUE (asset_name, user_login, deleted_at)
+);
+
+CREATE TABLE IF NOT EXISTS "api_keys" (
+    "id" integer PRIMARY KEY AUTOINCREMENT,
+    "user_login" text NOT NULL,
+    "prefix" text NOT NULL UNIQUE,
+    "key_hash" text NOT NULL,
+    "scope" text NOT NULL DEFAULT '',
+    "expire_at" integer NOT NULL DEFAULT 0, -- unix timestamp in sec, 0 means no expiry
+    "last_used_at" integer NOT NULL DEFAULT 0, -- unix timestamp in sec
+    "created_at" integer NOT NULL DEFAULT (unixepoch()),
+    "updated_at" integer NOT NULL DEFAULT 0, -- unix timestamp in sec
+    "deleted_at" integer NOT NULL DEFAULT 0, -- unix timestamp in sec
+    CONSTRAINT fk_user_login FOREIGN KEY ("user_login") REFERENCES "users"("login")
+);
+
+CREATE TABLE IF NOT EXISTS "oauth_clients" (
+    "id" integer PRIMARY KEY AUTOINCREMENT,
+    "client_id" text NOT NULL UNIQUE,
+    "secret_hash" text NOT NULL,
+    "user_login" text NOT NULL, -- owner the client acts on behalf of
+    "scope" text NOT NULL CHECK ("scope" <> ''), -- allowed scopes, required so client tokens never carry all rights of the owner
+    "created_at" integer NOT NULL DEFAULT (unixepoch()),
+    "updated_at" integer NOT NULL DEFAULT 0, -- unix timestamp in sec
+    "deleted_at" integer NOT NULL DEFAULT 0, -- unix timestamp in sec
+    CONSTRAINT fk_user_login FOREIGN KEY ("user_login") REFERENCES "users"("login")
+);
+
+CREATE TABLE IF NOT EXISTS "recovery_codes" (
+    "id" integer PRIMARY KEY AUTOINCREMENT,
+    "user_login" text NOT NULL,
+    "code_hash" text NOT NULL,
+    "used_at" integer NOT NULL DEFAULT 0, -- unix timestamp in sec
+    "created_at" integer NOT NULL DEFAULT (unixepoch()),
+    "updated_at" integer NOT NULL DEFAULT 0, -- unix timestamp in sec
+    "deleted_at" integer NOT NULL DEFAULT 0, -- unix timestamp in sec
+    CONSTRAINT fk_user_login FOREIGN KEY ("user_login") REFERENCES "users"("login")
+);
+
+CREATE TABLE IF NOT EXISTS "user_identities" (
+    "id" integer PRIMARY KEY AUTOINCREMENT,
+    "issuer" text NOT NULL, -- external identity provider
+    "subject" text NOT NULL, -- subject at the provider
+    "user_login" text NOT NULL,
+    "created_at" integer NOT NULL DEFAULT (unixepoch()),
+    "updated_at" integer NOT NULL DEFAULT 0, -- unix timestamp in sec
+    "deleted_at" integer NOT NULL DEFAULT 0, -- unix timestamp in sec
+    CONSTRAINT fk_user_login FOREIGN KEY ("user_login") REFERENCES "users"("login")
+);
+
+-- previous password hashes, most recent are kept to prevent reuse
+CREATE TABLE IF NOT EXISTS "password_history" (
+    "id" integer PRIMARY KEY AUTOINCREMENT,
+    "user_login" text NOT NULL,
+    "pwd" text NOT NULL,
+    "created_at" integer NOT NULL DEFAULT (unixepoch()),
+    CONSTRAINT fk_user_login FOREIGN KEY ("user_login") REFERENCES "users"("login")
+);
+
+-- append-only, rows are never updated or deleted
+CREATE TABLE IF NOT EXISTS "login_audit" (
+    "id" integer PRIMARY KEY AUTOINCREMENT,
+    "login" text NOT NULL,
+    "method" text NOT NULL,
+    "success" boolean NOT NULL,
+    "reason" text NOT NULL,
+    "ip" text NOT NULL DEFAULT '',
+    "user_agent" text NOT NULL DEFAULT '',
+    "sid" text NOT NULL DEFAULT '', -- issued session id
+    "created_at" integer NOT NULL DEFAULT (unixepoch())
+);
+CREATE TRIGGER IF NOT EXISTS login_audit_no_update BEFORE UPDATE ON login_audit BEGIN SELECT RAISE(IGNORE); END;
+CREATE TRIGGER IF NOT EXISTS login_audit_no_delete BEFORE DELETE ON login_audit BEGIN SELECT RAISE(IGNORE); END;
+
+CREATE INDEX IF NOT EXISTS idx_asset_user ON files (asset_name, user_login);
+CREATE INDEX IF NOT EXISTS idx_api_keys_user ON api_keys (user_login);
+CREATE INDEX IF NOT EXISTS idx_recovery_codes_user ON recovery_codes (user_login);
+CREATE INDEX IF NOT EXISTS idx_sessions_subject ON sessions (subject);
+CREATE INDEX IF NOT EXISTS idx_login_audit_login ON login_audit (login, id);
+CREATE INDEX IF NOT EXISTS idx_password_history_user ON password_history (user_login, id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_user_identities_subject ON user_identities (issuer, subject) WHERE deleted_at =0;
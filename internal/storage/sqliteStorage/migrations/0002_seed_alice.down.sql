@@ -0,0 +1,9 @@
+-- rows referencing alice go first. Her login events stay, the audit trail is append-only
+DELETE FROM "password_history" WHERE user_login = 'alice';
+DELETE FROM "user_identities" WHERE user_login = 'alice';
+DELETE FROM "recovery_codes" WHERE user_login = 'alice';
+DELETE FROM "oauth_clients" WHERE user_login = 'alice';
+DELETE FROM "api_keys" WHERE user_login = 'alice';
+DELETE FROM "files" WHERE user_login = 'alice';
+DELETE FROM "sessions" WHERE user_login = 'alice';
+DELETE FROM "users" WHERE login = 'alice';
@@ -0,0 +1,2 @@
+-- demo user, password: secret
+INSERT INTO "users" (login, pwd) VALUES ('alice', '$2a$04$zkIAKg6l2DAuOMDDkRI9wuK43PjfONy41pgFqI6m8P2lueM13Rg1i') ON CONFLICT DO NOTHING;
@@ -0,0 +1,958 @@
+package sqliteStorage
+
+import (
+	myerrors "clearway-test-task/internal/errors"
+	"clearway-test-task/internal/storage"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+	"strconv"
+	"strings"
+)
+
+const queryGetUserPwdHash = `
+    SELECT pwd FROM "users"
+    WHERE login = ?1 AND deleted_at =0;
+`
+const queryGetUserRole = `
+    SELECT role FROM "users"
+    WHERE login = ?1 AND deleted_at =0;
+`
+const queryCreateUser = `
+    INSERT INTO "users" (login, pwd, created_at)
+    VALUES (?1, ?2, unixepoch());
+`
+const queryUpdateUserPwd = `
+    UPDATE "users"
+    SET pwd = ?2, updated_at = unixepoch()
+    WHERE login = ?1 AND deleted_at =0;
+`
+const queryLockUserPwd = `
+    SELECT pwd FROM "users"
+    WHERE login = ?1 AND deleted_at =0;
+`
+const queryChangeUserPwd = `
+    UPDATE "users"
+    SET pwd = ?2, pwd_changed_at = unixepoch(), updated_at = unixepoch()
+    WHERE login = ?1 AND deleted_at =0;
+`
+const queryGetUserPwdChangedAt = `
+    SELECT pwd_changed_at FROM "users"
+    WHERE login = ?1 AND deleted_at =0;
+`
+const queryInsertPwdHistory = `
+    INSERT INTO "password_history" (user_login, pwd)
+    VALUES (?1, ?2);
+`
+const queryPrunePwdHistory = `
+    DELETE FROM "password_history"
+    WHERE user_login = ?1 AND id NOT IN (
+        SELECT id FROM "password_history" WHERE user_login = ?1 ORDER BY id DESC LIMIT ?2
+    );
+`
+const queryGetPwdHistory = `
+    SELECT pwd FROM "password_history"
+    WHERE user_login = ?1
+    ORDER BY id DESC
+    LIMIT ?2;
+`
+const queryUpdateUserRole = `
+    UPDATE "users"
+    SET role = ?2, updated_at = unixepoch()
+    WHERE login = ?1 AND deleted_at =0;
+`
+const queryDeleteUser = `
+    UPDATE "users"
+    SET deleted_at = unixepoch()
+    WHERE login = ?1 AND deleted_at =0;
+`
+const queryDeleteApiKeysByLogin = `
+    UPDATE "api_keys"
+    SET deleted_at = unixepoch()
+    WHERE user_login = ?1 AND deleted_at =0;
+`
+const queryGetDataByAssetName = `
+    SELECT data, content_type FROM "files"
+    WHERE asset_name = ?1 AND user_login = ?2 AND deleted_at =0;
+`
+
+// the new row has deleted_at =0, so it conflicts only with the active asset
+const querySetDataByAssetName = `
+    INSERT INTO "files" (asset_name, user_login, content_type, data, created_at)
+    VALUES (?1, ?2, ?3, ?4, unixepoch())
+    ON CONFLICT (asset_name, user_login, deleted_at)
+    DO UPDATE SET
+        content_type = excluded.content_type,
+        data = excluded.data,
+        updated_at = excluded.created_at,
+        deleted_at = 0;
+`
+const queryDeleteDataByAssetName = `
+    UPDATE "files"
+    SET deleted_at = unixepoch()
+    WHERE asset_name = ?1 AND user_login = ?2 AND deleted_at =0;
+`
+
+const queryGetActiveSession = `
+    SELECT sid, subject, user_login, token, exp FROM "sessions"
+    WHERE deleted_at =0;
+`
+
+const queryGetActiveSessionBySubject = `
+    SELECT sid, subject, user_login, token, exp FROM "sessions"
+    WHERE subject = ?1 AND deleted_at =0;
+`
+
+const queryGetActiveSessionByLogin = `
+    SELECT sid, subject, user_login, token, exp FROM "sessions"
+    WHERE user_login = ?1 AND deleted_at =0;
+`
+
+const queryGetSessions = `
+    SELECT sid, subject, user_login, scope, iat, exp FROM "sessions"
+    WHERE (?1 = '' OR user_login = ?1) AND deleted_at =0 AND exp > unixepoch()
+    ORDER BY iat DESC;
+`
+
+const queryDeleteSessionById = `
+    UPDATE "sessions"
+    SET deleted_at = unixepoch()
+    WHERE sid = ?1 AND deleted_at =0
+    RETURNING subject;
+`
+
+const queryDeleteSessionsIssuedBefore = `
+    UPDATE "sessions"
+    SET deleted_at = unixepoch()
+    WHERE iat < ?1 AND deleted_at =0
+    RETURNING subject;
+`
+
+const querySetSessionUpdate = `
+    UPDATE "sessions"
+    SET deleted_at = unixepoch()
+    WHERE subject = ?1 AND deleted_at =0;
+`
+
+const querySetSessionInsert = `
+    INSERT INTO "sessions" (sid, subject, user_login, token, scope, iat, exp, created_at)
+    VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7, unixepoch());
+`
+
+const queryDeleteSessionBySubject = `
+    UPDATE "sessions"
+    SET deleted_at = unixepoch()
+    WHERE subject = ?1 AND deleted_at =0;
+`
+
+const queryDeleteSessionByLogin = `
+    UPDATE "sessions"
+    SET deleted_at = unixepoch()
+    WHERE user_login = ?1 AND deleted_at =0;
+`
+
+const queryCreateApiKey = `
+    INSERT INTO "api_keys" (user_login, prefix, key_hash, scope, expire_at, created_at)
+    VALUES (?1, ?2, ?3, ?4, ?5, unixepoch())
+    RETURNING id;
+`
+
+const queryGetApiKeyByPrefix = `
+    SELECT id, user_login, prefix, key_hash, scope, expire_at, last_used_at, created_at FROM "api_keys"
+    WHERE prefix = ?1 AND deleted_at =0;
+`
+
+const queryGetApiKeysByLogin = `
+    SELECT id, user_login, prefix, key_hash, scope, expire_at, last_used_at, created_at FROM "api_keys"
+    WHERE user_login = ?1 AND deleted_at =0
+    ORDER BY id;
+`
+
+const queryDeleteApiKey = `
+    UPDATE "api_keys"
+    SET deleted_at = unixepoch()
+    WHERE id = ?1 AND user_login = ?2 AND deleted_at =0;
+`
+
+const queryUpdateApiKeyLastUsed = `
+    UPDATE "api_keys"
+    SET last_used_at = ?2
+    WHERE id = ?1 AND deleted_at =0;
+`
+
+const queryGetUserTotp = `
+    SELECT totp_secret, totp_enabled, totp_last_step FROM "users"
+    WHERE login = ?1 AND deleted_at =0;
+`
+
+const querySetUserTotpSecret = `
+    UPDATE "users"
+    SET totp_secret = ?2, totp_enabled = 0, totp_last_step = 0, updated_at = unixepoch()
+    WHERE login = ?1 AND deleted_at =0;
+`
+
+const queryEnableUserTotp = `
+    UPDATE "users"
+    SET totp_enabled = 1, totp_last_step = ?2, updated_at = unixepoch()
+    WHERE login = ?1 AND deleted_at =0 AND totp_secret <> '';
+`
+
+const queryDisableUserTotp = `
+    UPDATE "users"
+    SET totp_secret = '', totp_enabled = 0, totp_last_step = 0, updated_at = unixepoch()
+    WHERE login = ?1 AND deleted_at =0;
+`
+
+const queryUpdateUserTotpStep = `
+    UPDATE "users"
+    SET totp_last_step = ?2
+    WHERE login = ?1 AND deleted_at =0 AND totp_last_step < ?2;
+`
+
+const queryDeleteRecoveryCodes = `
+    UPDATE "recovery_codes"
+    SET deleted_at = unixepoch()
+    WHERE user_login = ?1 AND deleted_at =0;
+`
+
+const queryInsertRecoveryCode = `
+    INSERT INTO "recovery_codes" (user_login, code_hash, created_at)
+    VALUES (?1, ?2, unixepoch());
+`
+
+const queryUseRecoveryCode = `
+    UPDATE "recovery_codes"
+    SET used_at = unixepoch()
+    WHERE user_login = ?1 AND code_hash = ?2 AND used_at =0 AND deleted_at =0;
+`
+
+const queryCreateClient = `
+    INSERT INTO "oauth_clients" (client_id, secret_hash, user_login, scope, created_at)
+    VALUES (?1, ?2, ?3, ?4, unixepoch())
+    RETURNING id;
+`
+
+const queryGetClient = `
+    SELECT c.id, c.client_id, c.secret_hash, c.user_login, c.scope, c.created_at FROM "oauth_clients" c
+    JOIN "users" u ON u.login = c.user_login AND u.deleted_at =0
+    WHERE c.client_id = ?1 AND c.deleted_at =0;
+`
+
+const queryGetClients = `
+    SELECT id, client_id, secret_hash, user_login, scope, created_at FROM "oauth_clients"
+    WHERE deleted_at =0
+    ORDER BY id;
+`
+
+const queryDeleteClient = `
+    UPDATE "oauth_clients"
+    SET deleted_at = unixepoch()
+    WHERE client_id = ?1 AND deleted_at =0;
+`
+
+const queryDeleteClientsByLogin = `
+    UPDATE "oauth_clients"
+    SET deleted_at = unixepoch()
+    WHERE user_login = ?1 AND deleted_at =0;
+`
+
+const queryGetLoginByIdentity = `
+    SELECT i.user_login FROM "user_identities" i
+    JOIN "users" u ON u.login = i.user_login AND u.deleted_at =0
+    WHERE i.issuer = ?1 AND i.subject = ?2 AND i.deleted_at =0;
+`
+
+const queryCreateIdentity = `
+    INSERT INTO "user_identities" (issuer, subject, user_login, created_at)
+    VALUES (?1, ?2, ?3, unixepoch());
+`
+
+const queryDeleteIdentitiesByLogin = `
+    UPDATE "user_identities"
+    SET deleted_at = unixepoch()
+    WHERE user_login = ?1 AND deleted_at =0;
+`
+
+const queryCreateLoginEvent = `
+    INSERT INTO "login_audit" (login, method, success, reason, ip, user_agent, sid, created_at)
+    VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8);
+`
+
+const queryGetLoginEvents = `
+    SELECT id, login, method, success, reason, ip, user_agent, sid, created_at FROM "login_audit"
+    WHERE (?1 = '' OR login = ?1) AND (?2 = 0 OR id < ?2)
+    ORDER BY id DESC
+    LIMIT ?3;
+`
+
+// SqliteStorage is storage.Db in a single SQLite file, for small deployments run as one instance.
+// Writes are serialized by SQLite, so a single connection is used
+type SqliteStorage struct {
+	sql *sql.DB
+}
+
+// Open opens the database file with foreign keys enforced. path is a file name or a file: URI
+func Open(path string) (*sql.DB, error) {
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+	db, err := sql.Open("sqlite", path+sep+"_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	return db, nil
+}
+
+func NewSqliteStorage(path string) (*SqliteStorage, error) {
+	db, err := Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if err = db.Ping(); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to open the database: %w", err)
+	}
+	return &SqliteStorage{sql: db}, nil
+}
+
+func (d *SqliteStorage) Close(lg *slog.Logger) error {
+	if err := d.sql.Close(); err != nil {
+		lg.Error("failed to close the database", "error", err)
+		return err
+	}
+	lg.Debug("closed db")
+	return nil
+}
+
+// Ping checks the database file is readable
+func (d *SqliteStorage) Ping(ctx context.Context) error {
+	return d.sql.PingContext(ctx)
+}
+
+func errCode(err error) int {
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code()
+	}
+	return 0
+}
+
+func isUniqueViolation(err error) bool {
+	code := errCode(err)
+	return code == sqlite3.SQLITE_CONSTRAINT_UNIQUE || code == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY
+}
+
+func isForeignKeyViolation(err error) bool {
+	return errCode(err) == sqlite3.SQLITE_CONSTRAINT_FOREIGNKEY
+}
+
+func (d *SqliteStorage) GetUserPwdHashByLogin(ctx context.Context, login string) (string, error) {
+	var hash string
+	if err := d.sql.QueryRowContext(ctx, queryGetUserPwdHash, login).Scan(&hash); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", myerrors.NewErrUserNotFound(login)
+		}
+		return "", err
+	}
+	return hash, nil
+}
+
+func (d *SqliteStorage) GetUserRole(ctx context.Context, login string) (string, error) {
+	var role string
+	if err := d.sql.QueryRowContext(ctx, queryGetUserRole, login).Scan(&role); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", myerrors.NewErrUserNotFound(login)
+		}
+		return "", err
+	}
+	return role, nil
+}
+
+func (d *SqliteStorage) CreateUser(ctx context.Context, login, pwdHash string) error {
+	if _, err := d.sql.ExecContext(ctx, queryCreateUser, login, pwdHash); err != nil {
+		if isUniqueViolation(err) {
+			return myerrors.NewErrUserExists(login)
+		}
+		return fmt.Errorf("failed to create user: %w", err)
+	}
+	return nil
+}
+
+func (d *SqliteStorage) UpdateUserPwd(ctx context.Context, login, pwdHash string) error {
+	return d.execOne(ctx, myerrors.NewErrUserNotFound(login), "failed to update user password", queryUpdateUserPwd, login, pwdHash)
+}
+
+// ChangeUserPwd sets password chosen by the user, the replaced hash is kept in history trimmed to keepHistory entries
+func (d *SqliteStorage) ChangeUserPwd(ctx context.Context, login, pwdHash string, keepHistory int) error {
+	tx, err := d.sql.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin tx: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var prev string
+	if err = tx.QueryRowContext(ctx, queryLockUserPwd, login).Scan(&prev); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return myerrors.NewErrUserNotFound(login)
+		}
+		return fmt.Errorf("failed to get user password: %w", err)
+	}
+	if _, err = tx.ExecContext(ctx, queryChangeUserPwd, login, pwdHash); err != nil {
+		return fmt.Errorf("failed to change user password: %w", err)
+	}
+	if keepHistory > 0 {
+		if _, err = tx.ExecContext(ctx, queryInsertPwdHistory, login, prev); err != nil {
+			return fmt.Errorf("failed to insert password history: %w", err)
+		}
+	}
+	if _, err = tx.ExecContext(ctx, queryPrunePwdHistory, login, keepHistory); err != nil {
+		return fmt.Errorf("failed to prune password history: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit tx: %w", err)
+	}
+	return nil
+}
+
+func (d *SqliteStorage) GetUserPwdChangedAt(ctx context.Context, login string) (int64, error) {
+	var changedAt int64
+	if err := d.sql.QueryRowContext(ctx, queryGetUserPwdChangedAt, login).Scan(&changedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, myerrors.NewErrUserNotFound(login)
+		}
+		return 0, err
+	}
+	return changedAt, nil
+}
+
+// GetPwdHistory returns previous password hashes of the user, most recent first
+func (d *SqliteStorage) GetPwdHistory(ctx context.Context, login string, limit int) ([]string, error) {
+	rows, err := d.sql.QueryContext(ctx, queryGetPwdHistory, login, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get password history: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var hashes []string
+	for rows.Next() {
+		var h string
+		if err = rows.Scan(&h); err != nil {
+			return nil, fmt.Errorf("failed to scan password history: %w", err)
+		}
+		hashes = append(hashes, h)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate password history: %w", err)
+	}
+	return hashes, nil
+}
+
+func (d *SqliteStorage) UpdateUserRole(ctx context.Context, login, role string) error {
+	return d.execOne(ctx, myerrors.NewErrUserNotFound(login), "failed to update user role", queryUpdateUserRole, login, role)
+}
+
+// execOne runs an update expected to affect a row and returns notFound if none was affected
+func (d *SqliteStorage) execOne(ctx context.Context, notFound error, msg, query string, args ...any) error {
+	res, err := d.sql.ExecContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("%s: %w", msg, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return notFound
+	}
+	return nil
+}
+
+// DeleteUser soft-deletes the user and revokes all of its sessions, api keys, clients, recovery codes and external identities
+func (d *SqliteStorage) DeleteUser(ctx context.Context, login string) error {
+	tx, err := d.sql.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin tx: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	res, err := tx.ExecContext(ctx, queryDeleteUser, login)
+	if err != nil {
+		return fmt.Errorf("failed to delete user: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return myerrors.NewErrUserNotFound(login)
+	}
+	if _, err = tx.ExecContext(ctx, queryDeleteSessionByLogin, login); err != nil {
+		return fmt.Errorf("failed to delete user sessions: %w", err)
+	}
+	if _, err = tx.ExecContext(ctx, queryDeleteApiKeysByLogin, login); err != nil {
+		return fmt.Errorf("failed to delete user api keys: %w", err)
+	}
+	if _, err = tx.ExecContext(ctx, queryDeleteRecoveryCodes, login); err != nil {
+		return fmt.Errorf("failed to delete user recovery codes: %w", err)
+	}
+	if _, err = tx.ExecContext(ctx, queryDeleteClientsByLogin, login); err != nil {
+		return fmt.Errorf("failed to delete user clients: %w", err)
+	}
+	if _, err = tx.ExecContext(ctx, queryDeleteIdentitiesByLogin, login); err != nil {
+		return fmt.Errorf("failed to delete user identities: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit tx: %w", err)
+	}
+	return nil
+}
+
+func (d *SqliteStorage) GetDataByAssetName(ctx context.Context, assetName, login string) ([]byte, string, error) {
+	var data []byte
+	var ct sql.NullString
+	if err := d.sql.QueryRowContext(ctx, queryGetDataByAssetName, assetName, login).Scan(&data, &ct); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, "", myerrors.NewErrAssetNotFound(login, assetName)
+		}
+		return nil, "", err
+	}
+	return data, ct.String, nil
+}
+
+func (d *SqliteStorage) SetDataByAssetName(ctx context.Context, assetName, login, contentType string, data []byte) error {
+	if _, err := d.sql.ExecContext(ctx, querySetDataByAssetName, assetName, login, contentType, data); err != nil {
+		return fmt.Errorf("failed to upsert data by asset name: %w", err)
+	}
+	return nil
+}
+
+func (d *SqliteStorage) DeleteDataByAssetName(ctx context.Context, assetName, login string) error {
+	if _, err := d.sql.ExecContext(ctx, queryDeleteDataByAssetName, assetName, login); err != nil {
+		return fmt.Errorf("failed to delete data by asset name: %w", err)
+	}
+	return nil
+}
+
+// UpdateSession revokes active session of the subject and stores the new one
+func (d *SqliteStorage) UpdateSession(ctx context.Context, session storage.Session) error {
+	tx, err := d.sql.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin tx: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err = tx.ExecContext(ctx, querySetSessionUpdate, session.Subject); err != nil {
+		return fmt.Errorf("failed to execute tx update statement: %w", err)
+	}
+	if _, err = tx.ExecContext(ctx, querySetSessionInsert, session.Id, session.Subject, session.Login, session.Token,
+		session.Scope, session.IssuedAt, session.ExpireAt); err != nil {
+		return fmt.Errorf("failed to execute tx insert statement: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit tx: %w", err)
+	}
+	return nil
+}
+
+func (d *SqliteStorage) DeleteSessionByLogin(ctx context.Context, login string) error {
+	if _, err := d.sql.ExecContext(ctx, queryDeleteSessionByLogin, login); err != nil {
+		return fmt.Errorf("failed to delete session by login: %w", err)
+	}
+	return nil
+}
+
+func (d *SqliteStorage) DeleteSessionBySubject(ctx context.Context, subject string) error {
+	if _, err := d.sql.ExecContext(ctx, queryDeleteSessionBySubject, subject); err != nil {
+		return fmt.Errorf("failed to delete session by subject: %w", err)
+	}
+	return nil
+}
+
+func (d *SqliteStorage) GetActiveSessions(ctx context.Context) (map[string]storage.Token, error) {
+	return d.getSessions(ctx, queryGetActiveSession)
+}
+
+func (d *SqliteStorage) GetActiveSessionsBySubject(ctx context.Context, subject string) (map[string]storage.Token, error) {
+	return d.getSessions(ctx, queryGetActiveSessionBySubject, subject)
+}
+
+func (d *SqliteStorage) GetActiveSessionsByLogin(ctx context.Context, login string) (map[string]storage.Token, error) {
+	return d.getSessions(ctx, queryGetActiveSessionByLogin, login)
+}
+
+func (d *SqliteStorage) getSessions(ctx context.Context, query string, args ...any) (map[string]storage.Token, error) {
+	cache := make(map[string]storage.Token)
+
+	rows, err := d.sql.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query active sessions: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	for rows.Next() {
+		var sid, subject, userLogin, token string
+		var exp int64
+		if err = rows.Scan(&sid, &subject, &userLogin, &token, &exp); err != nil {
+			return nil, fmt.Errorf("failed to scan row of active sessions: %w", err)
+		}
+		cache[subject] = storage.Token{Token: token, ExpireAt: exp, Login: userLogin, SessionId: sid}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate active sessions: %w", err)
+	}
+
+	return cache, nil
+}
+
+// GetSessions returns unexpired active sessions of login, or of everyone if login is empty. Tokens are not returned
+func (d *SqliteStorage) GetSessions(ctx context.Context, login string) ([]storage.Session, error) {
+	rows, err := d.sql.QueryContext(ctx, queryGetSessions, login)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query sessions: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	sessions := make([]storage.Session, 0)
+	for rows.Next() {
+		var s storage.Session
+		if err = rows.Scan(&s.Id, &s.Subject, &s.Login, &s.Scope, &s.IssuedAt, &s.ExpireAt); err != nil {
+			return nil, fmt.Errorf("failed to scan session: %w", err)
+		}
+		sessions = append(sessions, s)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate sessions: %w", err)
+	}
+	return sessions, nil
+}
+
+// DeleteSessionById revokes the session and returns its subject
+func (d *SqliteStorage) DeleteSessionById(ctx context.Context, id string) (string, error) {
+	var subject string
+	if err := d.sql.QueryRowContext(ctx, queryDeleteSessionById, id).Scan(&subject); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", myerrors.NewErrSessionNotFound(id)
+		}
+		return "", fmt.Errorf("failed to delete session: %w", err)
+	}
+	return subject, nil
+}
+
+// DeleteSessionsIssuedBefore revokes sessions issued before the unix timestamp. Returns subjects of revoked sessions
+func (d *SqliteStorage) DeleteSessionsIssuedBefore(ctx context.Context, before int64) ([]string, error) {
+	rows, err := d.sql.QueryContext(ctx, queryDeleteSessionsIssuedBefore, before)
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete sessions: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	subjects := make([]string, 0)
+	for rows.Next() {
+		var subject string
+		if err = rows.Scan(&subject); err != nil {
+			return nil, fmt.Errorf("failed to scan session: %w", err)
+		}
+		subjects = append(subjects, subject)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to delete sessions: %w", err)
+	}
+	return subjects, nil
+}
+
+// ListenSessionEvents has no other instances to hear from, it only waits until ctx is done
+func (d *SqliteStorage) ListenSessionEvents(ctx context.Context, onConnect func(), _ func(storage.SessionEvent)) error {
+	onConnect()
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (d *SqliteStorage) CreateApiKey(ctx context.Context, key storage.ApiKey) (int64, error) {
+	var id int64
+	if err := d.sql.QueryRowContext(ctx, queryCreateApiKey, key.Login, key.Prefix, key.Hash, key.Scope, key.ExpireAt).Scan(&id); err != nil {
+		return 0, fmt.Errorf("failed to create api key: %w", err)
+	}
+	return id, nil
+}
+
+func (d *SqliteStorage) GetApiKeyByPrefix(ctx context.Context, prefix string) (storage.ApiKey, error) {
+	var k storage.ApiKey
+	err := d.sql.QueryRowContext(ctx, queryGetApiKeyByPrefix, prefix).
+		Scan(&k.Id, &k.Login, &k.Prefix, &k.Hash, &k.Scope, &k.ExpireAt, &k.LastUsedAt, &k.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return storage.ApiKey{}, myerrors.NewErrApiKeyNotFound(prefix)
+		}
+		return storage.ApiKey{}, err
+	}
+	return k, nil
+}
+
+func (d *SqliteStorage) GetApiKeysByLogin(ctx context.Context, login string) ([]storage.ApiKey, error) {
+	rows, err := d.sql.QueryContext(ctx, queryGetApiKeysByLogin, login)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query api keys: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	keys := make([]storage.ApiKey, 0)
+	for rows.Next() {
+		var k storage.ApiKey
+		if err = rows.Scan(&k.Id, &k.Login, &k.Prefix, &k.Hash, &k.Scope, &k.ExpireAt, &k.LastUsedAt, &k.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan api key: %w", err)
+		}
+		keys = append(keys, k)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate api keys: %w", err)
+	}
+	return keys, nil
+}
+
+func (d *SqliteStorage) DeleteApiKey(ctx context.Context, id int64, login string) error {
+	return d.execOne(ctx, myerrors.NewErrApiKeyNotFound(strconv.FormatInt(id, 10)), "failed to delete api key",
+		queryDeleteApiKey, id, login)
+}
+
+func (d *SqliteStorage) UpdateApiKeyLastUsed(ctx context.Context, id, lastUsedAt int64) error {
+	if _, err := d.sql.ExecContext(ctx, queryUpdateApiKeyLastUsed, id, lastUsedAt); err != nil {
+		return fmt.Errorf("failed to update api key last used: %w", err)
+	}
+	return nil
+}
+
+func (d *SqliteStorage) GetUserTotp(ctx context.Context, login string) (storage.Totp, error) {
+	var t storage.Totp
+	if err := d.sql.QueryRowContext(ctx, queryGetUserTotp, login).Scan(&t.Secret, &t.Enabled, &t.LastStep); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return storage.Totp{}, myerrors.NewErrUserNotFound(login)
+		}
+		return storage.Totp{}, err
+	}
+	return t, nil
+}
+
+func (d *SqliteStorage) SetUserTotpSecret(ctx context.Context, login, secret string) error {
+	return d.execOne(ctx, myerrors.NewErrUserNotFound(login), "failed to set totp secret", querySetUserTotpSecret, login, secret)
+}
+
+// EnableUserTotp enables two-factor auth and replaces recovery codes of the user
+func (d *SqliteStorage) EnableUserTotp(ctx context.Context, login string, step int64, codeHashes []string) error {
+	tx, err := d.sql.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin tx: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	res, err := tx.ExecContext(ctx, queryEnableUserTotp, login, step)
+	if err != nil {
+		return fmt.Errorf("failed to enable totp: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return myerrors.NewErrUserNotFound(login)
+	}
+	if _, err = tx.ExecContext(ctx, queryDeleteRecoveryCodes, login); err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+	for _, h := range codeHashes {
+		if _, err = tx.ExecContext(ctx, queryInsertRecoveryCode, login, h); err != nil {
+			return fmt.Errorf("failed to insert recovery code: %w", err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit tx: %w", err)
+	}
+	return nil
+}
+
+func (d *SqliteStorage) DisableUserTotp(ctx context.Context, login string) error {
+	tx, err := d.sql.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin tx: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err = tx.ExecContext(ctx, queryDisableUserTotp, login); err != nil {
+		return fmt.Errorf("failed to disable totp: %w", err)
+	}
+	if _, err = tx.ExecContext(ctx, queryDeleteRecoveryCodes, login); err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit tx: %w", err)
+	}
+	return nil
+}
+
+// UpdateUserTotpStep stores the last accepted step. Returns false if the step was already used
+func (d *SqliteStorage) UpdateUserTotpStep(ctx context.Context, login string, step int64) (bool, error) {
+	res, err := d.sql.ExecContext(ctx, queryUpdateUserTotpStep, login, step)
+	if err != nil {
+		return false, fmt.Errorf("failed to update totp step: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to update totp step: %w", err)
+	}
+	return n == 1, nil
+}
+
+// UseRecoveryCode marks the code as used. Returns false if there is no such unused code
+func (d *SqliteStorage) UseRecoveryCode(ctx context.Context, login, codeHash string) (bool, error) {
+	res, err := d.sql.ExecContext(ctx, queryUseRecoveryCode, login, codeHash)
+	if err != nil {
+		return false, fmt.Errorf("failed to use recovery code: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to use recovery code: %w", err)
+	}
+	return n == 1, nil
+}
+
+func (d *SqliteStorage) CreateClient(ctx context.Context, client storage.Client) (int64, error) {
+	var id int64
+	err := d.sql.QueryRowContext(ctx, queryCreateClient, client.ClientId, client.SecretHash, client.Login, client.Scope).Scan(&id)
+	if err != nil {
+		if isForeignKeyViolation(err) {
+			return 0, myerrors.NewErrUserNotFound(client.Login)
+		}
+		return 0, fmt.Errorf("failed to create client: %w", err)
+	}
+	return id, nil
+}
+
+func (d *SqliteStorage) GetClient(ctx context.Context, clientId string) (storage.Client, error) {
+	var c storage.Client
+	err := d.sql.QueryRowContext(ctx, queryGetClient, clientId).
+		Scan(&c.Id, &c.ClientId, &c.SecretHash, &c.Login, &c.Scope, &c.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return storage.Client{}, myerrors.NewErrClientNotFound(clientId)
+		}
+		return storage.Client{}, err
+	}
+	return c, nil
+}
+
+func (d *SqliteStorage) GetClients(ctx context.Context) ([]storage.Client, error) {
+	rows, err := d.sql.QueryContext(ctx, queryGetClients)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query clients: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	clients := make([]storage.Client, 0)
+	for rows.Next() {
+		var c storage.Client
+		if err = rows.Scan(&c.Id, &c.ClientId, &c.SecretHash, &c.Login, &c.Scope, &c.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan client: %w", err)
+		}
+		clients = append(clients, c)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate clients: %w", err)
+	}
+	return clients, nil
+}
+
+func (d *SqliteStorage) DeleteClient(ctx context.Context, clientId string) error {
+	return d.execOne(ctx, myerrors.NewErrClientNotFound(clientId), "failed to delete client", queryDeleteClient, clientId)
+}
+
+func (d *SqliteStorage) GetLoginByIdentity(ctx context.Context, issuer, subject string) (string, error) {
+	var login string
+	if err := d.sql.QueryRowContext(ctx, queryGetLoginByIdentity, issuer, subject).Scan(&login); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", myerrors.NewErrIdentityNotFound(issuer, subject)
+		}
+		return "", err
+	}
+	return login, nil
+}
+
+func (d *SqliteStorage) CreateIdentity(ctx context.Context, issuer, subject, login string) error {
+	if _, err := d.sql.ExecContext(ctx, queryCreateIdentity, issuer, subject, login); err != nil {
+		switch {
+		case isForeignKeyViolation(err):
+			return myerrors.NewErrUserNotFound(login)
+		case isUniqueViolation(err):
+			return myerrors.NewInvalidArgumentError("identity is already linked")
+		}
+		return fmt.Errorf("failed to create identity: %w", err)
+	}
+	return nil
+}
+
+// CreateExternalUser creates user linked to the external identity, neither is created if the other fails
+func (d *SqliteStorage) CreateExternalUser(ctx context.Context, login, pwdHash, issuer, subject string) error {
+	tx, err := d.sql.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin tx: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err = tx.ExecContext(ctx, queryCreateUser, login, pwdHash); err != nil {
+		if isUniqueViolation(err) {
+			return myerrors.NewErrUserExists(login)
+		}
+		return fmt.Errorf("failed to create user: %w", err)
+	}
+	if _, err = tx.ExecContext(ctx, queryCreateIdentity, issuer, subject, login); err != nil {
+		if isUniqueViolation(err) {
+			return myerrors.NewInvalidArgumentError("identity is already linked")
+		}
+		return fmt.Errorf("failed to create identity: %w", err)
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit tx: %w", err)
+	}
+	return nil
+}
+
+// CreateLoginEvents appends events to the audit trail in a single transaction
+func (d *SqliteStorage) CreateLoginEvents(ctx context.Context, events []storage.LoginEvent) error {
+	tx, err := d.sql.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin tx: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	for _, ev := range events {
+		if _, err = tx.ExecContext(ctx, queryCreateLoginEvent, ev.Login, ev.Method, ev.Success, ev.Reason, ev.Ip,
+			ev.UserAgent, ev.SessionId, ev.CreatedAt); err != nil {
+			return fmt.Errorf("failed to create login event: %w", err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit tx: %w", err)
+	}
+	return nil
+}
+
+// GetLoginEvents returns up to limit events older than beforeId, newest first. Zero beforeId starts from the newest
+func (d *SqliteStorage) GetLoginEvents(ctx context.Context, login string, beforeId int64, limit int) ([]storage.LoginEvent, error) {
+	rows, err := d.sql.QueryContext(ctx, queryGetLoginEvents, login, beforeId, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query login events: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	events := make([]storage.LoginEvent, 0)
+	for rows.Next() {
+		var ev storage.LoginEvent
+		if err = rows.Scan(&ev.Id, &ev.Login, &ev.Method, &ev.Success, &ev.Reason, &ev.Ip, &ev.UserAgent,
+			&ev.SessionId, &ev.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan login event: %w", err)
+		}
+		events = append(events, ev)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate login events: %w", err)
+	}
+	return events, nil
+}
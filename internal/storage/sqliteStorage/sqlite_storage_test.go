@@ -0,0 +1,38 @@
+package sqliteStorage
+
+import (
+	"clearway-test-task/internal/storage"
+	"clearway-test-task/internal/storage/migrate"
+	"clearway-test-task/internal/storage/storagetest"
+	"context"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"testing"
+)
+
+func TestSqliteStorage(t *testing.T) {
+	lg := slog.New(slog.NewTextHandler(io.Discard, nil))
+	storagetest.Run(t, func(t *testing.T) storage.Db {
+		path := filepath.Join(t.TempDir(), "test.db")
+		conn, err := Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = conn.Close() }()
+		m, err := migrate.NewMigrator(conn, migrate.Sqlite, Migrations, "migrations", lg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = m.Up(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		d, err := NewSqliteStorage(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = d.Close(lg) })
+		return d
+	})
+}
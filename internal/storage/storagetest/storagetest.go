@@ -0,0 +1,440 @@
+// Package storagetest is the conformance suite every storage.Db backend must pass.
+// A backend runs it from its own test with a constructor of an empty, migrated database:
+//
+//	storagetest.Run(t, func(t *testing.T) storage.Db { ... })
+package storagetest
+
+import (
+	myerrors "clearway-test-task/internal/errors"
+	"clearway-test-task/internal/storage"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// Run runs every case against a new database created by newDb
+func Run(t *testing.T, newDb func(t *testing.T) storage.Db) {
+	cases := []struct {
+		name string
+		f    func(t *testing.T, db storage.Db)
+	}{
+		{"Users", testUsers},
+		{"PwdHistory", testPwdHistory},
+		{"DeleteUser", testDeleteUser},
+		{"Assets", testAssets},
+		{"Sessions", testSessions},
+		{"ApiKeys", testApiKeys},
+		{"Totp", testTotp},
+		{"Clients", testClients},
+		{"Identities", testIdentities},
+		{"LoginEvents", testLoginEvents},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			c.f(t, newDb(t))
+		})
+	}
+}
+
+func ctx(t *testing.T) context.Context {
+	c, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+	return c
+}
+
+func must(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func mustIs(t *testing.T, err, target error) {
+	t.Helper()
+	if !errors.Is(err, target) {
+		t.Fatalf("got error %v, want %v", err, target)
+	}
+}
+
+func mustAs[T error](t *testing.T, err error) {
+	t.Helper()
+	var target T
+	if !errors.As(err, &target) {
+		t.Fatalf("got error %v, want %T", err, target)
+	}
+}
+
+func equal[V comparable](t *testing.T, name string, got, want V) {
+	t.Helper()
+	if got != want {
+		t.Fatalf("%s: got %v, want %v", name, got, want)
+	}
+}
+
+func testUsers(t *testing.T, db storage.Db) {
+	c := ctx(t)
+	_, err := db.GetUserPwdHashByLogin(c, "bob")
+	mustAs[myerrors.ErrUserNotFound](t, err)
+
+	must(t, db.CreateUser(c, "bob", "hash1"))
+	mustAs[myerrors.ErrUserExists](t, db.CreateUser(c, "bob", "hash2"))
+
+	hash, err := db.GetUserPwdHashByLogin(c, "bob")
+	must(t, err)
+	equal(t, "hash", hash, "hash1")
+	role, err := db.GetUserRole(c, "bob")
+	must(t, err)
+	equal(t, "role", role, "user")
+
+	must(t, db.UpdateUserPwd(c, "bob", "hash2"))
+	hash, err = db.GetUserPwdHashByLogin(c, "bob")
+	must(t, err)
+	equal(t, "hash", hash, "hash2")
+	must(t, db.UpdateUserRole(c, "bob", "admin"))
+	role, err = db.GetUserRole(c, "bob")
+	must(t, err)
+	equal(t, "role", role, "admin")
+
+	mustAs[myerrors.ErrUserNotFound](t, db.UpdateUserPwd(c, "nobody", "hash"))
+	mustAs[myerrors.ErrUserNotFound](t, db.UpdateUserRole(c, "nobody", "admin"))
+}
+
+func testPwdHistory(t *testing.T, db storage.Db) {
+	c := ctx(t)
+	must(t, db.CreateUser(c, "bob", "hash0"))
+	changedAt, err := db.GetUserPwdChangedAt(c, "bob")
+	must(t, err)
+	if changedAt == 0 {
+		t.Fatal("pwd_changed_at is not set on creation")
+	}
+
+	for _, h := range []string{"hash1", "hash2", "hash3"} {
+		must(t, db.ChangeUserPwd(c, "bob", h, 2))
+	}
+	hash, err := db.GetUserPwdHashByLogin(c, "bob")
+	must(t, err)
+	equal(t, "hash", hash, "hash3")
+
+	hashes, err := db.GetPwdHistory(c, "bob", 10)
+	must(t, err)
+	equal(t, "history length", len(hashes), 2)
+	equal(t, "history[0]", hashes[0], "hash2")
+	equal(t, "history[1]", hashes[1], "hash1")
+
+	hashes, err = db.GetPwdHistory(c, "bob", 1)
+	must(t, err)
+	equal(t, "limited history length", len(hashes), 1)
+
+	must(t, db.ChangeUserPwd(c, "bob", "hash4", 0))
+	hashes, err = db.GetPwdHistory(c, "bob", 10)
+	must(t, err)
+	equal(t, "history length without keeping", len(hashes), 0)
+
+	mustAs[myerrors.ErrUserNotFound](t, db.ChangeUserPwd(c, "nobody", "hash", 2))
+	_, err = db.GetUserPwdChangedAt(c, "nobody")
+	mustAs[myerrors.ErrUserNotFound](t, err)
+}
+
+func testDeleteUser(t *testing.T, db storage.Db) {
+	c := ctx(t)
+	must(t, db.CreateUser(c, "bob", "hash"))
+	must(t, db.UpdateSession(c, session("s1", "bob", "bob", time.Now().Unix()+3600)))
+	_, err := db.CreateApiKey(c, storage.ApiKey{Login: "bob", Prefix: "pfx", Hash: "khash", Scope: "read"})
+	must(t, err)
+	_, err = db.CreateClient(c, storage.Client{ClientId: "cid", SecretHash: "shash", Login: "bob", Scope: "read"})
+	must(t, err)
+	must(t, db.CreateIdentity(c, "iss", "sub", "bob"))
+
+	must(t, db.DeleteUser(c, "bob"))
+	mustAs[myerrors.ErrUserNotFound](t, db.DeleteUser(c, "bob"))
+
+	_, err = db.GetUserPwdHashByLogin(c, "bob")
+	mustAs[myerrors.ErrUserNotFound](t, err)
+	sessions, err := db.GetActiveSessionsByLogin(c, "bob")
+	must(t, err)
+	equal(t, "sessions", len(sessions), 0)
+	_, err = db.GetApiKeyByPrefix(c, "pfx")
+	mustAs[myerrors.ErrApiKeyNotFound](t, err)
+	_, err = db.GetClient(c, "cid")
+	mustAs[myerrors.ErrClientNotFound](t, err)
+	_, err = db.GetLoginByIdentity(c, "iss", "sub")
+	mustAs[myerrors.ErrIdentityNotFound](t, err)
+
+	// logins of deleted users are not reused
+	mustAs[myerrors.ErrUserExists](t, db.CreateUser(c, "bob", "hash"))
+}
+
+func testAssets(t *testing.T, db storage.Db) {
+	c := ctx(t)
+	must(t, db.CreateUser(c, "bob", "hash"))
+	must(t, db.CreateUser(c, "eve", "hash"))
+
+	_, _, err := db.GetDataByAssetName(c, "a", "bob")
+	mustAs[myerrors.ErrAssetNotFound](t, err)
+
+	must(t, db.SetDataByAssetName(c, "a", "bob", "text/plain", []byte("v1")))
+	must(t, db.SetDataByAssetName(c, "a", "bob", "application/json", []byte("v2")))
+	data, ct, err := db.GetDataByAssetName(c, "a", "bob")
+	must(t, err)
+	equal(t, "data", string(data), "v2")
+	equal(t, "content type", ct, "application/json")
+
+	_, _, err = db.GetDataByAssetName(c, "a", "eve")
+	mustAs[myerrors.ErrAssetNotFound](t, err)
+
+	// a deleted asset doesn't block a new one with the same name
+	must(t, db.DeleteDataByAssetName(c, "a", "bob"))
+	_, _, err = db.GetDataByAssetName(c, "a", "bob")
+	mustAs[myerrors.ErrAssetNotFound](t, err)
+	must(t, db.SetDataByAssetName(c, "a", "bob", "text/plain", []byte("v3")))
+	data, _, err = db.GetDataByAssetName(c, "a", "bob")
+	must(t, err)
+	equal(t, "data", string(data), "v3")
+}
+
+func session(id, subject, login string, exp int64) storage.Session {
+	return storage.Session{
+		Id:       id,
+		Subject:  subject,
+		Login:    login,
+		Token:    "token-" + id,
+		Scope:    "read",
+		IssuedAt: time.Now().Unix(),
+		ExpireAt: exp,
+	}
+}
+
+func testSessions(t *testing.T, db storage.Db) {
+	c := ctx(t)
+	must(t, db.CreateUser(c, "bob", "hash"))
+	must(t, db.CreateUser(c, "eve", "hash"))
+	exp := time.Now().Unix() + 3600
+
+	must(t, db.UpdateSession(c, session("s1", "bob", "bob", exp)))
+	// a new session replaces the active one of the subject
+	must(t, db.UpdateSession(c, session("s2", "bob", "bob", exp)))
+	must(t, db.UpdateSession(c, session("s3", "client:cid", "bob", exp)))
+	must(t, db.UpdateSession(c, session("s4", "eve", "eve", exp)))
+
+	active, err := db.GetActiveSessions(c)
+	must(t, err)
+	equal(t, "active sessions", len(active), 3)
+	equal(t, "bob session", active["bob"], storage.Token{Token: "token-s2", ExpireAt: exp, Login: "bob", SessionId: "s2"})
+
+	bySubject, err := db.GetActiveSessionsBySubject(c, "client:cid")
+	must(t, err)
+	equal(t, "sessions by subject", len(bySubject), 1)
+	byLogin, err := db.GetActiveSessionsByLogin(c, "bob")
+	must(t, err)
+	equal(t, "sessions by login", len(byLogin), 2)
+
+	list, err := db.GetSessions(c, "bob")
+	must(t, err)
+	equal(t, "listed sessions", len(list), 2)
+	for _, s := range list {
+		equal(t, "listed token", s.Token, "")
+	}
+	list, err = db.GetSessions(c, "")
+	must(t, err)
+	equal(t, "all listed sessions", len(list), 3)
+
+	subject, err := db.DeleteSessionById(c, "s3")
+	must(t, err)
+	equal(t, "revoked subject", subject, "client:cid")
+	_, err = db.DeleteSessionById(c, "s3")
+	mustAs[myerrors.ErrSessionNotFound](t, err)
+
+	must(t, db.DeleteSessionBySubject(c, "eve"))
+	active, err = db.GetActiveSessions(c)
+	must(t, err)
+	equal(t, "active sessions", len(active), 1)
+
+	must(t, db.DeleteSessionByLogin(c, "bob"))
+	active, err = db.GetActiveSessions(c)
+	must(t, err)
+	equal(t, "active sessions", len(active), 0)
+
+	must(t, db.UpdateSession(c, session("s5", "bob", "bob", exp)))
+	revoked, err := db.DeleteSessionsIssuedBefore(c, time.Now().Unix()+1)
+	must(t, err)
+	equal(t, "revoked sessions", len(revoked), 1)
+	equal(t, "revoked subject", revoked[0], "bob")
+}
+
+func testApiKeys(t *testing.T, db storage.Db) {
+	c := ctx(t)
+	must(t, db.CreateUser(c, "bob", "hash"))
+
+	id, err := db.CreateApiKey(c, storage.ApiKey{Login: "bob", Prefix: "pfx1", Hash: "h1", Scope: "read", ExpireAt: 100})
+	must(t, err)
+	_, err = db.CreateApiKey(c, storage.ApiKey{Login: "bob", Prefix: "pfx2", Hash: "h2", Scope: "write"})
+	must(t, err)
+
+	k, err := db.GetApiKeyByPrefix(c, "pfx1")
+	must(t, err)
+	equal(t, "id", k.Id, id)
+	equal(t, "login", k.Login, "bob")
+	equal(t, "hash", k.Hash, "h1")
+	equal(t, "scope", k.Scope, "read")
+	equal(t, "expire at", k.ExpireAt, int64(100))
+
+	must(t, db.UpdateApiKeyLastUsed(c, id, 42))
+	keys, err := db.GetApiKeysByLogin(c, "bob")
+	must(t, err)
+	equal(t, "keys", len(keys), 2)
+	equal(t, "first key", keys[0].Prefix, "pfx1")
+	equal(t, "last used at", keys[0].LastUsedAt, int64(42))
+
+	mustAs[myerrors.ErrApiKeyNotFound](t, db.DeleteApiKey(c, id, "eve"))
+	must(t, db.DeleteApiKey(c, id, "bob"))
+	mustAs[myerrors.ErrApiKeyNotFound](t, db.DeleteApiKey(c, id, "bob"))
+	_, err = db.GetApiKeyByPrefix(c, "pfx1")
+	mustAs[myerrors.ErrApiKeyNotFound](t, err)
+}
+
+func testTotp(t *testing.T, db storage.Db) {
+	c := ctx(t)
+	must(t, db.CreateUser(c, "bob", "hash"))
+
+	totp, err := db.GetUserTotp(c, "bob")
+	must(t, err)
+	equal(t, "totp", totp, storage.Totp{})
+
+	// can't be enabled before a secret is set
+	mustAs[myerrors.ErrUserNotFound](t, db.EnableUserTotp(c, "bob", 1, nil))
+
+	must(t, db.SetUserTotpSecret(c, "bob", "secret"))
+	must(t, db.EnableUserTotp(c, "bob", 10, []string{"c1", "c2"}))
+	totp, err = db.GetUserTotp(c, "bob")
+	must(t, err)
+	equal(t, "totp", totp, storage.Totp{Secret: "secret", Enabled: true, LastStep: 10})
+
+	ok, err := db.UpdateUserTotpStep(c, "bob", 10)
+	must(t, err)
+	equal(t, "reused step accepted", ok, false)
+	ok, err = db.UpdateUserTotpStep(c, "bob", 11)
+	must(t, err)
+	equal(t, "next step accepted", ok, true)
+
+	ok, err = db.UseRecoveryCode(c, "bob", "c1")
+	must(t, err)
+	equal(t, "recovery code accepted", ok, true)
+	ok, err = db.UseRecoveryCode(c, "bob", "c1")
+	must(t, err)
+	equal(t, "used recovery code accepted", ok, false)
+
+	// enabling again replaces the codes
+	must(t, db.EnableUserTotp(c, "bob", 12, []string{"c3"}))
+	ok, err = db.UseRecoveryCode(c, "bob", "c2")
+	must(t, err)
+	equal(t, "replaced recovery code accepted", ok, false)
+
+	must(t, db.DisableUserTotp(c, "bob"))
+	totp, err = db.GetUserTotp(c, "bob")
+	must(t, err)
+	equal(t, "totp", totp, storage.Totp{})
+	ok, err = db.UseRecoveryCode(c, "bob", "c3")
+	must(t, err)
+	equal(t, "recovery code of disabled totp accepted", ok, false)
+
+	mustAs[myerrors.ErrUserNotFound](t, db.SetUserTotpSecret(c, "nobody", "secret"))
+}
+
+func testClients(t *testing.T, db storage.Db) {
+	c := ctx(t)
+	must(t, db.CreateUser(c, "bob", "hash"))
+
+	_, err := db.CreateClient(c, storage.Client{ClientId: "cid0", SecretHash: "h", Login: "nobody", Scope: "read"})
+	mustAs[myerrors.ErrUserNotFound](t, err)
+	// tokens of a client without scope would carry all rights of the owner
+	if _, err = db.CreateClient(c, storage.Client{ClientId: "cid0", SecretHash: "h", Login: "bob"}); err == nil {
+		t.Fatal("client without scope created")
+	}
+
+	id, err := db.CreateClient(c, storage.Client{ClientId: "cid1", SecretHash: "h1", Login: "bob", Scope: "read"})
+	must(t, err)
+	_, err = db.CreateClient(c, storage.Client{ClientId: "cid2", SecretHash: "h2", Login: "bob", Scope: "write"})
+	must(t, err)
+
+	cl, err := db.GetClient(c, "cid1")
+	must(t, err)
+	equal(t, "id", cl.Id, id)
+	equal(t, "secret hash", cl.SecretHash, "h1")
+	equal(t, "login", cl.Login, "bob")
+	equal(t, "scope", cl.Scope, "read")
+
+	clients, err := db.GetClients(c)
+	must(t, err)
+	equal(t, "clients", len(clients), 2)
+
+	must(t, db.DeleteClient(c, "cid1"))
+	mustAs[myerrors.ErrClientNotFound](t, db.DeleteClient(c, "cid1"))
+	_, err = db.GetClient(c, "cid1")
+	mustAs[myerrors.ErrClientNotFound](t, err)
+}
+
+func testIdentities(t *testing.T, db storage.Db) {
+	c := ctx(t)
+	must(t, db.CreateUser(c, "bob", "hash"))
+
+	_, err := db.GetLoginByIdentity(c, "iss", "sub")
+	mustAs[myerrors.ErrIdentityNotFound](t, err)
+	mustAs[myerrors.ErrUserNotFound](t, db.CreateIdentity(c, "iss", "sub", "nobody"))
+
+	must(t, db.CreateIdentity(c, "iss", "sub", "bob"))
+	mustIs(t, db.CreateIdentity(c, "iss", "sub", "bob"), myerrors.ErrInvalidArgument)
+	login, err := db.GetLoginByIdentity(c, "iss", "sub")
+	must(t, err)
+	equal(t, "login", login, "bob")
+
+	// neither the user nor the identity is created if the other one exists
+	mustIs(t, db.CreateExternalUser(c, "ann", "hash", "iss", "sub"), myerrors.ErrInvalidArgument)
+	_, err = db.GetUserRole(c, "ann")
+	mustAs[myerrors.ErrUserNotFound](t, err)
+	mustAs[myerrors.ErrUserExists](t, db.CreateExternalUser(c, "bob", "hash", "iss", "other"))
+	_, err = db.GetLoginByIdentity(c, "iss", "other")
+	mustAs[myerrors.ErrIdentityNotFound](t, err)
+
+	must(t, db.CreateExternalUser(c, "ann", "hash", "iss", "other"))
+	login, err = db.GetLoginByIdentity(c, "iss", "other")
+	must(t, err)
+	equal(t, "login", login, "ann")
+}
+
+func testLoginEvents(t *testing.T, db storage.Db) {
+	c := ctx(t)
+	events := make([]storage.LoginEvent, 0, 5)
+	for i := range 5 {
+		login := "bob"
+		if i%2 == 1 {
+			login = "eve"
+		}
+		events = append(events, storage.LoginEvent{
+			Login:     login,
+			Method:    storage.LoginMethodPassword,
+			Success:   i == 4,
+			Reason:    storage.LoginInvalidCredentials,
+			Ip:        "127.0.0.1",
+			UserAgent: "test",
+			CreatedAt: int64(1000 + i),
+		})
+	}
+	must(t, db.CreateLoginEvents(c, events))
+
+	got, err := db.GetLoginEvents(c, "", 0, 10)
+	must(t, err)
+	equal(t, "events", len(got), 5)
+	equal(t, "newest event", got[0].CreatedAt, int64(1004))
+	equal(t, "newest event success", got[0].Success, true)
+	equal(t, "newest event ip", got[0].Ip, "127.0.0.1")
+
+	page, err := db.GetLoginEvents(c, "bob", 0, 2)
+	must(t, err)
+	equal(t, "first page", len(page), 2)
+	equal(t, "first page login", page[1].Login, "bob")
+	page, err = db.GetLoginEvents(c, "bob", page[1].Id, 2)
+	must(t, err)
+	equal(t, "second page", len(page), 1)
+	equal(t, "oldest event", page[0].CreatedAt, int64(1000))
+}
@@ -3,6 +3,8 @@ package pkg
 import (
 	"encoding/base64"
 	"log/slog"
+	"net"
+	"net/http"
 	"os"
 	"unsafe"
 )
@@ -39,3 +41,12 @@ func DefaultLogger() *slog.Logger {
 
 	return slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: logLevel}))
 }
+
+// RemoteIp returns ip part of the request remote address
+func RemoteIp(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
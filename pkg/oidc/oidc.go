@@ -0,0 +1,349 @@
+package oidc
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	discoveryPath = "/.well-known/openid-configuration"
+	// pending authorization requests expire after this period
+	stateTTL = 10 * time.Minute
+	// jwks is refetched on unknown key id not more often than this
+	jwksRefreshInterval = time.Minute
+	maxResponseSize     = 1 << 20
+)
+
+// Discovery is the subset of provider metadata used by the relying party
+type Discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksUri               string `json:"jwks_uri"`
+}
+
+// Identity is the verified subject of an ID token
+type Identity struct {
+	Issuer  string
+	Subject string
+	Claims  jwt.MapClaims
+}
+
+// pending is an authorization request kept by the browser in the sealed state cookie
+type pending struct {
+	State    string `json:"s"`
+	Verifier string `json:"v"`
+	Nonce    string `json:"n"`
+	ExpireAt int64  `json:"e"`
+}
+
+// RelyingParty implements authorization code flow with PKCE and ID token verification against provider JWKS.
+// Pending requests are sealed for the browser instead of being kept here, so any instance sharing the state key
+// can complete the flow and anonymous requests cost no memory
+type RelyingParty struct {
+	issuer       string
+	clientId     string
+	clientSecret string
+	redirectUrl  string
+	scopes       []string
+	client       *http.Client
+	aead         cipher.AEAD
+
+	mtx       sync.Mutex
+	discovery *Discovery
+	keys      map[string]any
+	keysAt    time.Time
+}
+
+// NewRelyingParty creates relying party. Provider metadata is discovered lazily on first use,
+// so the service starts even if the provider is unavailable. client is used for all provider requests.
+// stateKey encrypts pending requests, it must be 32 bytes and the same on all instances
+func NewRelyingParty(issuer, clientId, clientSecret, redirectUrl string, scopes []string, client *http.Client, stateKey []byte) (*RelyingParty, error) {
+	block, err := aes.NewCipher(stateKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid state key: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &RelyingParty{
+		issuer:       strings.TrimSuffix(issuer, "/"),
+		clientId:     clientId,
+		clientSecret: clientSecret,
+		redirectUrl:  redirectUrl,
+		scopes:       scopes,
+		client:       client,
+		aead:         aead,
+		keys:         make(map[string]any),
+	}, nil
+}
+
+func (rp *RelyingParty) Issuer() string {
+	return rp.issuer
+}
+
+// AuthCodeUrl starts the flow. Returns provider authorization url with fresh state, nonce and S256 code challenge,
+// and the sealed request the browser has to present to Exchange
+func (rp *RelyingParty) AuthCodeUrl(ctx context.Context) (string, string, error) {
+	d, err := rp.getDiscovery(ctx)
+	if err != nil {
+		return "", "", err
+	}
+	state, err := randomString()
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := randomString()
+	if err != nil {
+		return "", "", err
+	}
+	verifier, err := randomString()
+	if err != nil {
+		return "", "", err
+	}
+	challenge := sha256.Sum256([]byte(verifier))
+
+	sealed, err := rp.seal(pending{State: state, Verifier: verifier, Nonce: nonce, ExpireAt: time.Now().Add(stateTTL).Unix()})
+	if err != nil {
+		return "", "", err
+	}
+
+	v := url.Values{}
+	v.Set("response_type", "code")
+	v.Set("client_id", rp.clientId)
+	v.Set("redirect_uri", rp.redirectUrl)
+	v.Set("scope", strings.Join(rp.scopes, " "))
+	v.Set("state", state)
+	v.Set("nonce", nonce)
+	v.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	v.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return d.AuthorizationEndpoint + sep + v.Encode(), sealed, nil
+}
+
+// Exchange completes the flow: checks state against the sealed request, redeems the code and verifies the returned ID token
+func (rp *RelyingParty) Exchange(ctx context.Context, sealed, state, code string) (Identity, error) {
+	p, err := rp.open(sealed)
+	if err != nil {
+		return Identity{}, err
+	}
+	if subtle.ConstantTimeCompare([]byte(p.State), []byte(state)) != 1 {
+		return Identity{}, errors.New("state doesn't match")
+	}
+	if time.Now().Unix() > p.ExpireAt {
+		return Identity{}, errors.New("expired state")
+	}
+
+	d, err := rp.getDiscovery(ctx)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", rp.redirectUrl)
+	form.Set("code_verifier", p.Verifier)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Identity{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(rp.clientId), url.QueryEscape(rp.clientSecret))
+
+	var res struct {
+		IdToken string `json:"id_token"`
+	}
+	if err = rp.do(req, &res); err != nil {
+		return Identity{}, fmt.Errorf("failed to redeem authorization code: %w", err)
+	}
+	if res.IdToken == "" {
+		return Identity{}, errors.New("token response has no id_token")
+	}
+
+	return rp.Verify(ctx, res.IdToken, p.Nonce)
+}
+
+// seal encrypts the pending request, the result is url safe
+func (rp *RelyingParty) seal(p pending) (string, error) {
+	plain, err := json.Marshal(p)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, rp.aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(rp.aead.Seal(nonce, nonce, plain, nil)), nil
+}
+
+func (rp *RelyingParty) open(sealed string) (pending, error) {
+	b, err := base64.RawURLEncoding.DecodeString(sealed)
+	if err != nil || len(b) < rp.aead.NonceSize() {
+		return pending{}, errors.New("malformed state")
+	}
+	plain, err := rp.aead.Open(nil, b[:rp.aead.NonceSize()], b[rp.aead.NonceSize():], nil)
+	if err != nil {
+		return pending{}, errors.New("invalid state")
+	}
+	var p pending
+	if err = json.Unmarshal(plain, &p); err != nil {
+		return pending{}, errors.New("invalid state")
+	}
+	return p, nil
+}
+
+// Verify checks ID token signature against provider keys and validates iss, aud, exp and nonce claims
+func (rp *RelyingParty) Verify(ctx context.Context, idToken, nonce string) (Identity, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(idToken, claims, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+		return rp.getKey(ctx, kid)
+	},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512"}),
+		jwt.WithIssuer(rp.issuer),
+		jwt.WithAudience(rp.clientId),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	)
+	if err != nil {
+		return Identity{}, fmt.Errorf("invalid id token: %w", err)
+	}
+	if n, _ := claims["nonce"].(string); n != nonce {
+		return Identity{}, errors.New("invalid id token: nonce mismatch")
+	}
+	sub, _ := claims["sub"].(string)
+	if sub == "" {
+		return Identity{}, errors.New("invalid id token: empty subject")
+	}
+	return Identity{Issuer: rp.issuer, Subject: sub, Claims: claims}, nil
+}
+
+func (rp *RelyingParty) getDiscovery(ctx context.Context) (*Discovery, error) {
+	rp.mtx.Lock()
+	d := rp.discovery
+	rp.mtx.Unlock()
+	if d != nil {
+		return d, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rp.issuer+discoveryPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	d = &Discovery{}
+	if err = rp.do(req, d); err != nil {
+		return nil, fmt.Errorf("failed to fetch discovery document: %w", err)
+	}
+	if strings.TrimSuffix(d.Issuer, "/") != rp.issuer {
+		return nil, fmt.Errorf("discovery issuer %q doesn't match configured %q", d.Issuer, rp.issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JwksUri == "" {
+		return nil, errors.New("discovery document is incomplete")
+	}
+
+	rp.mtx.Lock()
+	rp.discovery = d
+	rp.mtx.Unlock()
+	return d, nil
+}
+
+func (rp *RelyingParty) getKey(ctx context.Context, kid string) (any, error) {
+	rp.mtx.Lock()
+	key, ok := rp.lookupKey(kid)
+	stale := time.Since(rp.keysAt) > jwksRefreshInterval
+	rp.mtx.Unlock()
+	if ok {
+		return key, nil
+	}
+	if !stale {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+
+	keys, err := rp.fetchKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rp.mtx.Lock()
+	defer rp.mtx.Unlock()
+	rp.keys = keys
+	rp.keysAt = time.Now()
+	if key, ok = rp.lookupKey(kid); !ok {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+	return key, nil
+}
+
+// lookupKey finds key by id. Tokens without kid are accepted only if provider publishes a single key
+func (rp *RelyingParty) lookupKey(kid string) (any, bool) {
+	if kid == "" && len(rp.keys) == 1 {
+		for _, k := range rp.keys {
+			return k, true
+		}
+	}
+	k, ok := rp.keys[kid]
+	return k, ok
+}
+
+func (rp *RelyingParty) fetchKeys(ctx context.Context) (map[string]any, error) {
+	d, err := rp.getDiscovery(ctx)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.JwksUri, nil)
+	if err != nil {
+		return nil, err
+	}
+	var set jwks
+	if err = rp.do(req, &set); err != nil {
+		return nil, fmt.Errorf("failed to fetch jwks: %w", err)
+	}
+	return set.publicKeys(), nil
+}
+
+func (rp *RelyingParty) do(req *http.Request, v any) error {
+	resp, err := rp.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d: %s", resp.StatusCode, body)
+	}
+	return json.Unmarshal(body, v)
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,241 @@
+package oidc_test
+
+import (
+	"clearway-test-task/pkg/oidc"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/golang-jwt/jwt/v5"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	clientId     = "rp"
+	clientSecret = "rp-secret"
+	redirectUrl  = "https://rp.example/oidc/callback"
+	keyId        = "k1"
+)
+
+// provider is a mock OpenID provider serving discovery, JWKS and token endpoints.
+// Codes are issued by authorize, which records the request instead of asking the user
+type provider struct {
+	srv *httptest.Server
+	key *rsa.PrivateKey
+
+	mtx   sync.Mutex
+	codes map[string]url.Values
+	// claims override those of issued ID tokens
+	claims jwt.MapClaims
+}
+
+func newProvider(t *testing.T) *provider {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &provider{key: key, codes: make(map[string]url.Values)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, _ *http.Request) {
+		writeJson(w, map[string]string{
+			"issuer":                 p.srv.URL,
+			"authorization_endpoint": p.srv.URL + "/authorize",
+			"token_endpoint":         p.srv.URL + "/token",
+			"jwks_uri":               p.srv.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, _ *http.Request) {
+		writeJson(w, map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": keyId,
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("POST /token", p.token)
+	p.srv = httptest.NewServer(mux)
+	t.Cleanup(p.srv.Close)
+	return p
+}
+
+// authorize stands for the user approving the request at authorization url, returns its state and the issued code
+func (p *provider) authorize(t *testing.T, authUrl string) (string, string) {
+	t.Helper()
+	u, err := url.Parse(authUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("client_id") != clientId || q.Get("redirect_uri") != redirectUrl || q.Get("code_challenge_method") != "S256" {
+		t.Fatalf("unexpected authorization request: %s", authUrl)
+	}
+	code := "code-" + q.Get("state")[:8]
+	p.mtx.Lock()
+	p.codes[code] = q
+	p.mtx.Unlock()
+	return q.Get("state"), code
+}
+
+func (p *provider) token(w http.ResponseWriter, r *http.Request) {
+	id, secret, ok := r.BasicAuth()
+	if !ok || id != clientId || secret != clientSecret {
+		http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+		return
+	}
+	code := r.PostFormValue("code")
+	p.mtx.Lock()
+	q, ok := p.codes[code]
+	delete(p.codes, code)
+	p.mtx.Unlock()
+	if !ok || r.PostFormValue("redirect_uri") != redirectUrl {
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+		return
+	}
+	sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(sum[:]) != q.Get("code_challenge") {
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":                p.srv.URL,
+		"aud":                clientId,
+		"sub":                "subject-1",
+		"nonce":              q.Get("nonce"),
+		"iat":                now.Unix(),
+		"exp":                now.Add(time.Minute).Unix(),
+		"preferred_username": "dave",
+	}
+	for k, v := range p.claims {
+		claims[k] = v
+	}
+	tkn := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	tkn.Header["kid"] = keyId
+	signed, err := tkn.SignedString(p.key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJson(w, map[string]string{"access_token": "at", "token_type": "Bearer", "id_token": signed})
+}
+
+func writeJson(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func newRelyingParty(t *testing.T, p *provider, stateKey []byte) *oidc.RelyingParty {
+	t.Helper()
+	rp, err := oidc.NewRelyingParty(p.srv.URL, clientId, clientSecret, redirectUrl, []string{"openid"}, p.srv.Client(), stateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rp
+}
+
+func stateKey(b byte) []byte {
+	return []byte(strings.Repeat(string(rune(b)), 32))
+}
+
+func TestExchange(t *testing.T) {
+	p := newProvider(t)
+	rp := newRelyingParty(t, p, stateKey('a'))
+	ctx := context.Background()
+
+	authUrl, sealed, err := rp.AuthCodeUrl(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, code := p.authorize(t, authUrl)
+	if sealed == "" || strings.Contains(sealed, state) {
+		t.Fatalf("state isn't sealed: %q", sealed)
+	}
+
+	identity, err := rp.Exchange(ctx, sealed, state, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.Issuer != p.srv.URL || identity.Subject != "subject-1" || identity.Claims["preferred_username"] != "dave" {
+		t.Fatalf("unexpected identity %+v", identity)
+	}
+}
+
+func TestExchangeOnAnotherInstance(t *testing.T) {
+	p := newProvider(t)
+	ctx := context.Background()
+
+	authUrl, sealed, err := newRelyingParty(t, p, stateKey('a')).AuthCodeUrl(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, code := p.authorize(t, authUrl)
+
+	if _, err = newRelyingParty(t, p, stateKey('a')).Exchange(ctx, sealed, state, code); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExchangeRejected(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name string
+		// change modifies sealed state, state and code sent to Exchange
+		change func(sealed, state, code string) (string, string, string)
+		claims jwt.MapClaims
+		key    []byte
+	}{
+		{name: "state mismatch", change: func(sealed, _, code string) (string, string, string) {
+			return sealed, "other", code
+		}},
+		{name: "tampered sealed state", change: func(sealed, state, code string) (string, string, string) {
+			b := []byte(sealed)
+			b[len(b)/2] ^= 'A' ^ 'B'
+			return string(b), state, code
+		}},
+		{name: "malformed sealed state", change: func(_, state, code string) (string, string, string) {
+			return "%%%", state, code
+		}},
+		{name: "other state key", key: stateKey('b')},
+		{name: "unknown code", change: func(sealed, state, _ string) (string, string, string) {
+			return sealed, state, "code-unknown"
+		}},
+		{name: "nonce mismatch", claims: jwt.MapClaims{"nonce": "other"}},
+		{name: "wrong audience", claims: jwt.MapClaims{"aud": "other"}},
+		{name: "expired id token", claims: jwt.MapClaims{"exp": time.Now().Add(-time.Minute).Unix()}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newProvider(t)
+			p.claims = tt.claims
+			rp := newRelyingParty(t, p, stateKey('a'))
+
+			authUrl, sealed, err := rp.AuthCodeUrl(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			state, code := p.authorize(t, authUrl)
+			if tt.change != nil {
+				sealed, state, code = tt.change(sealed, state, code)
+			}
+			if tt.key != nil {
+				rp = newRelyingParty(t, p, tt.key)
+			}
+			if _, err = rp.Exchange(ctx, sealed, state, code); err == nil {
+				t.Fatal("exchange succeeded")
+			}
+		})
+	}
+}
@@ -0,0 +1,221 @@
+// Package otlpfile exports spans as OTLP/JSON lines, the format read by the collector's otlpjsonfile receiver.
+// Every export is a line holding one ExportTraceServiceRequest
+package otlpfile
+
+import (
+	"context"
+	"encoding/json"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/instrumentation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+)
+
+type Exporter struct {
+	mtx sync.Mutex
+	w   io.WriteCloser
+	enc *json.Encoder
+}
+
+// New appends spans to the file at path, creating it if needed
+func New(path string) (*Exporter, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &Exporter{w: f, enc: json.NewEncoder(f)}, nil
+}
+
+func (e *Exporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	if len(spans) == 0 {
+		return nil
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	req := toRequest(spans)
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	if e.w == nil {
+		return nil
+	}
+	return e.enc.Encode(req)
+}
+
+func (e *Exporter) Shutdown(context.Context) error {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	if e.w == nil {
+		return nil
+	}
+	err := e.w.Close()
+	e.w = nil
+	return err
+}
+
+type request struct {
+	ResourceSpans []*resourceSpans `json:"resourceSpans"`
+}
+
+type resourceSpans struct {
+	Resource   resource      `json:"resource"`
+	ScopeSpans []*scopeSpans `json:"scopeSpans"`
+}
+
+type resource struct {
+	Attributes []keyValue `json:"attributes,omitempty"`
+}
+
+type scopeSpans struct {
+	Scope scope  `json:"scope"`
+	Spans []span `json:"spans"`
+}
+
+type scope struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+type span struct {
+	TraceId           string     `json:"traceId"`
+	SpanId            string     `json:"spanId"`
+	ParentSpanId      string     `json:"parentSpanId,omitempty"`
+	Name              string     `json:"name"`
+	Kind              int        `json:"kind"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+	Attributes        []keyValue `json:"attributes,omitempty"`
+	Events            []event    `json:"events,omitempty"`
+	Status            status     `json:"status"`
+}
+
+type event struct {
+	TimeUnixNano string     `json:"timeUnixNano"`
+	Name         string     `json:"name"`
+	Attributes   []keyValue `json:"attributes,omitempty"`
+}
+
+type status struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type keyValue struct {
+	Key   string   `json:"key"`
+	Value anyValue `json:"value"`
+}
+
+// anyValue has exactly one field set. Integers are strings as protobuf JSON mapping requires for 64 bit values
+type anyValue struct {
+	StringValue *string     `json:"stringValue,omitempty"`
+	BoolValue   *bool       `json:"boolValue,omitempty"`
+	IntValue    *string     `json:"intValue,omitempty"`
+	DoubleValue *float64    `json:"doubleValue,omitempty"`
+	ArrayValue  *arrayValue `json:"arrayValue,omitempty"`
+}
+
+type arrayValue struct {
+	Values []anyValue `json:"values"`
+}
+
+// toRequest groups spans by resource and instrumentation scope
+func toRequest(spans []sdktrace.ReadOnlySpan) request {
+	var req request
+	resources := make(map[attribute.Distinct]*resourceSpans)
+	scopes := make(map[attribute.Distinct]map[instrumentation.Scope]*scopeSpans)
+	for _, s := range spans {
+		key := s.Resource().Equivalent()
+		rs, ok := resources[key]
+		if !ok {
+			rs = &resourceSpans{Resource: resource{Attributes: keyValues(s.Resource().Attributes())}}
+			resources[key] = rs
+			scopes[key] = make(map[instrumentation.Scope]*scopeSpans)
+			req.ResourceSpans = append(req.ResourceSpans, rs)
+		}
+		is := s.InstrumentationScope()
+		ss, ok := scopes[key][is]
+		if !ok {
+			ss = &scopeSpans{Scope: scope{Name: is.Name, Version: is.Version}}
+			scopes[key][is] = ss
+			rs.ScopeSpans = append(rs.ScopeSpans, ss)
+		}
+		ss.Spans = append(ss.Spans, toSpan(s))
+	}
+	return req
+}
+
+func toSpan(s sdktrace.ReadOnlySpan) span {
+	res := span{
+		TraceId:           s.SpanContext().TraceID().String(),
+		SpanId:            s.SpanContext().SpanID().String(),
+		Name:              s.Name(),
+		Kind:              int(s.SpanKind()),
+		StartTimeUnixNano: strconv.FormatInt(s.StartTime().UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(s.EndTime().UnixNano(), 10),
+		Attributes:        keyValues(s.Attributes()),
+		Status:            status{Message: s.Status().Description},
+	}
+	if s.Parent().HasSpanID() {
+		res.ParentSpanId = s.Parent().SpanID().String()
+	}
+	// OTLP numbers status codes differently from the API
+	switch s.Status().Code {
+	case codes.Ok:
+		res.Status.Code = 1
+	case codes.Error:
+		res.Status.Code = 2
+	}
+	for _, ev := range s.Events() {
+		res.Events = append(res.Events, event{
+			TimeUnixNano: strconv.FormatInt(ev.Time.UnixNano(), 10),
+			Name:         ev.Name,
+			Attributes:   keyValues(ev.Attributes),
+		})
+	}
+	return res
+}
+
+func keyValues(attrs []attribute.KeyValue) []keyValue {
+	res := make([]keyValue, 0, len(attrs))
+	for _, kv := range attrs {
+		res = append(res, keyValue{Key: string(kv.Key), Value: toValue(kv.Value)})
+	}
+	return res
+}
+
+func toValue(v attribute.Value) anyValue {
+	switch v.Type() {
+	case attribute.BOOL:
+		b := v.AsBool()
+		return anyValue{BoolValue: &b}
+	case attribute.INT64:
+		i := strconv.FormatInt(v.AsInt64(), 10)
+		return anyValue{IntValue: &i}
+	case attribute.FLOAT64:
+		f := v.AsFloat64()
+		return anyValue{DoubleValue: &f}
+	case attribute.BOOLSLICE:
+		return array(v.AsBoolSlice(), attribute.BoolValue)
+	case attribute.INT64SLICE:
+		return array(v.AsInt64Slice(), attribute.Int64Value)
+	case attribute.FLOAT64SLICE:
+		return array(v.AsFloat64Slice(), attribute.Float64Value)
+	case attribute.STRINGSLICE:
+		return array(v.AsStringSlice(), attribute.StringValue)
+	default:
+		s := v.Emit()
+		return anyValue{StringValue: &s}
+	}
+}
+
+func array[T any](values []T, value func(T) attribute.Value) anyValue {
+	res := &arrayValue{Values: make([]anyValue, 0, len(values))}
+	for _, v := range values {
+		res.Values = append(res.Values, toValue(value(v)))
+	}
+	return anyValue{ArrayValue: res}
+}
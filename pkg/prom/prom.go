@@ -0,0 +1,308 @@
+// Package prom exposes metrics in the Prometheus text format, version 0.0.4
+package prom
+
+import (
+	"bufio"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefBuckets are latency buckets in seconds suited to request durations
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type collector interface {
+	write(w *bufio.Writer)
+}
+
+// Registry holds metrics in the order they were registered
+type Registry struct {
+	mtx        sync.Mutex
+	collectors []collector
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(c collector) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.collectors = append(r.collectors, c)
+}
+
+// Handler serves all registered metrics
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		bw := bufio.NewWriter(w)
+		r.mtx.Lock()
+		collectors := r.collectors
+		r.mtx.Unlock()
+		for _, c := range collectors {
+			c.write(bw)
+		}
+		_ = bw.Flush()
+	})
+}
+
+// float is a float64 updated atomically
+type float struct {
+	bits atomic.Uint64
+}
+
+func (f *float) add(v float64) {
+	for {
+		old := f.bits.Load()
+		if f.bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+func (f *float) load() float64 {
+	return math.Float64frombits(f.bits.Load())
+}
+
+// family is a metric with one child per combination of label values
+type family[T any] struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+	create func() *T
+
+	mtx      sync.RWMutex
+	children map[string]*T
+	values   map[string][]string
+}
+
+func newFamily[T any](name, help, typ string, labels []string, create func() *T) *family[T] {
+	return &family[T]{
+		name:     name,
+		help:     help,
+		typ:      typ,
+		labels:   labels,
+		create:   create,
+		children: make(map[string]*T),
+		values:   make(map[string][]string),
+	}
+}
+
+// with returns the child of values, which must match labels in number
+func (f *family[T]) with(values []string) *T {
+	if len(values) != len(f.labels) {
+		panic("prom: " + f.name + ": wrong number of label values")
+	}
+	key := strings.Join(values, "\xff")
+	f.mtx.RLock()
+	c, ok := f.children[key]
+	f.mtx.RUnlock()
+	if ok {
+		return c
+	}
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	if c, ok = f.children[key]; !ok {
+		c = f.create()
+		f.children[key] = c
+		f.values[key] = append([]string(nil), values...)
+	}
+	return c
+}
+
+// each calls fn for children sorted by label values, so output is stable between scrapes
+func (f *family[T]) each(fn func(values []string, c *T)) {
+	f.mtx.RLock()
+	keys := make([]string, 0, len(f.children))
+	for k := range f.children {
+		keys = append(keys, k)
+	}
+	f.mtx.RUnlock()
+	sort.Strings(keys)
+	for _, k := range keys {
+		f.mtx.RLock()
+		c, values := f.children[k], f.values[k]
+		f.mtx.RUnlock()
+		fn(values, c)
+	}
+}
+
+func (f *family[T]) header(w *bufio.Writer) {
+	writeHeader(w, f.name, f.help, f.typ)
+}
+
+func writeHeader(w *bufio.Writer, name, help, typ string) {
+	w.WriteString("# HELP " + name + " " + escapeHelp(help) + "\n")
+	w.WriteString("# TYPE " + name + " " + typ + "\n")
+}
+
+// writeSample writes a sample line. extra is an additional label pair like le of histogram buckets
+func writeSample(w *bufio.Writer, name string, labels, values []string, extraName, extraValue string, v float64) {
+	w.WriteString(name)
+	if len(labels) > 0 || extraName != "" {
+		w.WriteByte('{')
+		for i, l := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(l + `="` + escapeLabel(values[i]) + `"`)
+		}
+		if extraName != "" {
+			if len(labels) > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(extraName + `="` + extraValue + `"`)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(v))
+	w.WriteByte('\n')
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+var labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+// Counter is a value which only goes up
+type Counter struct {
+	v float
+}
+
+func (c *Counter) Inc() {
+	c.v.add(1)
+}
+
+// Add increases the counter, negative v is ignored
+func (c *Counter) Add(v float64) {
+	if v > 0 {
+		c.v.add(v)
+	}
+}
+
+// CounterVec is a counter partitioned by labels
+type CounterVec struct {
+	f *family[Counter]
+}
+
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	v := &CounterVec{f: newFamily(name, help, "counter", labels, func() *Counter { return &Counter{} })}
+	r.register(v)
+	return v
+}
+
+// NewCounter registers counter without labels
+func (r *Registry) NewCounter(name, help string) *Counter {
+	return r.NewCounterVec(name, help).WithLabelValues()
+}
+
+func (v *CounterVec) WithLabelValues(values ...string) *Counter {
+	return v.f.with(values)
+}
+
+func (v *CounterVec) write(w *bufio.Writer) {
+	v.f.header(w)
+	v.f.each(func(values []string, c *Counter) {
+		writeSample(w, v.f.name, v.f.labels, values, "", "", c.v.load())
+	})
+}
+
+// Histogram counts observations in cumulative buckets
+type Histogram struct {
+	upper  []float64
+	counts []atomic.Uint64
+	count  atomic.Uint64
+	sum    float
+}
+
+func (h *Histogram) Observe(v float64) {
+	// buckets are cumulative on output, here only the first fitting one is counted
+	if i := sort.SearchFloat64s(h.upper, v); i < len(h.upper) {
+		h.counts[i].Add(1)
+	}
+	h.count.Add(1)
+	h.sum.add(v)
+}
+
+// HistogramVec is a histogram partitioned by labels
+type HistogramVec struct {
+	f *family[Histogram]
+}
+
+// NewHistogramVec registers histogram of sorted buckets upper bounds, +Inf is added implicitly
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	upper := append([]float64(nil), buckets...)
+	sort.Float64s(upper)
+	v := &HistogramVec{f: newFamily(name, help, "histogram", labels, func() *Histogram {
+		return &Histogram{upper: upper, counts: make([]atomic.Uint64, len(upper))}
+	})}
+	r.register(v)
+	return v
+}
+
+func (v *HistogramVec) WithLabelValues(values ...string) *Histogram {
+	return v.f.with(values)
+}
+
+func (v *HistogramVec) write(w *bufio.Writer) {
+	v.f.header(w)
+	v.f.each(func(values []string, h *Histogram) {
+		// count is read first, so a concurrent observation can't make +Inf lower than a finite bucket
+		count := h.count.Load()
+		var cumulative uint64
+		for i, upper := range h.upper {
+			cumulative += h.counts[i].Load()
+			writeSample(w, v.f.name+"_bucket", v.f.labels, values, "le", formatFloat(upper), float64(min(cumulative, count)))
+		}
+		writeSample(w, v.f.name+"_bucket", v.f.labels, values, "le", "+Inf", float64(count))
+		writeSample(w, v.f.name+"_sum", v.f.labels, values, "", "", h.sum.load())
+		writeSample(w, v.f.name+"_count", v.f.labels, values, "", "", float64(count))
+	})
+}
+
+// valueFunc is a metric read on every scrape
+type valueFunc struct {
+	name string
+	help string
+	typ  string
+	f    func() float64
+}
+
+// NewGaugeFunc registers gauge whose value is f at scrape time
+func (r *Registry) NewGaugeFunc(name, help string, f func() float64) {
+	r.register(&valueFunc{name: name, help: help, typ: "gauge", f: f})
+}
+
+// NewCounterFunc registers counter whose value is f at scrape time, f must never decrease
+func (r *Registry) NewCounterFunc(name, help string, f func() float64) {
+	r.register(&valueFunc{name: name, help: help, typ: "counter", f: f})
+}
+
+func (v *valueFunc) write(w *bufio.Writer) {
+	writeHeader(w, v.name, v.help, v.typ)
+	writeSample(w, v.name, nil, nil, "", "", v.f())
+}
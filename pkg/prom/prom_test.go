@@ -0,0 +1,99 @@
+package prom
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func scrape(t *testing.T, r *Registry) string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	r.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if ct := w.Header().Get("Content-Type"); ct != "text/plain; version=0.0.4; charset=utf-8" {
+		t.Fatalf("got content type %q", ct)
+	}
+	return w.Body.String()
+}
+
+func TestCounterExposition(t *testing.T) {
+	r := NewRegistry()
+	v := r.NewCounterVec("http_requests_total", "Requests served.\nBy path \\ code.", "path", "code")
+	v.WithLabelValues("/b", "200").Inc()
+	v.WithLabelValues("/a", "200").Add(2.5)
+	v.WithLabelValues("/a", "200").Add(-1)
+	v.WithLabelValues("say \"hi\"\n\\", "500").Inc()
+	r.NewCounter("events_total", "Events.")
+
+	want := `# HELP http_requests_total Requests served.\nBy path \\ code.
+# TYPE http_requests_total counter
+http_requests_total{path="/a",code="200"} 2.5
+http_requests_total{path="/b",code="200"} 1
+http_requests_total{path="say \"hi\"\n\\",code="500"} 1
+# HELP events_total Events.
+# TYPE events_total counter
+events_total 0
+`
+	if got := scrape(t, r); got != want {
+		t.Fatalf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestHistogramExposition(t *testing.T) {
+	r := NewRegistry()
+	v := r.NewHistogramVec("request_seconds", "Latency.", []float64{1, 0.5}, "route")
+	h := v.WithLabelValues("/a")
+	for _, o := range []float64{0.25, 0.5, 0.75, 3} {
+		h.Observe(o)
+	}
+	v.WithLabelValues("/b")
+
+	// buckets are sorted and cumulative, le of an upper bound includes it
+	want := `# HELP request_seconds Latency.
+# TYPE request_seconds histogram
+request_seconds_bucket{route="/a",le="0.5"} 2
+request_seconds_bucket{route="/a",le="1"} 3
+request_seconds_bucket{route="/a",le="+Inf"} 4
+request_seconds_sum{route="/a"} 4.5
+request_seconds_count{route="/a"} 4
+request_seconds_bucket{route="/b",le="0.5"} 0
+request_seconds_bucket{route="/b",le="1"} 0
+request_seconds_bucket{route="/b",le="+Inf"} 0
+request_seconds_sum{route="/b"} 0
+request_seconds_count{route="/b"} 0
+`
+	if got := scrape(t, r); got != want {
+		t.Fatalf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestFuncExposition(t *testing.T) {
+	r := NewRegistry()
+	r.NewGaugeFunc("sessions", "Cached sessions.", func() float64 { return 3 })
+	r.NewCounterFunc("infinite_total", "Infinite.", func() float64 { return math.Inf(1) })
+	r.NewGaugeFunc("unknown", "Unknown.", math.NaN)
+
+	want := `# HELP sessions Cached sessions.
+# TYPE sessions gauge
+sessions 3
+# HELP infinite_total Infinite.
+# TYPE infinite_total counter
+infinite_total +Inf
+# HELP unknown Unknown.
+# TYPE unknown gauge
+unknown NaN
+`
+	if got := scrape(t, r); got != want {
+		t.Fatalf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestWrongLabelCount(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("no panic on wrong number of label values")
+		}
+	}()
+	NewRegistry().NewCounterVec("c", "C.", "a", "b").WithLabelValues("x")
+}
@@ -0,0 +1,99 @@
+package totp
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA1 seed of RFC 6238 appendix B, "12345678901234567890" base32 encoded
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCodeRfc6238(t *testing.T) {
+	// expected codes are the last Digits of the 8 digit ones in the RFC
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{unix: 59, code: "287082"},
+		{unix: 1111111109, code: "081804"},
+		{unix: 1111111111, code: "050471"},
+		{unix: 1234567890, code: "005924"},
+		{unix: 2000000000, code: "279037"},
+		{unix: 20000000000, code: "353130"},
+	}
+	for _, tt := range tests {
+		code, err := Code(rfcSecret, Step(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != tt.code {
+			t.Fatalf("at %d: got code %s, want %s", tt.unix, code, tt.code)
+		}
+	}
+}
+
+func TestCodeInvalidSecret(t *testing.T) {
+	if _, err := Code("not base32!", 1); err == nil {
+		t.Fatal("invalid secret accepted")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := Step(now)
+	codeAt := func(s int64) string {
+		c, err := Code(rfcSecret, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	tests := []struct {
+		name   string
+		secret string
+		code   string
+		ok     bool
+		step   int64
+	}{
+		{name: "current step", secret: rfcSecret, code: codeAt(step), ok: true, step: step},
+		{name: "previous step", secret: rfcSecret, code: codeAt(step - 1), ok: true, step: step - 1},
+		{name: "next step", secret: rfcSecret, code: codeAt(step + 1), ok: true, step: step + 1},
+		{name: "beyond skew", secret: rfcSecret, code: codeAt(step - 2)},
+		{name: "lowercase secret", secret: "gezdgnbvgy3tqojqgezdgnbvgy3tqojq", code: codeAt(step), ok: true, step: step},
+		{name: "wrong length", secret: rfcSecret, code: codeAt(step)[1:]},
+		{name: "invalid secret", secret: "not base32!", code: "000000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := Validate(tt.secret, tt.code, now, 1)
+			if ok != tt.ok || step != tt.step {
+				t.Fatalf("got step %d, ok %v, want step %d, ok %v", step, ok, tt.step, tt.ok)
+			}
+		})
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Code(secret, 1); err != nil {
+		t.Fatalf("generated secret %q is unusable: %v", secret, err)
+	}
+}
+
+func TestUri(t *testing.T) {
+	u, err := url.Parse(Uri("Clear Way", "bob", rfcSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Scheme != "otpauth" || u.Host != "totp" || u.Path != "/Clear Way:bob" {
+		t.Fatalf("unexpected uri %s", u)
+	}
+	q := u.Query()
+	if q.Get("secret") != rfcSecret || q.Get("issuer") != "Clear Way" || q.Get("digits") != "6" || q.Get("period") != "30" {
+		t.Fatalf("unexpected uri parameters %v", q)
+	}
+}